@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
@@ -9,26 +10,57 @@ import (
 	"syscall"
 	"time"
 
+	_ "github.com/Sys-Redux/rcnbuild-paas/docs"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/admin"
 	"github.com/Sys-Redux/rcnbuild-paas/internal/auth"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/cache"
 	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/deployments"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/logging"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
 	"github.com/Sys-Redux/rcnbuild-paas/internal/projects"
 	"github.com/Sys-Redux/rcnbuild-paas/internal/queue"
 	"github.com/Sys-Redux/rcnbuild-paas/internal/webhooks"
+	"github.com/Sys-Redux/rcnbuild-paas/pkg/crypto"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
-	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	swaggerFiles "github.com/swaggo/files"
+	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
+// @title RCNbuild API
+// @version 1.0
+// @description Deploy GitHub repos as containers. Authenticated routes use
+// @description the rcnbuild_token cookie set by the GitHub OAuth login.
+// @BasePath /api
 func main() {
+	dryRun := flag.Bool("dry-run", false,
+		"print pending database migrations & exit without applying them")
+	flag.Parse()
+
 	// Load .env file
 	if err := godotenv.Load(); err != nil {
 		fmt.Println("No .env file found, using environment variables")
 	}
 
 	// Setup zerolog with pretty console output
-	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
+	logging.Setup()
+
+	// Custom env var key format; an invalid pattern keeps the default
+	if pattern := os.Getenv("ENV_KEY_PATTERN"); pattern != "" {
+		if err := projects.SetEnvKeyPattern(pattern); err != nil {
+			log.Warn().Err(err).Str("pattern", pattern).
+				Msg("Invalid ENV_KEY_PATTERN, using default")
+		}
+	}
+
+	// Secrets are encrypted on nearly every request; refuse to start
+	// without a usable key rather than failing on the first one
+	if !crypto.IsConfigured() {
+		log.Fatal().Msg("ENCRYPTION_KEY (or JWT_SECRET) is missing or " +
+			"shorter than 32 bytes, or ENCRYPTION_KEY_PREVIOUS is invalid")
+	}
 
 	// Connect to database
 	if err := database.Connect(); err != nil {
@@ -36,6 +68,24 @@ func main() {
 	}
 	defer database.Close()
 
+	// Bring the schema up to date before serving anything
+	if *dryRun {
+		pending, err := database.PendingMigrations()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to list pending migrations")
+		}
+		if len(pending) == 0 {
+			fmt.Println("No pending migrations")
+		}
+		for _, name := range pending {
+			fmt.Println(name)
+		}
+		return
+	}
+	if err := database.RunMigrations(context.Background()); err != nil {
+		log.Fatal().Err(err).Msg("Failed to run database migrations")
+	}
+
 	// Connect to Redis for queue
 	redisAddr := os.Getenv("REDIS_URL")
 	if redisAddr == "" {
@@ -46,42 +96,101 @@ func main() {
 	}
 	defer queue.Close()
 
+	// Redis response cache (shares the queue's Redis)
+	if err := cache.Connect(redisAddr); err != nil {
+		log.Fatal().Err(err).Msg("Failed to connect to Redis cache")
+	}
+	defer cache.Close()
+
 	// Set Gin mode based on environment
 	if os.Getenv("ENVIRONMENT") == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	// Create Gin router
-	r := gin.Default()
+	// Create Gin router. The access log wraps everything so its latency
+	// is end to end, then CORS so preflights are answered before anything
+	// else runs, then request IDs so every log line is correlated
+	r := gin.New()
+	r.Use(middleware.AccessLog())
+	r.Use(middleware.CORS())
+	r.Use(middleware.RequestID(), middleware.JSONRecovery())
+	r.Use(middleware.SecurityHeaders())
+	r.Use(middleware.BodyLimit(middleware.DefaultBodyLimit))
 
 	// Health check endpoint
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
-			"status":  "ok",
-			"service": "rcnbuild-api",
+			"status":    "ok",
+			"service":   "rcnbuild-api",
+			"log_level": logging.Level(),
 		})
 	})
 
+	// Internal operations (shared secret, not exposed to users)
+	internal := r.Group("/internal")
+	internal.Use(logging.InternalSecretRequired())
+	{
+		internal.PUT("/log-level", logging.HandleSetLogLevel)
+		internal.POST("/rotate-encryption-key",
+			admin.HandleRotateEncryptionKey)
+	}
+
 	// Initialize handlers
 	authHandlers := auth.NewHandlers()
 	projectHandlers := projects.NewHandlers()
 	webhookHandlers := webhooks.NewHandlers()
+	adminHandlers := admin.NewHandlers()
+	deploymentHandlers := deployments.NewHandlers()
 
 	// API Routes
 	api := r.Group("/api")
 	{
+		// Swagger 2.0 spec & UI (public so clients can discover the API)
+		api.GET("/docs/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+
 		// Auth routes
 		authGroup := api.Group("/auth")
 		{
 			authGroup.GET("/github", authHandlers.HandleGitHubLogin)
 			authGroup.GET("/github/callback", authHandlers.HandleGitHubCallback)
+			authGroup.GET("/github/link", auth.AuthRequired(),
+				authHandlers.HandleGitHubLink)
+			authGroup.GET("/github/app-install",
+				authHandlers.HandleGitHubAppInstall)
+			authGroup.GET("/gitlab", authHandlers.HandleGitLabLogin)
+			authGroup.GET("/gitlab/callback", authHandlers.HandleGitLabCallback)
+			authGroup.GET("/gitlab/link", auth.AuthRequired(),
+				authHandlers.HandleGitLabLink)
 			authGroup.POST("/logout", authHandlers.HandleLogout)
 			authGroup.GET("/me", auth.AuthRequired(), authHandlers.HandleGetMe)
+			authGroup.GET("/sessions", auth.AuthRequired(),
+				authHandlers.HandleListSessions)
+			authGroup.DELETE("/sessions/:id", auth.AuthRequired(),
+				authHandlers.HandleRevokeSession)
 		}
 
+		// Current user's account
+		api.DELETE("/user/me", auth.AuthRequired(),
+			authHandlers.HandleDeleteAccount)
+
 		// GitHub repos (for selecting repo to deploy)
 		api.GET("/repos", auth.AuthRequired(),
 			projectHandlers.HandleListRepos)
+		api.GET("/repos/:owner/:repo/detect-runtime", auth.AuthRequired(),
+			projectHandlers.HandleDetectRuntime)
+		api.GET("/repos/:owner/:repo/commits", auth.AuthRequired(),
+			projectHandlers.HandleListCommits)
+
+		// GitHub organizations & their repos
+		api.GET("/orgs", auth.AuthRequired(), projectHandlers.HandleListOrgs)
+		api.GET("/orgs/:org/repos", auth.AuthRequired(),
+			projectHandlers.HandleListOrgRepos)
+
+		// Tags across the user's projects
+		api.GET("/tags", auth.AuthRequired(), projectHandlers.HandleListTags)
+
+		// Public deployment status badge (looked up by slug)
+		api.GET("/projects/:id/badge", projectHandlers.HandleGetBadge)
 
 		// Project routes
 		projectsGroup := api.Group("/projects")
@@ -92,19 +201,110 @@ func main() {
 			projectsGroup.GET("/:id", projectHandlers.HandleGetProject)
 			projectsGroup.PATCH("/:id", projectHandlers.HandleUpdateProject)
 			projectsGroup.DELETE("/:id", projectHandlers.HandleDeleteProject)
+			projectsGroup.POST("/:id/clone", projectHandlers.HandleCloneProject)
+			projectsGroup.POST("/:id/pause", projectHandlers.HandlePauseProject)
+			projectsGroup.POST("/:id/resume",
+				projectHandlers.HandleResumeProject)
+			projectsGroup.GET("/:id/stats/container",
+				projectHandlers.HandleGetContainerStats)
+			projectsGroup.GET("/:id/stats/image-sizes",
+				projectHandlers.HandleGetImageSizes)
+			projectsGroup.GET("/:id/build-queue",
+				projectHandlers.HandleGetBuildQueue)
+			projectsGroup.GET("/:id/metrics",
+				projectHandlers.HandleGetProjectMetrics)
+			projectsGroup.GET("/:id/tls",
+				projectHandlers.HandleGetTLSStatus)
+
+			// Compare two of the project's deployments
+			projectsGroup.GET("/:id/deployments/diff",
+				deploymentHandlers.HandleDiffDeployments)
+
+			// Deployment freeze window routes
+			projectsGroup.POST("/:id/freeze-windows",
+				projectHandlers.HandleCreateFreezeWindow)
+			projectsGroup.DELETE("/:id/freeze-windows/:index",
+				projectHandlers.HandleDeleteFreezeWindow)
+
+			// Traefik middleware routes (basic auth, IP allowlist)
+			projectsGroup.POST("/:id/middlewares",
+				projectHandlers.HandleSetMiddleware)
+			projectsGroup.DELETE("/:id/middlewares/:type",
+				projectHandlers.HandleDeleteMiddleware)
+
+			// Credential rotation
+			projectsGroup.POST("/:id/rotate-deploy-key",
+				projectHandlers.HandleRotateDeployKey)
+			projectsGroup.POST("/:id/rotate-webhook-secret",
+				projectHandlers.HandleRotateWebhookSecret)
+
+			// Deployment notification webhook routes
+			projectsGroup.POST("/:id/notification",
+				projectHandlers.HandleSetNotification)
+			projectsGroup.DELETE("/:id/notification",
+				projectHandlers.HandleDeleteNotification)
+
+			// Webhook delivery replay
+			projectsGroup.POST(
+				"/:id/webhook-deliveries/:delivery_id/redeliver",
+				webhookHandlers.HandleRedeliver)
 
 			// Environment variable routes
 			projectsGroup.GET("/:id/env", projectHandlers.HandleListEnvVars)
 			projectsGroup.POST("/:id/env", projectHandlers.HandleCreateEnvVar)
 			projectsGroup.DELETE("/:id/env/:key",
 				projectHandlers.HandleDeleteEnvVar)
+			projectsGroup.GET("/:id/env/:key/reveal",
+				middleware.UserRateLimit("env_reveal", 10, time.Minute),
+				projectHandlers.HandleRevealEnvVar)
+			projectsGroup.GET("/:id/env/:key/history",
+				projectHandlers.HandleGetEnvVarHistory)
+			projectsGroup.POST("/:id/env/:key/restore/:history_id",
+				projectHandlers.HandleRestoreEnvVar)
+			projectsGroup.POST("/:id/env/sync",
+				projectHandlers.HandleSyncEnvVars)
+		}
+
+		// Deployment routes
+		api.POST("/deployments/:id/cancel", auth.AuthRequired(),
+			deploymentHandlers.HandleCancelDeployment)
+		api.GET("/deployments/:id/runtime-logs", auth.AuthRequired(),
+			deploymentHandlers.HandleGetRuntimeLogs)
+		api.GET("/deployments/:id/build-logs/stream", auth.AuthRequired(),
+			deploymentHandlers.HandleStreamBuildLogs)
+
+		// Deployment routes (token query param - WebSocket clients)
+		deploymentsGroup := api.Group("/deployments")
+		deploymentsGroup.Use(auth.QueryTokenRequired())
+		{
+			deploymentsGroup.GET("/:id/logs/ws",
+				projectHandlers.HandleStreamDeploymentLogs)
 		}
 
 		// Webhook routes (no auth - handled via secret)
-		webhooks := api.Group("/webhooks")
+		webhookGroup := api.Group("/webhooks")
 		{
-			webhooks.POST("/github", webhookHandlers.HandleGitHubWebhook)
+			// Push payloads can run well past the default body limit
+			webhookGroup.POST("/github",
+				middleware.BodyLimit(webhooks.MaxBodyBytes),
+				webhookHandlers.HandleGitHubWebhook)
 		}
+
+		// Platform admin routes
+		adminGroup := api.Group("/admin")
+		adminGroup.Use(auth.AuthRequired(), auth.AdminRequired())
+		{
+			adminGroup.GET("/users", adminHandlers.HandleListUsers)
+			adminGroup.GET("/users/:id", adminHandlers.HandleGetUser)
+			adminGroup.DELETE("/users/:id", adminHandlers.HandleDeleteUser)
+			adminGroup.POST("/users/:id/quota",
+				adminHandlers.HandleSetUserQuota)
+			adminGroup.GET("/stats", adminHandlers.HandleGetStats)
+		}
+
+		// Platform-wide metrics (admin only)
+		api.GET("/platform/metrics", auth.AuthRequired(), auth.AdminRequired(),
+			adminHandlers.HandleGetPlatformMetrics)
 	}
 
 	// Get configuration from environment
@@ -0,0 +1,79 @@
+// Re-encrypts every secret stored in the database under a new key.
+// Run it with the API & workers stopped, then deploy with the new
+// ENCRYPTION_KEY. Safe to re-run: values already under the new key are
+// left alone.
+//
+//	go run ./cmd/rekey --old-key "$OLD_KEY" --new-key "$NEW_KEY"
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/logging"
+	"github.com/Sys-Redux/rcnbuild-paas/pkg/crypto"
+	"github.com/joho/godotenv"
+	"github.com/rs/zerolog/log"
+)
+
+func main() {
+	oldKey := flag.String("old-key", "", "current encryption key")
+	newKey := flag.String("new-key", "", "encryption key to switch to")
+	flag.Parse()
+
+	if *oldKey == "" || *newKey == "" {
+		fmt.Fprintln(os.Stderr, "both --old-key and --new-key are required")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *oldKey == *newKey {
+		fmt.Fprintln(os.Stderr, "--old-key and --new-key are the same")
+		os.Exit(2)
+	}
+
+	// Load .env file (for DATABASE_URL)
+	if err := godotenv.Load(); err != nil {
+		fmt.Println("No .env file found, using environment variables")
+	}
+	logging.Setup()
+
+	// Fail on bad keys before touching any rows
+	for _, key := range []string{*oldKey, *newKey} {
+		if _, err := crypto.EncryptWithKey("", key); err != nil {
+			log.Fatal().Err(err).Msg("Invalid encryption key")
+		}
+	}
+
+	if err := database.Connect(); err != nil {
+		log.Fatal().Err(err).Msg("Failed to connect to database")
+	}
+	defer database.Close()
+
+	rekey := func(ciphertext string) (string, bool, error) {
+		plaintext, err := crypto.DecryptWithKey(ciphertext, *oldKey)
+		if err == nil {
+			newValue, err := crypto.EncryptWithKey(plaintext, *newKey)
+			return newValue, true, err
+		}
+		// Converted by an earlier, interrupted run
+		if _, newErr := crypto.DecryptWithKey(ciphertext,
+			*newKey); newErr == nil {
+			return ciphertext, false, nil
+		}
+		return "", false, err
+	}
+
+	rekeyed, err := crypto.RekeyAll(context.Background(),
+		database.Pool(), rekey)
+	if err != nil {
+		log.Error().Err(err).Int("rekeyed", rekeyed).
+			Msg("Some values were not re-keyed; fix the errors & re-run " +
+				"before switching keys")
+		os.Exit(1)
+	}
+	log.Info().Int("rekeyed", rekeyed).
+		Msg("All values re-keyed; set ENCRYPTION_KEY to the new key")
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/cache"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/containers"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/logging"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/queue"
+	"github.com/Sys-Redux/rcnbuild-paas/pkg/crypto"
+	"github.com/hibiken/asynq"
+	"github.com/joho/godotenv"
+	"github.com/rs/zerolog/log"
+)
+
+// Tasks processed at once unless WORKER_CONCURRENCY says otherwise
+const defaultConcurrency = 5
+
+// How long to wait for the Docker daemon at startup
+const dockerPingTimeout = 10 * time.Second
+
+// Where the queue UI is mounted on the admin server
+const monitorPath = "/admin/queues"
+
+// Processes build, deploy & maintenance tasks from the Asynq queues
+func main() {
+	// Load .env file
+	if err := godotenv.Load(); err != nil {
+		fmt.Println("No .env file found, using environment variables")
+	}
+
+	// Worker logs go to an aggregator, so one JSON object per line
+	logging.SetupJSON()
+
+	// Builds decrypt deploy keys & env vars; refuse to start without a
+	// usable key rather than failing every task
+	if !crypto.IsConfigured() {
+		log.Fatal().Msg("ENCRYPTION_KEY (or JWT_SECRET) is missing or " +
+			"shorter than 32 bytes, or ENCRYPTION_KEY_PREVIOUS is invalid")
+	}
+
+	concurrency := positiveIntEnv("WORKER_CONCURRENCY", defaultConcurrency)
+	// Per-queue caps within WORKER_CONCURRENCY
+	buildsConcurrency := positiveIntEnv("QUEUE_BUILDS_CONCURRENCY",
+		queue.DefaultBuildsConcurrency)
+	deploysConcurrency := positiveIntEnv("QUEUE_DEPLOYMENTS_CONCURRENCY",
+		queue.DefaultDeploymentsConcurrency)
+
+	// Every queue tasks are enqueued on, unless narrowed by WORKER_QUEUES
+	queues := queue.QueuePriorities(
+		positiveIntEnv("QUEUE_PRIORITY_BUILDS", queue.DefaultBuildsPriority),
+		positiveIntEnv("QUEUE_PRIORITY_DEPLOYMENTS",
+			queue.DefaultDeploymentsPriority),
+	)
+	if v := os.Getenv("WORKER_QUEUES"); v != "" {
+		parsed, err := queue.ParseQueues(v)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid WORKER_QUEUES")
+		}
+		queues = parsed
+	}
+
+	// Builds & deploys can't run without Docker; fail now, not per task
+	pingCtx, cancel := context.WithTimeout(context.Background(),
+		dockerPingTimeout)
+	err := containers.Ping(pingCtx)
+	cancel()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Docker daemon is unreachable")
+	}
+
+	// Connect to database
+	if err := database.Connect(); err != nil {
+		log.Fatal().Err(err).Msg("Failed to connect to database")
+	}
+	defer database.Close()
+
+	// Connect to Redis (the client enqueues follow-up tasks, e.g. deploys)
+	redisAddr := os.Getenv("REDIS_URL")
+	if redisAddr == "" {
+		redisAddr = "localhost:6379"
+	}
+	if err := queue.Connect(redisAddr); err != nil {
+		log.Fatal().Err(err).Msg("Failed to connect to Redis queue")
+	}
+	defer queue.Close()
+
+	// Live build logs are published through the cache's Redis client
+	if err := cache.Connect(redisAddr); err != nil {
+		log.Fatal().Err(err).Msg("Failed to connect to Redis cache")
+	}
+	defer cache.Close()
+
+	srv := queue.NewServer(concurrency, queues)
+	mux := queue.NewServeMux(buildsConcurrency, deploysConcurrency)
+	if err := srv.Start(mux); err != nil {
+		log.Fatal().Err(err).Msg("Failed to start worker server")
+	}
+
+	// Tailers of live containers' output died with the previous worker
+	if err := queue.ResumeRuntimeLogs(context.Background()); err != nil {
+		log.Error().Err(err).Msg("Failed to resume runtime log tailing")
+	}
+
+	// Cron-style maintenance tasks (cleanup, metrics, certificate checks)
+	// Every scheduler enqueues its own copy, so with several workers only
+	// one may run it (WORKER_PERIODIC_TASKS=false on the others)
+	var periodic *asynq.PeriodicTaskManager
+	schedulePeriodic := os.Getenv("WORKER_PERIODIC_TASKS") != "false"
+	if schedulePeriodic {
+		periodic, err = queue.NewPeriodicTaskManager(redisAddr)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to create periodic task manager")
+		}
+		if err := periodic.Start(); err != nil {
+			log.Fatal().Err(err).Msg("Failed to start periodic task manager")
+		}
+	}
+
+	// Asynq web UI for operators (HTTP Basic Auth via ADMIN_USERNAME &
+	// ADMIN_PASSWORD) - inspect, delete & re-queue tasks without redis-cli
+	// Served only on ADMIN_ADDR, an address kept off the public network
+	var admin *http.Server
+	if addr := os.Getenv("ADMIN_ADDR"); addr != "" {
+		monitor := queue.NewMonitor(monitorPath)
+		defer monitor.Close()
+		mux := http.NewServeMux()
+		mux.Handle(monitorPath+"/", queue.MonitorAuthRequired(monitor))
+		admin = &http.Server{
+			Addr:              addr,
+			Handler:           mux,
+			ReadHeaderTimeout: 15 * time.Second,
+			IdleTimeout:       60 * time.Second,
+		}
+		go func() {
+			log.Info().Str("addr", addr).Msg("Starting admin server")
+			if err := admin.ListenAndServe(); err != nil &&
+				err != http.ErrServerClosed {
+				log.Fatal().Err(err).Msg("Failed to start admin server")
+			}
+		}()
+	}
+
+	log.Info().
+		Int("concurrency", concurrency).
+		Int("builds_concurrency", buildsConcurrency).
+		Int("deployments_concurrency", deploysConcurrency).
+		Interface("queues", queues).
+		Bool("periodic_tasks", schedulePeriodic).
+		Msg("Worker started")
+
+	// SIGTSTP stops pulling new tasks (ahead of a deploy); SIGINT/SIGTERM
+	// wait for running tasks, up to the server's shutdown timeout
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGTSTP)
+	for sig := range sigs {
+		if sig == syscall.SIGTSTP {
+			log.Info().Msg("Worker no longer accepting new tasks")
+			srv.Stop()
+			continue
+		}
+		break
+	}
+
+	log.Info().Msg("Shutting down worker...")
+	if admin != nil {
+		ctx, cancel := context.WithTimeout(context.Background(),
+			5*time.Second)
+		admin.Shutdown(ctx)
+		cancel()
+	}
+	if periodic != nil {
+		periodic.Shutdown()
+	}
+	srv.Shutdown()
+	log.Info().Msg("Worker exited")
+}
+
+// Reads a positive integer setting, exiting if it's set to anything else
+func positiveIntEnv(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		log.Fatal().Str("value", v).Msg(name + " must be a positive integer")
+	}
+	return n
+}
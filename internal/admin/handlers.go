@@ -0,0 +1,341 @@
+package admin
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/auth"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/containers"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog"
+)
+
+// Holds dependencies for admin handlers
+// Admins are flagged by hand on the database:
+//
+//	UPDATE users SET is_admin = true WHERE github_username = '<login>';
+type Handlers struct{}
+
+// Create Handlers instance
+func NewHandlers() *Handlers {
+	return &Handlers{}
+}
+
+// Body for adjusting a user's limits (omitted fields are unchanged)
+type SetQuotaRequest struct {
+	Projects            *int `json:"projects"`
+	DeploymentsPerMonth *int `json:"deployments_per_month"`
+}
+
+// Adjust a user's project & monthly deployment limits
+// POST /api/admin/users/:id/quota
+func (h *Handlers) HandleSetUserQuota(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	var req SetQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.RespondBodyError(c, err)
+		return
+	}
+
+	if (req.Projects != nil && *req.Projects < 0) ||
+		(req.DeploymentsPerMonth != nil && *req.DeploymentsPerMonth < 0) {
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "quotas must not be negative"})
+		return
+	}
+
+	user, err := database.SetUserQuota(c.Request.Context(), c.Param("id"),
+		req.Projects, req.DeploymentsPerMonth)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		logger.Error().Err(err).Msg("Failed to update user quota")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to update quota"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// Query params for listing users
+type ListUsersRequest struct {
+	Limit  int    `form:"limit,default=50" binding:"min=1,max=100"`
+	Cursor string `form:"cursor"`
+}
+
+// List all users, newest first (keyset paginated)
+// GET /api/admin/users
+func (h *Handlers) HandleListUsers(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	var req ListUsersRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var after *database.UserCursor
+	if req.Cursor != "" {
+		cursor, err := decodeUserCursor(req.Cursor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		after = cursor
+	}
+
+	users, err := database.ListUsers(c.Request.Context(), req.Limit, after)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list users")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to list users"})
+		return
+	}
+
+	// A full page means there may be more
+	var nextCursor *string
+	if len(users) == req.Limit {
+		last := users[len(users)-1]
+		cursor := encodeUserCursor(last.CreatedAt, last.ID)
+		nextCursor = &cursor
+	}
+
+	if users == nil {
+		users = []*database.User{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":       users,
+		"next_cursor": nextCursor,
+	})
+}
+
+// Get a user with usage totals & a fingerprint of their GitHub token
+// GET /api/admin/users/:id
+func (h *Handlers) HandleGetUser(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user, err := database.GetUserByID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	projectCount, err := database.CountProjectsByUserID(c.Request.Context(),
+		user.ID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to count user projects")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	deployments, err := database.CountDeploymentsThisMonth(
+		c.Request.Context(), user.ID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to count user deployments")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	// Only a hash is exposed: enough to compare tokens, useless to call
+	// GitHub with
+	var tokenHash *string
+	if token, err := database.GetUserAccessToken(c.Request.Context(),
+		user.ID); err == nil {
+		sum := sha256.Sum256([]byte(token))
+		hash := "sha256:" + hex.EncodeToString(sum[:])
+		tokenHash = &hash
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":                   user,
+		"project_count":          projectCount,
+		"deployments_this_month": deployments,
+		"access_token_hash":      tokenHash,
+	})
+}
+
+// Delete a user along with their projects, deployments & env vars
+// DELETE /api/admin/users/:id
+func (h *Handlers) HandleDeleteUser(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	userID := c.Param("id")
+	if admin := auth.GetCurrentUser(c); admin != nil && admin.ID == userID {
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "Admins cannot delete themselves"})
+		return
+	}
+
+	user, err := database.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Containers aren't covered by the DB cascade
+	projects, err := database.GetProjectsByUserID(c.Request.Context(),
+		user.ID, nil, 0, 0)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get user projects")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to delete user"})
+		return
+	}
+	removeProjectContainers(c, logger, projects)
+
+	// Projects, deployments, env vars etc. go via ON DELETE CASCADE
+	if err := database.DeleteUser(c.Request.Context(), user.ID); err != nil {
+		logger.Error().Err(err).Msg("Failed to delete user")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to delete user"})
+		return
+	}
+
+	logger.Info().
+		Str("user_id", user.ID).
+		Str("username", user.Username()).
+		Int("projects", len(projects)).
+		Msg("User deleted by admin")
+
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
+}
+
+// Platform-wide totals
+// GET /api/admin/stats
+func (h *Handlers) HandleGetStats(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	stats, err := database.GetPlatformStats(c.Request.Context())
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get platform stats")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to get stats"})
+		return
+	}
+
+	// Fall back to the DB's view if Docker can't be reached
+	activeContainers := stats.LiveDeployments
+	if managed, err := containers.ListManagedContainers(
+		c.Request.Context()); err == nil {
+		activeContainers = 0
+		for _, mc := range managed {
+			if mc.State == "running" {
+				activeContainers++
+			}
+		}
+	} else {
+		logger.Warn().Err(err).Msg("Failed to list containers")
+	}
+
+	failedRate := 0.0
+	if stats.DeploymentsThisMonth > 0 {
+		failedRate = float64(stats.FailedDeploymentsThisMonth) /
+			float64(stats.DeploymentsThisMonth)
+	}
+
+	cacheHits, cacheMisses := database.ProjectCacheStats()
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":                  stats.Users,
+		"projects":               stats.Projects,
+		"active_containers":      activeContainers,
+		"deployments_this_month": stats.DeploymentsThisMonth,
+		"failed_deployment_rate": failedRate,
+		"failed_deployments":     stats.FailedDeploymentsThisMonth,
+		"project_cache": gin.H{
+			"hits":   cacheHits,
+			"misses": cacheMisses,
+		},
+	})
+}
+
+// Force-removes every managed container belonging to the given projects
+func removeProjectContainers(c *gin.Context, logger *zerolog.Logger,
+	projects []*database.Project) {
+	if len(projects) == 0 {
+		return
+	}
+
+	slugs := make(map[string]bool, len(projects))
+	for _, p := range projects {
+		slugs[p.Slug] = true
+	}
+
+	managed, err := containers.ListManagedContainers(c.Request.Context())
+	if err != nil {
+		logger.Warn().Err(err).Msg("Failed to list containers")
+		return
+	}
+
+	for _, mc := range managed {
+		if !slugs[mc.Slug] {
+			continue
+		}
+		if err := containers.Remove(c.Request.Context(), mc.ID); err != nil {
+			logger.Warn().Err(err).Str("container", mc.Name).
+				Msg("Failed to remove container")
+		}
+	}
+}
+
+// Cursors are opaque to clients: base64 of "<created_at>|<id>"
+func encodeUserCursor(createdAt time.Time, id string) string {
+	raw := createdAt.UTC().Format(time.RFC3339Nano) + "|" + id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// Parses a cursor produced by encodeUserCursor
+func decodeUserCursor(cursor string) (*database.UserCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, errors.New("malformed cursor")
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, err
+	}
+	return &database.UserCursor{CreatedAt: createdAt, ID: id}, nil
+}
+
+// Build duration percentiles & success rate across all projects
+// (last 30 days, refreshed hourly)
+// GET /api/platform/metrics
+func (h *Handlers) HandleGetPlatformMetrics(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	metrics, err := database.GetPlatformDeploymentMetrics(
+		c.Request.Context())
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get platform metrics")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to get platform metrics"})
+		return
+	}
+
+	c.JSON(http.StatusOK, metrics)
+}
@@ -0,0 +1,38 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
+	"github.com/Sys-Redux/rcnbuild-paas/pkg/crypto"
+	"github.com/gin-gonic/gin"
+)
+
+// Re-encrypt every secret still under ENCRYPTION_KEY_PREVIOUS with
+// ENCRYPTION_KEY. Rotation: deploy with the new ENCRYPTION_KEY & the old
+// one as ENCRYPTION_KEY_PREVIOUS, call this, then drop the previous key.
+// POST /internal/rotate-encryption-key
+func HandleRotateEncryptionKey(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	rotated, err := crypto.RotateKey(c.Request.Context(), database.Pool())
+	if errors.Is(err, crypto.ErrPreviousKeyNotSet) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		// Committed batches stay rotated; re-running picks up the rest
+		logger.Error().Err(err).Int("rotated", rotated).
+			Msg("Encryption key rotation failed")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "key rotation incomplete; fix the errors & re-run",
+			"rotated": rotated,
+		})
+		return
+	}
+
+	logger.Info().Int("rotated", rotated).Msg("Rotated encryption key")
+	c.JSON(http.StatusOK, gin.H{"rotated": rotated})
+}
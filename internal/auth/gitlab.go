@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/gitlab"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// Redirect the user to GitLab OAuth authorization page
+// @Summary Start GitLab OAuth login
+// @Tags auth
+// @Success 307
+// @Failure 500 {object} map[string]string
+// @Router /auth/gitlab [get]
+func (h *Handlers) HandleGitLabLogin(c *gin.Context) {
+	redirectToGitLab(c, oauthFlowLogin, "")
+}
+
+// Redirect a signed-in user to GitLab to link their GitLab account
+// @Summary Link a GitLab account to the current user
+// @Tags auth
+// @Success 307
+// @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/gitlab/link [get]
+func (h *Handlers) HandleGitLabLink(c *gin.Context) {
+	user := GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	if user.GitLabID != nil {
+		c.JSON(http.StatusConflict,
+			gin.H{"error": "A GitLab account is already linked"})
+		return
+	}
+
+	redirectToGitLab(c, oauthFlowLink, user.ID)
+}
+
+// Sends the browser to GitLab's authorize page for an OAuth flow
+func redirectToGitLab(c *gin.Context, flow, userID string) {
+	logger := middleware.Logger(c)
+
+	clientID := os.Getenv("GITLAB_CLIENT_ID")
+	redirectURI := os.Getenv("GITLAB_REDIRECT_URI")
+
+	if clientID == "" || redirectURI == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "GitLab OAuth not configured",
+		})
+		return
+	}
+
+	state, err := newOAuthState(c, flow, userID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create OAuth state")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to start GitLab sign-in",
+		})
+		return
+	}
+
+	params := url.Values{}
+	params.Set("client_id", clientID)
+	params.Set("redirect_uri", redirectURI)
+	params.Set("response_type", "code")
+	params.Set("scope", "read_user")
+	params.Set("state", state)
+
+	c.Redirect(http.StatusTemporaryRedirect,
+		"https://gitlab.com/oauth/authorize?"+params.Encode())
+}
+
+// Handle the OAuth callback from GitLab
+// Signs in as the GitLab user, or links the account when the flow was
+// started from /auth/gitlab/link
+// @Summary GitLab OAuth callback
+// @Tags auth
+// @Param code query string true "OAuth code"
+// @Param state query string true "OAuth state"
+// @Success 307
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/gitlab/callback [get]
+func (h *Handlers) HandleGitLabCallback(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	flow, linkUserID, err := verifyOAuthState(c)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Rejected GitLab OAuth callback")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Missing authorization code",
+		})
+		return
+	}
+
+	// A link must finish as the user who started it
+	var current *database.User
+	if flow == oauthFlowLink {
+		current = sessionUser(c)
+		if current == nil || current.ID != linkUserID {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Sign in as the user who started the link",
+			})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	accessToken, err := exchangeGitLabCode(ctx, code)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to exchange GitLab code for token")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to exchange code for token",
+		})
+		return
+	}
+
+	glUser, err := gitlab.NewClient(accessToken).GetUser(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to fetch GitLab user")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch GitLab user info",
+		})
+		return
+	}
+
+	gitlabUser := &database.GitLabUser{
+		ID:        glUser.ID,
+		Username:  glUser.Username,
+		Email:     glUser.Email,
+		AvatarURL: glUser.AvatarURL,
+	}
+
+	if flow == oauthFlowLink {
+		if current.GitLabID != nil {
+			c.JSON(http.StatusConflict,
+				gin.H{"error": "A GitLab account is already linked"})
+			return
+		}
+		if existing, err := database.GetUserByGitLabID(ctx,
+			gitlabUser.ID); err == nil && existing.ID != current.ID {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "GitLab account is linked to another user",
+			})
+			return
+		}
+		if _, err := database.LinkGitLabUser(ctx, current.ID, gitlabUser,
+			accessToken); err != nil {
+			logger.Error().Err(err).Msg("Failed to link GitLab account")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to link GitLab account",
+			})
+			return
+		}
+
+		// Already signed in; the session carries on as before
+		logger.Info().
+			Str("user_id", current.ID).
+			Str("gitlab_username", gitlabUser.Username).
+			Msg("Linked GitLab account")
+		redirectToDashboard(c)
+		return
+	}
+
+	user, err := database.CreateOrUpdateGitLabUser(ctx, gitlabUser,
+		accessToken)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create/update user")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create or update user",
+		})
+		return
+	}
+
+	jwtToken, err := GenerateToken(c, user.ID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to generate JWT")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to generate authentication token",
+		})
+		return
+	}
+
+	SetAuthCookie(c, jwtToken)
+	logger.Info().
+		Str("user_id", user.ID).
+		Str("gitlab_username", gitlabUser.Username).
+		Msg("User authenticated successfully")
+
+	redirectToDashboard(c)
+}
+
+// Represents GitLab's token exchange response
+type gitlabTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	Scope       string `json:"scope"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// Exchange the authorization code for a GitLab access token
+func exchangeGitLabCode(ctx context.Context, code string) (string, error) {
+	data := url.Values{}
+	data.Set("client_id", os.Getenv("GITLAB_CLIENT_ID"))
+	data.Set("client_secret", os.Getenv("GITLAB_CLIENT_SECRET"))
+	data.Set("redirect_uri", os.Getenv("GITLAB_REDIRECT_URI"))
+	data.Set("grant_type", "authorization_code")
+	data.Set("code", code)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		"https://gitlab.com/oauth/token", strings.NewReader(data.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var tokenResp gitlabTokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", err
+	}
+	if tokenResp.AccessToken == "" {
+		return "", fmt.Errorf("GitLab token exchange failed: %s %s",
+			tokenResp.Error, tokenResp.Description)
+	}
+
+	return tokenResp.AccessToken, nil
+}
@@ -7,10 +7,12 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"time"
 
 	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/queue"
 	"github.com/gin-gonic/gin"
-	"github.com/rs/zerolog/log"
 )
 
 // Handlers provides HTTP handlers for authentication
@@ -22,7 +24,42 @@ func NewHandlers() *Handlers {
 }
 
 // Redirect the user to GitHub OAuth authorization page
+// @Summary Start GitHub OAuth login
+// @Tags auth
+// @Success 307
+// @Failure 500 {object} map[string]string
+// @Router /auth/github [get]
 func (h *Handlers) HandleGitHubLogin(c *gin.Context) {
+	redirectToGitHub(c, oauthFlowLogin, "")
+}
+
+// Redirect a signed-in user to GitHub to link their GitHub account
+// @Summary Link a GitHub account to the current user
+// @Tags auth
+// @Success 307
+// @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/github/link [get]
+func (h *Handlers) HandleGitHubLink(c *gin.Context) {
+	user := GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+	if user.GitHubID != nil {
+		c.JSON(http.StatusConflict,
+			gin.H{"error": "A GitHub account is already linked"})
+		return
+	}
+
+	redirectToGitHub(c, oauthFlowLink, user.ID)
+}
+
+// Sends the browser to GitHub's authorize page for an OAuth flow
+func redirectToGitHub(c *gin.Context, flow, userID string) {
+	logger := middleware.Logger(c)
+
 	clientID := os.Getenv("GITHUB_CLIENT_ID")
 	redirectURI := os.Getenv("GITHUB_REDIRECT_URI")
 
@@ -33,16 +70,71 @@ func (h *Handlers) HandleGitHubLogin(c *gin.Context) {
 		return
 	}
 
+	state, err := newOAuthState(c, flow, userID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create OAuth state")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to start GitHub sign-in",
+		})
+		return
+	}
+
 	// Build GitHub OAuth URL
 	// For GitHub Apps, permissions are defined in the app settings
-	authURL := "https://github.com/login/oauth/authorize?client_id=" +
-		clientID + "&redirect_uri=" + redirectURI
+	params := url.Values{}
+	params.Set("client_id", clientID)
+	params.Set("redirect_uri", redirectURI)
+	params.Set("state", state)
 
-	c.Redirect(http.StatusTemporaryRedirect, authURL)
+	c.Redirect(http.StatusTemporaryRedirect,
+		"https://github.com/login/oauth/authorize?"+params.Encode())
+}
+
+// Redirect the user to the GitHub App installation page
+// @Summary Redirect to the GitHub App install page
+// @Tags auth
+// @Success 307
+// @Failure 500 {object} map[string]string
+// @Router /auth/github/app-install [get]
+func (h *Handlers) HandleGitHubAppInstall(c *gin.Context) {
+	appSlug := os.Getenv("GITHUB_APP_SLUG")
+	if appSlug == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "GitHub App not configured",
+		})
+		return
+	}
+
+	// Installation is recorded when GitHub sends the `installation` webhook
+	installURL := "https://github.com/apps/" + url.PathEscape(appSlug) +
+		"/installations/new"
+
+	c.Redirect(http.StatusTemporaryRedirect, installURL)
 }
 
 // Handle the OAuth callback from GitHub
+// Signs in as the GitHub user, or links the account when the flow was
+// started from /auth/github/link
+// @Summary GitHub OAuth callback
+// @Tags auth
+// @Param code query string true "OAuth code"
+// @Param state query string true "OAuth state"
+// @Success 307
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/github/callback [get]
 func (h *Handlers) HandleGitHubCallback(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	flow, linkUserID, err := verifyOAuthState(c)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Rejected GitHub OAuth callback")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	code := c.Query("code")
 	if code == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -51,10 +143,22 @@ func (h *Handlers) HandleGitHubCallback(c *gin.Context) {
 		return
 	}
 
+	// A link must finish as the user who started it
+	var current *database.User
+	if flow == oauthFlowLink {
+		current = sessionUser(c)
+		if current == nil || current.ID != linkUserID {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Sign in as the user who started the link",
+			})
+			return
+		}
+	}
+
 	// Exchange code for access token
 	tokenResp, err := exchangeCodeForToken(code)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to exchange code for token")
+		logger.Error().Err(err).Msg("Failed to exchange code for token")
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to exchange code for token",
 		})
@@ -62,30 +166,65 @@ func (h *Handlers) HandleGitHubCallback(c *gin.Context) {
 	}
 
 	// Fetch user info from GitHub
-	githubUser, err := fetchGitHubUser(tokenResp.AccessToken)
+	githubUser, userResp, err := fetchGitHubUser(tokenResp.AccessToken)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to fetch GitHub user")
+		logger.Error().Err(err).Msg("Failed to fetch GitHub user")
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to fetch GitHub user info",
 		})
 		return
 	}
+	githubUser.TokenExpiresAt = tokenExpiry(userResp, tokenResp)
+	logger.Debug().
+		Str("scopes", userResp.Header.Get("X-OAuth-Scopes")).
+		Msg("Fetched GitHub user")
+
+	ctx := c.Request.Context()
+	if flow == oauthFlowLink {
+		if current.GitHubID != nil {
+			c.JSON(http.StatusConflict,
+				gin.H{"error": "A GitHub account is already linked"})
+			return
+		}
+		if existing, err := database.GetUserByGitHubID(ctx,
+			githubUser.ID); err == nil && existing.ID != current.ID {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "GitHub account is linked to another user",
+			})
+			return
+		}
+		if _, err := database.LinkGitHubUser(ctx, current.ID, githubUser,
+			tokenResp.AccessToken); err != nil {
+			logger.Error().Err(err).Msg("Failed to link GitHub account")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to link GitHub account",
+			})
+			return
+		}
+
+		// Already signed in; the session carries on as before
+		logger.Info().
+			Str("user_id", current.ID).
+			Str("github_username", githubUser.Login).
+			Msg("Linked GitHub account")
+		redirectToDashboard(c)
+		return
+	}
 
-	// Create or update user in database
-	user, err := database.CreateOrUpdateUser(c.Request.Context(),
-		githubUser, tokenResp.AccessToken)
+	user, err := database.CreateOrUpdateUser(ctx, githubUser,
+		tokenResp.AccessToken)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to create/update user")
+		logger.Error().Err(err).Msg("Failed to create/update user")
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to create or update user",
 		})
 		return
 	}
 
-	// Generate JWT
-	jwtToken, err := GenerateToken(user.ID)
+	// Generate JWT (or session id, see AuthMode)
+	jwtToken, err := GenerateToken(c, user.ID)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to generate JWT")
+		logger.Error().Err(err).Msg("Failed to generate JWT")
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to generate authentication token",
 		})
@@ -94,21 +233,22 @@ func (h *Handlers) HandleGitHubCallback(c *gin.Context) {
 
 	// Set auth cookie
 	SetAuthCookie(c, jwtToken)
-	log.Info().
+	logger.Info().
 		Str("user_id", user.ID).
-		Str("github_username", user.GitHubUsername).
+		Str("github_username", githubUser.Login).
 		Msg("User authenticated successfully")
 
-	// Redirect to dashboard
-	dashboardURL := os.Getenv("DASHBOARD_URL")
-	if dashboardURL == "" {
-		dashboardURL = "/dashboard"
-	}
-	c.Redirect(http.StatusTemporaryRedirect, dashboardURL)
+	redirectToDashboard(c)
 }
 
 // Clear the user's session
+// @Summary Log out
+// @Tags auth
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Router /auth/logout [post]
 func (h *Handlers) HandleLogout(c *gin.Context) {
+	revokeCurrentSession(c)
 	ClearAuthCookie(c)
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Logged out successfully",
@@ -116,6 +256,12 @@ func (h *Handlers) HandleLogout(c *gin.Context) {
 }
 
 // Get the current authenticated user
+// @Summary Get the current user
+// @Tags auth
+// @Produce json
+// @Success 200 {object} database.User
+// @Failure 401 {object} map[string]string
+// @Router /auth/me [get]
 func (h *Handlers) HandleGetMe(c *gin.Context) {
 	user := GetCurrentUser(c)
 	if user == nil {
@@ -128,15 +274,81 @@ func (h *Handlers) HandleGetMe(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
+// Delete the current user's account & everything they deployed
+// Cleanup runs on a worker; progress is kept in account_deletions
+// DELETE /api/user/me
+// @Summary Delete the current user's account
+// @Tags auth
+// @Produce json
+// @Success 202 {object} map[string]interface{}
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /user/me [delete]
+func (h *Handlers) HandleDeleteAccount(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	deletion, created, err := database.CreateAccountDeletion(
+		c.Request.Context(), user.ID, user.Username())
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to record account deletion")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to delete account"})
+		return
+	}
+
+	if created {
+		if _, err := queue.EnqueueDeleteAccount(c.Request.Context(),
+			&queue.DeleteAccountPayload{
+				DeletionID: deletion.ID,
+				UserID:     user.ID,
+			}); err != nil {
+			logger.Error().Err(err).Msg("Failed to enqueue account deletion")
+			// Closes the record so a later request can start over
+			errMsg := "failed to enqueue account deletion"
+			if err := database.FinishAccountDeletion(c.Request.Context(),
+				deletion.ID, &errMsg); err != nil {
+				logger.Error().Err(err).
+					Msg("Failed to record deletion failure")
+			}
+			c.JSON(http.StatusInternalServerError,
+				gin.H{"error": "Failed to delete account"})
+			return
+		}
+	}
+
+	ClearAuthCookie(c)
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":     "Account deletion started",
+		"deletion_id": deletion.ID,
+		"status":      deletion.Status,
+	})
+}
+
 // ===========================================
 // Internal helpers
 // ===========================================
 
+// Sends a freshly signed-in user to the dashboard
+func redirectToDashboard(c *gin.Context) {
+	dashboardURL := os.Getenv("DASHBOARD_URL")
+	if dashboardURL == "" {
+		dashboardURL = "/dashboard"
+	}
+	c.Redirect(http.StatusTemporaryRedirect, dashboardURL)
+}
+
 // Represents GitHub's token exchange response
 type tokenResponse struct {
 	AccessToken string `json:"access_token"`
 	TokenType   string `json:"token_type"`
 	Scope       string `json:"scope"`
+	ExpiresIn   int    `json:"expires_in"` // GitHub App user tokens only
 }
 
 // Exchange the authorization code for an access token
@@ -176,10 +388,12 @@ func exchangeCodeForToken(code string) (*tokenResponse, error) {
 }
 
 // Fetch the authenticated user's info from GitHub API
-func fetchGitHubUser(accessToken string) (*database.GitHubUser, error) {
+// The response is returned (body already closed) for its token headers
+func fetchGitHubUser(accessToken string) (*database.GitHubUser,
+	*http.Response, error) {
 	req, err := http.NewRequest("GET", "https://api.github.com/user", nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	req.Header.Set("Authorization", "Bearer "+accessToken)
@@ -188,19 +402,44 @@ func fetchGitHubUser(accessToken string) (*database.GitHubUser, error) {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	var user database.GitHubUser
 	if err := json.Unmarshal(body, &user); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return &user, nil
+	return &user, resp, nil
+}
+
+// Layouts GitHub uses for github-authentication-token-expiration
+var tokenExpiryLayouts = []string{
+	"2006-01-02 15:04:05 MST",
+	"2006-01-02 15:04:05 -0700",
+}
+
+// When the access token expires, or nil if it doesn't
+// GitHub reports expiring tokens via a response header; GitHub App user
+// tokens also carry expires_in from the code exchange
+func tokenExpiry(resp *http.Response, tokenResp *tokenResponse) *time.Time {
+	if header := resp.Header.Get(
+		"github-authentication-token-expiration"); header != "" {
+		for _, layout := range tokenExpiryLayouts {
+			if t, err := time.Parse(layout, header); err == nil {
+				return &t
+			}
+		}
+	}
+	if tokenResp.ExpiresIn > 0 {
+		t := time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+		return &t
+	}
+	return nil
 }
@@ -5,6 +5,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -19,8 +20,20 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
+// How long a login lasts, for JWTs & sessions alike
+const tokenLifetime = 7 * 24 * time.Hour
+
+// Create the auth cookie value for a user: a JWT, or in session mode the
+// id of a new session recorded against the request's IP & user agent
+func GenerateToken(c *gin.Context, userID string) (string, error) {
+	if AuthMode() == AuthModeSession {
+		return createSession(c, userID)
+	}
+	return generateJWT(userID)
+}
+
 // Create new JWT for a user
-func GenerateToken(userID string) (string, error) {
+func generateJWT(userID string) (string, error) {
 	secret := os.Getenv("JWT_SECRET")
 	if secret == "" {
 		return "", errors.New("JWT_SECRET not set")
@@ -29,7 +42,7 @@ func GenerateToken(userID string) (string, error) {
 	claims := Claims{
 		UserID: userID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(7 * 24 * time.Hour)), // 7 days
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(tokenLifetime)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "rcnbuild",
 		},
@@ -2,8 +2,10 @@ package auth
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
 	"github.com/gin-gonic/gin"
 )
 
@@ -12,9 +14,16 @@ const (
 	CookieName = "rcnbuild_token"
 	// UserContextKey is the key used to store user in gin context
 	UserContextKey = "user"
+	// SessionContextKey holds the current session id (session mode only)
+	SessionContextKey = "session_id"
+	// TokenExpiringHeader tells the frontend to prompt for re-auth
+	TokenExpiringHeader = "X-Token-Expiring"
 )
 
-// Middleware that requires a valid JWT
+// How close to its GitHub token's expiry a user is prompted to re-auth
+const tokenExpiryWarning = 24 * time.Hour
+
+// Middleware that requires a valid JWT (or session, see AuthMode)
 func AuthRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get token from cookie
@@ -26,7 +35,7 @@ func AuthRequired() gin.HandlerFunc {
 		}
 
 		// Validate token
-		claims, err := ValidateToken(tokenString)
+		userID, sessionID, err := resolveToken(c, tokenString)
 		if err != nil {
 			// Clear invalid cookie
 			c.SetCookie(CookieName, "", -1, "/", "", false, true)
@@ -35,16 +44,73 @@ func AuthRequired() gin.HandlerFunc {
 			return
 		}
 
-		// Fetch user from database
-		user, err := database.GetUserByID(c.Request.Context(), claims.UserID)
+		setCurrentUser(c, userID, sessionID)
+	}
+}
+
+// Middleware that authenticates via a `token` query param
+// For WebSocket clients, which can't send the auth cookie cross-origin
+func QueryTokenRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := c.Query("token")
+		if tokenString == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			c.Abort()
+			return
+		}
+
+		userID, sessionID, err := resolveToken(c, tokenString)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			c.Abort()
+			return
+		}
+
+		setCurrentUser(c, userID, sessionID)
+	}
+}
+
+// Loads the user for a validated token into context & continues the chain
+func setCurrentUser(c *gin.Context, userID, sessionID string) {
+	// Fetch user from database
+	user, err := database.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		c.Abort()
+		return
+	}
+
+	if user.TokenExpiresAt != nil &&
+		time.Until(*user.TokenExpiresAt) < tokenExpiryWarning {
+		c.Header(TokenExpiringHeader, "true")
+	}
+
+	// Store user in context for handlers to use
+	c.Set(UserContextKey, user)
+	c.Set(middleware.UserIDKey, user.ID) // For the access log
+	if sessionID != "" {
+		c.Set(SessionContextKey, sessionID)
+	}
+	c.Next()
+}
+
+// Middleware that requires the authenticated user to be a platform admin
+// Must run after AuthRequired
+func AdminRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := GetCurrentUser(c)
+		if user == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			c.Abort()
+			return
+		}
+
+		if !user.IsAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
 			c.Abort()
 			return
 		}
 
-		// Store user in context for handlers to use
-		c.Set(UserContextKey, user)
 		c.Next()
 	}
 }
@@ -58,6 +124,26 @@ func GetCurrentUser(c *gin.Context) *database.User {
 	return user.(*database.User)
 }
 
+// Returns the user behind a valid auth cookie, or nil
+// For public routes that behave differently when already signed in
+func sessionUser(c *gin.Context) *database.User {
+	tokenString, err := c.Cookie(CookieName)
+	if err != nil {
+		return nil
+	}
+
+	userID, _, err := resolveToken(c, tokenString)
+	if err != nil {
+		return nil
+	}
+
+	user, err := database.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
 // Set the JWT cookie
 func SetAuthCookie(c *gin.Context, token string) {
 	// HTTP-only cookie prevents JavaScript access (XSS protection)
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// How auth cookies are issued & checked (AUTH_MODE)
+const (
+	// Signed JWTs; stateless, but can't be revoked before they expire
+	AuthModeJWT = "jwt"
+	// Session ids looked up in user_sessions; revocable per device
+	AuthModeSession = "session"
+)
+
+// Returns the configured auth mode (AUTH_MODE, default jwt)
+// Switching modes signs everyone out, as old cookies no longer validate
+func AuthMode() string {
+	if os.Getenv("AUTH_MODE") == AuthModeSession {
+		return AuthModeSession
+	}
+	return AuthModeJWT
+}
+
+// A session as listed to its owner
+type SessionInfo struct {
+	*database.UserSession
+	Current bool `json:"current"` // The session making the request
+}
+
+// Records a session for the request's client & returns its id
+func createSession(c *gin.Context, userID string) (string, error) {
+	session, err := database.CreateUserSession(c.Request.Context(), userID,
+		c.ClientIP(), c.Request.UserAgent(), time.Now().Add(tokenLifetime))
+	if err != nil {
+		return "", err
+	}
+	return session.ID, nil
+}
+
+// Validates an auth cookie value for the current auth mode
+// Returns the user it belongs to & the session id (blank for JWTs)
+func resolveToken(c *gin.Context, token string) (userID, sessionID string,
+	err error) {
+	if AuthMode() != AuthModeSession {
+		claims, err := ValidateToken(token)
+		if err != nil {
+			return "", "", err
+		}
+		return claims.UserID, "", nil
+	}
+
+	// Anything else (e.g. a JWT from before the switch) is just invalid
+	if _, err := uuid.Parse(token); err != nil {
+		return "", "", ErrInvalidToken
+	}
+	session, err := database.TouchUserSession(c.Request.Context(), token)
+	if err != nil {
+		return "", "", ErrInvalidToken
+	}
+	return session.UserID, session.ID, nil
+}
+
+// Revokes the session behind the request's auth cookie, if any
+func revokeCurrentSession(c *gin.Context) {
+	if AuthMode() != AuthModeSession {
+		return
+	}
+	token, err := c.Cookie(CookieName)
+	if err != nil {
+		return
+	}
+	userID, sessionID, err := resolveToken(c, token)
+	if err != nil {
+		return
+	}
+	if err := database.DeleteUserSession(c.Request.Context(), sessionID,
+		userID); err != nil {
+		middleware.Logger(c).Warn().Err(err).Msg("Failed to revoke session")
+	}
+}
+
+// List the current user's active sessions
+// GET /api/auth/sessions
+// @Summary List active sessions
+// @Description Only available with AUTH_MODE=session
+// @Tags auth
+// @Produce json
+// @Success 200 {object} map[string][]SessionInfo
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /auth/sessions [get]
+func (h *Handlers) HandleListSessions(c *gin.Context) {
+	logger := middleware.Logger(c)
+	user := GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	if AuthMode() != AuthModeSession {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Sessions are only tracked with AUTH_MODE=session",
+		})
+		return
+	}
+
+	sessions, err := database.ListUserSessions(c.Request.Context(), user.ID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list sessions")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to list sessions",
+		})
+		return
+	}
+
+	currentID := c.GetString(SessionContextKey)
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, s := range sessions {
+		infos = append(infos, SessionInfo{
+			UserSession: s,
+			Current:     s.ID == currentID,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": infos})
+}
+
+// Revoke one of the current user's sessions (signs that device out)
+// DELETE /api/auth/sessions/:id
+// @Summary Revoke a session
+// @Description Only available with AUTH_MODE=session
+// @Tags auth
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /auth/sessions/{id} [delete]
+func (h *Handlers) HandleRevokeSession(c *gin.Context) {
+	logger := middleware.Logger(c)
+	user := GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	if AuthMode() != AuthModeSession {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Sessions are only tracked with AUTH_MODE=session",
+		})
+		return
+	}
+
+	sessionID := c.Param("id")
+	if _, err := uuid.Parse(sessionID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	// Scoped to the user, so other users' sessions read as not found
+	if err := database.DeleteUserSession(c.Request.Context(), sessionID,
+		user.ID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	// Revoking the session in use signs this client out too
+	if sessionID == c.GetString(SessionContextKey) {
+		ClearAuthCookie(c)
+	}
+
+	logger.Info().Str("session_id", sessionID).Msg("Session revoked")
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
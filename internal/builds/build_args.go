@@ -0,0 +1,59 @@
+package builds
+
+import (
+	"sort"
+	"strings"
+)
+
+// Escapes a value for a double-quoted Dockerfile word
+var dockerfileQuoter = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`)
+
+// Declares buildEnv in every stage of a generated Dockerfile.
+// Each stage gets ARG defaults (exposed to RUN steps); intermediate stages
+// also get ENV so tools that read the environment at any point see them.
+// The final stage only gets ARG, which Docker doesn't persist into the
+// image config, so the values never reach the running container
+func InjectBuildArgs(dockerfile string, buildEnv map[string]string) string {
+	if len(buildEnv) == 0 {
+		return dockerfile
+	}
+
+	keys := make([]string, 0, len(buildEnv))
+	for key := range buildEnv {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := strings.Split(dockerfile, "\n")
+
+	lastFrom := -1
+	for i, line := range lines {
+		if isFromLine(line) {
+			lastFrom = i
+		}
+	}
+
+	var out []string
+	for i, line := range lines {
+		out = append(out, line)
+		if !isFromLine(line) {
+			continue
+		}
+		for _, key := range keys {
+			out = append(out, "ARG "+key+"=\""+
+				dockerfileQuoter.Replace(buildEnv[key])+"\"")
+		}
+		if i != lastFrom {
+			for _, key := range keys {
+				out = append(out, "ENV "+key+"=${"+key+"}")
+			}
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// Reports whether a Dockerfile line starts a new build stage
+func isFromLine(line string) bool {
+	fields := strings.Fields(line)
+	return len(fields) > 0 && strings.EqualFold(fields[0], "FROM")
+}
@@ -0,0 +1,29 @@
+package builds
+
+import "strings"
+
+// Prepends a note to a generated Dockerfile listing the project's build
+// secrets & how to consume them. Generated steps never read secrets, so
+// projects that need one at build time commit their own Dockerfile.
+func AddBuildSecretsHelp(dockerfile string, keys []string) string {
+	if len(keys) == 0 {
+		return dockerfile
+	}
+
+	var b strings.Builder
+	b.WriteString("# Build secrets: " + strings.Join(keys, ", ") + "\n")
+	b.WriteString(`#
+# Secrets are mounted by BuildKit only for the RUN step that asks for one
+# and are never written to an image layer. To use one, commit a Dockerfile
+# and add a secret mount to the step that needs it, e.g.:
+#
+#   RUN --mount=type=secret,id=npm_token \
+#       NPM_TOKEN="$(cat /run/secrets/npm_token)" npm ci
+#
+# The id is the secret's key; the file holds the mapped env var's value.
+# Don't COPY or ARG the value, either would bake it into the image.
+
+`)
+	b.WriteString(dockerfile)
+	return b.String()
+}
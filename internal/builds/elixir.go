@@ -0,0 +1,50 @@
+package builds
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Elixir frameworks (RuntimeInfo.Framework)
+const FrameworkPhoenix = "phoenix"
+
+// The `app: :name` entry in mix.exs's project/0
+var mixAppPattern = regexp.MustCompile(`app:\s*:([a-zA-Z_][a-zA-Z0-9_]*)`)
+
+// Fills app name, framework & start command from mix.exs contents
+func applyMixExs(info *RuntimeInfo, data []byte) {
+	content := string(data)
+
+	if match := mixAppPattern.FindStringSubmatch(content); match != nil {
+		info.AppName = match[1]
+	}
+
+	if strings.Contains(content, "{:phoenix,") {
+		info.Framework = FrameworkPhoenix
+		info.StartCommand = "mix phx.server"
+		info.Port = 4000
+		return
+	}
+	info.Framework = ""
+	info.StartCommand = "mix run --no-halt"
+}
+
+// Reads mix.exs from a checked-out repo into info (worker side)
+// The project's stored start command & port are left as they are
+func DetectElixirProject(dir string, info *RuntimeInfo) {
+	data, err := os.ReadFile(filepath.Join(dir, "mix.exs"))
+	if err != nil {
+		return
+	}
+
+	startCmd, port := info.StartCommand, info.Port
+	applyMixExs(info, data)
+	if startCmd != "" {
+		info.StartCommand = startCmd
+	}
+	if port != 0 {
+		info.Port = port
+	}
+}
@@ -0,0 +1,99 @@
+package builds
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/github"
+	"github.com/rs/zerolog/log"
+)
+
+// GoReleaser config file names, in the order GoReleaser looks for them
+var goReleaserConfigs = []string{
+	".goreleaser.yml",
+	".goreleaser.yaml",
+	"goreleaser.yml",
+	"goreleaser.yaml",
+}
+
+// Returns the package to `go build`: "." when main.go is at the root,
+// else ./cmd/{name} for the first cmd/ subdirectory with a main.go
+// Falls back to "." when no main package is found
+func DetectGoMainPackage(ctx context.Context, client *github.Client, owner,
+	repo, branch, rootPath string) (string, error) {
+	if exists, _ := client.FileExists(ctx, owner, repo,
+		joinPath(rootPath, "main.go"), branch); exists {
+		return ".", nil
+	}
+
+	cmdPath := joinPath(rootPath, "cmd")
+	if exists, _ := client.FileExists(ctx, owner, repo, cmdPath,
+		branch); !exists {
+		return ".", nil
+	}
+	contents, err := client.GetRepoContents(ctx, owner, repo, cmdPath, branch)
+	if err != nil {
+		return ".", err
+	}
+
+	var matches []string
+	for _, entry := range contents {
+		if entry.Type != "dir" {
+			continue
+		}
+		if exists, _ := client.FileExists(ctx, owner, repo,
+			entry.Path+"/main.go", branch); exists {
+			matches = append(matches, "./cmd/"+entry.Name)
+		}
+	}
+	return pickGoMainPackage(owner+"/"+repo, matches), nil
+}
+
+// Reports whether the repo ships a GoReleaser config
+func DetectGoReleaser(ctx context.Context, client *github.Client, owner,
+	repo, branch, rootPath string) bool {
+	for _, name := range goReleaserConfigs {
+		if exists, _ := client.FileExists(ctx, owner, repo,
+			joinPath(rootPath, name), branch); exists {
+			return true
+		}
+	}
+	return false
+}
+
+// Records the main package of a checked-out repo in info (worker side,
+// mirrors what DetectGoMainPackage does via the GitHub API)
+func DetectGoProject(dir string, info *RuntimeInfo) {
+	info.MainPackage = "."
+	if fileExists(filepath.Join(dir, "main.go")) {
+		return
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "cmd"))
+	if err != nil {
+		return
+	}
+	var matches []string
+	for _, entry := range entries {
+		if entry.IsDir() && fileExists(filepath.Join(dir, "cmd",
+			entry.Name(), "main.go")) {
+			matches = append(matches, "./cmd/"+entry.Name())
+		}
+	}
+	info.MainPackage = pickGoMainPackage(dir, matches)
+}
+
+// First candidate main package, warning when the choice is ambiguous
+func pickGoMainPackage(source string, matches []string) string {
+	if len(matches) == 0 {
+		return "."
+	}
+	sort.Strings(matches)
+	if len(matches) > 1 {
+		log.Warn().Str("source", source).Strs("candidates", matches).
+			Msg("Multiple Go main packages under cmd/, using first")
+	}
+	return matches[0]
+}
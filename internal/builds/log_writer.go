@@ -0,0 +1,92 @@
+package builds
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// Published after the last line, so subscribers know the build output ended
+const LogStreamEnd = "\x00end"
+
+// Redis pub/sub channel a deployment's live build output is published on
+func LogChannel(deploymentID string) string {
+	return "build-logs:" + deploymentID
+}
+
+// Publishes build output to Redis one line at a time
+// Partial lines are held until their newline arrives (or Close). Publish
+// failures never fail the build; the writer just stops publishing.
+type LogWriter struct {
+	ctx     context.Context
+	client  *redis.Client
+	channel string
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+// Creates a writer for a deployment's build output
+// A nil client gives a writer that discards everything
+func NewLogWriter(ctx context.Context, client *redis.Client,
+	deploymentID string) *LogWriter {
+	return &LogWriter{
+		ctx:     ctx,
+		client:  client,
+		channel: LogChannel(deploymentID),
+	}
+}
+
+func (w *LogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.client == nil {
+		return len(p), nil
+	}
+
+	w.partial = append(w.partial, p...)
+	start := 0
+	for {
+		i := bytes.IndexByte(w.partial[start:], '\n')
+		if i < 0 {
+			break
+		}
+		w.publish(w.ctx, string(w.partial[start:start+i]))
+		start += i + 1
+	}
+	w.partial = append(w.partial[:0], w.partial[start:]...)
+
+	return len(p), nil
+}
+
+// Publishes any trailing partial line & the end-of-stream marker
+// Runs even if the build was cancelled, so subscribers aren't left waiting
+func (w *LogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ctx := context.WithoutCancel(w.ctx)
+	if len(w.partial) > 0 {
+		w.publish(ctx, string(w.partial))
+		w.partial = nil
+	}
+	w.publish(ctx, LogStreamEnd)
+	w.client = nil
+	return nil
+}
+
+// Sends one message; the first failure disables the writer
+func (w *LogWriter) publish(ctx context.Context, msg string) {
+	if w.client == nil {
+		return
+	}
+	if err := w.client.Publish(ctx, w.channel, msg).Err(); err != nil {
+		log.Warn().Err(err).Str("channel", w.channel).
+			Msg("Failed to publish build log, live output disabled")
+		w.client = nil
+	}
+}
@@ -0,0 +1,118 @@
+package builds
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Node.js majors with published alpine images we build on (ascending)
+var supportedNodeVersions = []int{18, 20, 22}
+
+// Used when package.json doesn't pin an engine we can match
+const defaultNodeVersion = "20"
+
+// Node.js package managers (RuntimeInfo.PackageManager)
+const (
+	NodeNPM  = "npm"
+	NodePNPM = "pnpm"
+	NodeYarn = "yarn"
+	NodeBun  = "bun"
+)
+
+// Lockfile that marks each package manager other than npm
+var nodeLockfiles = map[string]string{
+	NodePNPM: "pnpm-lock.yaml",
+	NodeYarn: "yarn.lock",
+	NodeBun:  "bun.lockb",
+}
+
+// The package.json fields that affect how the app is built & started
+type packageJSON struct {
+	Type    string `json:"type"`
+	Main    string `json:"main"`
+	Engines struct {
+		Node string `json:"node"`
+	} `json:"engines"`
+}
+
+// Fills module type, entry point & Node version from package.json contents
+// Invalid JSON is ignored (defaults are kept)
+func applyPackageJSON(info *RuntimeInfo, data []byte) {
+	var pkg packageJSON
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return
+	}
+
+	info.ModuleType = "commonjs"
+	if pkg.Type == "module" {
+		info.ModuleType = "module"
+	}
+	info.EntryPoint = pkg.Main
+	info.NodeVersion = resolveNodeVersion(pkg.Engines.Node)
+}
+
+// Reads package.json from a checked-out repo into info (worker side,
+// mirrors what detectNodeJSRuntime does via the GitHub API)
+func DetectNodePackage(dir string, info *RuntimeInfo) {
+	info.PackageManager = detectNodePackageManager(dir)
+
+	data, err := os.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return
+	}
+	applyPackageJSON(info, data)
+}
+
+// Package manager for a checked-out repo, by lockfile (npm if none)
+// Checked in the same order as detectNodeJSRuntime
+func detectNodePackageManager(dir string) string {
+	for _, pm := range []string{NodePNPM, NodeYarn, NodeBun} {
+		if fileExists(filepath.Join(dir, nodeLockfiles[pm])) {
+			return pm
+		}
+	}
+	return NodeNPM
+}
+
+// First major version number in a semver range
+var nodeMajorPattern = regexp.MustCompile(`(\d+)`)
+
+// Picks the base image Node major for an engines.node range, e.g.
+// ">=20" -> 20, "^22.1.0" -> 22, "16.x" -> 18 (oldest we support)
+func resolveNodeVersion(constraint string) string {
+	match := nodeMajorPattern.FindString(constraint)
+	if match == "" {
+		return defaultNodeVersion
+	}
+	major, err := strconv.Atoi(match)
+	if err != nil {
+		return defaultNodeVersion
+	}
+
+	// Lowest supported version that satisfies the lower bound
+	for _, v := range supportedNodeVersions {
+		if v >= major {
+			return strconv.Itoa(v)
+		}
+	}
+	return strconv.Itoa(supportedNodeVersions[len(supportedNodeVersions)-1])
+}
+
+// Dockerfile CMD for a Node app. An .mjs entry point (or an ES module
+// package with no start command) runs directly with node; anything else
+// goes through the start command in shell form so "npm run start" works
+func nodeStartInstruction(info *RuntimeInfo, startCmd string) string {
+	entry := info.EntryPoint
+	if entry != "" && (strings.HasSuffix(entry, ".mjs") ||
+		(info.ModuleType == "module" && startCmd == "")) {
+		return `CMD ["node", ` + strconv.Quote(entry) + `]`
+	}
+	if startCmd == "" {
+		startCmd = "npm start"
+	}
+	return "CMD " + startCmd
+}
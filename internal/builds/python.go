@@ -0,0 +1,125 @@
+package builds
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/github"
+)
+
+// Python frameworks (RuntimeInfo.Framework)
+const (
+	FrameworkDjango  = "django"
+	FrameworkFastAPI = "fastapi"
+	FrameworkFlask   = "flask"
+)
+
+// Matches a dependency named exactly `name` in requirements.txt, Pipfile
+// or pyproject.toml (so "django" doesn't match "django-environ")
+func pythonDependencyPattern(name string) *regexp.Regexp {
+	return regexp.MustCompile(`(?im)(^|[\s"'\[,])` + name +
+		`([\s"'\]\[,;<>=!~]|$)`)
+}
+
+// Frameworks in detection order, for repos that list more than one
+var pythonFrameworks = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{FrameworkDjango, pythonDependencyPattern("django")},
+	{FrameworkFastAPI, pythonDependencyPattern("fastapi")},
+	{FrameworkFlask, pythonDependencyPattern("flask")},
+}
+
+// Servers the suggested start commands rely on
+var (
+	gunicornPattern = pythonDependencyPattern("gunicorn")
+	uvicornPattern  = pythonDependencyPattern("uvicorn")
+)
+
+// The project package in manage.py's
+// os.environ.setdefault("DJANGO_SETTINGS_MODULE", "mysite.settings")
+var djangoSettingsPattern = regexp.MustCompile(
+	`DJANGO_SETTINGS_MODULE['"]\s*,\s*['"]([A-Za-z_][A-Za-z0-9_]*)\.settings`)
+
+// Detects a Python app's installer & framework (empty path = repo root)
+// Returns nil when the directory has no requirements.txt, pyproject.toml
+// or Pipfile
+func detectPythonRuntime(ctx context.Context, client *github.Client, owner,
+	repo, branch, checkPath string) (*RuntimeInfo, error) {
+	info := &RuntimeInfo{
+		Runtime:      RuntimePython,
+		StartCommand: "python app.py",
+		Port:         8000,
+	}
+
+	// Manifests in installer precedence order; the first found wins
+	var deps []byte
+	if data, err := client.GetFileContents(ctx, owner, repo,
+		joinPath(checkPath, "requirements.txt"), branch); err == nil {
+		deps = data
+		info.BuildCommand = "pip install -r requirements.txt"
+		info.PackageManager = PythonPip
+	} else if data, err := client.GetFileContents(ctx, owner, repo,
+		joinPath(checkPath, "pyproject.toml"), branch); err == nil {
+		deps = data
+		info.StartCommand = "python -m app"
+		// Poetry projects install from the lockfile
+		if exists, _ := client.FileExists(ctx, owner, repo,
+			joinPath(checkPath, "poetry.lock"), branch); exists {
+			info.BuildCommand = "poetry install"
+			info.PackageManager = PythonPoetry
+		} else {
+			info.BuildCommand = "pip install ."
+			info.PackageManager = PythonPyproject
+		}
+	} else if data, err := client.GetFileContents(ctx, owner, repo,
+		joinPath(checkPath, "Pipfile"), branch); err == nil {
+		deps = data
+		info.BuildCommand = "pipenv install"
+		info.PackageManager = PythonPipenv
+	} else {
+		return nil, nil
+	}
+
+	for _, fw := range pythonFrameworks {
+		if fw.pattern.Match(deps) {
+			info.Framework = fw.name
+			break
+		}
+	}
+
+	switch info.Framework {
+	case FrameworkDjango:
+		if data, err := client.GetFileContents(ctx, owner, repo,
+			joinPath(checkPath, "manage.py"), branch); err == nil {
+			if match := djangoSettingsPattern.FindSubmatch(data); match != nil {
+				info.AppName = string(match[1])
+			}
+		}
+		if info.AppName == "" {
+			info.StartCommand = "python manage.py runserver 0.0.0.0:8000"
+			info.Suggestion = "Couldn't find the Django project in " +
+				"manage.py; set the start command to " +
+				"`gunicorn {project}.wsgi:application --bind 0.0.0.0:8000`"
+			break
+		}
+		info.StartCommand = "gunicorn " + info.AppName +
+			".wsgi:application --bind 0.0.0.0:8000"
+		if !gunicornPattern.Match(deps) {
+			info.Suggestion = "Add gunicorn to your dependencies"
+		}
+	case FrameworkFastAPI:
+		info.StartCommand = "uvicorn main:app --host 0.0.0.0 --port 8000"
+		if !uvicornPattern.Match(deps) {
+			info.Suggestion = "Add uvicorn to your dependencies"
+		}
+	case FrameworkFlask:
+		info.StartCommand = "gunicorn app:app --bind 0.0.0.0:8000"
+		if !gunicornPattern.Match(deps) {
+			info.Suggestion = "Add gunicorn to your dependencies"
+		}
+	}
+
+	return info, nil
+}
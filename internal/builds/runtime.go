@@ -3,8 +3,11 @@ package builds
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 
 	"github.com/Sys-Redux/rcnbuild-paas/internal/github"
+	"github.com/rs/zerolog/log"
 )
 
 // Represents the detected application runtime
@@ -16,18 +19,58 @@ const (
     RuntimeGo      Runtime = "go"
     RuntimeStatic  Runtime = "static"
     RuntimeDocker  Runtime = "docker"
+    RuntimeElixir  Runtime = "elixir"
     RuntimeUnknown Runtime = "unknown"
+    // Multi-service repos; detected so they can be refused, never built
+    RuntimeDockerCompose Runtime = "docker_compose"
 )
 
 // Represents detected runtime information and suggested commands
 type RuntimeInfo struct {
-    Runtime      Runtime `json:"runtime"`
-    BuildCommand string  `json:"build_command"`
-    StartCommand string  `json:"start_command"`
-    Port         int     `json:"port"`
+    Runtime         Runtime `json:"runtime"`
+    BuildCommand    string  `json:"build_command"`
+    StartCommand    string  `json:"start_command"`
+    Port            int     `json:"port"`
+    PackageManager  string  `json:"package_manager,omitempty"`
+    DetectedRootDir string  `json:"detected_root_dir,omitempty"`
+    NodeVersion     string  `json:"node_version,omitempty"` // Base image major
+    ModuleType      string  `json:"module_type,omitempty"`  // module/commonjs
+    EntryPoint      string  `json:"entry_point,omitempty"`  // package.json main
+    AppName         string  `json:"app_name,omitempty"`     // mix.exs/Django app
+    Framework       string  `json:"framework,omitempty"`
+    MainPackage     string  `json:"main_package,omitempty"` // go build target
+    Suggestion      string  `json:"suggestion,omitempty"`
+    WarningMessage  string  `json:"warning_message,omitempty"`
+}
+
+// Compose file names, in the order Docker Compose looks for them
+var composeFiles = []string{
+    "compose.yaml", "compose.yml", "docker-compose.yml", "docker-compose.yaml",
+}
+
+// Python dependency installers (RuntimeInfo.PackageManager)
+const (
+    PythonPip       = "pip"       // requirements.txt
+    PythonPoetry    = "poetry"    // pyproject.toml + poetry.lock
+    PythonPipenv    = "pipenv"    // Pipfile
+    PythonPyproject = "pyproject" // pyproject.toml (PEP 517 build)
+)
+
+// Files whose presence marks a directory as an app root (monorepos)
+var runtimeSignalFiles = map[string]bool{
+    "Dockerfile":       true,
+    "package.json":     true,
+    "requirements.txt": true,
+    "pyproject.toml":   true,
+    "Pipfile":          true,
+    "go.mod":           true,
+    "mix.exs":          true,
+    "index.html":       true,
 }
 
 // Analyzes a repository to determine its runtime
+// With a blank root & nothing found there, falls back to the first
+// top-level subdirectory with runtime files (sets DetectedRootDir)
 func DetectRuntime(ctx context.Context, client *github.Client, owner, repo,
 	branch, rootDir string) (*RuntimeInfo, error) {
     // Path to check (empty string = root)
@@ -36,6 +79,100 @@ func DetectRuntime(ctx context.Context, client *github.Client, owner, repo,
         checkPath = ""
     }
 
+    info, err := detectRuntimeAt(ctx, client, owner, repo, branch, checkPath)
+    if err != nil || info.Runtime != RuntimeUnknown || checkPath != "" {
+        return info, err
+    }
+
+    // Nothing at the root: look one level down for a monorepo app
+    subDir, err := detectSubdirRoot(ctx, client, owner, repo, branch)
+    if err != nil || subDir == "" {
+        return info, nil
+    }
+
+    subInfo, err := detectRuntimeAt(ctx, client, owner, repo, branch, subDir)
+    if err != nil {
+        return info, nil
+    }
+    subInfo.DetectedRootDir = subDir
+    return subInfo, nil
+}
+
+// Returns the first top-level subdirectory containing runtime files
+func detectSubdirRoot(ctx context.Context, client *github.Client, owner,
+	repo, branch string) (string, error) {
+    contents, err := client.GetRepoContents(ctx, owner, repo, "", branch)
+    if err != nil {
+        return "", err
+    }
+
+    var matches []string
+    for _, entry := range contents {
+        if entry.Type != "dir" {
+            continue
+        }
+        files, err := client.GetRepoContents(ctx, owner, repo, entry.Path,
+			branch)
+        if err != nil {
+            continue
+        }
+        for _, f := range files {
+            if f.Type == "file" && runtimeSignalFiles[f.Name] {
+                matches = append(matches, entry.Path)
+                break
+            }
+        }
+    }
+
+    if len(matches) == 0 {
+        return "", nil
+    }
+    if len(matches) > 1 {
+        log.Warn().Str("repo", owner+"/"+repo).Strs("candidates", matches).
+			Msg("Multiple subdirectories look like app roots, using first")
+    }
+    return matches[0], nil
+}
+
+// Returns the name of the directory's compose file ("" if none)
+func findComposeFile(ctx context.Context, client *github.Client, owner,
+	repo, branch, checkPath string) string {
+    contents, err := client.GetRepoContents(ctx, owner, repo, checkPath,
+		branch)
+    if err != nil {
+        return ""
+    }
+
+    present := map[string]bool{}
+    for _, entry := range contents {
+        if entry.Type == "file" {
+            present[entry.Name] = true
+        }
+    }
+    for _, name := range composeFiles {
+        if present[name] {
+            return name
+        }
+    }
+    return ""
+}
+
+// Checks a single directory for runtime files (empty path = repo root)
+func detectRuntimeAt(ctx context.Context, client *github.Client, owner, repo,
+	branch, checkPath string) (*RuntimeInfo, error) {
+    // A compose file means several services, which one container can't
+    // represent; checked before the Dockerfile, which is often just one
+    // of them
+    if name := findComposeFile(ctx, client, owner, repo, branch,
+		checkPath); name != "" {
+        return &RuntimeInfo{
+            Runtime:        RuntimeDockerCompose,
+            WarningMessage: name + " found: multi-service projects aren't " +
+                "supported yet. Set root_directory to a single service " +
+                "with its own Dockerfile or runtime files",
+        }, nil
+    }
+
     // Check for Dockerfile first (highest priority - user has custom build)
     if exists, _ := client.FileExists(ctx, owner, repo, joinPath(checkPath,
 		"Dockerfile"), branch); exists {
@@ -53,46 +190,49 @@ func DetectRuntime(ctx context.Context, client *github.Client, owner, repo,
         return detectNodeJSRuntime(ctx, client, owner, repo, branch, checkPath)
     }
 
-    // Check for Python
-    if exists, _ := client.FileExists(ctx, owner, repo, joinPath(checkPath,
-		"requirements.txt"), branch); exists {
-        return &RuntimeInfo{
-            Runtime:      RuntimePython,
-            BuildCommand: "pip install -r requirements.txt",
-            StartCommand: "python app.py",
-            Port:         8000,
-        }, nil
+    // Check for Python (requirements.txt, pyproject.toml or Pipfile)
+    if info, err := detectPythonRuntime(ctx, client, owner, repo, branch,
+		checkPath); err != nil || info != nil {
+        return info, err
     }
 
-    if exists, _ := client.FileExists(ctx, owner, repo, joinPath(checkPath,
-		"pyproject.toml"), branch); exists {
-        return &RuntimeInfo{
-            Runtime:      RuntimePython,
-            BuildCommand: "pip install .",
-            StartCommand: "python -m app",
-            Port:         8000,
-        }, nil
-    }
-
-    if exists, _ := client.FileExists(ctx, owner, repo, joinPath(checkPath,
-		"Pipfile"), branch); exists {
-        return &RuntimeInfo{
-            Runtime:      RuntimePython,
-            BuildCommand: "pipenv install",
-            StartCommand: "pipenv run python app.py",
-            Port:         8000,
-        }, nil
-    }
-
-    // Check for Go
+    // Check for Go (main package may live under cmd/)
     if exists, _ := client.FileExists(ctx, owner, repo, joinPath(checkPath,
 		"go.mod"), branch); exists {
-        return &RuntimeInfo{
+        mainPkg, err := DetectGoMainPackage(ctx, client, owner, repo, branch,
+			checkPath)
+        if err != nil {
+            log.Warn().Err(err).Str("repo", owner+"/"+repo).
+				Msg("Failed to detect Go main package, using root")
+        }
+        info := &RuntimeInfo{
             Runtime:      RuntimeGo,
-            BuildCommand: "go build -o app .",
+            BuildCommand: "go build -o app " + mainPkg,
             StartCommand: "./app",
             Port:         8080,
-        }, nil
+            MainPackage:  mainPkg,
+        }
+        if DetectGoReleaser(ctx, client, owner, repo, branch, checkPath) {
+            info.Suggestion = "GoReleaser config found; consider a " +
+				"Dockerfile that runs `goreleaser build --single-target`"
+        }
+        return info, nil
+    }
+
+    // Check for Elixir (Phoenix apps are told apart by their deps)
+    if exists, _ := client.FileExists(ctx, owner, repo, joinPath(checkPath,
+		"mix.exs"), branch); exists {
+        info := &RuntimeInfo{
+            Runtime:      RuntimeElixir,
+            BuildCommand: "mix deps.get && mix compile",
+            StartCommand: "mix run --no-halt",
+            Port:         4000,
+        }
+        if data, err := client.GetFileContents(ctx, owner, repo,
+			joinPath(checkPath, "mix.exs"), branch); err == nil {
+            applyMixExs(info, data)
+        }
+        return info, nil
     }
 
     // Check for static site (index.html)
@@ -119,27 +259,35 @@ func DetectRuntime(ctx context.Context, client *github.Client, owner, repo,
 func detectNodeJSRuntime(ctx context.Context, client *github.Client, owner,
 	repo, branch, checkPath string) (*RuntimeInfo, error) {
     info := &RuntimeInfo{
-        Runtime: RuntimeNodeJS,
-        Port:    3000,
+        Runtime:     RuntimeNodeJS,
+        Port:        3000,
+        NodeVersion: defaultNodeVersion,
+    }
+
+    // Module type & engine version drive the generated Dockerfile
+    if data, err := client.GetFileContents(ctx, owner, repo,
+		joinPath(checkPath, "package.json"), branch); err == nil {
+        applyPackageJSON(info, data)
     }
 
     // Determine package manager
-    packageManager := "npm"
+    packageManager := NodeNPM
     runCmd := "npm run"
 
     if exists, _ := client.FileExists(ctx, owner, repo,
-		joinPath(checkPath,"pnpm-lock.yaml"), branch); exists {
-        packageManager = "pnpm"
+		joinPath(checkPath, nodeLockfiles[NodePNPM]), branch); exists {
+        packageManager = NodePNPM
         runCmd = "pnpm"
     } else if exists, _ := client.FileExists(ctx, owner, repo,
-		joinPath(checkPath, "yarn.lock"), branch); exists {
-        packageManager = "yarn"
+		joinPath(checkPath, nodeLockfiles[NodeYarn]), branch); exists {
+        packageManager = NodeYarn
         runCmd = "yarn"
     } else if exists, _ := client.FileExists(ctx, owner, repo,
-		joinPath(checkPath, "bun.lockb"), branch); exists {
-        packageManager = "bun"
+		joinPath(checkPath, nodeLockfiles[NodeBun]), branch); exists {
+        packageManager = NodeBun
         runCmd = "bun run"
     }
+    info.PackageManager = packageManager
 
     // Check for Next.js
     if exists, _ := client.FileExists(ctx, owner, repo,
@@ -197,52 +345,141 @@ func GetDockerfileForRuntime(info *RuntimeInfo, buildCmd,
 	startCmd string) string {
     switch info.Runtime {
     case RuntimeNodeJS:
-        return generateNodeJSDockerfile(buildCmd, startCmd, info.Port)
+        return generateNodeJSDockerfile(info, info.PackageManager, buildCmd,
+			startCmd)
     case RuntimePython:
-        return generatePythonDockerfile(buildCmd, startCmd, info.Port)
+        return generatePythonDockerfile(info.PackageManager, startCmd,
+			info.Port)
     case RuntimeGo:
-        return generateGoDockerfile(buildCmd, info.Port)
+        return generateGoDockerfile(info.MainPackage, info.Port)
     case RuntimeStatic:
         return generateStaticDockerfile()
+    case RuntimeElixir:
+        return generateElixirDockerfile(info, startCmd)
     default:
         return ""
     }
 }
 
-func generateNodeJSDockerfile(buildCmd, startCmd string, port int) string {
-    return `FROM node:20-alpine AS builder
+// Base image follows engines.node (bun apps use the bun image); the
+// dependency layer installs from the package manager's lockfile
+// See nodeStartInstruction for the CMD
+func generateNodeJSDockerfile(info *RuntimeInfo, packageManager, buildCmd,
+	startCmd string) string {
+    nodeVersion := info.NodeVersion
+    if nodeVersion == "" {
+        nodeVersion = defaultNodeVersion
+    }
+    image := "node:" + nodeVersion + "-alpine"
+
+    // runtimeSetup is repeated in the final stage, where the start command
+    // (e.g. pnpm start) runs
+    var install, runtimeSetup string
+    switch packageManager {
+    case NodePNPM:
+        install = `COPY package.json pnpm-lock.yaml ./
+RUN corepack enable pnpm && pnpm install --frozen-lockfile`
+        runtimeSetup = "RUN corepack enable pnpm\n"
+    case NodeYarn:
+        install = `COPY package.json yarn.lock ./
+RUN yarn install --frozen-lockfile`
+    case NodeBun:
+        image = "oven/bun:latest"
+        install = `COPY package.json bun.lockb ./
+RUN bun install --frozen-lockfile`
+    default:
+        install = `COPY package*.json ./
+RUN npm ci`
+    }
+
+    return `FROM ` + image + ` AS builder
 WORKDIR /app
-COPY package*.json ./
-RUN npm ci
+` + install + `
 COPY . .
 RUN ` + buildCmd + `
 
-FROM node:20-alpine
+FROM ` + image + `
 WORKDIR /app
-COPY --from=builder /app .
-EXPOSE ` + itoa(port) + `
-CMD ["` + startCmd + `"]
+` + runtimeSetup + `COPY --from=builder /app .
+EXPOSE ` + itoa(info.Port) + `
+` + nodeStartInstruction(info, startCmd) + `
 `
 }
 
-func generatePythonDockerfile(buildCmd, startCmd string, port int) string {
-    return `FROM python:3.11-slim
+// Multi-stage build: dependencies are installed into a virtualenv in the
+// builder stage, then only /venv & the app code are copied to the final image
+func generatePythonDockerfile(packageManager, startCmd string,
+	port int) string {
+    var install string
+    switch packageManager {
+    case PythonPoetry:
+        install = `COPY pyproject.toml poetry.lock ./
+RUN pip install --no-cache-dir poetry poetry-plugin-export && \
+    poetry export -f requirements.txt --without-hashes | \
+    /venv/bin/pip install --no-cache-dir -r /dev/stdin`
+    case PythonPipenv:
+        install = `COPY Pipfile* ./
+RUN pip install --no-cache-dir pipenv && \
+    pipenv requirements | /venv/bin/pip install --no-cache-dir -r /dev/stdin`
+    case PythonPyproject:
+        install = `COPY . .
+RUN /venv/bin/pip install --no-cache-dir .`
+    default:
+        install = `COPY requirements.txt ./
+RUN /venv/bin/pip install --no-cache-dir -r requirements.txt`
+    }
+
+    return `FROM python:3.12-slim AS builder
+WORKDIR /app
+RUN python -m venv /venv
+` + install + `
+
+FROM python:3.12-slim
 WORKDIR /app
-COPY requirements.txt ./
-RUN pip install --no-cache-dir -r requirements.txt
+ENV PATH="/venv/bin:$PATH" \
+    PYTHONUNBUFFERED=1
+COPY --from=builder /venv /venv
 COPY . .
 EXPOSE ` + itoa(port) + `
-CMD ["` + startCmd + `"]
+CMD ` + startCmd + `
 `
 }
 
-func generateGoDockerfile(buildCmd string, port int) string {
+// Detects the Python dependency installer from files in a checked-out repo
+func DetectPythonPackageManager(dir string) string {
+    if fileExists(filepath.Join(dir, "pyproject.toml")) &&
+		fileExists(filepath.Join(dir, "poetry.lock")) {
+        return PythonPoetry
+    }
+    if fileExists(filepath.Join(dir, "requirements.txt")) {
+        return PythonPip
+    }
+    if fileExists(filepath.Join(dir, "Pipfile")) {
+        return PythonPipenv
+    }
+    if fileExists(filepath.Join(dir, "pyproject.toml")) {
+        return PythonPyproject
+    }
+    return PythonPip
+}
+
+// Reports whether a regular file exists on local disk
+func fileExists(path string) bool {
+    info, err := os.Stat(path)
+    return err == nil && !info.IsDir()
+}
+
+// Builds mainPkg (see DetectGoProject); blank means the module root
+func generateGoDockerfile(mainPkg string, port int) string {
+    if mainPkg == "" {
+        mainPkg = "."
+    }
     return `FROM golang:1.22-alpine AS builder
 WORKDIR /app
 COPY go.mod go.sum ./
 RUN go mod download
 COPY . .
-RUN CGO_ENABLED=0 GOOS=linux go build -o app .
+RUN CGO_ENABLED=0 GOOS=linux go build -o app ` + mainPkg + `
 
 FROM alpine:latest
 RUN apk --no-cache add ca-certificates
@@ -261,6 +498,78 @@ CMD ["nginx", "-g", "daemon off;"]
 `
 }
 
+// Compiles in prod mode; Phoenix apps also build their assets
+// APP_NAME is set from mix.exs for release/runtime scripts that need it
+func generateElixirDockerfile(info *RuntimeInfo, startCmd string) string {
+    if startCmd == "" {
+        startCmd = "mix run --no-halt"
+    }
+
+    assets := ""
+    if info.Framework == FrameworkPhoenix {
+        assets = "RUN mix assets.deploy\n"
+    }
+
+    return `FROM elixir:1.16-alpine
+RUN apk add --no-cache build-base git
+WORKDIR /app
+ENV MIX_ENV=prod \
+    APP_NAME=` + info.AppName + `
+RUN mix local.hex --force && mix local.rebar --force
+COPY mix.exs mix.lock* ./
+RUN mix deps.get --only prod
+COPY . .
+RUN mix compile
+` + assets + `EXPOSE ` + itoa(info.Port) + `
+CMD ` + startCmd + `
+`
+}
+
+// Returns .dockerignore contents suited to the runtime
+// Keeps the build context small (e.g. node_modules can be hundreds of MB)
+func GenerateDockerignore(runtime Runtime) string {
+    common := `.git
+.gitignore
+.dockerignore
+.env
+.env.*
+.DS_Store
+*.log
+`
+
+    switch runtime {
+    case RuntimeNodeJS:
+        return common + `node_modules
+.next/cache
+npm-debug.log*
+yarn-debug.log*
+yarn-error.log*
+coverage
+`
+    case RuntimePython:
+        return common + `.venv
+venv
+__pycache__
+*.pyc
+*.pyo
+.pytest_cache
+.mypy_cache
+`
+    case RuntimeGo:
+        // The generated Dockerfile runs `go mod download`, so vendor is unused
+        return common + `vendor
+*.test
+`
+    case RuntimeElixir:
+        // Rebuilt inside the image for linux/prod
+        return common + `_build
+deps
+`
+    default:
+        return common
+    }
+}
+
 func itoa(i int) string {
     return fmt.Sprintf("%d", i)
 }
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// Redis client for short-lived response caching
+var client *redis.Client
+
+// Initialize Redis cache client
+// Accepts either host:port or a redis:// URL
+func Connect(redisAddr string) error {
+	opts := &redis.Options{Addr: redisAddr}
+	if strings.HasPrefix(redisAddr, "redis://") ||
+		strings.HasPrefix(redisAddr, "rediss://") {
+		parsed, err := redis.ParseURL(redisAddr)
+		if err != nil {
+			return err
+		}
+		opts = parsed
+	}
+
+	client = redis.NewClient(opts)
+	log.Info().Str("redis_addr", opts.Addr).Msg("Connected to Redis cache")
+	return nil
+}
+
+// Returns the shared Redis client (nil before Connect)
+// For pub/sub users such as live build logs
+func Client() *redis.Client {
+	return client
+}
+
+// Close Redis cache client
+func Close() error {
+	if client != nil {
+		return client.Close()
+	}
+	return nil
+}
+
+// Returns a cached value; ok is false on a miss or when Redis is unavailable
+func Get(ctx context.Context, key string) (value []byte, ok bool) {
+	if client == nil {
+		return nil, false
+	}
+
+	value, err := client.Get(ctx, key).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Warn().Err(err).Str("key", key).Msg("Cache read failed")
+		}
+		return nil, false
+	}
+	return value, true
+}
+
+// Stores a value with a TTL (failures are logged, never fatal)
+func Set(ctx context.Context, key string, value []byte, ttl time.Duration) {
+	if client == nil {
+		return
+	}
+
+	if err := client.Set(ctx, key, value, ttl).Err(); err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("Cache write failed")
+	}
+}
+
+// Counts a hit against key's fixed window & reports whether it's within
+// limit, plus how long until the window resets. Unlike Get & Set, a Redis
+// failure is returned so callers can fail closed.
+func Allow(ctx context.Context, key string, limit int,
+	window time.Duration) (allowed bool, retryAfter time.Duration,
+	err error) {
+	if client == nil {
+		return false, 0, errors.New("redis cache not connected")
+	}
+
+	pipe := client.TxPipeline()
+	count := pipe.Incr(ctx, key)
+	// Only the first hit starts the window
+	pipe.ExpireNX(ctx, key, window)
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, 0, err
+	}
+
+	return count.Val() <= int64(limit), ttl.Val(), nil
+}
@@ -1,18 +1,34 @@
 package containers
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
+	"github.com/moby/patternmatcher/ignorefile"
 	"github.com/rs/zerolog/log"
 )
 
@@ -23,10 +39,151 @@ type DeployConfig struct {
 	Port          int
 	EnvVars       map[string]string
 	Slug          string
+	Environment   string
 	BaseDomain    string
+	PRNumber      int // Non-zero for pull request previews
+	// Traefik middlewares in front of the app; empty means none
+	BasicAuthUsers []string // htpasswd entries (user:hash)
+	IPAllowlist    []string // IPs or CIDRs allowed through
+	// Run inside the container once it's up; the deploy fails unless it
+	// exits 0 within the timeout (DefaultReadinessProbeTimeout if zero)
+	ReadinessProbeCmd     string
+	ReadinessProbeTimeout time.Duration
+}
+
+// Returned when the Docker daemon can't be reached
+var ErrDockerUnavailable = errors.New("docker daemon unavailable")
+
+// Point-in-time resource usage of a container
+type ContainerStats struct {
+	CPUPercent    float64 `json:"cpu_percent"`
+	MemoryUsageMB float64 `json:"memory_usage_mb"`
+	MemoryLimitMB float64 `json:"memory_limit_mb"`
+	MemoryPercent float64 `json:"memory_percent"`
+	OnlineCPUs    uint32  `json:"online_cpus"`
+}
+
+// Represents a container created by RCNbuild
+type ManagedContainer struct {
+	ID    string
+	Name  string
+	Slug  string
+	State string
+}
+
+// Returns the base domain for deployed apps (BASE_DOMAIN env var)
+func BaseDomain() string {
+	baseDomain := os.Getenv("BASE_DOMAIN")
+	if baseDomain == "" {
+		baseDomain = "rcnbuild.dev"
+	}
+	return baseDomain
+}
+
+// Default target platform for image builds
+const defaultBuildPlatform = "linux/amd64"
+
+// Returns the platform single-platform builds target (BUILD_PLATFORM)
+// Set it to linux/arm64 on ARM workers (e.g. AWS Graviton)
+func BuildPlatform() string {
+	if platform := os.Getenv("BUILD_PLATFORM"); platform != "" {
+		return platform
+	}
+	return defaultBuildPlatform
+}
+
+// Returns the platforms for multi-platform builds (BUILD_PLATFORMS,
+// comma-separated); nil means build for BuildPlatform only
+func BuildPlatforms() []string {
+	var platforms []string
+	for _, p := range strings.Split(os.Getenv("BUILD_PLATFORMS"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			platforms = append(platforms, p)
+		}
+	}
+	return platforms
+}
+
+// Returns the subdomain for a project's environment
+// production -> {slug}, staging -> staging-{slug}, preview -> preview-{slug}
+func Subdomain(slug, environment string) string {
+	if environment == "" || environment == "production" {
+		return slug
+	}
+	return environment + "-" + slug
+}
+
+// Returns the Docker network Traefik routes over (TRAEFIK_NETWORK env var)
+func TraefikNetwork() string {
+	networkName := os.Getenv("TRAEFIK_NETWORK")
+	if networkName == "" {
+		networkName = "rcnbuild-network"
+	}
+	return networkName
+}
+
+// Returns the prefix Traefik reads labels from (TRAEFIK_LABEL_PREFIX)
+// Must match the Traefik instance's provider configuration
+func TraefikLabelPrefix() string {
+	prefix := os.Getenv("TRAEFIK_LABEL_PREFIX")
+	if prefix == "" {
+		prefix = "traefik"
+	}
+	return prefix
+}
+
+// Creates the named bridge network unless it already exists
+// The client always sends CheckDuplicate, so a concurrent create by
+// another worker surfaces as a conflict, which is treated as success
+func EnsureNetworkExists(ctx context.Context, networkName string) error {
+	cli, err := client.NewClientWithOpts(client.FromEnv,
+		client.WithAPIVersionNegotiation())
+	if err != nil {
+		return fmt.Errorf("failed to create Docker client: %w", err)
+	}
+	defer cli.Close()
+
+	return ensureNetwork(ctx, cli, networkName)
+}
+
+func ensureNetwork(ctx context.Context, cli *client.Client,
+	networkName string) error {
+	_, err := cli.NetworkInspect(ctx, networkName, network.InspectOptions{})
+	if err == nil {
+		return nil
+	}
+	if !errdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to inspect network %s: %w", networkName,
+			err)
+	}
+
+	_, err = cli.NetworkCreate(ctx, networkName, network.CreateOptions{
+		Driver: "bridge",
+	})
+	if errdefs.IsConflict(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create network %s: %w", networkName,
+			err)
+	}
+
+	log.Info().Str("network", networkName).Msg("Created Traefik network")
+	return nil
+}
+
+// Returns the slug a pull request preview is routed on: pr{N}-{slug}
+func PreviewSlug(slug string, prNumber int) string {
+	return fmt.Sprintf("pr%d-%s", prNumber, slug)
+}
+
+// Returns the container name used for a project
+func ContainerName(slug string) string {
+	return fmt.Sprintf("rcn-%s", slug)
 }
 
 // Creates and starts a container with Traefik labels
+// Replaces any existing container outright (the "recreate" strategy)
 func Deploy(ctx context.Context, cfg *DeployConfig) (string, error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv,
 		client.WithAPIVersionNegotiation())
@@ -41,6 +198,113 @@ func Deploy(ctx context.Context, cfg *DeployConfig) (string, error) {
 			Msg("Failed to stop existing container (may not exist)")
 	}
 
+	containerID, err := startContainer(ctx, cli, cfg, cfg.ContainerName)
+	if err != nil {
+		return "", err
+	}
+
+	// Don't report success while Traefik would route to a closed port
+	if err := WaitForReady(ctx, cli, containerID, cfg.Port,
+		healthCheckTimeout); err != nil {
+		stopAndRemove(ctx, cli, cfg.ContainerName)
+		return "", fmt.Errorf("container never became ready: %w", err)
+	}
+	if cfg.ReadinessProbeCmd != "" {
+		if err := runReadinessProbe(ctx, cli, containerID,
+			cfg.ReadinessProbeCmd, cfg.ReadinessProbeTimeout); err != nil {
+			stopAndRemove(ctx, cli, cfg.ContainerName)
+			return "", err
+		}
+	}
+
+	log.Info().
+		Str("container_id", containerID[:12]).
+		Str("name", cfg.ContainerName).
+		Str("hostname", RouteHostname(cfg)).
+		Msg("Container started successfully")
+
+	return containerID, nil
+}
+
+// Starts the new container alongside the old one & only removes the old
+// container once the new one is ready, so no requests are dropped.
+// Docker labels are immutable, so the "-green" container is created with
+// the canonical Traefik router/service labels. Traefik load-balances
+// across both as soon as it's running (only a failing HEALTHCHECK keeps
+// it out), so the old container is stopped only after WaitForReady sees
+// the new one serving; the new one is then renamed to the canonical name.
+// oldContainerID is "" on first deploy.
+func BlueGreenDeploy(ctx context.Context,
+	cfg *DeployConfig) (newContainerID, oldContainerID string, err error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv,
+		client.WithAPIVersionNegotiation())
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create Docker client: %w", err)
+	}
+	defer cli.Close()
+
+	oldContainerID, err = findContainerByName(ctx, cli, cfg.ContainerName)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Clear out a green container left behind by a failed cutover
+	greenName := cfg.ContainerName + "-green"
+	if err := stopAndRemove(ctx, cli, greenName); err != nil {
+		log.Warn().Err(err).Str("container", greenName).
+			Msg("Failed to remove stale green container")
+	}
+
+	newContainerID, err = startContainer(ctx, cli, cfg, greenName)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Old container keeps serving if the new one never becomes ready
+	if err := WaitForReady(ctx, cli, newContainerID, cfg.Port,
+		healthCheckTimeout); err != nil {
+		stopAndRemove(ctx, cli, greenName)
+		return "", "", fmt.Errorf("new container never became ready: %w",
+			err)
+	}
+	if cfg.ReadinessProbeCmd != "" {
+		if err := runReadinessProbe(ctx, cli, newContainerID,
+			cfg.ReadinessProbeCmd, cfg.ReadinessProbeTimeout); err != nil {
+			stopAndRemove(ctx, cli, greenName)
+			return "", "", err
+		}
+	}
+
+	if oldContainerID != "" {
+		timeout := 30
+		cli.ContainerStop(ctx, oldContainerID,
+			container.StopOptions{Timeout: &timeout})
+		if err := cli.ContainerRemove(ctx, oldContainerID,
+			container.RemoveOptions{Force: true}); err != nil {
+			log.Warn().Err(err).Str("container_id", oldContainerID[:12]).
+				Msg("Failed to remove old container")
+		}
+	}
+
+	// Take over the canonical name so the next deploy finds it
+	if err := cli.ContainerRename(ctx, newContainerID,
+		cfg.ContainerName); err != nil {
+		log.Warn().Err(err).Str("container_id", newContainerID[:12]).
+			Msg("Failed to rename green container")
+	}
+
+	log.Info().
+		Str("container_id", newContainerID[:12]).
+		Str("name", cfg.ContainerName).
+		Str("hostname", RouteHostname(cfg)).
+		Msg("Blue-green cutover complete")
+
+	return newContainerID, oldContainerID, nil
+}
+
+// Pulls the image, then creates & starts a container named name
+func startContainer(ctx context.Context, cli *client.Client,
+	cfg *DeployConfig, name string) (string, error) {
 	// Pull the image
 	reader, err := cli.ImagePull(ctx, cfg.ImageTag, image.PullOptions{})
 	if err != nil {
@@ -56,27 +320,9 @@ func Deploy(ctx context.Context, cfg *DeployConfig) (string, error) {
 	}
 
 	// Traefik labels for dynamic routing
-	hostname := fmt.Sprintf("%s.%s", cfg.Slug, cfg.BaseDomain)
-	labels := map[string]string{
-		"traefik.enable": "true",
-		// HTTP Router
-		fmt.Sprintf("traefik.http.routers.%s.rule", cfg.Slug):        fmt.Sprintf("Host(`%s`)", hostname),
-		fmt.Sprintf("traefik.http.routers.%s.entrypoints", cfg.Slug): "web",
-		// HTTPS Router
-		fmt.Sprintf("traefik.http.routers.%s-secure.rule", cfg.Slug):        fmt.Sprintf("Host(`%s`)", hostname),
-		fmt.Sprintf("traefik.http.routers.%s-secure.entrypoints", cfg.Slug): "websecure",
-		fmt.Sprintf("traefik.http.routers.%s-secure.tls", cfg.Slug):         "true",
-		// Service port
-		fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.port", cfg.Slug): fmt.Sprintf("%d", cfg.Port),
-		// RCNbuild metadata
-		"rcnbuild.managed": "true",
-		"rcnbuild.slug":    cfg.Slug,
-	}
-
-	// Add Let's Encrypt certresolver if TLS enabled
-	if os.Getenv("TLS_ENABLED") == "true" {
-		labels[fmt.Sprintf("traefik.http.routers.%s-secure.tls.certresolver", cfg.Slug)] = "letsencrypt"
-	}
+	networkName := TraefikNetwork()
+	labels := buildTraefikLabels(cfg, TLSEnabled(),
+		TraefikLabelPrefix(), networkName)
 
 	// Container configuration
 	containerCfg := &container.Config{
@@ -99,16 +345,19 @@ func Deploy(ctx context.Context, cfg *DeployConfig) (string, error) {
 		},
 	}
 
-	// Network configuration - connect to rcnbuild-network for Traefik
+	// Network configuration - connect to Traefik's network
+	if err := ensureNetwork(ctx, cli, networkName); err != nil {
+		return "", err
+	}
 	networkCfg := &network.NetworkingConfig{
 		EndpointsConfig: map[string]*network.EndpointSettings{
-			"rcnbuild-network": {},
+			networkName: {},
 		},
 	}
 
 	// Create the container
 	resp, err := cli.ContainerCreate(ctx, containerCfg, hostCfg, networkCfg, nil,
-		cfg.ContainerName)
+		name)
 	if err != nil {
 		return "", fmt.Errorf("failed to create container: %w", err)
 	}
@@ -118,15 +367,100 @@ func Deploy(ctx context.Context, cfg *DeployConfig) (string, error) {
 		return "", fmt.Errorf("failed to start container: %w", err)
 	}
 
-	log.Info().
-		Str("container_id", resp.ID[:12]).
-		Str("name", cfg.ContainerName).
-		Str("hostname", hostname).
-		Msg("Container started successfully")
-
 	return resp.ID, nil
 }
 
+// How long a new container gets to become healthy
+const healthCheckTimeout = 2 * time.Minute
+
+// Containers whose port can't be checked count as ready after running
+// this long
+const healthGracePeriod = 5 * time.Second
+
+// Blocks until the container accepts TCP connections on port
+// Containers with a HEALTHCHECK are judged by its status instead, since an
+// open port doesn't mean the app can serve yet. If the port can't be
+// checked at all, the container counts as ready once it has been running
+// for healthGracePeriod
+func WaitForReady(ctx context.Context, cli *client.Client, containerID string,
+	port int, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	networkName := TraefikNetwork()
+	var runningSince time.Time
+	for {
+		info, err := cli.ContainerInspect(ctx, containerID)
+		if err != nil {
+			return err
+		}
+
+		state := info.State
+		if state != nil && state.Status == "exited" {
+			return fmt.Errorf("container exited with code %d", state.ExitCode)
+		}
+
+		if state == nil || !state.Running {
+			runningSince = time.Time{}
+		} else if state.Health != nil {
+			switch state.Health.Status {
+			case "healthy":
+				return nil
+			case "unhealthy":
+				return errors.New("container reported unhealthy")
+			}
+		} else {
+			if runningSince.IsZero() {
+				runningSince = time.Now()
+			}
+			open, err := portOpen(ctx, cli, info, networkName, port)
+			if open {
+				return nil
+			}
+			if err != nil && time.Since(runningSince) >= healthGracePeriod {
+				log.Warn().Err(err).Str("container_id", containerID[:12]).
+					Msg("Can't check container port, assuming ready")
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Returns the container's address on the named network ("" until assigned)
+func containerIP(info types.ContainerJSON, networkName string) string {
+	if info.NetworkSettings == nil {
+		return ""
+	}
+	if ep, ok := info.NetworkSettings.Networks[networkName]; ok && ep != nil {
+		return ep.IPAddress
+	}
+	return ""
+}
+
+// Checks the Docker daemon answers, returning ErrDockerUnavailable if not
+func Ping(ctx context.Context) error {
+	cli, err := client.NewClientWithOpts(client.FromEnv,
+		client.WithAPIVersionNegotiation())
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	if _, err := cli.Ping(ctx); err != nil {
+		return fmt.Errorf("%w: %v", ErrDockerUnavailable, err)
+	}
+	return nil
+}
+
 // Stop stops a running container
 func Stop(ctx context.Context, containerID string) error {
 	cli, err := client.NewClientWithOpts(client.FromEnv,
@@ -156,6 +490,404 @@ func Remove(ctx context.Context, containerID string) error {
 	})
 }
 
+// Returns the size in bytes of a locally available image
+func ImageSize(ctx context.Context, imageTag string) (int64, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv,
+		client.WithAPIVersionNegotiation())
+	if err != nil {
+		return 0, err
+	}
+	defer cli.Close()
+
+	info, _, err := cli.ImageInspectWithRaw(ctx, imageTag)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+// Lists all containers (running or stopped) managed by RCNbuild
+func ListManagedContainers(ctx context.Context) ([]*ManagedContainer, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv,
+		client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+
+	list, err := cli.ContainerList(ctx, container.ListOptions{
+		All: true,
+		Filters: filters.NewArgs(
+			filters.Arg("label", "rcnbuild.managed=true"),
+		),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*ManagedContainer, 0, len(list))
+	for _, c := range list {
+		name := ""
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		result = append(result, &ManagedContainer{
+			ID:    c.ID,
+			Name:  name,
+			Slug:  c.Labels["rcnbuild.slug"],
+			State: c.State,
+		})
+	}
+	return result, nil
+}
+
+// Removes an image from the local Docker daemon
+func RemoveImage(ctx context.Context, imageTag string) error {
+	cli, err := client.NewClientWithOpts(client.FromEnv,
+		client.WithAPIVersionNegotiation())
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	_, err = cli.ImageRemove(ctx, imageTag, image.RemoveOptions{
+		Force:         true,
+		PruneChildren: true,
+	})
+	return err
+}
+
+// Builds an image from a local directory via the Docker SDK
+// dockerfile is an absolute path; one outside contextDir is sent along
+// with the context (see addDockerfileToContext)
+// Returns the plain-text build output; a failed build surfaces as a read
+// error once the output is drained. Caller must close the reader.
+func BuildImage(ctx context.Context, contextDir, dockerfile,
+	imageTag string) (io.ReadCloser, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv,
+		client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+
+	// Honour .dockerignore the same way the docker CLI does
+	excludes, err := readDockerignore(contextDir)
+	if err != nil {
+		cli.Close()
+		return nil, err
+	}
+
+	buildContext, err := archive.TarWithOptions(contextDir,
+		&archive.TarOptions{ExcludePatterns: excludes})
+	if err != nil {
+		cli.Close()
+		return nil, fmt.Errorf("failed to archive build context: %w", err)
+	}
+
+	dockerfileName, err := filepath.Rel(contextDir, dockerfile)
+	if err != nil || dockerfileName == ".." ||
+		strings.HasPrefix(dockerfileName, ".."+string(filepath.Separator)) {
+		buildContext, dockerfileName, err = addDockerfileToContext(
+			buildContext, dockerfile)
+		if err != nil {
+			buildContext.Close()
+			cli.Close()
+			return nil, err
+		}
+	}
+
+	resp, err := cli.ImageBuild(ctx, buildContext, types.ImageBuildOptions{
+		Tags:        []string{imageTag},
+		Dockerfile:  filepath.ToSlash(dockerfileName),
+		Remove:      true,
+		ForceRemove: true,
+		Platform:    BuildPlatform(),
+	})
+	if err != nil {
+		buildContext.Close()
+		cli.Close()
+		return nil, err
+	}
+
+	// Decode the JSON message stream into plain text
+	pr, pw := io.Pipe()
+	go func() {
+		defer cli.Close()
+		defer buildContext.Close()
+		defer resp.Body.Close()
+		pw.CloseWithError(decodeBuildStream(resp.Body, pw))
+	}()
+
+	return pr, nil
+}
+
+// Adds a Dockerfile from outside the build context to the context's tar
+// stream under a random name, the way the docker CLI does. The name (and
+// .dockerignore) are appended to .dockerignore, so the daemon drops both
+// once it has read the Dockerfile & neither ends up in COPY . layers.
+// Returns the new stream & the name to build with
+func addDockerfileToContext(buildContext io.ReadCloser,
+	dockerfile string) (io.ReadCloser, string, error) {
+	content, err := os.ReadFile(dockerfile)
+	if err != nil {
+		return nil, "", err
+	}
+	suffix := make([]byte, 10)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, "", err
+	}
+	name := ".dockerfile." + hex.EncodeToString(suffix)
+	now := time.Now()
+
+	return archive.ReplaceFileTarWrapper(buildContext,
+		map[string]archive.TarModifierFunc{
+			name: func(string, *tar.Header, io.Reader) (*tar.Header,
+				[]byte, error) {
+				return &tar.Header{
+					Name:     name,
+					Mode:     0o600,
+					ModTime:  now,
+					Typeflag: tar.TypeReg,
+				}, content, nil
+			},
+			".dockerignore": func(_ string, header *tar.Header,
+				existing io.Reader) (*tar.Header, []byte, error) {
+				if header == nil {
+					header = &tar.Header{
+						Name:     ".dockerignore",
+						Mode:     0o600,
+						ModTime:  now,
+						Typeflag: tar.TypeReg,
+					}
+				}
+				var ignore bytes.Buffer
+				if existing != nil {
+					if _, err := io.Copy(&ignore, existing); err != nil {
+						return nil, nil, err
+					}
+				}
+				ignore.WriteString("\n.dockerignore\n" + name + "\n")
+				return header, ignore.Bytes(), nil
+			},
+		}), name, nil
+}
+
+// Builds an image with BuildKit secrets (secret id -> file path)
+// The SDK can only attach secrets through a BuildKit session, so this runs
+// the docker CLI instead; dockerfile is an absolute path & may be outside
+// contextDir. Output matches BuildImage: plain text, with a
+// failed build surfacing as a read error. Caller must close the reader.
+func BuildImageWithSecrets(ctx context.Context, contextDir, dockerfile,
+	imageTag string, secrets map[string]string) (io.ReadCloser, error) {
+	args := []string{"build", "--progress=plain",
+		"--platform", BuildPlatform(), "-t", imageTag,
+		"-f", dockerfile}
+	args = append(args, secretArgs(secrets)...)
+	args = append(args, contextDir)
+
+	return runDockerBuild(ctx, args)
+}
+
+// Builds an image for several platforms with buildx & pushes it
+// A multi-platform image can't be loaded into the local daemon, so it goes
+// straight to the registry; no separate push is needed. Secrets & output
+// are handled as in BuildImageWithSecrets.
+func BuildMultiPlatformImage(ctx context.Context, contextDir, dockerfile,
+	imageTag string, platforms []string,
+	secrets map[string]string) (io.ReadCloser, error) {
+	args := []string{"buildx", "build", "--progress=plain",
+		"--platform", strings.Join(platforms, ","), "-t", imageTag, "--push",
+		"-f", dockerfile}
+	args = append(args, secretArgs(secrets)...)
+	args = append(args, contextDir)
+
+	return runDockerBuild(ctx, args)
+}
+
+// Returns --secret flags for each secret, in a stable order
+func secretArgs(secrets map[string]string) []string {
+	ids := make([]string, 0, len(secrets))
+	for id := range secrets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	args := make([]string, 0, 2*len(ids))
+	for _, id := range ids {
+		args = append(args, "--secret", "id="+id+",src="+secrets[id])
+	}
+	return args
+}
+
+// Runs a docker CLI build with BuildKit, streaming its combined output
+// A non-zero exit surfaces as a read error once the output is drained
+func runDockerBuild(ctx context.Context, args []string) (io.ReadCloser,
+	error) {
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Env = append(os.Environ(), "DOCKER_BUILDKIT=1")
+
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		pw.Close()
+		return nil, err
+	}
+
+	go func() {
+		err := cmd.Wait()
+		if err != nil {
+			err = fmt.Errorf("docker %s exited: %w", args[0], err)
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return pr, nil
+}
+
+// Copies build output to w, returning the daemon's error if the build failed
+func decodeBuildStream(r io.Reader, w io.Writer) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if msg.Error != nil {
+			return errors.New(msg.Error.Message)
+		}
+		if msg.Stream != "" {
+			if _, err := io.WriteString(w, msg.Stream); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Reads exclude patterns from a build context's .dockerignore (if any)
+func readDockerignore(contextDir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(contextDir, ".dockerignore"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	return ignorefile.ReadAll(f)
+}
+
+// Public hostname a deployed container is routed on
+// Previews get pr{N}-{slug} regardless of the project's environment
+func RouteHostname(cfg *DeployConfig) string {
+	return fmt.Sprintf("%s.%s", routeSlug(cfg), cfg.BaseDomain)
+}
+
+// Subdomain label a container is routed on
+func routeSlug(cfg *DeployConfig) string {
+	if cfg.PRNumber > 0 {
+		return PreviewSlug(cfg.Slug, cfg.PRNumber)
+	}
+	return Subdomain(cfg.Slug, cfg.Environment)
+}
+
+// Builds the Traefik routing & RCNbuild metadata labels for a container
+// With TLS enabled, certs come from Let's Encrypt & HTTP redirects to HTTPS
+// prefix is Traefik's label prefix; networkName is pinned so Traefik never
+// picks another network the container may be attached to
+func buildTraefikLabels(cfg *DeployConfig, tlsEnabled bool,
+	prefix, networkName string) map[string]string {
+	hostname := RouteHostname(cfg)
+	name := cfg.Slug
+	if cfg.PRNumber > 0 {
+		name = PreviewSlug(cfg.Slug, cfg.PRNumber)
+	}
+	router := prefix + ".http.routers." + name
+	secureRouter := router + "-secure"
+
+	labels := map[string]string{
+		prefix + ".enable":         "true",
+		prefix + ".docker.network": networkName,
+		// HTTP Router
+		router + ".rule":        fmt.Sprintf("Host(`%s`)", hostname),
+		router + ".entrypoints": "web",
+		// HTTPS Router
+		secureRouter + ".rule":        fmt.Sprintf("Host(`%s`)", hostname),
+		secureRouter + ".entrypoints": "websecure",
+		secureRouter + ".tls":         "true",
+		// Service port
+		prefix + ".http.services." + name +
+			".loadbalancer.server.port": fmt.Sprintf("%d", cfg.Port),
+		// RCNbuild metadata
+		"rcnbuild.managed": "true",
+		"rcnbuild.slug":    cfg.Slug,
+	}
+	if cfg.PRNumber > 0 {
+		labels["rcnbuild.pr_number"] = fmt.Sprintf("%d", cfg.PRNumber)
+	}
+
+	if tlsEnabled {
+		// Let's Encrypt certresolver
+		labels[secureRouter+".tls.certresolver"] = "letsencrypt"
+
+		// Redirect plain HTTP to HTTPS
+		labels[prefix+".http.middlewares.redirect-to-https.redirectscheme.scheme"] = "https"
+		labels[router+".middlewares"] = "redirect-to-https"
+	}
+
+	// Project middlewares; the allowlist goes first so blocked clients
+	// never see an auth prompt
+	var chain []string
+	if len(cfg.IPAllowlist) > 0 {
+		mw := name + "-ip_allowlist"
+		labels[prefix+".http.middlewares."+mw+".ipallowlist.sourcerange"] =
+			strings.Join(cfg.IPAllowlist, ",")
+		chain = append(chain, mw)
+	}
+	if len(cfg.BasicAuthUsers) > 0 {
+		mw := name + "-basic_auth"
+		labels[prefix+".http.middlewares."+mw+".basicauth.users"] =
+			strings.Join(cfg.BasicAuthUsers, ",")
+		chain = append(chain, mw)
+	}
+	if len(chain) > 0 {
+		labels[secureRouter+".middlewares"] = strings.Join(chain, ",")
+		// Without TLS the HTTP router serves the app instead of redirecting
+		if !tlsEnabled {
+			labels[router+".middlewares"] = strings.Join(chain, ",")
+		}
+	}
+
+	return labels
+}
+
+// Returns the ID of the container with exactly this name ("" if none)
+func findContainerByName(ctx context.Context, cli *client.Client,
+	name string) (string, error) {
+	containers, err := cli.ContainerList(ctx, container.ListOptions{
+		All: true,
+		Filters: filters.NewArgs(
+			filters.Arg("name", name),
+		),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for _, c := range containers {
+		for _, n := range c.Names {
+			if strings.TrimPrefix(n, "/") == name {
+				return c.ID, nil
+			}
+		}
+	}
+	return "", nil
+}
+
 // Stops and removes a container by name
 func stopAndRemove(ctx context.Context, cli *client.Client, name string) error {
 	// Find container by name
@@ -213,3 +945,87 @@ func GetContainerLogs(ctx context.Context, containerID string, tail int) (string
 
 	return string(logs), nil
 }
+
+// Follows a container's logs, demultiplexing stdout & stderr
+// Blocks until the container stops or ctx is cancelled
+func StreamContainerLogs(ctx context.Context, containerID, since string,
+	stdout, stderr io.Writer) error {
+	cli, err := client.NewClientWithOpts(client.FromEnv,
+		client.WithAPIVersionNegotiation())
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	reader, err := cli.ContainerLogs(ctx, containerID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+		Since:      since,
+	})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	// Each 8-byte header frame is written to the matching writer
+	_, err = stdcopy.StdCopy(stdout, stderr, reader)
+	return err
+}
+
+// Returns a single (non-streaming) CPU & memory snapshot for a container
+func GetContainerStats(ctx context.Context,
+	containerID string) (*ContainerStats, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv,
+		client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDockerUnavailable, err)
+	}
+	defer cli.Close()
+
+	resp, err := cli.ContainerStats(ctx, containerID, false)
+	if err != nil {
+		if client.IsErrConnectionFailed(err) {
+			return nil, fmt.Errorf("%w: %v", ErrDockerUnavailable, err)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var raw container.StatsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to decode container stats: %w", err)
+	}
+
+	stats := &ContainerStats{}
+
+	// CPU % = CPUDelta / SystemCPUDelta * NCPUs * 100
+	onlineCPUs := raw.CPUStats.OnlineCPUs
+	if onlineCPUs == 0 {
+		onlineCPUs = uint32(len(raw.CPUStats.CPUUsage.PercpuUsage))
+	}
+	stats.OnlineCPUs = onlineCPUs
+	cpuDelta := float64(raw.CPUStats.CPUUsage.TotalUsage) -
+		float64(raw.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(raw.CPUStats.SystemUsage) -
+		float64(raw.PreCPUStats.SystemUsage)
+	if cpuDelta > 0 && systemDelta > 0 {
+		stats.CPUPercent = cpuDelta / systemDelta * float64(onlineCPUs) * 100
+	}
+
+	// Memory usage excludes page cache (matches `docker stats`)
+	memUsage := raw.MemoryStats.Usage
+	if cache, ok := raw.MemoryStats.Stats["inactive_file"]; ok &&
+		cache < memUsage {
+		memUsage -= cache
+	}
+	const mb = 1024 * 1024
+	stats.MemoryUsageMB = float64(memUsage) / mb
+	stats.MemoryLimitMB = float64(raw.MemoryStats.Limit) / mb
+	if raw.MemoryStats.Limit > 0 {
+		stats.MemoryPercent = float64(memUsage) /
+			float64(raw.MemoryStats.Limit) * 100
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,133 @@
+package containers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// How long a readiness probe may keep failing when the project sets none
+const DefaultReadinessProbeTimeout = 60 * time.Second
+
+// Pause between readiness probe attempts
+const readinessProbeInterval = 2 * time.Second
+
+// Runs cmd (through sh -c) inside the container until it exits 0
+// Fails with the last exit code once timeout passes
+func runReadinessProbe(ctx context.Context, cli *client.Client,
+	containerID, cmd string, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = DefaultReadinessProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(readinessProbeInterval)
+	defer ticker.Stop()
+
+	var lastErr error
+	for {
+		exitCode, err := execExitCode(ctx, cli, containerID, cmd)
+		if err == nil && exitCode == 0 {
+			return nil
+		}
+		if err == nil {
+			lastErr = fmt.Errorf("readiness probe exited with code %d",
+				exitCode)
+		} else if !errors.Is(err, context.DeadlineExceeded) {
+			lastErr = err
+		}
+
+		select {
+		case <-ctx.Done():
+			if lastErr == nil {
+				lastErr = ctx.Err()
+			}
+			return fmt.Errorf("readiness probe failed after %s: %w",
+				timeout, lastErr)
+		case <-ticker.C:
+		}
+	}
+}
+
+// Runs cmd in the container & waits for its exit code
+func execExitCode(ctx context.Context, cli *client.Client, containerID,
+	cmd string) (int, error) {
+	exec, err := cli.ContainerExecCreate(ctx, containerID,
+		container.ExecOptions{Cmd: []string{"sh", "-c", cmd}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create probe exec: %w", err)
+	}
+	if err := cli.ContainerExecStart(ctx, exec.ID,
+		container.ExecStartOptions{Detach: true}); err != nil {
+		return 0, fmt.Errorf("failed to start probe exec: %w", err)
+	}
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		info, err := cli.ContainerExecInspect(ctx, exec.ID)
+		if err != nil {
+			return 0, err
+		}
+		if !info.Running {
+			return info.ExitCode, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reports whether the container is listening on port
+// Dials its address on the network when the worker can reach it; when it
+// can't (e.g. the worker isn't on Docker's bridge), looks for a listening
+// socket from inside the container instead. err is set only if neither
+// check could run
+func portOpen(ctx context.Context, cli *client.Client,
+	info types.ContainerJSON, networkName string, port int) (bool, error) {
+	if ip := containerIP(info, networkName); ip != "" {
+		addr := net.JoinHostPort(ip, strconv.Itoa(port))
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			return true, nil
+		}
+		// Refused: the address is routable, nothing is listening yet
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return false, nil
+		}
+	}
+
+	exitCode, err := execExitCode(ctx, cli, info.ID, listeningSocketCmd(port))
+	if err != nil {
+		return false, err
+	}
+	switch exitCode {
+	case 0:
+		return true, nil
+	case 1: // grep found no match
+		return false, nil
+	default: // No shell or grep in the image
+		return false, fmt.Errorf("listening socket check exited with code %d",
+			exitCode)
+	}
+}
+
+// Shell command exiting 0 if a TCP socket listens on port, 1 if none does
+// Matches the local port & LISTEN state (0A) in /proc/net/tcp{,6}
+func listeningSocketCmd(port int) string {
+	return fmt.Sprintf("cat /proc/net/tcp /proc/net/tcp6 2>/dev/null | "+
+		"grep -qiE '^ *[0-9]+: [0-9a-f]+:%04X [0-9a-f]+:0000 0A '", port)
+}
@@ -0,0 +1,141 @@
+package containers
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// Signal sent to a container's PID 1 to reload config, unless the project
+// picks another one
+const DefaultReloadSignal = "SIGHUP"
+
+// Where the current env vars are written inside a reloaded container
+// Docker can't change a running container's environment (ContainerUpdate
+// only covers resource limits), so apps must re-read this file on the
+// reload signal; their process environment keeps the values from start
+const ReloadEnvFile = "/.rcnbuild.env"
+
+// Signals a project may choose for reloads; all are harmless to ignore
+// for apps that don't handle them, unlike e.g. SIGTERM
+var reloadSignals = map[string]bool{
+	"SIGHUP":  true,
+	"SIGUSR1": true,
+	"SIGUSR2": true,
+}
+
+// Returned by ReloadEnv when the container isn't running
+var ErrContainerNotRunning = errors.New("container is not running")
+
+// Uppercases a signal name & adds the SIG prefix ("hup" -> "SIGHUP")
+// Returns an error for signals that aren't allowed for reloads
+func NormalizeReloadSignal(signal string) (string, error) {
+	s := strings.ToUpper(strings.TrimSpace(signal))
+	if !strings.HasPrefix(s, "SIG") {
+		s = "SIG" + s
+	}
+	if !reloadSignals[s] {
+		return "", fmt.Errorf(
+			"reload signal must be SIGHUP, SIGUSR1 or SIGUSR2, got %q", signal)
+	}
+	return s, nil
+}
+
+// Writes envVars to ReloadEnvFile in a running container & sends it signal
+// Returns the keys whose values differ from the environment the container
+// was started with (keys removed since then can't be told apart from the
+// image's own env, so they aren't reported)
+// Traefik labels are fixed at creation too; routing doesn't depend on env
+// vars, so there's nothing to refresh there
+func ReloadEnv(ctx context.Context, containerID string,
+	envVars map[string]string, signal string) ([]string, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv,
+		client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+
+	info, err := cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	if info.State == nil || !info.State.Running {
+		return nil, ErrContainerNotRunning
+	}
+
+	started := map[string]string{}
+	if info.Config != nil {
+		for _, kv := range info.Config.Env {
+			if key, value, ok := strings.Cut(kv, "="); ok {
+				started[key] = value
+			}
+		}
+	}
+	changed := []string{}
+	for key, value := range envVars {
+		if current, ok := started[key]; !ok || current != value {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+
+	archive, err := envFileArchive(envVars)
+	if err != nil {
+		return nil, err
+	}
+	if err := cli.CopyToContainer(ctx, containerID, path.Dir(ReloadEnvFile),
+		archive, container.CopyToContainerOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to write env file: %w", err)
+	}
+
+	// Sent by the daemon, so images without a shell or kill binary work too
+	if err := cli.ContainerKill(ctx, containerID, signal); err != nil {
+		return nil, fmt.Errorf("failed to send %s: %w", signal, err)
+	}
+
+	return changed, nil
+}
+
+// Tar archive holding envVars as a dotenv file named after ReloadEnvFile
+// Values are double-quoted with Go escaping, which dotenv parsers accept
+func envFileArchive(envVars map[string]string) (*bytes.Buffer, error) {
+	keys := make([]string, 0, len(envVars))
+	for key := range envVars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var content bytes.Buffer
+	for _, key := range keys {
+		fmt.Fprintf(&content, "%s=%s\n", key, strconv.Quote(envVars[key]))
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    path.Base(ReloadEnvFile),
+		Mode:    0644, // Apps often run as a non-root user
+		Size:    int64(content.Len()),
+		ModTime: time.Now(),
+	}); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(content.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
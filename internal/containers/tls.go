@@ -0,0 +1,113 @@
+package containers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math"
+	"net"
+	"os"
+	"time"
+)
+
+// Days before expiry at which a certificate is flagged
+const (
+	CertWarningDays  = 30 // Shown as a warning in the API
+	CertCriticalDays = 7  // Reported by the nightly check
+)
+
+// How long to wait for the TLS handshake when inspecting a certificate
+const certDialTimeout = 10 * time.Second
+
+// The certificate served for a deployed app's domain
+type CertificateInfo struct {
+	Domain        string    `json:"domain"`
+	ValidFrom     time.Time `json:"valid_from"`
+	ValidUntil    time.Time `json:"valid_until"`
+	DaysRemaining int       `json:"days_remaining"`
+	Issuer        string    `json:"issuer"`
+	// Whether the chain verifies for the domain against the system roots;
+	// if not, VerifyError says why (expired, wrong name, untrusted, ...)
+	Valid       bool   `json:"valid"`
+	VerifyError string `json:"verify_error,omitempty"`
+}
+
+// Reports whether deployed apps are served over HTTPS (TLS_ENABLED)
+// Traefik obtains & renews their Let's Encrypt certificates
+func TLSEnabled() bool {
+	return os.Getenv("TLS_ENABLED") == "true"
+}
+
+// Public hostname a project's (non-preview) deployments are routed on
+// A blank baseDomain means the platform default (BaseDomain)
+func ProjectHostname(slug, environment, baseDomain string) string {
+	if baseDomain == "" {
+		baseDomain = BaseDomain()
+	}
+	return RouteHostname(&DeployConfig{
+		Slug:        slug,
+		Environment: environment,
+		BaseDomain:  baseDomain,
+	})
+}
+
+// Connects to hostname:443 & reads the leaf certificate it serves
+// The chain is verified separately from the handshake, so an expired or
+// mismatched cert is still reported (with Valid false) rather than an
+// error; errors mean the domain couldn't be reached at all
+func InspectCertificate(ctx context.Context,
+	hostname string) (*CertificateInfo, error) {
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: certDialTimeout},
+		Config: &tls.Config{
+			ServerName:         hostname,
+			InsecureSkipVerify: true, // Verified below
+		},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp",
+		net.JoinHostPort(hostname, "443"))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate presented")
+	}
+	leaf := certs[0]
+
+	issuer := leaf.Issuer.CommonName
+	if len(leaf.Issuer.Organization) > 0 {
+		issuer = leaf.Issuer.Organization[0]
+	}
+	info := &CertificateInfo{
+		Domain:     hostname,
+		ValidFrom:  leaf.NotBefore,
+		ValidUntil: leaf.NotAfter,
+		DaysRemaining: int(math.Floor(
+			time.Until(leaf.NotAfter).Hours() / 24)),
+		Issuer: issuer,
+		Valid:  true,
+	}
+	if err := verifyChain(certs, hostname); err != nil {
+		info.Valid = false
+		info.VerifyError = err.Error()
+	}
+	return info, nil
+}
+
+// Verifies a served chain (leaf first) for hostname against the system
+// roots
+func verifyChain(certs []*x509.Certificate, hostname string) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       hostname,
+		Intermediates: intermediates,
+	})
+	return err
+}
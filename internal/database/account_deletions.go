@@ -0,0 +1,134 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Progress of an account deletion
+const (
+	AccountDeletionPending   = "pending"
+	AccountDeletionRunning   = "running"
+	AccountDeletionCompleted = "completed"
+	AccountDeletionFailed    = "failed"
+)
+
+// Tracks the background cleanup of a deleted account
+type AccountDeletion struct {
+	ID              string     `json:"id"`
+	UserID          string     `json:"user_id"`
+	GitHubUsername  string     `json:"github_username"`
+	Status          string     `json:"status"`
+	ProjectsTotal   int        `json:"projects_total"`
+	ProjectsDeleted int        `json:"projects_deleted"`
+	ErrorMessage    *string    `json:"error_message,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	CompletedAt     *time.Time `json:"completed_at,omitempty"`
+}
+
+// Columns selected for an AccountDeletion, in scanAccountDeletion order
+const accountDeletionColumns = `
+	id, user_id, github_username, status, projects_total, projects_deleted,
+	error_message, created_at, completed_at`
+
+// Scans a single row selected with accountDeletionColumns
+func scanAccountDeletion(row pgx.Row) (*AccountDeletion, error) {
+	var d AccountDeletion
+	err := row.Scan(
+		&d.ID, &d.UserID, &d.GitHubUsername, &d.Status, &d.ProjectsTotal,
+		&d.ProjectsDeleted, &d.ErrorMessage, &d.CreatedAt, &d.CompletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Starts tracking an account deletion
+// Returns the existing record if one is already in progress for the user
+func CreateAccountDeletion(ctx context.Context, userID,
+	githubUsername string) (*AccountDeletion, bool, error) {
+	query := `
+		INSERT INTO account_deletions (user_id, github_username)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) WHERE status IN ('pending', 'running')
+			DO NOTHING
+		RETURNING ` + accountDeletionColumns
+
+	d, err := scanAccountDeletion(pool.QueryRow(ctx, query, userID,
+		githubUsername))
+	if err == nil {
+		return d, true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, err
+	}
+
+	existing := `
+		SELECT ` + accountDeletionColumns + `
+		FROM account_deletions
+		WHERE user_id = $1 AND status IN ('pending', 'running')
+	`
+	d, err = scanAccountDeletion(pool.QueryRow(ctx, existing, userID))
+	return d, false, err
+}
+
+// Marks a deletion as running over projectsTotal projects
+func StartAccountDeletion(ctx context.Context, id string,
+	projectsTotal int) error {
+	query := `
+		UPDATE account_deletions
+		SET status = 'running', projects_total = $2
+		WHERE id = $1
+	`
+
+	result, err := pool.Exec(ctx, query, id, projectsTotal)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("account deletion not found")
+	}
+
+	return nil
+}
+
+// Records one more project cleaned up
+func IncrementAccountDeletionProgress(ctx context.Context, id string) error {
+	query := `
+		UPDATE account_deletions
+		SET projects_deleted = projects_deleted + 1
+		WHERE id = $1
+	`
+
+	_, err := pool.Exec(ctx, query, id)
+	return err
+}
+
+// Marks a deletion as completed, or failed when errorMsg is set
+func FinishAccountDeletion(ctx context.Context, id string,
+	errorMsg *string) error {
+	query := `
+		UPDATE account_deletions
+		SET status = CASE WHEN $2::text IS NULL
+				THEN 'completed' ELSE 'failed' END,
+			error_message = $2,
+			completed_at = NOW()
+		WHERE id = $1
+	`
+
+	result, err := pool.Exec(ctx, query, id, errorMsg)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("account deletion not found")
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package database
+
+import (
+	"context"
+)
+
+// Audit log actions
+const (
+	AuditActionEnvVarRevealed       = "env_var.revealed"
+	AuditActionWebhookSecretRotated = "webhook_secret.rotate"
+)
+
+// For recording an audited action
+type CreateAuditLogInput struct {
+	UserID    string
+	ProjectID *string
+	Action    string
+	Target    *string // What was acted on, e.g. an env var key
+	IPAddress string
+}
+
+// Appends an entry to the audit log
+func CreateAuditLogEntry(ctx context.Context,
+	input *CreateAuditLogInput) error {
+	query := `
+		INSERT INTO audit_log (
+			user_id, project_id, action, target, ip_address
+		) VALUES ($1, $2, $3, $4, $5)
+	`
+
+	_, err := pool.Exec(ctx, query,
+		input.UserID,
+		input.ProjectID,
+		input.Action,
+		input.Target,
+		input.IPAddress,
+	)
+	return err
+}
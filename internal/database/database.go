@@ -5,6 +5,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog/log"
 )
@@ -29,6 +30,11 @@ func Connect() error {
 	config.MaxConnLifetime = time.Hour
 	config.MaxConnIdleTime = 30 * time.Minute
 
+	// Log each query's duration; slow ones at warn level
+	config.ConnConfig.Tracer = &queryTracer{
+		slowThreshold: slowQueryThreshold(),
+	}
+
 	pool, err = pgxpool.NewWithConfig(context.Background(), config)
 	if err != nil {
 		return err
@@ -50,7 +56,24 @@ func Close() {
 	}
 }
 
-// Return connection pool for queries
-func getPool() *pgxpool.Pool {
+// Returns the connection pool, for packages that run their own queries
+// (e.g. crypto.RotateKey)
+func Pool() *pgxpool.Pool {
 	return pool
 }
+
+// Runs fn inside a transaction, committing if it returns nil and
+// rolling back otherwise
+func withTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op after a successful commit
+	defer tx.Rollback(ctx)
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// Represents captured build output for a deployment
+type DeploymentLog struct {
+	ID           int64     `json:"id"`
+	DeploymentID string    `json:"deployment_id"`
+	Content      string    `json:"content"`
+	Truncated    bool      `json:"truncated"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Stores build output for a deployment
+func SaveDeploymentLog(ctx context.Context, deploymentID, content string,
+	truncated bool) error {
+	query := `
+		INSERT INTO deployment_logs (deployment_id, content, truncated)
+		VALUES ($1, $2, $3)
+	`
+
+	_, err := pool.Exec(ctx, query, deploymentID, content, truncated)
+	return err
+}
+
+// Retrieves all captured logs for a deployment, oldest first
+func GetDeploymentLogs(ctx context.Context,
+	deploymentID string) ([]*DeploymentLog, error) {
+	query := `
+		SELECT id, deployment_id, content, truncated, created_at
+		FROM deployment_logs
+		WHERE deployment_id = $1
+		ORDER BY created_at ASC, id ASC
+	`
+
+	rows, err := pool.Query(ctx, query, deploymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var logs []*DeploymentLog
+	for rows.Next() {
+		var l DeploymentLog
+		if err := rows.Scan(&l.ID, &l.DeploymentID, &l.Content,
+			&l.Truncated, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		logs = append(logs, &l)
+	}
+
+	return logs, rows.Err()
+}
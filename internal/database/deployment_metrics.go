@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Build performance over the last 30 days (as of RefreshedAt)
+// Durations are in seconds & nil until a deployment has succeeded
+type DeploymentMetrics struct {
+	ProjectID            string     `json:"project_id,omitempty"`
+	DeploymentsTotal     int        `json:"deployments_total"`
+	DeploymentsSucceeded int        `json:"deployments_succeeded"`
+	AvgBuildDuration     *float64   `json:"avg_build_duration"`
+	P50BuildDuration     *float64   `json:"p50_build_duration"`
+	P95BuildDuration     *float64   `json:"p95_build_duration"`
+	P99BuildDuration     *float64   `json:"p99_build_duration"`
+	SuccessRate          *float64   `json:"success_rate"`
+	RefreshedAt          *time.Time `json:"refreshed_at"`
+}
+
+// Columns selected for DeploymentMetrics, in scanDeploymentMetrics order
+const deploymentMetricsColumns = `
+	deployments_total, deployments_succeeded, avg_build_duration,
+	p50_build_duration, p95_build_duration, p99_build_duration,
+	success_rate, refreshed_at`
+
+// Scans a metrics row selected with deploymentMetricsColumns
+func scanDeploymentMetrics(row pgx.Row, m *DeploymentMetrics) error {
+	return row.Scan(
+		&m.DeploymentsTotal, &m.DeploymentsSucceeded, &m.AvgBuildDuration,
+		&m.P50BuildDuration, &m.P95BuildDuration, &m.P99BuildDuration,
+		&m.SuccessRate, &m.RefreshedAt,
+	)
+}
+
+// Recomputes the per-project & platform metrics views
+// The per-project view refreshes concurrently so reads never block
+func RefreshDeploymentMetrics(ctx context.Context) error {
+	query := `REFRESH MATERIALIZED VIEW CONCURRENTLY deployment_metrics`
+	if _, err := pool.Exec(ctx, query); err != nil {
+		return err
+	}
+
+	query = `REFRESH MATERIALIZED VIEW platform_deployment_metrics`
+	_, err := pool.Exec(ctx, query)
+	return err
+}
+
+// Returns a project's metrics as of the last refresh
+// Projects without finished deployments in the window get zero counts
+func GetDeploymentMetrics(ctx context.Context,
+	projectID string) (*DeploymentMetrics, error) {
+	query := `
+		SELECT ` + deploymentMetricsColumns + `
+		FROM deployment_metrics
+		WHERE project_id = $1
+	`
+
+	m := &DeploymentMetrics{ProjectID: projectID}
+	err := scanDeploymentMetrics(pool.QueryRow(ctx, query, projectID), m)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Returns metrics across all projects as of the last refresh
+func GetPlatformDeploymentMetrics(
+	ctx context.Context) (*DeploymentMetrics, error) {
+	query := `
+		SELECT ` + deploymentMetricsColumns + `
+		FROM platform_deployment_metrics
+	`
+
+	m := &DeploymentMetrics{}
+	if err := scanDeploymentMetrics(pool.QueryRow(ctx, query), m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
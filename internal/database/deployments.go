@@ -2,8 +2,11 @@ package database
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"time"
+
+	"github.com/jackc/pgx/v5"
 )
 
 // Represents state of deployment
@@ -17,100 +20,183 @@ const (
 	DeploymentStatusFailed     DeploymentStatus = "failed"
 	DeploymentStatusCancelled  DeploymentStatus = "cancelled"
 	DeploymentStatusSuperseded DeploymentStatus = "superseded"
+	DeploymentStatusFrozen     DeploymentStatus = "frozen"
+)
+
+// What triggered a deployment
+const (
+	DeploymentTypePush    = "push"    // Push to the project's branch
+	DeploymentTypePreview = "preview" // Pull request preview
 )
 
+// Returned when a status change is refused because the user cancelled the
+// deployment; the worker should stop working on it
+var ErrDeploymentCancelled = errors.New("deployment was cancelled")
+
 // Represents a single deployment attempt
 type Deployment struct {
-	ID            string           `json:"id"`
-	ProjectID     string           `json:"project_id"`
-	CommitSHA     string           `json:"commit_sha"`
-	CommitMessage *string          `json:"commit_message,omitempty"`
-	CommitAuthor  *string          `json:"commit_author,omitempty"`
-	Branch        *string          `json:"branch,omitempty"`
-	Status        DeploymentStatus `json:"status"`
-	ImageTag      *string          `json:"image_tag,omitempty"`
-	ContainerID   *string          `json:"-"` // Internal use only
-	URL           *string          `json:"url,omitempty"`
-	BuildLogsURL  *string          `json:"build_logs_url,omitempty"`
-	ErrorMessage  *string          `json:"error_message,omitempty"`
-	CreatedAt     time.Time        `json:"created_at"`
-	StartedAt     *time.Time       `json:"started_at,omitempty"`
-	CompletedAt   *time.Time       `json:"completed_at,omitempty"`
+	ID             string           `json:"id"`
+	ProjectID      string           `json:"project_id"`
+	CommitSHA      string           `json:"commit_sha"`
+	CommitMessage  *string          `json:"commit_message,omitempty"`
+	CommitAuthor   *string          `json:"commit_author,omitempty"`
+	Branch         *string          `json:"branch,omitempty"`
+	Environment    string           `json:"environment"`
+	DeploymentType string           `json:"deployment_type"`
+	Status         DeploymentStatus `json:"status"`
+	ImageTag       *string          `json:"image_tag,omitempty"`
+	ContainerID    *string          `json:"-"` // Internal use only
+	URL            *string          `json:"url,omitempty"`
+	BuildLogsURL   *string          `json:"build_logs_url,omitempty"`
+	ErrorMessage   *string          `json:"error_message,omitempty"`
+	QueueTaskID    *string          `json:"-"` // Asynq build task
+	PRNumber       *int             `json:"pr_number,omitempty"`
+	Platform       string           `json:"platform,omitempty"`
+	ConfigSnapshot *ConfigSnapshot  `json:"config_snapshot,omitempty"`
+	// User whose push or PR triggered it (webhook deployments only)
+	TriggeredByUserID *string `json:"triggered_by_user_id,omitempty"`
+	// Size of the built image; unset for multi-platform builds
+	ImageSizeBytes *int64     `json:"image_size_bytes,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+}
+
+// Project settings a deployment was built & run with
+// Recorded when the build starts; EnvKeys is updated again on deploy with
+// the keys the container actually got. A nil EnvKeys means the keys were
+// never recorded (older deployments), unlike an empty list
+type ConfigSnapshot struct {
+	BuildCommand string   `json:"build_command"`
+	StartCommand string   `json:"start_command"`
+	Port         int      `json:"port"`
+	Runtime      string   `json:"runtime"`
+	EnvKeys      []string `json:"env_keys"` // Never values
+}
+
+// Columns selected by every deployment query (order matches scanDeployment)
+const deploymentColumns = `
+	id, project_id, commit_sha, commit_message, commit_author,
+	branch, environment, deployment_type, status, image_tag, container_id,
+	url, build_logs_url, error_message, queue_task_id, pr_number, platform,
+	config_snapshot, triggered_by_user_id, image_size_bytes, created_at,
+	started_at, completed_at`
+
+// Scans a single deployment row selected with deploymentColumns
+func scanDeployment(row pgx.Row) (*Deployment, error) {
+	var d Deployment
+	err := row.Scan(
+		&d.ID, &d.ProjectID, &d.CommitSHA, &d.CommitMessage, &d.CommitAuthor,
+		&d.Branch, &d.Environment, &d.DeploymentType, &d.Status, &d.ImageTag,
+		&d.ContainerID,
+		&d.URL, &d.BuildLogsURL, &d.ErrorMessage, &d.QueueTaskID, &d.PRNumber,
+		&d.Platform, &d.ConfigSnapshot, &d.TriggeredByUserID, &d.ImageSizeBytes,
+		&d.CreatedAt, &d.StartedAt, &d.CompletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Scans all deployment rows & closes them
+func scanDeployments(rows pgx.Rows) ([]*Deployment, error) {
+	defer rows.Close()
+
+	var deployments []*Deployment
+	for rows.Next() {
+		d, err := scanDeployment(rows)
+		if err != nil {
+			return nil, err
+		}
+		deployments = append(deployments, d)
+	}
+	return deployments, rows.Err()
 }
 
 // For creating a new deployment
 type CreateDeploymentInput struct {
-	ProjectID     string
-	CommitSHA     string
-	CommitMessage *string
-	CommitAuthor  *string
-	Branch        *string
+	ProjectID      string
+	CommitSHA      string
+	CommitMessage  *string
+	CommitAuthor   *string
+	Branch         *string
+	Environment    string           // Defaults to "production"
+	DeploymentType string           // Defaults to "push"
+	Status         DeploymentStatus // Defaults to "pending"
+	PRNumber       *int             // Set for pull request previews
+	// Webhook sender's account, when they have one
+	TriggeredByUserID *string
 }
 
-// Creates new deploy w/ status "pending"
+// Creates new deploy w/ status "pending" (or input.Status if set)
 func CreateDeployment(ctx context.Context,
 	input *CreateDeploymentInput) (*Deployment, error) {
 	query := `
 		INSERT INTO deployments (
 			project_id, commit_sha, commit_message, commit_author,
-			branch, status
-		) VALUES ($1, $2, $3, $4, $5, 'pending')
-		RETURNING id, project_id, commit_sha, commit_message, commit_author,
-			branch, status, image_tag, container_id, url, build_logs_url,
-			error_message, created_at, started_at, completed_at
-	`
+			branch, environment, deployment_type, status, pr_number,
+			triggered_by_user_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING ` + deploymentColumns
+
+	status := input.Status
+	if status == "" {
+		status = DeploymentStatusPending
+	}
+	environment := input.Environment
+	if environment == "" {
+		environment = EnvironmentProduction
+	}
+	deploymentType := input.DeploymentType
+	if deploymentType == "" {
+		deploymentType = DeploymentTypePush
+	}
 
-	var d Deployment
-	err := pool.QueryRow(ctx, query,
+	return scanDeployment(pool.QueryRow(ctx, query,
 		input.ProjectID,
 		input.CommitSHA,
 		input.CommitMessage,
 		input.CommitAuthor,
 		input.Branch,
-	).Scan(
-		&d.ID, &d.ProjectID, &d.CommitSHA, &d.CommitMessage, &d.CommitAuthor,
-		&d.Branch, &d.Status, &d.ImageTag, &d.ContainerID, &d.URL,
-		&d.BuildLogsURL, &d.ErrorMessage, &d.CreatedAt, &d.StartedAt,
-		&d.CompletedAt,
-	)
-
-	if err != nil {
-		return nil, err
-	}
-	return &d, nil
+		environment,
+		deploymentType,
+		status,
+		input.PRNumber,
+		input.TriggeredByUserID,
+	))
 }
 
 // Retrieve deployment by ID
 func GetDeploymentByID(ctx context.Context, id string) (*Deployment, error) {
 	query := `
-		SELECT id, project_id, commit_sha, commit_message, commit_author,
-			branch, status, image_tag, container_id, url, build_logs_url,
-			error_message, created_at, started_at, completed_at
+		SELECT ` + deploymentColumns + `
 		FROM deployments
 		WHERE id = $1
 	`
 
-	var d Deployment
-	err := pool.QueryRow(ctx, query, id).Scan(
-		&d.ID, &d.ProjectID, &d.CommitSHA, &d.CommitMessage, &d.CommitAuthor,
-		&d.Branch, &d.Status, &d.ImageTag, &d.ContainerID, &d.URL,
-		&d.BuildLogsURL, &d.ErrorMessage, &d.CreatedAt, &d.StartedAt,
-		&d.CompletedAt,
-	)
+	return scanDeployment(pool.QueryRow(ctx, query, id))
+}
 
-	if err != nil {
-		return nil, err
-	}
-	return &d, nil
+// Retrieve deployment by its container ID
+func GetDeploymentByContainerID(ctx context.Context,
+	containerID string) (*Deployment, error) {
+	query := `
+		SELECT ` + deploymentColumns + `
+		FROM deployments
+		WHERE container_id = $1
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+
+	return scanDeployment(pool.QueryRow(ctx, query, containerID))
 }
 
 // Return deploys for a project
 func GetDeploymentsByProjectID(ctx context.Context,
 	projectID string, limit int) ([]*Deployment, error) {
 	query := `
-		SELECT id, project_id, commit_sha, commit_message, commit_author,
-			branch, status, image_tag, container_id, url, build_logs_url,
-			error_message, created_at, started_at, completed_at
+		SELECT ` + deploymentColumns + `
 		FROM deployments
 		WHERE project_id = $1
 		ORDER BY created_at DESC
@@ -121,78 +207,110 @@ func GetDeploymentsByProjectID(ctx context.Context,
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var deployments []*Deployment
-	for rows.Next() {
-		var d Deployment
-		err := rows.Scan(
-			&d.ID, &d.ProjectID, &d.CommitSHA, &d.CommitMessage, &d.CommitAuthor,
-			&d.Branch, &d.Status, &d.ImageTag, &d.ContainerID, &d.URL,
-			&d.BuildLogsURL, &d.ErrorMessage, &d.CreatedAt, &d.StartedAt,
-			&d.CompletedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-		deployments = append(deployments, &d)
-	}
-	return deployments, nil
+	return scanDeployments(rows)
 }
 
 // Returns current live deployment
 func GetLiveDeployment(ctx context.Context,
 	projectID string) (*Deployment, error) {
 	query := `
-		SELECT id, project_id, commit_sha, commit_message, commit_author,
-			branch, status, image_tag, container_id, url, build_logs_url,
-			error_message, created_at, started_at, completed_at
+		SELECT ` + deploymentColumns + `
 		FROM deployments
 		WHERE project_id = $1 AND status = 'live'
+			AND deployment_type <> 'preview'
 		LIMIT 1
 	`
 
-	var d Deployment
-	err := pool.QueryRow(ctx, query, projectID).Scan(
-		&d.ID, &d.ProjectID, &d.CommitSHA, &d.CommitMessage, &d.CommitAuthor,
-		&d.Branch, &d.Status, &d.ImageTag, &d.ContainerID, &d.URL,
-		&d.BuildLogsURL, &d.ErrorMessage, &d.CreatedAt, &d.StartedAt,
-		&d.CompletedAt,
-	)
+	return scanDeployment(pool.QueryRow(ctx, query, projectID))
+}
 
+// Returns every live deployment (previews included) that has a container
+func GetLiveDeploymentsWithContainers(ctx context.Context) ([]*Deployment,
+	error) {
+	query := `
+		SELECT ` + deploymentColumns + `
+		FROM deployments
+		WHERE status = 'live' AND container_id IS NOT NULL
+		ORDER BY created_at ASC
+	`
+
+	rows, err := pool.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
-	return &d, nil
+	return scanDeployments(rows)
+}
+
+// Something that can run a single-row query (the pool or a transaction)
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// The error for a status change guarded against cancellation that updated
+// no row: ErrDeploymentCancelled, or not found if the deployment is gone
+func unchangedDeploymentError(ctx context.Context, q rowQuerier,
+	id string) error {
+	var status DeploymentStatus
+	err := q.QueryRow(ctx, "SELECT status FROM deployments WHERE id = $1",
+		id).Scan(&status)
+	if err == nil && status == DeploymentStatusCancelled {
+		return ErrDeploymentCancelled
+	}
+	return errors.New("deployment not found")
 }
 
-// Updates status & optionally sets error message
-func UpdateDeploymentStatus(ctx context.Context, id string,
-	status DeploymentStatus, errorMsg *string) error {
+// Marks deployment as building
+// Returns ErrDeploymentCancelled if it was cancelled while queued
+func StartDeploymentBuild(ctx context.Context, id string) error {
 	query := `
 		UPDATE deployments
-		SET status = $2, error_message = $3, updated_at = NOW()
-		WHERE id = $1
+		SET status = 'building', started_at = NOW()
+		WHERE id = $1 AND status <> 'cancelled'
 	`
 
-	result, err := pool.Exec(ctx, query, id, status, errorMsg)
+	result, err := pool.Exec(ctx, query, id)
 	if err != nil {
 		return err
 	}
 
 	if result.RowsAffected() == 0 {
-		return errors.New("deployment not found")
+		return unchangedDeploymentError(ctx, pool, id)
 	}
 
 	return nil
 }
 
-// Marks deployment as building
-func StartDeploymentBuild(ctx context.Context, id string) error {
+// Marks build complete & stores the image tag, platform(s) it targets &
+// its size (nil when unknown)
+// Returns ErrDeploymentCancelled if it was cancelled during the build
+func SetDeploymentBuilt(ctx context.Context, id string,
+	imageTag, platform string, imageSize *int64) error {
 	query := `
 		UPDATE deployments
-		SET status = 'building', started_at = NOW()
-		WHERE id = $1
+		SET status = 'deploying', image_tag = $2, platform = $3,
+			image_size_bytes = $4, built_at = NOW()
+		WHERE id = $1 AND status <> 'cancelled'
+	`
+
+	result, err := pool.Exec(ctx, query, id, imageTag, platform, imageSize)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return unchangedDeploymentError(ctx, pool, id)
+	}
+
+	return nil
+}
+
+// Marks a built deployment as deploying when its deploy job starts
+// Returns ErrDeploymentCancelled if it was cancelled while queued
+func StartDeploymentDeploy(ctx context.Context, id string) error {
+	query := `
+		UPDATE deployments
+		SET status = 'deploying'
+		WHERE id = $1 AND status <> 'cancelled'
 	`
 
 	result, err := pool.Exec(ctx, query, id)
@@ -201,22 +319,28 @@ func StartDeploymentBuild(ctx context.Context, id string) error {
 	}
 
 	if result.RowsAffected() == 0 {
-		return errors.New("deployment not found")
+		return unchangedDeploymentError(ctx, pool, id)
 	}
 
 	return nil
 }
 
-// Marks build complete & stores image tag
-func SetDeploymentBuilt(ctx context.Context, id string,
-	imageTag string) error {
+// Starts a deployment's snapshot with the settings it's being built with
+// Replaces any earlier snapshot (e.g. from a previous build attempt)
+func SetDeploymentBuildSnapshot(ctx context.Context, id string,
+	snapshot *ConfigSnapshot) error {
 	query := `
 		UPDATE deployments
-		SET status = 'deploying', image_tag = $2
+		SET config_snapshot = $2::jsonb
 		WHERE id = $1
 	`
 
-	result, err := pool.Exec(ctx, query, id, imageTag)
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	result, err := pool.Exec(ctx, query, id, string(data))
 	if err != nil {
 		return err
 	}
@@ -228,17 +352,17 @@ func SetDeploymentBuilt(ctx context.Context, id string,
 	return nil
 }
 
-// Marks deployment as live & stores container info
-func SetDeploymentLive(ctx context.Context, id string,
-	containerID string, url string) error {
+// Records the env var keys a deployment's container was started with
+func SetDeploymentEnvKeys(ctx context.Context, id string,
+	keys []string) error {
 	query := `
 		UPDATE deployments
-		SET status = 'live', container_id = $2, url = $3,
-			completed_at = NOW()
+		SET config_snapshot = COALESCE(config_snapshot, '{}'::jsonb) ||
+			jsonb_build_object('env_keys', $2::text[])
 		WHERE id = $1
 	`
 
-	result, err := pool.Exec(ctx, query, id, containerID, url)
+	result, err := pool.Exec(ctx, query, id, keys)
 	if err != nil {
 		return err
 	}
@@ -250,26 +374,115 @@ func SetDeploymentLive(ctx context.Context, id string,
 	return nil
 }
 
-// Marks all other 'live' deployments for a project as 'superseded'
-func SupersededOldDeployments(ctx context.Context, projectID string,
-	excludeDeploymentID string) error {
+// Updates the container ID of a deployment (e.g. after a restart)
+func SetDeploymentContainerID(ctx context.Context, id string,
+	containerID string) error {
 	query := `
 		UPDATE deployments
-		SET status = 'superseded', completed_at = NOW()
-		WHERE project_id = $1 AND status = 'live' AND id != $2
+		SET container_id = $2
+		WHERE id = $1
 	`
 
-	_, err := pool.Exec(ctx, query, projectID, excludeDeploymentID)
-	return err
+	result, err := pool.Exec(ctx, query, id, containerID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("deployment not found")
+	}
+
+	return nil
 }
 
-// Marks deployment as failed
+// Supersedes the project's current live deployment & marks this one live
+// in a single transaction. idx_one_live_per_project guarantees at most one
+// live deployment; the project row lock makes concurrent promotions queue
+// up instead of failing on it. Returns ErrDeploymentCancelled (changing
+// nothing) if the deployment was cancelled.
+func PromoteDeploymentToLive(ctx context.Context, projectID, deploymentID,
+	containerID, url string) error {
+	return withTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx,
+			"SELECT 1 FROM projects WHERE id = $1 FOR UPDATE",
+			projectID); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, `
+			UPDATE deployments
+			SET status = 'superseded', completed_at = NOW()
+			WHERE project_id = $1 AND status = 'live' AND id != $2
+				AND deployment_type <> 'preview'
+		`, projectID, deploymentID); err != nil {
+			return err
+		}
+
+		result, err := tx.Exec(ctx, `
+			UPDATE deployments
+			SET status = 'live', container_id = $3, url = $4,
+				completed_at = NOW()
+			WHERE id = $1 AND project_id = $2 AND status <> 'cancelled'
+		`, deploymentID, projectID, containerID, url)
+		if err != nil {
+			return err
+		}
+
+		// Rolls back the supersede above too
+		if result.RowsAffected() == 0 {
+			return unchangedDeploymentError(ctx, tx, deploymentID)
+		}
+		return nil
+	})
+}
+
+// Marks a pull request preview live, superseding the PR's previous preview
+// Leaves the project's own live deployment alone. Returns
+// ErrDeploymentCancelled (changing nothing) if the PR closed meanwhile.
+func PromotePreviewDeploymentToLive(ctx context.Context, projectID,
+	deploymentID string, prNumber int, containerID, url string) error {
+	return withTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx,
+			"SELECT 1 FROM projects WHERE id = $1 FOR UPDATE",
+			projectID); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, `
+			UPDATE deployments
+			SET status = 'superseded', completed_at = NOW()
+			WHERE project_id = $1 AND status = 'live' AND id != $2
+				AND deployment_type = 'preview' AND pr_number = $3
+		`, projectID, deploymentID, prNumber); err != nil {
+			return err
+		}
+
+		result, err := tx.Exec(ctx, `
+			UPDATE deployments
+			SET status = 'live', container_id = $3, url = $4,
+				completed_at = NOW()
+			WHERE id = $1 AND project_id = $2
+				AND status NOT IN ('cancelled', 'failed')
+		`, deploymentID, projectID, containerID, url)
+		if err != nil {
+			return err
+		}
+
+		// Rolls back the supersede above too
+		if result.RowsAffected() == 0 {
+			return unchangedDeploymentError(ctx, tx, deploymentID)
+		}
+		return nil
+	})
+}
+
+// Marks deployment as failed (unless it was cancelled in the meantime)
 func SetDeploymentFailed(ctx context.Context, id string,
 	errorMsg string) error {
 	query := `
 		UPDATE deployments
 		SET status = 'failed', error_message = $2, completed_at = NOW()
-		WHERE id = $1
+		WHERE id = $1 AND status != 'cancelled'
 	`
 
 	result, err := pool.Exec(ctx, query, id, errorMsg)
@@ -284,12 +497,44 @@ func SetDeploymentFailed(ctx context.Context, id string,
 	return nil
 }
 
+// Stores the asynq task ID of the deployment's build job
+func SetDeploymentQueueTaskID(ctx context.Context, id,
+	taskID string) error {
+	query := `
+		UPDATE deployments
+		SET queue_task_id = $2
+		WHERE id = $1
+	`
+
+	result, err := pool.Exec(ctx, query, id, taskID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("deployment not found")
+	}
+
+	return nil
+}
+
+// Checks if a deployment can no longer change state
+func IsTerminalStatus(status DeploymentStatus) bool {
+	switch status {
+	case DeploymentStatusLive, DeploymentStatusFailed,
+		DeploymentStatusCancelled, DeploymentStatusSuperseded:
+		return true
+	}
+	return false
+}
+
 // Marks deployment as cancelled
 func CancelDeployment(ctx context.Context, id string) error {
 	query := `
 		UPDATE deployments
 		SET status = 'cancelled', completed_at = NOW()
-		WHERE id = $1 AND status IN ('pending', 'building', 'deploying')
+		WHERE id = $1
+			AND status IN ('pending', 'building', 'deploying', 'frozen')
 	`
 
 	result, err := pool.Exec(ctx, query, id)
@@ -304,6 +549,63 @@ func CancelDeployment(ctx context.Context, id string) error {
 	return nil
 }
 
+// Cancels a pull request's preview deployments that haven't already been
+// cancelled, failed or superseded, live ones included, & returns them
+// Workers still building or deploying one stop at their next status change
+func CancelPreviewDeployments(ctx context.Context, projectID string,
+	prNumber int) ([]*Deployment, error) {
+	query := `
+		UPDATE deployments
+		SET status = 'cancelled', completed_at = NOW()
+		WHERE project_id = $1
+			AND deployment_type = 'preview'
+			AND pr_number = $2
+			AND status NOT IN ('cancelled', 'failed', 'superseded')
+		RETURNING ` + deploymentColumns
+
+	rows, err := pool.Query(ctx, query, projectID, prNumber)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeployments(rows)
+}
+
+// Returns all frozen deployments, newest first
+func GetFrozenDeployments(ctx context.Context) ([]*Deployment, error) {
+	query := `
+		SELECT ` + deploymentColumns + `
+		FROM deployments
+		WHERE status = 'frozen'
+		ORDER BY created_at DESC
+	`
+
+	rows, err := pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeployments(rows)
+}
+
+// Moves a frozen deployment back to 'pending' so it can be built
+func ReleaseFrozenDeployment(ctx context.Context, id string) error {
+	query := `
+		UPDATE deployments
+		SET status = 'pending'
+		WHERE id = $1 AND status = 'frozen'
+	`
+
+	result, err := pool.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("deployment not found or not frozen")
+	}
+
+	return nil
+}
+
 // Removes deployment record (cleanup)
 func DeleteDeployment(ctx context.Context, id string) error {
 	query := `DELETE FROM deployments WHERE id = $1`
@@ -328,3 +630,113 @@ func DeleteDeploymentsByProjectID(ctx context.Context,
 	_, err := pool.Exec(ctx, query, projectID)
 	return err
 }
+
+// Count deployments across a user's projects in the current calendar month
+func CountDeploymentsThisMonth(ctx context.Context,
+	userID string) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM deployments d
+		JOIN projects p ON p.id = d.project_id
+		WHERE p.user_id = $1
+			AND d.created_at >= date_trunc('month', NOW())
+	`
+
+	var count int
+	err := pool.QueryRow(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// Count a project's deployments waiting to build or building
+func CountActiveBuilds(ctx context.Context, projectID string) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM deployments
+		WHERE project_id = $1 AND status IN ('pending', 'building')
+	`
+
+	var count int
+	err := pool.QueryRow(ctx, query, projectID).Scan(&count)
+	return count, err
+}
+
+// Returns a project's oldest deployment still waiting to build
+func GetOldestPendingDeployment(ctx context.Context,
+	projectID string) (*Deployment, error) {
+	query := `
+		SELECT ` + deploymentColumns + `
+		FROM deployments
+		WHERE project_id = $1 AND status = 'pending'
+		ORDER BY created_at ASC
+		LIMIT 1
+	`
+
+	return scanDeployment(pool.QueryRow(ctx, query, projectID))
+}
+
+// Average build time over a project's last 20 successful deploys
+// Returns 0 when there's no history yet
+func AverageBuildDuration(ctx context.Context,
+	projectID string) (time.Duration, error) {
+	query := `
+		SELECT COALESCE(AVG(EXTRACT(EPOCH FROM built_at - started_at)), 0)
+		FROM (
+			SELECT started_at, built_at
+			FROM deployments
+			WHERE project_id = $1
+				AND status IN ('live', 'superseded')
+				AND started_at IS NOT NULL
+				AND built_at IS NOT NULL
+			ORDER BY created_at DESC
+			LIMIT 20
+		) recent
+	`
+
+	var seconds float64
+	if err := pool.QueryRow(ctx, query, projectID).Scan(&seconds); err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// A deployment's image size, for tracking image growth over time
+type ImageSizePoint struct {
+	DeploymentID   string    `json:"deployment_id"`
+	CommitSHA      string    `json:"commit_sha"`
+	ImageSizeBytes int64     `json:"image_size_bytes"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Image sizes of a project's last `limit` sized deployments, oldest first
+// Deployments without a recorded size (e.g. failed builds) are skipped
+func GetImageSizeHistory(ctx context.Context, projectID string,
+	limit int) ([]*ImageSizePoint, error) {
+	query := `
+		SELECT id, commit_sha, image_size_bytes, created_at
+		FROM (
+			SELECT id, commit_sha, image_size_bytes, created_at
+			FROM deployments
+			WHERE project_id = $1 AND image_size_bytes IS NOT NULL
+			ORDER BY created_at DESC
+			LIMIT $2
+		) recent
+		ORDER BY created_at ASC
+	`
+
+	rows, err := pool.Query(ctx, query, projectID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []*ImageSizePoint{}
+	for rows.Next() {
+		var p ImageSizePoint
+		if err := rows.Scan(&p.DeploymentID, &p.CommitSHA,
+			&p.ImageSizeBytes, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		points = append(points, &p)
+	}
+	return points, rows.Err()
+}
@@ -0,0 +1,300 @@
+//go:build integration
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// Runs the deployment tests against a throwaway PostgreSQL container with
+// every migration applied. Needs Docker: go test -tags=integration ./...
+func TestDatabase(t *testing.T) {
+	ctx := context.Background()
+
+	container, err := postgres.Run(ctx, "postgres:16-alpine",
+		postgres.WithDatabase("rcnbuild"),
+		postgres.WithUsername("rcnbuild"),
+		postgres.WithPassword("rcnbuild"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").
+				WithOccurrence(2).
+				WithStartupTimeout(time.Minute)),
+	)
+	if err != nil {
+		t.Fatalf("failed to start PostgreSQL container: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := testcontainers.TerminateContainer(container); err != nil {
+			t.Logf("failed to terminate PostgreSQL container: %v", err)
+		}
+	})
+
+	databaseURL, err := container.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		t.Fatalf("failed to get connection string: %v", err)
+	}
+	t.Setenv("DATABASE_URL", databaseURL)
+	t.Setenv("ENCRYPTION_KEY", "0123456789abcdef0123456789abcdef")
+
+	if err := Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(Close)
+
+	if err := RunMigrations(ctx); err != nil {
+		t.Fatalf("RunMigrations: %v", err)
+	}
+
+	t.Run("OneLiveDeploymentPerProject", testOneLiveDeploymentPerProject)
+	t.Run("PromoteSupersedesLiveDeployment",
+		testPromoteSupersedesLiveDeployment)
+	t.Run("CancelDeployment", testCancelDeployment)
+	t.Run("DeleteDeploymentsByProjectID", testDeleteDeploymentsByProjectID)
+}
+
+// A second live deployment can't be written directly: the unique partial
+// index rejects it
+func testOneLiveDeploymentPerProject(t *testing.T) {
+	ctx := context.Background()
+	project := createTestProject(t)
+
+	first := createTestDeployment(t, project.ID)
+	second := createTestDeployment(t, project.ID)
+	promote(t, project.ID, first.ID)
+
+	_, err := pool.Exec(ctx,
+		"UPDATE deployments SET status = 'live' WHERE id = $1", second.ID)
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" ||
+		pgErr.ConstraintName != "idx_one_live_per_project" {
+		t.Fatalf("second live deployment error = %v, want a unique "+
+			"violation of idx_one_live_per_project", err)
+	}
+}
+
+// Promoting a deployment supersedes the live one in the same transaction,
+// so the project always has exactly one live deployment
+func testPromoteSupersedesLiveDeployment(t *testing.T) {
+	ctx := context.Background()
+	project := createTestProject(t)
+
+	first := createTestDeployment(t, project.ID)
+	second := createTestDeployment(t, project.ID)
+	promote(t, project.ID, first.ID)
+	promote(t, project.ID, second.ID)
+
+	assertStatus(t, first.ID, DeploymentStatusSuperseded)
+	assertStatus(t, second.ID, DeploymentStatusLive)
+
+	live, err := GetLiveDeployment(ctx, project.ID)
+	if err != nil {
+		t.Fatalf("GetLiveDeployment: %v", err)
+	}
+	if live.ID != second.ID {
+		t.Errorf("live deployment = %s, want %s", live.ID, second.ID)
+	}
+
+	// Once the live deployment is superseded too, there's none left
+	if _, err := pool.Exec(ctx,
+		"UPDATE deployments SET status = 'superseded' WHERE id = $1",
+		second.ID); err != nil {
+		t.Fatalf("failed to supersede deployment: %v", err)
+	}
+	if live, err := GetLiveDeployment(ctx, project.ID); !errors.Is(err,
+		pgx.ErrNoRows) {
+		t.Errorf("GetLiveDeployment after supersession = %v, %v; want "+
+			"no deployment", live, err)
+	}
+}
+
+// Only deployments that haven't finished can be cancelled, and cancelled
+// ones can't be built, deployed or promoted afterwards
+func testCancelDeployment(t *testing.T) {
+	ctx := context.Background()
+	project := createTestProject(t)
+
+	for _, status := range []DeploymentStatus{
+		DeploymentStatusPending,
+		DeploymentStatusBuilding,
+		DeploymentStatusDeploying,
+		DeploymentStatusFrozen,
+	} {
+		t.Run(string(status), func(t *testing.T) {
+			d := createTestDeploymentWithStatus(t, project.ID, status)
+			if err := CancelDeployment(ctx, d.ID); err != nil {
+				t.Fatalf("CancelDeployment: %v", err)
+			}
+			assertStatus(t, d.ID, DeploymentStatusCancelled)
+		})
+	}
+
+	for _, status := range []DeploymentStatus{
+		DeploymentStatusFailed,
+		DeploymentStatusSuperseded,
+		DeploymentStatusCancelled,
+	} {
+		t.Run(string(status), func(t *testing.T) {
+			d := createTestDeploymentWithStatus(t, project.ID, status)
+			if err := CancelDeployment(ctx, d.ID); err == nil {
+				t.Fatalf("CancelDeployment of a %s deployment succeeded",
+					status)
+			}
+			assertStatus(t, d.ID, status)
+		})
+	}
+
+	t.Run("live", func(t *testing.T) {
+		d := createTestDeployment(t, project.ID)
+		promote(t, project.ID, d.ID)
+		if err := CancelDeployment(ctx, d.ID); err == nil {
+			t.Fatal("CancelDeployment of the live deployment succeeded")
+		}
+		assertStatus(t, d.ID, DeploymentStatusLive)
+	})
+
+	t.Run("blocks later transitions", func(t *testing.T) {
+		d := createTestDeployment(t, project.ID)
+		if err := CancelDeployment(ctx, d.ID); err != nil {
+			t.Fatalf("CancelDeployment: %v", err)
+		}
+
+		if err := StartDeploymentBuild(ctx, d.ID); !errors.Is(err,
+			ErrDeploymentCancelled) {
+			t.Errorf("StartDeploymentBuild error = %v, want %v", err,
+				ErrDeploymentCancelled)
+		}
+		if err := StartDeploymentDeploy(ctx, d.ID); !errors.Is(err,
+			ErrDeploymentCancelled) {
+			t.Errorf("StartDeploymentDeploy error = %v, want %v", err,
+				ErrDeploymentCancelled)
+		}
+		if err := PromoteDeploymentToLive(ctx, project.ID, d.ID,
+			"container", "https://example.test"); !errors.Is(err,
+			ErrDeploymentCancelled) {
+			t.Errorf("PromoteDeploymentToLive error = %v, want %v", err,
+				ErrDeploymentCancelled)
+		}
+		assertStatus(t, d.ID, DeploymentStatusCancelled)
+	})
+}
+
+// Deleting a project's deployments removes them & what hangs off them,
+// and leaves other projects' deployments alone
+func testDeleteDeploymentsByProjectID(t *testing.T) {
+	ctx := context.Background()
+	project := createTestProject(t)
+	other := createTestProject(t)
+
+	d := createTestDeployment(t, project.ID)
+	promote(t, project.ID, d.ID)
+	kept := createTestDeployment(t, other.ID)
+
+	if err := SaveRuntimeLog(ctx, d.ID, "stdout", "hello"); err != nil {
+		t.Fatalf("SaveRuntimeLog: %v", err)
+	}
+
+	if err := DeleteDeploymentsByProjectID(ctx, project.ID); err != nil {
+		t.Fatalf("DeleteDeploymentsByProjectID: %v", err)
+	}
+
+	if _, err := GetDeploymentByID(ctx, d.ID); !errors.Is(err,
+		pgx.ErrNoRows) {
+		t.Errorf("GetDeploymentByID after delete error = %v, want %v",
+			err, pgx.ErrNoRows)
+	}
+	logs, err := GetRuntimeLogs(ctx, d.ID, nil, 10)
+	if err != nil {
+		t.Fatalf("GetRuntimeLogs: %v", err)
+	}
+	if len(logs) != 0 {
+		t.Errorf("%d runtime logs left after delete, want 0", len(logs))
+	}
+	if _, err := GetDeploymentByID(ctx, kept.ID); err != nil {
+		t.Errorf("other project's deployment: %v", err)
+	}
+}
+
+// Creates a user & a project owned by them
+func createTestProject(t *testing.T) *Project {
+	t.Helper()
+	ctx := context.Background()
+
+	githubID := time.Now().UnixNano()
+	user, err := CreateOrUpdateUser(ctx, &GitHubUser{
+		ID:    githubID,
+		Login: fmt.Sprintf("user-%d", githubID),
+	}, "token")
+	if err != nil {
+		t.Fatalf("CreateOrUpdateUser: %v", err)
+	}
+
+	slug := fmt.Sprintf("project-%d", githubID)
+	project, err := CreateProject(ctx, &CreateProjectInput{
+		UserId:       user.ID,
+		Name:         slug,
+		Slug:         slug,
+		RepoFullName: "owner/" + slug,
+		RepoURL:      "https://github.com/owner/" + slug,
+		Branch:       "main",
+		Port:         3000,
+		Environment:  EnvironmentProduction,
+	})
+	if err != nil {
+		t.Fatalf("CreateProject: %v", err)
+	}
+	return project
+}
+
+// Creates a pending push deployment
+func createTestDeployment(t *testing.T, projectID string) *Deployment {
+	t.Helper()
+	return createTestDeploymentWithStatus(t, projectID,
+		DeploymentStatusPending)
+}
+
+func createTestDeploymentWithStatus(t *testing.T, projectID string,
+	status DeploymentStatus) *Deployment {
+	t.Helper()
+
+	d, err := CreateDeployment(context.Background(), &CreateDeploymentInput{
+		ProjectID: projectID,
+		CommitSHA: fmt.Sprintf("%040d", time.Now().UnixNano()),
+		Status:    status,
+	})
+	if err != nil {
+		t.Fatalf("CreateDeployment: %v", err)
+	}
+	return d
+}
+
+// Makes a deployment the project's live one
+func promote(t *testing.T, projectID, deploymentID string) {
+	t.Helper()
+	if err := PromoteDeploymentToLive(context.Background(), projectID,
+		deploymentID, "container-"+deploymentID,
+		"https://example.test"); err != nil {
+		t.Fatalf("PromoteDeploymentToLive: %v", err)
+	}
+}
+
+func assertStatus(t *testing.T, deploymentID string,
+	want DeploymentStatus) {
+	t.Helper()
+	d, err := GetDeploymentByID(context.Background(), deploymentID)
+	if err != nil {
+		t.Fatalf("GetDeploymentByID: %v", err)
+	}
+	if d.Status != want {
+		t.Errorf("deployment status = %s, want %s", d.Status, want)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"time"
+
+	"github.com/jackc/pgx/v5"
 )
 
 // Represents environment variables for a project (internal use only)
@@ -51,9 +53,10 @@ func ToDisplayList(envVars []*EnvVar) []EnvVarDisplay {
 }
 
 // Upserts an environment variable for a project
+// userID is recorded as the author of the change in env_var_history
 // NOTE: Caller must encrypt value first using crypto.Encrypt()
 func CreateOrUpdateEnvVar(ctx context.Context, projectID, key,
-	encryptedValue string) (*EnvVar, error) {
+	encryptedValue, userID string) (*EnvVar, error) {
 	query := `
 		INSERT INTO env_vars (
 			project_id, key, value_encrypted
@@ -64,11 +67,13 @@ func CreateOrUpdateEnvVar(ctx context.Context, projectID, key,
 	`
 
 	var e EnvVar
-	err := pool.QueryRow(ctx, query,
-		projectID, key, encryptedValue,
-	).Scan(
-		&e.ID, &e.ProjectID, &e.Key, &e.ValueEncrypted, &e.CreatedAt,
-	)
+	err := withChangedBy(ctx, userID, func(tx pgx.Tx) error {
+		return tx.QueryRow(ctx, query,
+			projectID, key, encryptedValue,
+		).Scan(
+			&e.ID, &e.ProjectID, &e.Key, &e.ValueEncrypted, &e.CreatedAt,
+		)
+	})
 
 	if err != nil {
 		return nil, err
@@ -76,6 +81,46 @@ func CreateOrUpdateEnvVar(ctx context.Context, projectID, key,
 	return &e, nil
 }
 
+// Upserts several environment variables for a project in one transaction
+// Values must already be encrypted (e.g. copied from another project's
+// EnvVars, since every project shares ENCRYPTION_KEY)
+func BulkUpsertEnvVars(ctx context.Context, projectID, userID string,
+	envVars []*EnvVar) error {
+	if len(envVars) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO env_vars (
+			project_id, key, value_encrypted
+		) VALUES ($1, $2, $3)
+		ON CONFLICT (project_id, key) DO UPDATE SET
+			value_encrypted = EXCLUDED.value_encrypted
+	`
+
+	return withChangedBy(ctx, userID, func(tx pgx.Tx) error {
+		batch := &pgx.Batch{}
+		for _, e := range envVars {
+			batch.Queue(query, projectID, e.Key, e.ValueEncrypted)
+		}
+		return tx.SendBatch(ctx, batch).Close()
+	})
+}
+
+// Runs fn in a transaction with rcnbuild.user_id set, which the
+// env_vars history trigger reads to attribute the change
+func withChangedBy(ctx context.Context, userID string,
+	fn func(tx pgx.Tx) error) error {
+	return withTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx,
+			"SELECT set_config('rcnbuild.user_id', $1, true)",
+			userID); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
 // Returns all environment variables for a project
 func GetEnvVarsByProjectID(ctx context.Context,
 	projectID string) ([]*EnvVar, error) {
@@ -107,6 +152,56 @@ func GetEnvVarsByProjectID(ctx context.Context,
 	return envVars, nil
 }
 
+// Returns a project's environment variable keys, sorted (never values)
+// Empty, not nil, when the project has none
+func GetEnvVarKeys(ctx context.Context, projectID string) ([]string, error) {
+	query := `
+		SELECT key
+		FROM env_vars
+		WHERE project_id = $1
+		ORDER BY key ASC
+	`
+
+	rows, err := pool.Query(ctx, query, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// Returns a project's environment variable by key
+func GetEnvVarByKey(ctx context.Context, projectID,
+	key string) (*EnvVar, error) {
+	query := `
+		SELECT id, project_id, key, value_encrypted, created_at
+		FROM env_vars
+		WHERE project_id = $1 AND key = $2
+	`
+
+	var e EnvVar
+	err := pool.QueryRow(ctx, query, projectID, key).Scan(
+		&e.ID, &e.ProjectID, &e.Key, &e.ValueEncrypted, &e.CreatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errors.New("env var not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
 // Removes an environment variable
 func DeleteEnvVar(ctx context.Context, projectID, key string) error {
 	query := `
@@ -159,3 +254,99 @@ func GetEnvVarsAsMap(ctx context.Context, projectID string,
 
 	return result, nil
 }
+
+// A previous (or current) value of an env var (internal use only)
+// Like EnvVar, never return this directly - use ToDisplay()
+type EnvVarHistoryEntry struct {
+	ID              string    `json:"-"`
+	EnvVarID        *string   `json:"-"`
+	ProjectID       string    `json:"-"`
+	Key             string    `json:"-"`
+	ValueEncrypted  string    `json:"-"`
+	ChangedByUserID *string   `json:"-"`
+	ChangedAt       time.Time `json:"-"`
+}
+
+// Safe for API responses - values are always masked
+type EnvVarHistoryDisplay struct {
+	ID              string    `json:"id"`
+	Key             string    `json:"key"`
+	Value           string    `json:"value"` // Always masked: "••••••••"
+	ChangedByUserID *string   `json:"changed_by_user_id,omitempty"`
+	ChangedAt       time.Time `json:"changed_at"`
+}
+
+// ToDisplay converts to a safe API response format with masked value
+func (h *EnvVarHistoryEntry) ToDisplay() EnvVarHistoryDisplay {
+	return EnvVarHistoryDisplay{
+		ID:              h.ID,
+		Key:             h.Key,
+		Value:           maskedValue,
+		ChangedByUserID: h.ChangedByUserID,
+		ChangedAt:       h.ChangedAt,
+	}
+}
+
+// Returns the most recent values of an env var, newest first
+func GetEnvVarHistory(ctx context.Context, projectID, key string,
+	limit int) ([]*EnvVarHistoryEntry, error) {
+	query := `
+		SELECT id, env_var_id, project_id, key, value_encrypted,
+			changed_by_user_id, changed_at
+		FROM env_var_history
+		WHERE project_id = $1 AND key = $2
+		ORDER BY changed_at DESC
+		LIMIT $3
+	`
+
+	rows, err := pool.Query(ctx, query, projectID, key, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*EnvVarHistoryEntry
+	for rows.Next() {
+		var h EnvVarHistoryEntry
+		err := rows.Scan(
+			&h.ID, &h.EnvVarID, &h.ProjectID, &h.Key, &h.ValueEncrypted,
+			&h.ChangedByUserID, &h.ChangedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &h)
+	}
+
+	return entries, rows.Err()
+}
+
+// Copies a historical value back into env_vars (recreating the var if it
+// was deleted since). The restore itself is recorded as a new history entry
+func RestoreEnvVarFromHistory(ctx context.Context, projectID, key,
+	historyID, userID string) (*EnvVar, error) {
+	query := `
+		INSERT INTO env_vars (project_id, key, value_encrypted)
+		SELECT project_id, key, value_encrypted
+		FROM env_var_history
+		WHERE id = $1 AND project_id = $2 AND key = $3
+		ON CONFLICT (project_id, key) DO UPDATE SET
+			value_encrypted = EXCLUDED.value_encrypted
+		RETURNING id, project_id, key, value_encrypted, created_at
+	`
+
+	var e EnvVar
+	err := withChangedBy(ctx, userID, func(tx pgx.Tx) error {
+		return tx.QueryRow(ctx, query, historyID, projectID, key).Scan(
+			&e.ID, &e.ProjectID, &e.Key, &e.ValueEncrypted, &e.CreatedAt,
+		)
+	})
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errors.New("env var history entry not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
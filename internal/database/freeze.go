@@ -0,0 +1,105 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// A recurring period during which deployments are frozen
+// CronExpression (standard 5-field, UTC) marks the start of each window
+type FreezeWindow struct {
+	CronExpression  string `json:"cron_expression"`
+	DurationMinutes int    `json:"duration_minutes"`
+}
+
+// Checks the cron expression parses and the duration is sensible
+func (w *FreezeWindow) Validate() error {
+	if _, err := cron.ParseStandard(w.CronExpression); err != nil {
+		return fmt.Errorf("invalid cron expression: %w", err)
+	}
+	if w.DurationMinutes <= 0 || w.DurationMinutes > 7*24*60 {
+		return errors.New("duration_minutes must be between 1 and 10080")
+	}
+	return nil
+}
+
+// Reports whether t (UTC) falls inside this window
+func (w *FreezeWindow) IsActive(t time.Time) bool {
+	schedule, err := cron.ParseStandard(w.CronExpression)
+	if err != nil {
+		return false
+	}
+
+	// The window is active if it started within the last DurationMinutes
+	duration := time.Duration(w.DurationMinutes) * time.Minute
+	start := schedule.Next(t.UTC().Add(-duration))
+	return !start.After(t.UTC())
+}
+
+// Reports whether the project is inside any of its freeze windows
+func (p *Project) IsFrozen(t time.Time) bool {
+	for i := range p.FreezeWindows {
+		if p.FreezeWindows[i].IsActive(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Appends a freeze window to a project
+func AddProjectFreezeWindow(ctx context.Context, id string,
+	window *FreezeWindow) error {
+	defer InvalidateProjectCache(id)
+
+	data, err := json.Marshal([]*FreezeWindow{window})
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE projects SET
+			freeze_windows = freeze_windows || $2::jsonb,
+			updated_at = NOW()
+		WHERE id = $1
+	`
+
+	result, err := pool.Exec(ctx, query, id, string(data))
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("project not found")
+	}
+
+	return nil
+}
+
+// Removes the freeze window at index from a project
+func RemoveProjectFreezeWindow(ctx context.Context, id string,
+	index int) error {
+	defer InvalidateProjectCache(id)
+
+	query := `
+		UPDATE projects SET
+			freeze_windows = freeze_windows - $2::int,
+			updated_at = NOW()
+		WHERE id = $1
+	`
+
+	result, err := pool.Exec(ctx, query, id, index)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("project not found")
+	}
+
+	return nil
+}
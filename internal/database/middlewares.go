@@ -0,0 +1,158 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Traefik middleware types a project can configure
+const (
+	MiddlewareBasicAuth   = "basic_auth"
+	MiddlewareIPAllowlist = "ip_allowlist"
+)
+
+// Reports whether t is a supported middleware type
+func IsValidMiddlewareType(t string) bool {
+	return t == MiddlewareBasicAuth || t == MiddlewareIPAllowlist
+}
+
+// Password hash prefixes Traefik's basicauth accepts (MD5, bcrypt, SHA1)
+var htpasswdHashPrefixes = []string{"$apr1$", "$2a$", "$2b$", "$2y$", "{SHA}"}
+
+// A Traefik middleware in front of a project's routers
+// Users are htpasswd entries (user:hash); SourceRange holds IPs or CIDRs
+type TraefikMiddleware struct {
+	Type        string   `json:"type"`
+	Users       []string `json:"users,omitempty"`
+	SourceRange []string `json:"source_range,omitempty"`
+}
+
+// Checks the middleware has valid settings for its type
+func (m *TraefikMiddleware) Validate() error {
+	switch m.Type {
+	case MiddlewareBasicAuth:
+		if len(m.Users) == 0 {
+			return errors.New("basic_auth needs at least one user")
+		}
+		for _, entry := range m.Users {
+			if err := validateHtpasswdEntry(entry); err != nil {
+				return err
+			}
+		}
+	case MiddlewareIPAllowlist:
+		if len(m.SourceRange) == 0 {
+			return errors.New("ip_allowlist needs at least one source range")
+		}
+		for _, r := range m.SourceRange {
+			if _, _, err := net.ParseCIDR(r); err != nil &&
+				net.ParseIP(r) == nil {
+				return fmt.Errorf("invalid IP or CIDR: %q", r)
+			}
+		}
+	default:
+		return errors.New("type must be basic_auth or ip_allowlist")
+	}
+	return nil
+}
+
+// Checks entry is user:hash with a hash Traefik can verify
+// Plain-text passwords are rejected so they're never stored
+func validateHtpasswdEntry(entry string) error {
+	user, hash, ok := strings.Cut(entry, ":")
+	if !ok || user == "" || strings.ContainsAny(entry, ",\n") {
+		return fmt.Errorf("invalid htpasswd entry for user %q", user)
+	}
+	for _, prefix := range htpasswdHashPrefixes {
+		if strings.HasPrefix(hash, prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf(
+		"password for %q must be an htpasswd MD5, bcrypt or SHA1 hash", user)
+}
+
+// Returns the project's middleware of type t (nil if not configured)
+func (p *Project) Middleware(t string) *TraefikMiddleware {
+	for i := range p.TraefikMiddlewares {
+		if p.TraefikMiddlewares[i].Type == t {
+			return &p.TraefikMiddlewares[i]
+		}
+	}
+	return nil
+}
+
+// The project's htpasswd users & allowed source ranges (nil if unset)
+func (p *Project) MiddlewareSettings() (basicAuthUsers,
+	ipAllowlist []string) {
+	if m := p.Middleware(MiddlewareBasicAuth); m != nil {
+		basicAuthUsers = m.Users
+	}
+	if m := p.Middleware(MiddlewareIPAllowlist); m != nil {
+		ipAllowlist = m.SourceRange
+	}
+	return basicAuthUsers, ipAllowlist
+}
+
+// Sets a project's middleware, replacing any of the same type
+func SetProjectMiddleware(ctx context.Context, id string,
+	m *TraefikMiddleware) error {
+	defer InvalidateProjectCache(id)
+
+	data, err := json.Marshal([]*TraefikMiddleware{m})
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE projects SET
+			traefik_middlewares = COALESCE((
+				SELECT jsonb_agg(e)
+				FROM jsonb_array_elements(traefik_middlewares) e
+				WHERE e->>'type' <> $2
+			), '[]'::jsonb) || $3::jsonb,
+			updated_at = NOW()
+		WHERE id = $1
+	`
+
+	result, err := pool.Exec(ctx, query, id, m.Type, string(data))
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("project not found")
+	}
+
+	return nil
+}
+
+// Removes a project's middleware of type t
+func RemoveProjectMiddleware(ctx context.Context, id, t string) error {
+	defer InvalidateProjectCache(id)
+
+	query := `
+		UPDATE projects SET
+			traefik_middlewares = COALESCE((
+				SELECT jsonb_agg(e)
+				FROM jsonb_array_elements(traefik_middlewares) e
+				WHERE e->>'type' <> $2
+			), '[]'::jsonb),
+			updated_at = NOW()
+		WHERE id = $1
+	`
+
+	result, err := pool.Exec(ctx, query, id, t)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("project not found")
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/Sys-Redux/rcnbuild-paas/migrations"
+	"github.com/golang-migrate/migrate/v4"
+	pgxmigrate "github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/rs/zerolog/log"
+)
+
+// Applies every pending embedded migration
+// Uses the schema_migrations table the migrate CLI (make migrate-up) keeps,
+// so databases migrated either way stay in step. Safe to call from several
+// processes at once; migrate holds an advisory lock while applying
+func RunMigrations(ctx context.Context) error {
+	m, err := newMigrate()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	// Let a shutdown stop between migrations rather than mid-file
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			m.GracefulStop <- true
+		case <-done:
+		}
+	}()
+
+	before, _, _ := m.Version()
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("failed to apply migrations: %w", err)
+	}
+	after, _, _ := m.Version()
+
+	if after != before {
+		log.Info().Uint("from", before).Uint("to", after).
+			Msg("Applied database migrations")
+	}
+	return nil
+}
+
+// Lists the embedded migrations not yet applied, oldest first
+// Each entry is the up file's name, e.g. 000035_add_x.up.sql
+func PendingMigrations() ([]string, error) {
+	m, err := newMigrate()
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+
+	current, dirty, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return nil, err
+	}
+	if dirty {
+		return nil, fmt.Errorf("database is dirty at migration %d", current)
+	}
+
+	src, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	var pending []string
+	version, err := src.First()
+	for err == nil {
+		if version > current {
+			name, err := upFileName(version)
+			if err != nil {
+				return nil, err
+			}
+			pending = append(pending, name)
+		}
+		version, err = src.Next(version)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// Migrate instance over the embedded files & the shared pool
+func newMigrate() (*migrate.Migrate, error) {
+	src, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	// Closing this *sql.DB (via m.Close) leaves the pool open
+	driver, err := pgxmigrate.WithInstance(stdlib.OpenDBFromPool(pool),
+		&pgxmigrate.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	return migrate.NewWithInstance("iofs", src, "pgx5", driver)
+}
+
+// Name of the embedded up file for a migration version
+func upFileName(version uint) (string, error) {
+	matches, err := fs.Glob(migrations.FS,
+		fmt.Sprintf("%06d_*.up.sql", version))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no up migration for version %d", version)
+	}
+	return matches[0], nil
+}
@@ -3,53 +3,187 @@ package database
 import (
 	"context"
 	"errors"
+	"sync/atomic"
 	"time"
+
+	"github.com/Sys-Redux/rcnbuild-paas/pkg/crypto"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/jackc/pgx/v5"
+)
+
+// Deployment environment tiers
+const (
+	EnvironmentProduction = "production"
+	EnvironmentStaging    = "staging"
+	EnvironmentPreview    = "preview"
+)
+
+// Checks if env is a known environment tier
+func IsValidEnvironment(env string) bool {
+	switch env {
+	case EnvironmentProduction, EnvironmentStaging, EnvironmentPreview:
+		return true
+	}
+	return false
+}
+
+// How a new container replaces the running one
+const (
+	DeployStrategyRecreate  = "recreate"
+	DeployStrategyBlueGreen = "blue_green"
 )
 
+// Checks if strategy is a supported deploy strategy
+func IsValidDeployStrategy(strategy string) bool {
+	return strategy == DeployStrategyRecreate ||
+		strategy == DeployStrategyBlueGreen
+}
+
 // Project represents a deployed application
 type Project struct {
-	ID            string    `json:"id"`
-	UserID        string    `json:"user_id"`
-	Name          string    `json:"name"`
-	Slug          string    `json:"slug"`
-	RepoFullName  string    `json:"repo_full_name"`
-	RepoURL       string    `json:"repo_url"`
-	Branch        string    `json:"branch"`
-	RootDirectory string    `json:"root_directory"`
-	BuildCommand  *string   `json:"build_command,omitempty"`
-	StartCommand  *string   `json:"start_command,omitempty"`
-	Runtime       *string   `json:"runtime,omitempty"`
-	Port          int       `json:"port"`
-	WebhookID     *int64    `json:"-"`
-	WebhookSecret *string   `json:"-"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
+	ID                  string              `json:"id"`
+	UserID              string              `json:"user_id"`
+	Name                string              `json:"name"`
+	DisplayName         *string             `json:"display_name,omitempty"`
+	Description         *string             `json:"description,omitempty"`
+	Slug                string              `json:"slug"`
+	RepoFullName        string              `json:"repo_full_name"`
+	RepoURL             string              `json:"repo_url"`
+	Branch              string              `json:"branch"`
+	RootDirectory       string              `json:"root_directory"`
+	BuildContext        string              `json:"build_context"` // "" = RootDirectory
+	BuildCommand        *string             `json:"build_command,omitempty"`
+	StartCommand        *string             `json:"start_command,omitempty"`
+	Runtime             *string             `json:"runtime,omitempty"`
+	Port                int                 `json:"port"`
+	Environment         string              `json:"environment"`
+	WebhookID           *int64              `json:"-"`
+	WebhookSecret       *string             `json:"-"`
+	DeployKeyID         *int64              `json:"deploy_key_id,omitempty"`
+	DeployKeyEncrypted  *string             `json:"-"`
+	NotificationURL     *string             `json:"notification_url,omitempty"`
+	NotificationSecret  *string             `json:"-"`
+	PausedAt            *time.Time          `json:"paused_at,omitempty"`
+	FreezeWindows       []FreezeWindow      `json:"freeze_windows"`
+	Tags                []string            `json:"tags"`
+	MaxConcurrentBuilds int                 `json:"max_concurrent_builds"`
+	DeployStrategy      string              `json:"deploy_strategy"`
+	BuildEnvVars        map[string]string   `json:"build_env_vars"`
+	BuildSecrets        []BuildSecret       `json:"build_secrets"`
+	SubmodulesEnabled   bool                `json:"submodules_enabled"`
+	WatchPullRequests   bool                `json:"watch_pull_requests"`
+	BaseDomain          *string             `json:"base_domain,omitempty"`
+	ReloadSignal        *string             `json:"reload_signal,omitempty"`
+	TraefikMiddlewares  []TraefikMiddleware `json:"traefik_middlewares"`
+	// Run in new containers; the deploy fails unless it exits 0 in time
+	ReadinessProbeCmd            *string   `json:"readiness_probe_cmd,omitempty"`
+	ReadinessProbeTimeoutSeconds int       `json:"readiness_probe_timeout_seconds"`
+	CreatedAt                    time.Time `json:"created_at"`
+	UpdatedAt                    time.Time `json:"updated_at"`
+}
+
+// How long the readiness probe may keep failing
+func (p *Project) ReadinessProbeTimeout() time.Duration {
+	return time.Duration(p.ReadinessProbeTimeoutSeconds) * time.Second
+}
+
+// An env var exposed to the build as a BuildKit secret (never as a layer)
+// Dockerfiles read it with `RUN --mount=type=secret,id=<Key>`
+type BuildSecret struct {
+	Key        string `json:"key"`
+	EnvVarName string `json:"env_var_name"`
+}
+
+// Columns selected by every project query (order matches scanProject)
+const projectColumns = `
+	id, user_id, name, display_name, description, slug, repo_full_name,
+	repo_url,
+	branch, root_directory, build_command, start_command,
+	runtime, port, environment, webhook_id, webhook_secret,
+	deploy_key_id, deploy_key_encrypted, notification_url,
+	notification_secret, paused_at, freeze_windows, tags,
+	max_concurrent_builds, deploy_strategy, build_env_vars, build_secrets,
+	submodules_enabled, watch_pull_requests, base_domain, reload_signal,
+	traefik_middlewares, build_context, readiness_probe_cmd,
+	readiness_probe_timeout_seconds, created_at, updated_at`
+
+// Scans a single project row selected with projectColumns
+func scanProject(row pgx.Row) (*Project, error) {
+	var p Project
+	if err := row.Scan(projectScanDest(&p)...); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Scan destinations for projectColumns, in order
+func projectScanDest(p *Project) []any {
+	return []any{
+		&p.ID, &p.UserID, &p.Name, &p.DisplayName, &p.Description, &p.Slug,
+		&p.RepoFullName, &p.RepoURL,
+		&p.Branch, &p.RootDirectory, &p.BuildCommand, &p.StartCommand,
+		&p.Runtime, &p.Port, &p.Environment, &p.WebhookID, &p.WebhookSecret,
+		&p.DeployKeyID, &p.DeployKeyEncrypted, &p.NotificationURL,
+		&p.NotificationSecret, &p.PausedAt, &p.FreezeWindows, &p.Tags,
+		&p.MaxConcurrentBuilds, &p.DeployStrategy, &p.BuildEnvVars,
+		&p.BuildSecrets, &p.SubmodulesEnabled, &p.WatchPullRequests,
+		&p.BaseDomain, &p.ReloadSignal, &p.TraefikMiddlewares,
+		&p.BuildContext, &p.ReadinessProbeCmd, &p.ReadinessProbeTimeoutSeconds,
+		&p.CreatedAt, &p.UpdatedAt,
+	}
 }
 
 // For creating a new project
 type CreateProjectInput struct {
-	UserId        string
-	Name          string
-	Slug          string
-	RepoFullName  string
-	RepoURL       string
-	Branch        string
-	RootDirectory string
-	BuildCommand  *string
-	StartCommand  *string
-	Runtime       *string
-	Port          int
+	UserId            string
+	Name              string
+	Slug              string
+	RepoFullName      string
+	RepoURL           string
+	Branch            string
+	RootDirectory     string
+	BuildContext      string // "" builds from RootDirectory
+	BuildCommand      *string
+	StartCommand      *string
+	Runtime           *string
+	Port              int
+	Environment       string
+	Tags              []string
+	BuildEnvVars      map[string]string
+	BuildSecrets      []BuildSecret
+	SubmodulesEnabled bool
+	WatchPullRequests bool
+	BaseDomain        *string // nil uses BASE_DOMAIN
+	DisplayName       *string
+	Description       *string
+	ReadinessProbeCmd *string
+	// Seconds the probe may keep failing; 0 uses the default (60)
+	ReadinessProbeTimeoutSeconds int
 }
 
 // Contains fields that can be updated
 type UpdateProjectInput struct {
-	Name          *string
-	Branch        *string
-	RootDirectory *string
-	BuildCommand  *string
-	StartCommand  *string
-	Runtime       *string
-	Port          *int
+	Name              *string
+	Branch            *string
+	RootDirectory     *string
+	BuildContext      *string // "" resets it to RootDirectory
+	BuildCommand      *string
+	StartCommand      *string
+	Runtime           *string
+	Port              *int
+	Tags              []string // nil leaves tags unchanged
+	DeployStrategy    *string
+	BuildEnvVars      map[string]string // nil leaves build env unchanged
+	BuildSecrets      []BuildSecret     // nil leaves build secrets unchanged
+	SubmodulesEnabled *bool
+	WatchPullRequests *bool
+	BaseDomain        *string // "" clears it (back to BASE_DOMAIN)
+	DisplayName       *string // "" clears it
+	Description       *string // "" clears it
+	ReloadSignal      *string // "" clears it (back to SIGHUP)
+	ReadinessProbeCmd *string // "" clears it (no probe)
+	// Seconds the probe may keep failing before the deploy fails
+	ReadinessProbeTimeoutSeconds *int
 }
 
 // Inserts a new project in database
@@ -59,15 +193,17 @@ func CreateProject(ctx context.Context,
 		INSERT INTO projects (
 			user_id, name, slug, repo_full_name, repo_url,
 			branch, root_directory, build_command, start_command,
-			runtime, port
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-		RETURNING id, user_id, name, slug, repo_full_name, repo_url,
-			branch, root_directory, build_command, start_command,
-			runtime, port, webhook_id, webhook_secret, created_at, updated_at
-	`
-
-	var p Project
-	err := pool.QueryRow(ctx, query,
+			runtime, port, environment, tags, build_env_vars,
+			display_name, description, build_secrets, submodules_enabled,
+			watch_pull_requests, base_domain, build_context,
+			readiness_probe_cmd, readiness_probe_timeout_seconds
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12,
+			COALESCE($13::text[], '{}'), COALESCE($14::jsonb, '{}'),
+			$15, $16, COALESCE($17::jsonb, '[]'), $18, $19, $20, $21,
+			$22, COALESCE(NULLIF($23, 0), 60))
+		RETURNING ` + projectColumns
+
+	return scanProject(pool.QueryRow(ctx, query,
 		input.UserId,
 		input.Name,
 		input.Slug,
@@ -79,113 +215,236 @@ func CreateProject(ctx context.Context,
 		input.StartCommand,
 		input.Runtime,
 		input.Port,
-	).Scan(
-		&p.ID, &p.UserID, &p.Name, &p.Slug, &p.RepoFullName, &p.RepoURL,
-		&p.Branch, &p.RootDirectory, &p.BuildCommand, &p.StartCommand,
-		&p.Runtime, &p.Port, &p.WebhookID, &p.WebhookSecret,
-		&p.CreatedAt, &p.UpdatedAt,
-	)
+		input.Environment,
+		input.Tags,
+		input.BuildEnvVars,
+		input.DisplayName,
+		input.Description,
+		input.BuildSecrets,
+		input.SubmodulesEnabled,
+		input.WatchPullRequests,
+		input.BaseDomain,
+		input.BuildContext,
+		input.ReadinessProbeCmd,
+		input.ReadinessProbeTimeoutSeconds,
+	))
+}
 
+// Inserts a new project & stores its GitHub webhook in one transaction
+// so a project is never left without its webhook secret
+func CreateProjectWithWebhook(ctx context.Context, input *CreateProjectInput,
+	webhookID int64, encryptedSecret string) (*Project, error) {
+	insertQuery := `
+		INSERT INTO projects (
+			user_id, name, slug, repo_full_name, repo_url,
+			branch, root_directory, build_command, start_command,
+			runtime, port, environment, tags, build_env_vars,
+			display_name, description, build_secrets, submodules_enabled,
+			watch_pull_requests, base_domain, build_context,
+			readiness_probe_cmd, readiness_probe_timeout_seconds
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12,
+			COALESCE($13::text[], '{}'), COALESCE($14::jsonb, '{}'),
+			$15, $16, COALESCE($17::jsonb, '[]'), $18, $19, $20, $21,
+			$22, COALESCE(NULLIF($23, 0), 60))
+		RETURNING id
+	`
+	webhookQuery := `
+		UPDATE projects SET
+			webhook_id = $2,
+			webhook_secret = $3,
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING ` + projectColumns
+
+	var project *Project
+	err := withTx(ctx, func(tx pgx.Tx) error {
+		var id string
+		err := tx.QueryRow(ctx, insertQuery,
+			input.UserId,
+			input.Name,
+			input.Slug,
+			input.RepoFullName,
+			input.RepoURL,
+			input.Branch,
+			input.RootDirectory,
+			input.BuildCommand,
+			input.StartCommand,
+			input.Runtime,
+			input.Port,
+			input.Environment,
+			input.Tags,
+			input.BuildEnvVars,
+			input.DisplayName,
+			input.Description,
+			input.BuildSecrets,
+			input.SubmodulesEnabled,
+			input.WatchPullRequests,
+			input.BaseDomain,
+			input.BuildContext,
+			input.ReadinessProbeCmd,
+			input.ReadinessProbeTimeoutSeconds,
+		).Scan(&id)
+		if err != nil {
+			return err
+		}
+
+		project, err = scanProject(tx.QueryRow(ctx, webhookQuery,
+			id, webhookID, encryptedSecret))
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	return &p, nil
+	return project, nil
 }
 
-// Retrieves project by its UUID
+// Short-lived cache for GetProjectByID, which runs on most API requests
+// Writes through this package evict their entry; writes made by another
+// process show up once the entry expires
+const (
+	projectCacheSize = 1000
+	projectCacheTTL  = 30 * time.Second
+)
+
+var (
+	projectCache = expirable.NewLRU[string, *Project](projectCacheSize, nil,
+		projectCacheTTL)
+	projectCacheHits   atomic.Int64
+	projectCacheMisses atomic.Int64
+)
+
+// Retrieves project by its UUID (cached for up to projectCacheTTL)
+// Returns a copy, so callers may modify the top-level fields freely
 func GetProjectByID(ctx context.Context, id string) (*Project, error) {
+	if cached, ok := projectCache.Get(id); ok {
+		projectCacheHits.Add(1)
+		p := *cached
+		return &p, nil
+	}
+	projectCacheMisses.Add(1)
+
 	query := `
-		SELECT
-			id, user_id, name, slug, repo_full_name, repo_url,
-			branch, root_directory, build_command, start_command,
-			runtime, port, webhook_id, webhook_secret,
-			created_at, updated_at
+		SELECT ` + projectColumns + `
 		FROM projects
 		WHERE id = $1
 	`
 
-	var p Project
-	err := pool.QueryRow(ctx, query, id).Scan(
-		&p.ID, &p.UserID, &p.Name, &p.Slug, &p.RepoFullName, &p.RepoURL,
-		&p.Branch, &p.RootDirectory, &p.BuildCommand, &p.StartCommand,
-		&p.Runtime, &p.Port, &p.WebhookID, &p.WebhookSecret,
-		&p.CreatedAt, &p.UpdatedAt,
-	)
-
+	p, err := scanProject(pool.QueryRow(ctx, query, id))
 	if err != nil {
 		return nil, err
 	}
-	return &p, nil
+	cached := *p
+	projectCache.Add(id, &cached)
+	return p, nil
+}
+
+// Drops a project from the GetProjectByID cache
+// For callers that change projects outside this package's helpers
+func InvalidateProjectCache(id string) {
+	projectCache.Remove(id)
+}
+
+// GetProjectByID cache hit & miss counts since startup
+func ProjectCacheStats() (hits, misses int) {
+	return int(projectCacheHits.Load()), int(projectCacheMisses.Load())
 }
 
 // Retrieves project by its slug
 func GetProjectBySlug(ctx context.Context, slug string) (*Project, error) {
 	query := `
-		SELECT
-			id, user_id, name, slug, repo_full_name, repo_url,
-			branch, root_directory, build_command, start_command,
-			runtime, port, webhook_id, webhook_secret,
-			created_at, updated_at
+		SELECT ` + projectColumns + `
 		FROM projects
 		WHERE slug = $1
 	`
 
-	var p Project
-	err := pool.QueryRow(ctx, query, slug).Scan(
-		&p.ID, &p.UserID, &p.Name, &p.Slug, &p.RepoFullName, &p.RepoURL,
-		&p.Branch, &p.RootDirectory, &p.BuildCommand, &p.StartCommand,
-		&p.Runtime, &p.Port, &p.WebhookID, &p.WebhookSecret,
-		&p.CreatedAt, &p.UpdatedAt,
-	)
+	return scanProject(pool.QueryRow(ctx, query, slug))
+}
 
+// Gets project by repo full name & environment
+func GetProjectByRepoFullName(ctx context.Context,
+	repoFullName, environment string) (*Project, error) {
+	query := `
+		SELECT ` + projectColumns + `
+		FROM projects
+		WHERE repo_full_name = $1 AND environment = $2
+	`
+
+	return scanProject(pool.QueryRow(ctx, query, repoFullName, environment))
+}
+
+// Gets all projects (one per environment) for a repo
+func GetProjectsByRepoFullName(ctx context.Context,
+	repoFullName string) ([]*Project, error) {
+	query := `
+		SELECT ` + projectColumns + `
+		FROM projects
+		WHERE repo_full_name = $1
+		ORDER BY created_at ASC
+	`
+
+	rows, err := pool.Query(ctx, query, repoFullName)
 	if err != nil {
 		return nil, err
 	}
-	return &p, nil
+	defer rows.Close()
+
+	var projects []*Project
+	for rows.Next() {
+		p, err := scanProject(rows)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, p)
+	}
+
+	return projects, nil
 }
 
-// Gets project by repo full name
-func GetProjectByRepoFullName(ctx context.Context,
-	repoFullName string) (*Project, error) {
+// Get every project with a live deployment (i.e. one being served)
+func GetLiveProjects(ctx context.Context) ([]*Project, error) {
 	query := `
-		SELECT
-			id, user_id, name, slug, repo_full_name, repo_url,
-			branch, root_directory, build_command, start_command,
-			runtime, port, webhook_id, webhook_secret,
-			created_at, updated_at
+		SELECT ` + projectColumns + `
 		FROM projects
-		WHERE repo_full_name = $1
+		WHERE EXISTS (
+			SELECT 1 FROM deployments d
+			WHERE d.project_id = projects.id AND d.status = 'live'
+		)
+		ORDER BY created_at ASC
 	`
 
-	var p Project
-	err := pool.QueryRow(ctx, query, repoFullName).Scan(
-		&p.ID, &p.UserID, &p.Name, &p.Slug, &p.RepoFullName, &p.RepoURL,
-		&p.Branch, &p.RootDirectory, &p.BuildCommand, &p.StartCommand,
-		&p.Runtime, &p.Port, &p.WebhookID, &p.WebhookSecret,
-		&p.CreatedAt, &p.UpdatedAt,
-	)
-
+	rows, err := pool.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
-	return &p, nil
+	defer rows.Close()
+
+	var projects []*Project
+	for rows.Next() {
+		p, err := scanProject(rows)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, p)
+	}
+
+	return projects, rows.Err()
 }
 
-// Get projects owned by a user
-func GetProjectsByUserID(ctx context.Context,
-	userID string) ([]*Project, error) {
+// Get a page of projects owned by a user, newest first
+// Optionally filtered to projects carrying the given tag
+// A limit of 0 returns every project (for cleanup paths)
+func GetProjectsByUserID(ctx context.Context, userID string, tag *string,
+	limit, offset int) ([]*Project, error) {
 	query := `
-		SELECT
-			id, user_id, name, slug, repo_full_name, repo_url,
-			branch, root_directory, build_command, start_command,
-			runtime, port, webhook_id, webhook_secret,
-			created_at, updated_at
+		SELECT ` + projectColumns + `
 		FROM projects
 		WHERE user_id = $1
-		ORDER BY created_at DESC
+			AND ($2::text = ANY(tags) OR $2::text IS NULL)
+		ORDER BY created_at DESC, id
+		LIMIT NULLIF($3, 0) OFFSET $4
 	`
 
-	rows, err := pool.Query(ctx, query, userID)
+	rows, err := pool.Query(ctx, query, userID, tag, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -193,25 +452,150 @@ func GetProjectsByUserID(ctx context.Context,
 
 	var projects []*Project
 	for rows.Next() {
-		var p Project
-		err := rows.Scan(
-			&p.ID, &p.UserID, &p.Name, &p.Slug, &p.RepoFullName, &p.RepoURL,
-			&p.Branch, &p.RootDirectory, &p.BuildCommand, &p.StartCommand,
-			&p.Runtime, &p.Port, &p.WebhookID, &p.WebhookSecret,
-			&p.CreatedAt, &p.UpdatedAt,
-		)
+		p, err := scanProject(rows)
 		if err != nil {
 			return nil, err
 		}
-		projects = append(projects, &p)
+		projects = append(projects, p)
 	}
 
 	return projects, nil
 }
 
+// Key fields of a project's live deployment
+type DeploymentSummary struct {
+	ID          string           `json:"id"`
+	Status      DeploymentStatus `json:"status"`
+	URL         *string          `json:"url,omitempty"`
+	CommitSHA   string           `json:"commit_sha"`
+	CompletedAt *time.Time       `json:"completed_at,omitempty"`
+}
+
+// A project with its live deployment (nil if none is live)
+type ProjectWithStatus struct {
+	Project
+	LatestDeployment *DeploymentSummary `json:"latest_deployment"`
+}
+
+// Get a page of a user's projects with their live deployment in one query
+// Optionally filtered to projects carrying the given tag
+func GetProjectsWithStatusByUserID(ctx context.Context, userID string,
+	tag *string, limit, offset int) ([]*ProjectWithStatus, error) {
+	query := `
+		WITH live AS (
+			SELECT DISTINCT ON (project_id)
+				project_id, id AS deployment_id, status AS deployment_status,
+				url AS deployment_url, commit_sha AS deployment_commit_sha,
+				completed_at AS deployment_completed_at
+			FROM deployments
+			WHERE status = 'live' AND deployment_type <> 'preview'
+			ORDER BY project_id, completed_at DESC NULLS LAST
+		)
+		SELECT ` + projectColumns + `,
+			live.deployment_id, live.deployment_status, live.deployment_url,
+			live.deployment_commit_sha, live.deployment_completed_at
+		FROM projects
+		LEFT JOIN live ON live.project_id = projects.id
+		WHERE user_id = $1
+			AND ($2::text = ANY(tags) OR $2::text IS NULL)
+		ORDER BY created_at DESC, id
+		LIMIT $3 OFFSET $4
+	`
+
+	rows, err := pool.Query(ctx, query, userID, tag, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var projects []*ProjectWithStatus
+	for rows.Next() {
+		var p ProjectWithStatus
+		var deploymentID, status, commitSHA *string
+		var url *string
+		var completedAt *time.Time
+
+		dest := append(projectScanDest(&p.Project),
+			&deploymentID, &status, &url, &commitSHA, &completedAt)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		if deploymentID != nil {
+			p.LatestDeployment = &DeploymentSummary{
+				ID:          *deploymentID,
+				Status:      DeploymentStatus(*status),
+				URL:         url,
+				CommitSHA:   *commitSHA,
+				CompletedAt: completedAt,
+			}
+		}
+		projects = append(projects, &p)
+	}
+
+	return projects, rows.Err()
+}
+
+// Count projects owned by a user
+func CountProjectsByUserID(ctx context.Context, userID string) (int, error) {
+	return CountProjectsByUserIDAndTag(ctx, userID, nil)
+}
+
+// Count a user's projects, optionally only those carrying the given tag
+// Matches the filter used by GetProjectsWithStatusByUserID
+func CountProjectsByUserIDAndTag(ctx context.Context, userID string,
+	tag *string) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM projects
+		WHERE user_id = $1
+			AND ($2::text = ANY(tags) OR $2::text IS NULL)
+	`
+
+	var count int
+	err := pool.QueryRow(ctx, query, userID, tag).Scan(&count)
+	return count, err
+}
+
+// A tag & how many of a user's projects carry it
+type TagCount struct {
+	Tag      string `json:"tag"`
+	Projects int    `json:"projects"`
+}
+
+// Get all distinct tags across a user's projects with project counts
+func GetUserTags(ctx context.Context, userID string) ([]*TagCount, error) {
+	query := `
+		SELECT tag, COUNT(*)
+		FROM projects, unnest(tags) AS tag
+		WHERE user_id = $1
+		GROUP BY tag
+		ORDER BY COUNT(*) DESC, tag ASC
+	`
+
+	rows, err := pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []*TagCount{}
+	for rows.Next() {
+		var t TagCount
+		if err := rows.Scan(&t.Tag, &t.Projects); err != nil {
+			return nil, err
+		}
+		tags = append(tags, &t)
+	}
+
+	return tags, rows.Err()
+}
+
 // Update a projects settings
 func UpdateProject(ctx context.Context, id string,
 	input *UpdateProjectInput) (*Project, error) {
+	defer InvalidateProjectCache(id)
+
 	query := `
 		UPDATE projects SET
 			name = COALESCE($2, name),
@@ -221,17 +605,25 @@ func UpdateProject(ctx context.Context, id string,
 			start_command = COALESCE($6, start_command),
 			runtime = COALESCE($7, runtime),
 			port = COALESCE($8, port),
+			tags = COALESCE($9, tags),
+			deploy_strategy = COALESCE($10, deploy_strategy),
+			build_env_vars = COALESCE($11::jsonb, build_env_vars),
+			display_name = NULLIF(COALESCE($12, display_name), ''),
+			description = NULLIF(COALESCE($13, description), ''),
+			build_secrets = COALESCE($14::jsonb, build_secrets),
+			submodules_enabled = COALESCE($15, submodules_enabled),
+			watch_pull_requests = COALESCE($16, watch_pull_requests),
+			base_domain = NULLIF(COALESCE($17, base_domain), ''),
+			reload_signal = NULLIF(COALESCE($18, reload_signal), ''),
+			build_context = COALESCE($19, build_context),
+			readiness_probe_cmd = NULLIF(COALESCE($20, readiness_probe_cmd), ''),
+			readiness_probe_timeout_seconds = COALESCE($21,
+				readiness_probe_timeout_seconds),
 			updated_at = NOW()
 		WHERE id = $1
-		RETURNING
-			id, user_id, name, slug, repo_full_name, repo_url,
-			branch, root_directory, build_command, start_command,
-			runtime, port, webhook_id, webhook_secret,
-			created_at, updated_at
-	`
+		RETURNING ` + projectColumns
 
-	var p Project
-	err := pool.QueryRow(ctx, query,
+	return scanProject(pool.QueryRow(ctx, query,
 		id,
 		input.Name,
 		input.Branch,
@@ -240,22 +632,27 @@ func UpdateProject(ctx context.Context, id string,
 		input.StartCommand,
 		input.Runtime,
 		input.Port,
-	).Scan(
-		&p.ID, &p.UserID, &p.Name, &p.Slug, &p.RepoFullName, &p.RepoURL,
-		&p.Branch, &p.RootDirectory, &p.BuildCommand, &p.StartCommand,
-		&p.Runtime, &p.Port, &p.WebhookID, &p.WebhookSecret,
-		&p.CreatedAt, &p.UpdatedAt,
-	)
-
-	if err != nil {
-		return nil, err
-	}
-	return &p, nil
+		input.Tags,
+		input.DeployStrategy,
+		input.BuildEnvVars,
+		input.DisplayName,
+		input.Description,
+		input.BuildSecrets,
+		input.SubmodulesEnabled,
+		input.WatchPullRequests,
+		input.BaseDomain,
+		input.ReloadSignal,
+		input.BuildContext,
+		input.ReadinessProbeCmd,
+		input.ReadinessProbeTimeoutSeconds,
+	))
 }
 
 // Store GitHub webhook ID & secret
 func SetProjectWebhook(ctx context.Context, id string,
 	webhookID int64, secret string) error {
+	defer InvalidateProjectCache(id)
+
 	query := `
 		UPDATE projects SET
 			webhook_id = $2,
@@ -276,8 +673,186 @@ func SetProjectWebhook(ctx context.Context, id string,
 	return nil
 }
 
+// Points a project at another repo, with the webhook created on it (both
+// nil if GitHub refused it). The old repo's deploy key is forgotten
+// NOTE: Caller must encrypt the secret first using crypto.Encrypt()
+func SetProjectRepo(ctx context.Context, id, repoFullName, repoURL string,
+	webhookID *int64, encryptedSecret *string) error {
+	defer InvalidateProjectCache(id)
+
+	query := `
+		UPDATE projects SET
+			repo_full_name = $2,
+			repo_url = $3,
+			webhook_id = $4,
+			webhook_secret = $5,
+			deploy_key_id = NULL,
+			deploy_key_encrypted = NULL,
+			updated_at = NOW()
+		WHERE id = $1
+	`
+
+	result, err := pool.Exec(ctx, query, id, repoFullName, repoURL,
+		webhookID, encryptedSecret)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("project not found")
+	}
+
+	return nil
+}
+
+// Forget a project's GitHub webhook (e.g. once it's been deleted)
+func ClearProjectWebhook(ctx context.Context, id string) error {
+	defer InvalidateProjectCache(id)
+
+	query := `
+		UPDATE projects SET
+			webhook_id = NULL,
+			webhook_secret = NULL,
+			updated_at = NOW()
+		WHERE id = $1
+	`
+
+	result, err := pool.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("project not found")
+	}
+
+	return nil
+}
+
+// Retrieves & decrypts the GitHub webhook secret for a project
+// Used to verify webhook signatures (the column stores ciphertext)
+func GetProjectWebhookSecret(ctx context.Context,
+	projectID string) (string, error) {
+	query := `
+		SELECT webhook_secret
+		FROM projects
+		WHERE id = $1
+	`
+
+	var encryptedSecret *string
+	err := pool.QueryRow(ctx, query, projectID).Scan(&encryptedSecret)
+	if err != nil {
+		return "", err
+	}
+
+	if encryptedSecret == nil || *encryptedSecret == "" {
+		return "", errors.New("project has no webhook secret")
+	}
+
+	return crypto.Decrypt(*encryptedSecret)
+}
+
+// Store GitHub deploy key ID & encrypted private key
+func SetProjectDeployKey(ctx context.Context, id string,
+	deployKeyID int64, encryptedKey string) error {
+	defer InvalidateProjectCache(id)
+
+	query := `
+		UPDATE projects SET
+			deploy_key_id = $2,
+			deploy_key_encrypted = $3,
+			updated_at = NOW()
+		WHERE id = $1
+	`
+
+	result, err := pool.Exec(ctx, query, id, deployKeyID, encryptedKey)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("project not found")
+	}
+
+	return nil
+}
+
+// Retrieves & decrypts the SSH deploy private key for a project
+// Returns an empty string if the project has no deploy key
+func GetProjectDeployKey(ctx context.Context,
+	projectID string) (string, error) {
+	query := `
+		SELECT deploy_key_encrypted
+		FROM projects
+		WHERE id = $1
+	`
+
+	var encryptedKey *string
+	err := pool.QueryRow(ctx, query, projectID).Scan(&encryptedKey)
+	if err != nil {
+		return "", err
+	}
+
+	if encryptedKey == nil || *encryptedKey == "" {
+		return "", nil
+	}
+
+	return crypto.Decrypt(*encryptedKey)
+}
+
+// Set (or clear, when url is nil) the outbound notification webhook
+// NOTE: Caller must encrypt the secret first using crypto.Encrypt()
+func SetProjectNotification(ctx context.Context, id string, url,
+	encryptedSecret *string) error {
+	defer InvalidateProjectCache(id)
+
+	query := `
+		UPDATE projects SET
+			notification_url = $2,
+			notification_secret = $3,
+			updated_at = NOW()
+		WHERE id = $1
+	`
+
+	result, err := pool.Exec(ctx, query, id, url, encryptedSecret)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("project not found")
+	}
+
+	return nil
+}
+
+// Mark a project as paused (paused=true) or resumed (paused=false)
+func SetProjectPaused(ctx context.Context, id string, paused bool) error {
+	defer InvalidateProjectCache(id)
+
+	query := `
+		UPDATE projects SET
+			paused_at = CASE WHEN $2 THEN NOW() ELSE NULL END,
+			updated_at = NOW()
+		WHERE id = $1
+	`
+
+	result, err := pool.Exec(ctx, query, id, paused)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("project not found")
+	}
+
+	return nil
+}
+
 // Remove a project & all related data
 func DeleteProject(ctx context.Context, id string) error {
+	defer InvalidateProjectCache(id)
+
 	query := `DELETE FROM projects WHERE id = $1`
 
 	result, err := pool.Exec(ctx, query, id)
@@ -292,6 +867,34 @@ func DeleteProject(ctx context.Context, id string) error {
 	return nil
 }
 
+// Removes a project with its deployments & env vars in one transaction
+func DeleteProjectWithData(ctx context.Context, id string) error {
+	defer InvalidateProjectCache(id)
+
+	return withTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx,
+			`DELETE FROM deployments WHERE project_id = $1`, id); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx,
+			`DELETE FROM env_vars WHERE project_id = $1`, id); err != nil {
+			return err
+		}
+
+		result, err := tx.Exec(ctx, `DELETE FROM projects WHERE id = $1`, id)
+		if err != nil {
+			return err
+		}
+
+		if result.RowsAffected() == 0 {
+			return errors.New("project not found")
+		}
+
+		return nil
+	})
+}
+
 // Check if a slug is already taken
 func SlugExists(ctx context.Context, slug string) (bool, error) {
 	query := `SELECT EXISTS(SELECT 1 FROM projects WHERE slug = $1)`
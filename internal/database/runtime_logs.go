@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// A chunk of a live container's output
+type RuntimeLog struct {
+	ID           int64     `json:"id"`
+	DeploymentID string    `json:"deployment_id"`
+	Stream       string    `json:"stream"`
+	Content      string    `json:"content"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Stores a chunk of container output for a deployment
+// Output is arbitrary bytes but content is TEXT, so invalid UTF-8 is
+// replaced with U+FFFD & NUL bytes (which Postgres text can't hold) are
+// dropped
+func SaveRuntimeLog(ctx context.Context, deploymentID, stream,
+	content string) error {
+	query := `
+		INSERT INTO runtime_logs (deployment_id, stream, content)
+		VALUES ($1, $2, $3)
+	`
+
+	content = strings.ReplaceAll(strings.ToValidUTF8(content, "\uFFFD"),
+		"\x00", "")
+	_, err := pool.Exec(ctx, query, deploymentID, stream, content)
+	return err
+}
+
+// When a deployment's newest runtime log chunk was saved (nil if none)
+func GetLatestRuntimeLogTime(ctx context.Context,
+	deploymentID string) (*time.Time, error) {
+	query := `
+		SELECT MAX(created_at)
+		FROM runtime_logs
+		WHERE deployment_id = $1
+	`
+
+	var latest *time.Time
+	err := pool.QueryRow(ctx, query, deploymentID).Scan(&latest)
+	return latest, err
+}
+
+// Deletes runtime log chunks saved before cutoff
+// Returns how many were deleted
+func DeleteRuntimeLogsBefore(ctx context.Context,
+	cutoff time.Time) (int64, error) {
+	query := `
+		DELETE FROM runtime_logs
+		WHERE created_at < $1
+	`
+
+	result, err := pool.Exec(ctx, query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// Retrieves up to limit runtime log chunks for a deployment, oldest first
+// A nil since returns chunks from the start of the deployment
+func GetRuntimeLogs(ctx context.Context, deploymentID string,
+	since *time.Time, limit int) ([]*RuntimeLog, error) {
+	query := `
+		SELECT id, deployment_id, stream, content, created_at
+		FROM runtime_logs
+		WHERE deployment_id = $1
+			AND (created_at >= $2 OR $2::timestamptz IS NULL)
+		ORDER BY created_at ASC, id ASC
+		LIMIT $3
+	`
+
+	rows, err := pool.Query(ctx, query, deploymentID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var logs []*RuntimeLog
+	for rows.Next() {
+		var l RuntimeLog
+		if err := rows.Scan(&l.ID, &l.DeploymentID, &l.Stream, &l.Content,
+			&l.CreatedAt); err != nil {
+			return nil, err
+		}
+		logs = append(logs, &l)
+	}
+
+	return logs, rows.Err()
+}
@@ -0,0 +1,36 @@
+package database
+
+import "context"
+
+// Platform-wide totals for the admin dashboard
+type PlatformStats struct {
+	Users                      int `json:"users"`
+	Projects                   int `json:"projects"`
+	LiveDeployments            int `json:"live_deployments"`
+	DeploymentsThisMonth       int `json:"deployments_this_month"`
+	FailedDeploymentsThisMonth int `json:"failed_deployments_this_month"`
+}
+
+// Counts users, projects & this month's deployments across all users
+func GetPlatformStats(ctx context.Context) (*PlatformStats, error) {
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM users),
+			(SELECT COUNT(*) FROM projects),
+			(SELECT COUNT(*) FROM deployments WHERE status = 'live'),
+			COUNT(*),
+			COUNT(*) FILTER (WHERE status = 'failed')
+		FROM deployments
+		WHERE created_at >= date_trunc('month', NOW())
+	`
+
+	var s PlatformStats
+	err := pool.QueryRow(ctx, query).Scan(
+		&s.Users, &s.Projects, &s.LiveDeployments,
+		&s.DeploymentsThisMonth, &s.FailedDeploymentsThisMonth,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
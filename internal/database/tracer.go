@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// Queries taking at least this long are logged at warn level
+const defaultSlowQueryThreshold = 100 * time.Millisecond
+
+// Returns SLOW_QUERY_THRESHOLD_MS as a duration (default 100 ms)
+func slowQueryThreshold() time.Duration {
+	if v := os.Getenv("SLOW_QUERY_THRESHOLD_MS"); v != "" {
+		if ms, err := strconv.Atoi(v); err == nil && ms > 0 {
+			return time.Duration(ms) * time.Millisecond
+		}
+	}
+	return defaultSlowQueryThreshold
+}
+
+// Logs every query with its duration & row count (pgx.QueryTracer)
+// Only the SQL text is logged, never the arguments, so secrets & tokens
+// passed as parameters stay out of the logs
+type queryTracer struct {
+	slowThreshold time.Duration
+}
+
+// A query in flight, carried in its context between the trace callbacks
+type tracedQuery struct {
+	sql   string
+	start time.Time
+}
+
+// Context key for the tracedQuery
+type tracedQueryKey struct{}
+
+// Records the query text & when it started
+func (t *queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn,
+	data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, tracedQueryKey{}, &tracedQuery{
+		sql:   data.SQL,
+		start: time.Now(),
+	})
+}
+
+// Logs the finished query at debug level, or warn when it was slow
+// Failures are logged too, though callers usually report them as well
+func (t *queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn,
+	data pgx.TraceQueryEndData) {
+	q, ok := ctx.Value(tracedQueryKey{}).(*tracedQuery)
+	if !ok {
+		return
+	}
+	duration := time.Since(q.start)
+
+	event := log.Debug()
+	if duration >= t.slowThreshold {
+		event = log.Warn()
+	}
+	if !event.Enabled() {
+		return
+	}
+
+	if data.Err != nil {
+		event = event.Err(data.Err)
+	}
+	event.
+		Str("sql", compactSQL(q.sql)).
+		Dur("duration", duration).
+		Int64("rows", data.CommandTag.RowsAffected()).
+		Msg("Database query")
+}
+
+// Collapses the whitespace of an (indented, multi-line) query onto one line
+func compactSQL(sql string) string {
+	return strings.Join(strings.Fields(sql), " ")
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// A server-side login session (AUTH_MODE=session)
+type UserSession struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	IPAddress  string    `json:"ip_address"`
+	UserAgent  string    `json:"user_agent"`
+}
+
+// Columns selected for a UserSession, in scanUserSession order
+const userSessionColumns = `
+	id, user_id, created_at, expires_at, last_seen_at, ip_address,
+	user_agent`
+
+// Scans a single session row selected with userSessionColumns
+func scanUserSession(row pgx.Row) (*UserSession, error) {
+	var s UserSession
+	err := row.Scan(
+		&s.ID, &s.UserID, &s.CreatedAt, &s.ExpiresAt, &s.LastSeenAt,
+		&s.IPAddress, &s.UserAgent,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Starts a session for a user that lasts until expiresAt
+func CreateUserSession(ctx context.Context, userID, ipAddress,
+	userAgent string, expiresAt time.Time) (*UserSession, error) {
+	query := `
+		INSERT INTO user_sessions (user_id, expires_at, ip_address, user_agent)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + userSessionColumns
+
+	return scanUserSession(pool.QueryRow(ctx, query, userID, expiresAt,
+		ipAddress, userAgent))
+}
+
+// Retrieves an unexpired session & records it as seen
+// last_seen_at is only bumped once a minute to spare writes per request
+func TouchUserSession(ctx context.Context, id string) (*UserSession,
+	error) {
+	query := `
+		UPDATE user_sessions
+		SET last_seen_at = CASE
+				WHEN last_seen_at < NOW() - INTERVAL '1 minute'
+				THEN NOW() ELSE last_seen_at END
+		WHERE id = $1 AND expires_at > NOW()
+		RETURNING ` + userSessionColumns
+
+	s, err := scanUserSession(pool.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("session not found")
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// Lists a user's unexpired sessions, most recently used first
+func ListUserSessions(ctx context.Context,
+	userID string) ([]*UserSession, error) {
+	query := `
+		SELECT ` + userSessionColumns + `
+		FROM user_sessions
+		WHERE user_id = $1 AND expires_at > NOW()
+		ORDER BY last_seen_at DESC
+	`
+
+	rows, err := pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*UserSession{}
+	for rows.Next() {
+		s, err := scanUserSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// Revokes one of a user's sessions
+func DeleteUserSession(ctx context.Context, id, userID string) error {
+	query := `
+		DELETE FROM user_sessions
+		WHERE id = $1 AND user_id = $2
+	`
+
+	result, err := pool.Exec(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("session not found")
+	}
+
+	return nil
+}
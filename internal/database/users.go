@@ -6,18 +6,38 @@ import (
 	"time"
 
 	"github.com/Sys-Redux/rcnbuild-paas/pkg/crypto"
+	"github.com/jackc/pgx/v5"
 )
 
 // User represents a user in the database
+// A user signs in with GitHub, GitLab or both, so either identity may be nil
 type User struct {
-	ID                   string    `json:"id"`
-	GitHubID             int64     `json:"github_id"`
-	GitHubUsername       string    `json:"github_username"`
-	Email                *string   `json:"email,omitempty"`
-	AvatarURL            *string   `json:"avatar_url,omitempty"`
-	AccessTokenEncrypted *string   `json:"-"` // Never expose in JSON
-	CreatedAt            time.Time `json:"created_at"`
-	UpdatedAt            time.Time `json:"updated_at"`
+	ID                       string     `json:"id"`
+	GitHubID                 *int64     `json:"github_id,omitempty"`
+	GitHubUsername           *string    `json:"github_username,omitempty"`
+	GitLabID                 *int64     `json:"gitlab_id,omitempty"`
+	GitLabUsername           *string    `json:"gitlab_username,omitempty"`
+	Email                    *string    `json:"email,omitempty"`
+	AvatarURL                *string    `json:"avatar_url,omitempty"`
+	AccessTokenEncrypted     *string    `json:"-"` // Never expose in JSON
+	GitHubInstallationID     *int64     `json:"github_installation_id,omitempty"`
+	IsAdmin                  bool       `json:"is_admin"`
+	QuotaProjects            int        `json:"quota_projects"`
+	QuotaDeploymentsPerMonth int        `json:"quota_deployments_per_month"`
+	TokenExpiresAt           *time.Time `json:"token_expires_at,omitempty"`
+	CreatedAt                time.Time  `json:"created_at"`
+	UpdatedAt                time.Time  `json:"updated_at"`
+}
+
+// Display name for the user, preferring their GitHub login
+func (u *User) Username() string {
+	if u.GitHubUsername != nil {
+		return *u.GitHubUsername
+	}
+	if u.GitLabUsername != nil {
+		return *u.GitLabUsername
+	}
+	return ""
 }
 
 // GitHubUser represents the user info returned from GitHub API
@@ -26,6 +46,37 @@ type GitHubUser struct {
 	Login     string `json:"login"`
 	Email     string `json:"email"`
 	AvatarURL string `json:"avatar_url"`
+	// When the access token used to fetch the user expires (nil = never)
+	TokenExpiresAt *time.Time `json:"-"`
+}
+
+// GitLabUser represents the user info returned from GitLab API
+type GitLabUser struct {
+	ID        int64
+	Username  string
+	Email     string
+	AvatarURL string
+}
+
+// Columns selected for a User, in scanUser order
+const userColumns = `
+	id, github_id, github_username, gitlab_id, gitlab_username,
+	email, avatar_url, github_installation_id, is_admin, quota_projects,
+	quota_deployments_per_month, token_expires_at, created_at, updated_at`
+
+// Scans a single user row selected with userColumns
+func scanUser(row pgx.Row) (*User, error) {
+	var u User
+	err := row.Scan(
+		&u.ID, &u.GitHubID, &u.GitHubUsername, &u.GitLabID, &u.GitLabUsername,
+		&u.Email, &u.AvatarURL, &u.GitHubInstallationID, &u.IsAdmin, &u.QuotaProjects,
+		&u.QuotaDeploymentsPerMonth, &u.TokenExpiresAt, &u.CreatedAt,
+		&u.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
 }
 
 // Upserts a user based on GitHub ID
@@ -39,16 +90,17 @@ func CreateOrUpdateUser(
 		email,
 		avatar_url,
 		access_token_encrypted,
+		token_expires_at,
 		updated_at)
-	VALUES ($1, $2, $3, $4, $5, NOW())
+	VALUES ($1, $2, $3, $4, $5, $6, NOW())
 	ON CONFLICT (github_id) DO UPDATE SET
 		github_username = EXCLUDED.github_username,
 		email = EXCLUDED.email,
 		avatar_url = EXCLUDED.avatar_url,
 		access_token_encrypted = EXCLUDED.access_token_encrypted,
+		token_expires_at = EXCLUDED.token_expires_at,
 		updated_at = NOW()
-	RETURNING id, github_id, github_username, email, avatar_url, created_at, updated_at
-	`
+	RETURNING ` + userColumns
 
 	// Encrypt access token before storing
 	encryptedToken, err := crypto.Encrypt(accessToken)
@@ -56,7 +108,6 @@ func CreateOrUpdateUser(
 		return nil, err
 	}
 
-	var user User
 	var email, avatarURL *string
 
 	if githubUser.Email != "" {
@@ -66,97 +117,161 @@ func CreateOrUpdateUser(
 		avatarURL = &githubUser.AvatarURL
 	}
 
-	err = pool.QueryRow(ctx, query,
+	return scanUser(pool.QueryRow(ctx, query,
 		githubUser.ID,
 		githubUser.Login,
 		email,
 		avatarURL,
 		encryptedToken,
-	).Scan(
-		&user.ID,
-		&user.GitHubID,
-		&user.GitHubUsername,
-		&user.Email,
-		&user.AvatarURL,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+		githubUser.TokenExpiresAt,
+	))
+}
 
+// Upserts a user based on GitLab ID
+// Email & avatar only fill gaps, so signing in with GitLab doesn't
+// overwrite what a linked GitHub account set
+func CreateOrUpdateGitLabUser(
+	ctx context.Context, gitlabUser *GitLabUser,
+	accessToken string) (*User, error) {
+	query := `
+	INSERT INTO users (
+		gitlab_id,
+		gitlab_username,
+		email,
+		avatar_url,
+		gitlab_access_token_encrypted,
+		updated_at)
+	VALUES ($1, $2, $3, $4, $5, NOW())
+	ON CONFLICT (gitlab_id) DO UPDATE SET
+		gitlab_username = EXCLUDED.gitlab_username,
+		email = COALESCE(users.email, EXCLUDED.email),
+		avatar_url = COALESCE(users.avatar_url, EXCLUDED.avatar_url),
+		gitlab_access_token_encrypted =
+			EXCLUDED.gitlab_access_token_encrypted,
+		updated_at = NOW()
+	RETURNING ` + userColumns
+
+	encryptedToken, err := crypto.Encrypt(accessToken)
 	if err != nil {
 		return nil, err
 	}
 
-	return &user, nil
+	email, avatarURL := optionalString(gitlabUser.Email),
+		optionalString(gitlabUser.AvatarURL)
+
+	return scanUser(pool.QueryRow(ctx, query,
+		gitlabUser.ID,
+		gitlabUser.Username,
+		email,
+		avatarURL,
+		encryptedToken,
+	))
 }
 
-// Retrieves user by their UUID
-func GetUserByID(ctx context.Context, id string) (*User, error) {
+// Attaches a GitHub identity to an existing user
+func LinkGitHubUser(ctx context.Context, userID string,
+	githubUser *GitHubUser, accessToken string) (*User, error) {
 	query := `
-		SELECT
-			id,
-			github_id,
-			github_username,
-			email,
-			avatar_url,
-			created_at,
-			updated_at
-		FROM users
+		UPDATE users SET
+			github_id = $2,
+			github_username = $3,
+			email = COALESCE(email, $4),
+			avatar_url = COALESCE(avatar_url, $5),
+			access_token_encrypted = $6,
+			token_expires_at = $7,
+			updated_at = NOW()
 		WHERE id = $1
-	`
+		RETURNING ` + userColumns
 
-	var user User
-	err := pool.QueryRow(ctx, query, id).Scan(
-		&user.ID,
-		&user.GitHubID,
-		&user.GitHubUsername,
-		&user.Email,
-		&user.AvatarURL,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	encryptedToken, err := crypto.Encrypt(accessToken)
+	if err != nil {
+		return nil, err
+	}
 
+	return scanUser(pool.QueryRow(ctx, query, userID,
+		githubUser.ID,
+		githubUser.Login,
+		optionalString(githubUser.Email),
+		optionalString(githubUser.AvatarURL),
+		encryptedToken,
+		githubUser.TokenExpiresAt,
+	))
+}
+
+// Attaches a GitLab identity to an existing user
+func LinkGitLabUser(ctx context.Context, userID string,
+	gitlabUser *GitLabUser, accessToken string) (*User, error) {
+	query := `
+		UPDATE users SET
+			gitlab_id = $2,
+			gitlab_username = $3,
+			email = COALESCE(email, $4),
+			avatar_url = COALESCE(avatar_url, $5),
+			gitlab_access_token_encrypted = $6,
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING ` + userColumns
+
+	encryptedToken, err := crypto.Encrypt(accessToken)
 	if err != nil {
 		return nil, err
 	}
 
-	return &user, nil
+	return scanUser(pool.QueryRow(ctx, query, userID,
+		gitlabUser.ID,
+		gitlabUser.Username,
+		optionalString(gitlabUser.Email),
+		optionalString(gitlabUser.AvatarURL),
+		encryptedToken,
+	))
+}
+
+// Returns nil for an empty string, so it's stored as NULL
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// Retrieves user by their UUID
+func GetUserByID(ctx context.Context, id string) (*User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE id = $1
+	`
+
+	return scanUser(pool.QueryRow(ctx, query, id))
 }
 
 // Retrieves a user by their GitHub ID
 func GetUserByGitHubID(ctx context.Context, githubID int64) (*User, error) {
 	query := `
-		SELECT
-			id,
-			github_id,
-			github_username,
-			email,
-			avatar_url,
-			created_at,
-			updated_at
+		SELECT ` + userColumns + `
 		FROM users
 		WHERE github_id = $1
 	`
 
-	var user User
-	err := pool.QueryRow(ctx, query, githubID).Scan(
-		&user.ID,
-		&user.GitHubID,
-		&user.GitHubUsername,
-		&user.Email,
-		&user.AvatarURL,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	return scanUser(pool.QueryRow(ctx, query, githubID))
+}
 
-	if err != nil {
-		return nil, err
-	}
+// Retrieves a user by their GitLab ID
+func GetUserByGitLabID(ctx context.Context, gitlabID int64) (*User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE gitlab_id = $1
+	`
 
-	return &user, nil
+	return scanUser(pool.QueryRow(ctx, query, gitlabID))
 }
 
 // DeleteUser permanently removes a user by their UUID
 func DeleteUser(ctx context.Context, id string) error {
+	// The user's projects go with them (ON DELETE CASCADE)
+	defer projectCache.Purge()
+
 	query := `DELETE FROM users WHERE id = $1`
 
 	result, err := pool.Exec(ctx, query, id)
@@ -171,6 +286,48 @@ func DeleteUser(ctx context.Context, id string) error {
 	return nil
 }
 
+// Position in the admin user list (newest first)
+type UserCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// Returns up to limit users, newest first, starting after cursor
+// (nil cursor starts from the newest user)
+func ListUsers(ctx context.Context, limit int,
+	after *UserCursor) ([]*User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE $1::timestamptz IS NULL
+			OR (created_at, id) < ($1::timestamptz, $2::uuid)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3
+	`
+
+	var createdAt *time.Time
+	var id *string
+	if after != nil {
+		createdAt, id = &after.CreatedAt, &after.ID
+	}
+
+	rows, err := pool.Query(ctx, query, createdAt, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
 // UpdateUserEmail updates just the user's email
 func UpdateUserEmail(ctx context.Context, id string, email string) error {
 	query := `
@@ -204,3 +361,90 @@ func GetUserAccessToken(ctx context.Context, userID string) (string, error) {
 
 	return crypto.Decrypt(*encryptedToken)
 }
+
+// SetUserInstallationID records (or clears, when nil) the GitHub App
+// installation for the user with the given GitHub ID
+func SetUserInstallationID(ctx context.Context, githubID int64,
+	installationID *int64) error {
+	query := `
+		UPDATE users
+		SET github_installation_id = $2,
+			installation_token_encrypted = NULL,
+			installation_token_expires_at = NULL,
+			updated_at = NOW()
+		WHERE github_id = $1
+	`
+
+	result, err := pool.Exec(ctx, query, githubID, installationID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("user not found")
+	}
+
+	return nil
+}
+
+// SetUserInstallationToken encrypts and caches a GitHub App installation
+// token until it expires
+func SetUserInstallationToken(ctx context.Context, userID string,
+	token string, expiresAt time.Time) error {
+	encryptedToken, err := crypto.Encrypt(token)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE users
+		SET installation_token_encrypted = $2,
+			installation_token_expires_at = $3,
+			updated_at = NOW()
+		WHERE id = $1
+	`
+
+	_, err = pool.Exec(ctx, query, userID, encryptedToken, expiresAt)
+	return err
+}
+
+// GetUserInstallationToken retrieves and decrypts the cached installation
+// token for a user. Returns an empty token if none is cached or it expired
+func GetUserInstallationToken(ctx context.Context,
+	userID string) (string, error) {
+	query := `
+		SELECT installation_token_encrypted, installation_token_expires_at
+		FROM users
+		WHERE id = $1
+	`
+
+	var encryptedToken *string
+	var expiresAt *time.Time
+	err := pool.QueryRow(ctx, query, userID).Scan(&encryptedToken, &expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	if encryptedToken == nil || expiresAt == nil ||
+		time.Now().After(*expiresAt) {
+		return "", nil
+	}
+
+	return crypto.Decrypt(*encryptedToken)
+}
+
+// Sets a user's project & monthly deployment limits
+func SetUserQuota(ctx context.Context, id string, projects,
+	deploymentsPerMonth *int) (*User, error) {
+	query := `
+		UPDATE users SET
+			quota_projects = COALESCE($2, quota_projects),
+			quota_deployments_per_month = COALESCE($3,
+				quota_deployments_per_month),
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING ` + userColumns
+
+	return scanUser(pool.QueryRow(ctx, query, id, projects,
+		deploymentsPerMonth))
+}
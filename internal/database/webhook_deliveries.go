@@ -0,0 +1,162 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Outcome of processing a webhook delivery
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryStatusPending WebhookDeliveryStatus = "pending"
+	WebhookDeliveryStatusSuccess WebhookDeliveryStatus = "success"
+	WebhookDeliveryStatusFailed  WebhookDeliveryStatus = "failed"
+)
+
+// A GitHub webhook delivery as received (kept for replay)
+type WebhookDelivery struct {
+	ID           string                `json:"id"`
+	DeliveryID   string                `json:"delivery_id"`
+	ProjectID    string                `json:"project_id"`
+	Event        string                `json:"event"`
+	Status       WebhookDeliveryStatus `json:"status"`
+	Payload      []byte                `json:"-"`
+	Signature    string                `json:"-"`
+	DeploymentID *string               `json:"deployment_id,omitempty"`
+	CreatedAt    time.Time             `json:"created_at"`
+}
+
+// For recording a webhook delivery
+type CreateWebhookDeliveryInput struct {
+	DeliveryID string
+	ProjectID  string
+	Event      string
+	Payload    []byte
+	Signature  string
+}
+
+// Columns selected for a WebhookDelivery, in scanWebhookDelivery order
+const webhookDeliveryColumns = `
+	id, delivery_id, project_id, event, status, payload, signature,
+	deployment_id, created_at`
+
+// Scans a single delivery row selected with webhookDeliveryColumns
+func scanWebhookDelivery(row pgx.Row) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	err := row.Scan(
+		&d.ID, &d.DeliveryID, &d.ProjectID, &d.Event, &d.Status,
+		&d.Payload, &d.Signature, &d.DeploymentID, &d.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Stores a received webhook delivery as pending
+// A redelivery (same delivery ID) of one that failed or never finished is
+// reset to pending & returned so it's processed again; created is false
+// (with a nil delivery) only if it was already processed successfully
+func CreateWebhookDelivery(ctx context.Context,
+	input *CreateWebhookDeliveryInput) (*WebhookDelivery, bool, error) {
+	query := `
+		INSERT INTO webhook_deliveries (
+			delivery_id, project_id, event, payload, signature
+		) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (delivery_id) DO UPDATE
+		SET status = 'pending'
+		WHERE webhook_deliveries.status <> 'success'
+		RETURNING ` + webhookDeliveryColumns
+
+	d, err := scanWebhookDelivery(pool.QueryRow(ctx, query,
+		input.DeliveryID,
+		input.ProjectID,
+		input.Event,
+		input.Payload,
+		input.Signature,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return d, true, nil
+}
+
+// Retrieves a project's delivery by GitHub delivery ID
+func GetWebhookDelivery(ctx context.Context, projectID,
+	deliveryID string) (*WebhookDelivery, error) {
+	query := `
+		SELECT ` + webhookDeliveryColumns + `
+		FROM webhook_deliveries
+		WHERE project_id = $1 AND delivery_id = $2
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+
+	return scanWebhookDelivery(pool.QueryRow(ctx, query, projectID,
+		deliveryID))
+}
+
+// Links a delivery to the deployment it triggered
+func SetWebhookDeliveryDeployment(ctx context.Context, id,
+	deploymentID string) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET deployment_id = $2
+		WHERE id = $1
+	`
+
+	result, err := pool.Exec(ctx, query, id, deploymentID)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("webhook delivery not found")
+	}
+
+	return nil
+}
+
+// Records the outcome of processing a delivery
+func SetWebhookDeliveryStatus(ctx context.Context, id string,
+	status WebhookDeliveryStatus) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $2
+		WHERE id = $1
+	`
+
+	result, err := pool.Exec(ctx, query, id, status)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return errors.New("webhook delivery not found")
+	}
+
+	return nil
+}
+
+// Deletes deliveries received before cutoff
+// Returns how many were deleted
+func DeleteWebhookDeliveriesBefore(ctx context.Context,
+	cutoff time.Time) (int64, error) {
+	query := `
+		DELETE FROM webhook_deliveries
+		WHERE created_at < $1
+	`
+
+	result, err := pool.Exec(ctx, query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,168 @@
+package deployments
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/auth"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// A setting that differs between two deployments
+type FieldChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// Env var keys added & removed between two deployments (never values)
+type EnvVarChanges struct {
+	Added       int      `json:"added"`
+	Removed     int      `json:"removed"`
+	AddedKeys   []string `json:"added_keys"`
+	RemovedKeys []string `json:"removed_keys"`
+}
+
+// What changed between two deployments of a project
+type DeploymentDiffResponse struct {
+	From *database.Deployment `json:"from"`
+	To   *database.Deployment `json:"to"`
+	// GitHub compare view; empty when both deployed the same commit
+	CompareURL string `json:"compare_url,omitempty"`
+	// Only settings that differ; keyed by setting name
+	ConfigChanges map[string]FieldChange `json:"config_changes"`
+	// Null when either deployment's env var keys weren't recorded, which
+	// is different from no keys changing
+	EnvVars *EnvVarChanges `json:"env_vars"`
+	// False when either deployment predates config snapshots, in which
+	// case only the commits are compared
+	SnapshotsAvailable bool `json:"snapshots_available"`
+}
+
+// Compare two of a project's deployments
+// GET /api/projects/:id/deployments/diff?from=:id&to=:id
+// @Summary Compare two deployments
+// @Description Commit range, changed build/start command, port & runtime,
+// @Description and env var keys added or removed between the deployments.
+// @Description env_vars is null when either deployment's keys weren't
+// @Description recorded.
+// @Tags deployments
+// @Produce json
+// @Param id path string true "Project ID"
+// @Param from query string true "Earlier deployment ID"
+// @Param to query string true "Later deployment ID"
+// @Success 200 {object} DeploymentDiffResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /projects/{id}/deployments/diff [get]
+func (h *Handlers) HandleDiffDeployments(c *gin.Context) {
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	project, err := database.GetProjectByID(c.Request.Context(),
+		c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	fromID, toID := c.Query("from"), c.Query("to")
+	if fromID == "" || toID == "" {
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "from and to deployment IDs are required"})
+		return
+	}
+
+	// Deployments of other projects read as not found
+	from, err := database.GetDeploymentByID(c.Request.Context(), fromID)
+	if err != nil || from.ProjectID != project.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+	to, err := database.GetDeploymentByID(c.Request.Context(), toID)
+	if err != nil || to.ProjectID != project.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+
+	resp := &DeploymentDiffResponse{
+		From:          from,
+		To:            to,
+		ConfigChanges: map[string]FieldChange{},
+	}
+	if from.CommitSHA != to.CommitSHA {
+		resp.CompareURL = strings.TrimSuffix(project.RepoURL, "/") +
+			"/compare/" + from.CommitSHA + "..." + to.CommitSHA
+	}
+
+	if from.ConfigSnapshot != nil && to.ConfigSnapshot != nil {
+		resp.SnapshotsAvailable = true
+		resp.ConfigChanges = diffSnapshots(from.ConfigSnapshot,
+			to.ConfigSnapshot)
+		if from.ConfigSnapshot.EnvKeys != nil &&
+			to.ConfigSnapshot.EnvKeys != nil {
+			resp.EnvVars = diffEnvKeys(from.ConfigSnapshot.EnvKeys,
+				to.ConfigSnapshot.EnvKeys)
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// Returns the snapshot settings that differ, keyed by JSON name
+func diffSnapshots(from, to *database.ConfigSnapshot) map[string]FieldChange {
+	changes := map[string]FieldChange{}
+	if from.BuildCommand != to.BuildCommand {
+		changes["build_command"] = FieldChange{from.BuildCommand,
+			to.BuildCommand}
+	}
+	if from.StartCommand != to.StartCommand {
+		changes["start_command"] = FieldChange{from.StartCommand,
+			to.StartCommand}
+	}
+	if from.Port != to.Port {
+		changes["port"] = FieldChange{from.Port, to.Port}
+	}
+	if from.Runtime != to.Runtime {
+		changes["runtime"] = FieldChange{from.Runtime, to.Runtime}
+	}
+	return changes
+}
+
+// Counts the keys only in to (added) & only in from (removed)
+func diffEnvKeys(from, to []string) *EnvVarChanges {
+	inFrom := make(map[string]bool, len(from))
+	for _, key := range from {
+		inFrom[key] = true
+	}
+	inTo := make(map[string]bool, len(to))
+	for _, key := range to {
+		inTo[key] = true
+	}
+
+	changes := &EnvVarChanges{AddedKeys: []string{}, RemovedKeys: []string{}}
+	for _, key := range to {
+		if !inFrom[key] {
+			changes.AddedKeys = append(changes.AddedKeys, key)
+		}
+	}
+	for _, key := range from {
+		if !inTo[key] {
+			changes.RemovedKeys = append(changes.RemovedKeys, key)
+		}
+	}
+	changes.Added = len(changes.AddedKeys)
+	changes.Removed = len(changes.RemovedKeys)
+	return changes
+}
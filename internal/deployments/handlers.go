@@ -0,0 +1,362 @@
+package deployments
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/auth"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/builds"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/cache"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/queue"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// Holds dependencies for deployment handlers
+type Handlers struct{}
+
+// Create Handlers instance
+func NewHandlers() *Handlers {
+	return &Handlers{}
+}
+
+// Cancel a deployment that hasn't finished yet
+// POST /api/deployments/:id/cancel
+// @Summary Cancel a queued or running build
+// @Tags deployments
+// @Produce json
+// @Param id path string true "Deployment ID"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /deployments/{id}/cancel [post]
+func (h *Handlers) HandleCancelDeployment(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	deployment, err := database.GetDeploymentByID(c.Request.Context(),
+		c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+
+	project, err := database.GetProjectByID(c.Request.Context(),
+		deployment.ProjectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	if database.IsTerminalStatus(deployment.Status) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  "Deployment already finished",
+			"status": deployment.Status,
+		})
+		return
+	}
+
+	// Status check happens again in SQL, in case the build just finished
+	if err := database.CancelDeployment(c.Request.Context(),
+		deployment.ID); err != nil {
+		c.JSON(http.StatusConflict,
+			gin.H{"error": "Deployment can no longer be cancelled"})
+		return
+	}
+
+	// Deployments from before task IDs were stored use the derived ID
+	taskID := queue.BuildTaskID(deployment.ID)
+	if deployment.QueueTaskID != nil {
+		taskID = *deployment.QueueTaskID
+	}
+	if err := queue.CancelBuildTask(taskID); err != nil {
+		logger.Error().Err(err).Str("deployment_id", deployment.ID).
+			Msg("Failed to cancel build task")
+	}
+	// The build may have finished & handed over to its deploy job
+	if err := queue.CancelDeployTask(deployment.ID); err != nil {
+		logger.Error().Err(err).Str("deployment_id", deployment.ID).
+			Msg("Failed to cancel deploy task")
+	}
+
+	logger.Info().
+		Str("deployment_id", deployment.ID).
+		Str("project_id", project.ID).
+		Msg("Deployment cancelled")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Deployment cancelled"})
+}
+
+// Query params for runtime log retrieval
+type RuntimeLogsRequest struct {
+	Since string `form:"since"` // RFC3339; blank for the beginning
+	Limit int    `form:"limit,default=500" binding:"min=1,max=1000"`
+}
+
+// Returns persisted container output for a deployment, oldest first
+// Still available after the container has been replaced or removed
+// GET /api/deployments/:id/runtime-logs
+// @Summary Get a deployment's persisted runtime logs
+// @Tags deployments
+// @Produce json
+// @Param id path string true "Deployment ID"
+// @Param since query string false "Only logs at or after this RFC3339 time"
+// @Param limit query int false "Max chunks (1-1000)" default(500)
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /deployments/{id}/runtime-logs [get]
+func (h *Handlers) HandleGetRuntimeLogs(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	var req RuntimeLogsRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var since *time.Time
+	if req.Since != "" {
+		t, err := time.Parse(time.RFC3339, req.Since)
+		if err != nil {
+			c.JSON(http.StatusBadRequest,
+				gin.H{"error": "since must be an RFC3339 timestamp"})
+			return
+		}
+		since = &t
+	}
+
+	deployment, err := database.GetDeploymentByID(c.Request.Context(),
+		c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+
+	project, err := database.GetProjectByID(c.Request.Context(),
+		deployment.ProjectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	// Fetch one extra row to tell whether more remain
+	logs, err := database.GetRuntimeLogs(c.Request.Context(), deployment.ID,
+		since, req.Limit+1)
+	if err != nil {
+		logger.Error().Err(err).Str("deployment_id", deployment.ID).
+			Msg("Failed to get runtime logs")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to get runtime logs"})
+		return
+	}
+
+	hasMore := len(logs) > req.Limit
+	if hasMore {
+		logs = logs[:req.Limit]
+	}
+	if logs == nil {
+		logs = []*database.RuntimeLog{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deployment_id": deployment.ID,
+		"logs":          logs,
+		"has_more":      hasMore,
+	})
+}
+
+// How often an idle build log stream sends a keepalive & rechecks status
+const buildLogKeepalive = 15 * time.Second
+
+// Streams a deployment's build output as Server-Sent Events
+// `log` events carry one line each; `end` closes the stream. Running builds
+// are followed live over Redis pub/sub, finished ones replay the snapshot
+// saved in deployment_logs.
+// GET /api/deployments/:id/build-logs/stream
+// @Summary Stream a deployment's build logs (SSE)
+// @Tags deployments
+// @Produce text/event-stream
+// @Param id path string true "Deployment ID"
+// @Success 200 {string} string "event stream"
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /deployments/{id}/build-logs/stream [get]
+func (h *Handlers) HandleStreamBuildLogs(c *gin.Context) {
+	logger := middleware.Logger(c)
+	ctx := c.Request.Context()
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	deployment, err := database.GetDeploymentByID(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+
+	project, err := database.GetProjectByID(ctx, deployment.ProjectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	rdb := cache.Client()
+	if rdb == nil {
+		c.JSON(http.StatusServiceUnavailable,
+			gin.H{"error": "Live build logs unavailable"})
+		return
+	}
+
+	// Subscribe before looking for the snapshot: it's saved before the
+	// end marker is published, so one of the two is always seen
+	sub := rdb.Subscribe(ctx, builds.LogChannel(deployment.ID))
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		logger.Error().Err(err).Msg("Failed to subscribe to build logs")
+		c.JSON(http.StatusServiceUnavailable,
+			gin.H{"error": "Live build logs unavailable"})
+		return
+	}
+
+	snapshot, err := database.GetDeploymentLogs(ctx, deployment.ID)
+	if err != nil {
+		logger.Error().Err(err).Str("deployment_id", deployment.ID).
+			Msg("Failed to get build logs")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to get build logs"})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	if len(snapshot) > 0 || !buildInProgress(deployment.Status) {
+		for _, l := range snapshot {
+			for _, line := range strings.Split(
+				strings.TrimSuffix(l.Content, "\n"), "\n") {
+				c.SSEvent("log", line)
+			}
+		}
+		c.SSEvent("end", "")
+		return
+	}
+
+	streamBuildLogs(c, sub.Channel(), buildLogKeepalive, func() bool {
+		current, err := database.GetDeploymentByID(ctx, deployment.ID)
+		return err == nil && !buildInProgress(current.Status)
+	})
+}
+
+// Writes live build log lines as SSE events until the end marker, the
+// client leaving, or finished reporting (checked every keepalive) that
+// the build is over. Streams outlive the server's WriteTimeout, so each
+// write pushes the deadline two keepalives ahead instead
+func streamBuildLogs(c *gin.Context, messages <-chan *redis.Message,
+	keepaliveInterval time.Duration, finished func() bool) {
+	ctx := c.Request.Context()
+	rc := http.NewResponseController(c.Writer)
+	extendDeadline := func() {
+		rc.SetWriteDeadline(time.Now().Add(2 * keepaliveInterval))
+	}
+	extendDeadline()
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case msg, ok := <-messages:
+			if !ok {
+				return false
+			}
+			extendDeadline()
+			if msg.Payload == builds.LogStreamEnd {
+				c.SSEvent("end", "")
+				return false
+			}
+			c.SSEvent("log", msg.Payload)
+			return true
+		case <-keepalive.C:
+			extendDeadline()
+			// Builds that fail before producing output never publish an
+			// end marker, so stop once the deployment has moved on
+			if finished() {
+				flushBuildLogs(c, messages)
+				c.SSEvent("end", "")
+				return false
+			}
+			io.WriteString(w, ": keepalive\n\n")
+			return true
+		}
+	})
+}
+
+// Whether a deployment may still produce build output
+func buildInProgress(status database.DeploymentStatus) bool {
+	return status == database.DeploymentStatusPending ||
+		status == database.DeploymentStatusBuilding
+}
+
+// Sends lines already delivered but not yet written, up to the end marker
+func flushBuildLogs(c *gin.Context, messages <-chan *redis.Message) {
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok || msg.Payload == builds.LogStreamEnd {
+				return
+			}
+			c.SSEvent("log", msg.Payload)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package deployments
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/builds"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// A build log stream must keep going past the server's WriteTimeout: lines
+// sent after it elapses still arrive, followed by the end event
+func TestStreamBuildLogsOutlivesWriteTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const (
+		writeTimeout = 200 * time.Millisecond
+		keepalive    = 50 * time.Millisecond
+		lineCount    = 8
+		lineInterval = 100 * time.Millisecond // lineCount lines take 4x WriteTimeout
+	)
+
+	router := gin.New()
+	router.GET("/stream", func(c *gin.Context) {
+		messages := make(chan *redis.Message)
+		go func() {
+			defer close(messages)
+			for i := 0; i < lineCount; i++ {
+				time.Sleep(lineInterval)
+				messages <- &redis.Message{Payload: "line"}
+			}
+			messages <- &redis.Message{Payload: builds.LogStreamEnd}
+		}()
+		streamBuildLogs(c, messages, keepalive,
+			func() bool { return false })
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := &http.Server{Handler: router, WriteTimeout: writeTimeout}
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.Close() })
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/stream")
+	if err != nil {
+		t.Fatalf("GET /stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	lines, ended := 0, false
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		switch {
+		case strings.HasPrefix(scanner.Text(), "event:log"):
+			lines++
+		case strings.HasPrefix(scanner.Text(), "event:end"):
+			ended = true
+		}
+	}
+
+	if lines != lineCount {
+		t.Errorf("received %d log events, want %d (stream cut at %s?)",
+			lines, lineCount, writeTimeout)
+	}
+	if !ended {
+		t.Error("stream closed without an end event")
+	}
+}
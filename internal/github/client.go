@@ -1,15 +1,23 @@
 package github
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/golang-jwt/jwt/v5"
 )
 
 const (
@@ -17,20 +25,50 @@ const (
 	userAgent        = "RCNbuild-PaaS/1.0"
 )
 
+// Longest wait for a rate limit reset before retrying a limited request
+// Resets further out fail fast instead of holding the caller. The default
+// keeps API handlers well inside the server's 15s WriteTimeout; workers
+// can afford WorkerRateLimitWait (see SetMaxRateLimitWait)
+const (
+	DefaultRateLimitWait = 5 * time.Second
+	WorkerRateLimitWait  = time.Minute
+)
+
 // Client wraps GitHub API calls with auth
 type Client struct {
 	accessToken string
 	httpClient  *http.Client
+
+	maxRateLimitWait time.Duration
+
+	mu        sync.Mutex
+	rateLimit RateLimitInfo // From the latest response
+}
+
+// GitHub's rate limit state, as reported by the X-RateLimit-* headers
+// Zero until the client has made a request
+type RateLimitInfo struct {
+	Remaining int       `json:"remaining"`
+	Limit     int       `json:"limit"`
+	ResetAt   time.Time `json:"reset_at"`
 }
 
 // Creates a GitHub API client with the provided access token
 func NewClient(accessToken string) *Client {
 	return &Client{
-		accessToken: accessToken,
-		httpClient:  &http.Client{Timeout: 30 * time.Second},
+		accessToken:      accessToken,
+		httpClient:       &http.Client{Timeout: 30 * time.Second},
+		maxRateLimitWait: DefaultRateLimitWait,
 	}
 }
 
+// Sets the longest wait for a rate limit reset (DefaultRateLimitWait)
+// Only callers outside an HTTP request, like worker tasks, should raise it
+func (c *Client) SetMaxRateLimitWait(d time.Duration) *Client {
+	c.maxRateLimitWait = d
+	return c
+}
+
 // Represents a GitHub repository
 type Repository struct {
 	ID            int64     `json:"id"`
@@ -80,6 +118,16 @@ type WebhookCreateConfig struct {
 	InsecureSSL string `json:"insecure_ssl"`
 }
 
+// Represents a repository deploy key
+type DeployKey struct {
+	ID        int64     `json:"id"`
+	Key       string    `json:"key"`
+	Title     string    `json:"title"`
+	ReadOnly  bool      `json:"read_only"`
+	Verified  bool      `json:"verified"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
 // Represents a file or directory in a repository
 type RepoContent struct {
 	Name string `json:"name"`
@@ -87,11 +135,110 @@ type RepoContent struct {
 	Type string `json:"type"` // "file" or "dir"
 }
 
+// Represents a commit on a branch (message trimmed to its first line)
+type CommitSummary struct {
+	SHA        string    `json:"sha"`
+	Message    string    `json:"message"`
+	AuthorName string    `json:"author_name"`
+	AuthorDate time.Time `json:"author_date"`
+	URL        string    `json:"url"`
+}
+
 // Perform an authenticated request to the GitHub API
+// A request refused for an exhausted rate limit is retried once after the
+// reset, if that's within the client's max rate limit wait
 func (c *Client) doRequest(ctx context.Context, method, endpoint string,
 	body io.Reader) (*http.Response, error) {
+	// Buffered so the body can be sent again on retry
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = io.ReadAll(body); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.send(ctx, method, endpoint, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	info, limited := parseRateLimit(resp)
+	c.setRateLimit(info)
+	if !limited || (resp.StatusCode != http.StatusForbidden &&
+		resp.StatusCode != http.StatusTooManyRequests) {
+		return resp, nil
+	}
+
+	wait := time.Until(info.ResetAt)
+	if wait > c.maxRateLimitWait {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	resp, err = c.send(ctx, method, endpoint, payload)
+	if err != nil {
+		return nil, err
+	}
+	info, _ = parseRateLimit(resp)
+	c.setRateLimit(info)
+	return resp, nil
+}
+
+// Returns the rate limit state from the latest response (for logging)
+func (c *Client) RateLimitInfo() RateLimitInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rateLimit
+}
+
+// Records rate limit state, unless the response didn't report any
+func (c *Client) setRateLimit(info RateLimitInfo) {
+	if info.Limit == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rateLimit = info
+}
+
+// Reads the X-RateLimit-* headers; limited is true when none remain
+func parseRateLimit(resp *http.Response) (info RateLimitInfo, limited bool) {
+	limit, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	if err != nil {
+		return RateLimitInfo{}, false
+	}
+	remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return RateLimitInfo{}, false
+	}
+	info = RateLimitInfo{Remaining: remaining, Limit: limit}
+	if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"),
+		10, 64); err == nil {
+		info.ResetAt = time.Unix(reset, 0)
+	}
+	return info, remaining == 0
+}
+
+// Sends a single authenticated API request
+func (c *Client) send(ctx context.Context, method, endpoint string,
+	payload []byte) (*http.Response, error) {
 	url := githubAPIBaseURL + endpoint
 
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
 	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, err
@@ -139,14 +286,124 @@ func (c *Client) ListUserRepos(ctx context.Context, page,
 		return nil, fmt.Errorf("Failed to decode repos response: %w", err)
 	}
 
-	// Filter only repos where user can push
+	return filterPushable(repos), nil
+}
+
+// Represents an organization the user belongs to
+type Organization struct {
+	Login     string `json:"login"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+// Fetch the organizations the authenticated user is a member of
+func (c *Client) ListUserOrgs(ctx context.Context) ([]*Organization, error) {
+	resp, err := c.doRequest(ctx, http.MethodGet, "/user/orgs?per_page=100",
+		nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch orgs: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("GitHub API error: %s - %s",
+			resp.Status, string(body))
+	}
+
+	var orgs []*Organization
+	if err := json.NewDecoder(resp.Body).Decode(&orgs); err != nil {
+		return nil, fmt.Errorf("Failed to decode orgs response: %w", err)
+	}
+	return orgs, nil
+}
+
+// Fetch an organization's repositories
+// Only returns repos where the user has push access
+func (c *Client) ListOrgRepos(ctx context.Context, org string, page,
+	perPage int) ([]*Repository, error) {
+	if perPage <= 0 {
+		perPage = 30
+	}
+	if page <= 0 {
+		page = 1
+	}
+
+	endpoint := fmt.Sprintf("/orgs/%s/repos?sort=updated&per_page=%d&page=%d",
+		url.PathEscape(org), perPage, page)
+
+	resp, err := c.doRequest(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch org repos: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("Organization not found: %s", org)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("GitHub API error: %s - %s",
+			resp.Status, string(body))
+	}
+
+	var repos []*Repository
+	if err := json.NewDecoder(resp.Body).Decode(&repos); err != nil {
+		return nil, fmt.Errorf("Failed to decode repos response: %w", err)
+	}
+
+	return filterPushable(repos), nil
+}
+
+// Keeps only repos the user can push to (and so deploy)
+func filterPushable(repos []*Repository) []*Repository {
 	var deployableRepos []*Repository
 	for _, repo := range repos {
 		if repo.Permissions.Push || repo.Permissions.Admin {
 			deployableRepos = append(deployableRepos, repo)
 		}
 	}
-	return deployableRepos, nil
+	return deployableRepos
+}
+
+// Search response from GitHub's /search/repositories endpoint
+type repoSearchResult struct {
+	TotalCount int           `json:"total_count"`
+	Items      []*Repository `json:"items"`
+}
+
+// Search repositories owned by a user
+// Returns the matching page plus the total number of matches
+func (c *Client) SearchUserRepos(ctx context.Context, userLogin, query string,
+	page, perPage int) ([]*Repository, int, error) {
+	if perPage <= 0 {
+		perPage = 30
+	}
+	if page <= 0 {
+		page = 1
+	}
+
+	q := url.QueryEscape(fmt.Sprintf("%s user:%s", query, userLogin))
+	endpoint := fmt.Sprintf("/search/repositories?q=%s&sort=updated&per_page=%d&page=%d", q, perPage, page)
+
+	resp, err := c.doRequest(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Failed to search repos: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, 0, fmt.Errorf("GitHub API error: %s - %s",
+			resp.Status, string(body))
+	}
+
+	var result repoSearchResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, 0, fmt.Errorf("Failed to decode search response: %w", err)
+	}
+
+	return result.Items, result.TotalCount, nil
 }
 
 // Fetch a specific repo by owner/repo
@@ -177,6 +434,70 @@ func (c *Client) GetRepo(ctx context.Context, owner,
 	return &repository, nil
 }
 
+// Fetch the most recent commits on a branch, newest first
+func (c *Client) ListCommits(ctx context.Context, owner, repo, branch string,
+	limit int) ([]*CommitSummary, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	params := url.Values{}
+	params.Set("per_page", strconv.Itoa(limit))
+	if branch != "" {
+		params.Set("sha", branch)
+	}
+	endpoint := fmt.Sprintf("/repos/%s/%s/commits?%s", owner, repo,
+		params.Encode())
+
+	resp, err := c.doRequest(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch commits: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("Repository or branch not found: %s/%s@%s",
+			owner, repo, branch)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("GitHub API error: %s - %s",
+			resp.Status, string(body))
+	}
+
+	var commits []struct {
+		SHA     string `json:"sha"`
+		HTMLURL string `json:"html_url"`
+		Commit  struct {
+			Message string `json:"message"`
+			Author  struct {
+				Name string    `json:"name"`
+				Date time.Time `json:"date"`
+			} `json:"author"`
+		} `json:"commit"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&commits); err != nil {
+		return nil, fmt.Errorf("Failed to decode commits response: %w", err)
+	}
+
+	result := make([]*CommitSummary, len(commits))
+	for i, commit := range commits {
+		message, _, _ := strings.Cut(commit.Commit.Message, "\n")
+		result[i] = &CommitSummary{
+			SHA:        commit.SHA,
+			Message:    strings.TrimSpace(message),
+			AuthorName: commit.Commit.Author.Name,
+			AuthorDate: commit.Commit.Author.Date,
+			URL:        commit.HTMLURL,
+		}
+	}
+	return result, nil
+}
+
 // Get contents of a directory in a repository
 // Used for runtime detection
 func (c *Client) GetRepoContents(ctx context.Context, owner,
@@ -212,6 +533,48 @@ func (c *Client) GetRepoContents(ctx context.Context, owner,
 	return contents, nil
 }
 
+// Fetch the raw contents of a single file in a repository
+func (c *Client) GetFileContents(ctx context.Context, owner, repo,
+	path, ref string) ([]byte, error) {
+	endpoint := fmt.Sprintf("/repos/%s/%s/contents/%s", owner, repo, path)
+	if ref != "" {
+		endpoint += "?ref=" + ref
+	}
+
+	resp, err := c.doRequest(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("File not found: %s/%s/%s", owner, repo, path)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("GitHub API error: %s - %s",
+			resp.Status, string(body))
+	}
+
+	var file struct {
+		Type     string `json:"type"`
+		Encoding string `json:"encoding"`
+		Content  string `json:"content"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&file); err != nil {
+		return nil, fmt.Errorf("Failed to decode file response: %w", err)
+	}
+
+	if file.Type != "file" || file.Encoding != "base64" {
+		return nil, fmt.Errorf("Not a regular file: %s", path)
+	}
+
+	// GitHub wraps the base64 payload at 60 columns
+	return base64.StdEncoding.DecodeString(
+		strings.ReplaceAll(file.Content, "\n", ""))
+}
+
 // Checks if specific file exists in the repository
 func (c *Client) FileExists(ctx context.Context, owner, repo,
 	path, ref string) (bool, error) {
@@ -238,6 +601,11 @@ func GenerateWebhookSecret() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
+// Endpoint repository webhooks deliver to (API_URL + /api/webhooks/github)
+func WebhookURL() string {
+	return os.Getenv("API_URL") + "/api/webhooks/github"
+}
+
 // Create a webhook for push events on a repository
 func (c *Client) CreateWebhook(ctx context.Context, owner, repo,
 	webhookURL, secret string) (*Webhook, error) {
@@ -280,6 +648,63 @@ func (c *Client) CreateWebhook(ctx context.Context, owner, repo,
 	return &webhook, nil
 }
 
+// Replace the secret of an existing webhook
+// The current config is fetched first so the URL & content type are kept
+func (c *Client) UpdateWebhook(ctx context.Context, owner, repo string,
+	webhookID int64, secret string) (*Webhook, error) {
+	endpoint := fmt.Sprintf("/repos/%s/%s/hooks/%d", owner, repo, webhookID)
+
+	resp, err := c.doRequest(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Failed to fetch webhook: %s - %s",
+			resp.Status, string(body))
+	}
+
+	var current Webhook
+	if err := json.NewDecoder(resp.Body).Decode(&current); err != nil {
+		return nil, fmt.Errorf("Failed to decode webhook response: %w", err)
+	}
+
+	payload := map[string]any{
+		"config": WebhookCreateConfig{
+			URL:         current.Config.URL,
+			ContentType: current.Config.ContentType,
+			Secret:      secret,
+			InsecureSSL: current.Config.InsecureSSL,
+		},
+	}
+
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal webhook payload: %w", err)
+	}
+
+	patchResp, err := c.doRequest(ctx, http.MethodPatch, endpoint,
+		strings.NewReader(string(payloadJSON)))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to update webhook: %w", err)
+	}
+	defer patchResp.Body.Close()
+
+	if patchResp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(patchResp.Body)
+		return nil, fmt.Errorf("Failed to update webhook: %s - %s",
+			patchResp.Status, string(body))
+	}
+
+	var webhook Webhook
+	if err := json.NewDecoder(patchResp.Body).Decode(&webhook); err != nil {
+		return nil, fmt.Errorf("Failed to decode webhook response: %w", err)
+	}
+	return &webhook, nil
+}
+
 // Remove a webhook from a repository
 func (c *Client) DeleteWebhook(ctx context.Context, owner,
 	repo string, webhookID int64) error {
@@ -302,6 +727,116 @@ func (c *Client) DeleteWebhook(ctx context.Context, owner,
 	return nil
 }
 
+// Add an SSH deploy key (authorized_keys format) to a repository
+func (c *Client) CreateDeployKey(ctx context.Context, owner, repo, title,
+	publicKey string, readOnly bool) (*DeployKey, error) {
+	endpoint := fmt.Sprintf("/repos/%s/%s/keys", owner, repo)
+
+	payloadJSON, err := json.Marshal(map[string]interface{}{
+		"title":     title,
+		"key":       publicKey,
+		"read_only": readOnly,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal deploy key payload: %w", err)
+	}
+
+	resp, err := c.doRequest(ctx, http.MethodPost, endpoint,
+		strings.NewReader(string(payloadJSON)))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create deploy key: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Failed to create deploy key: %s - %s",
+			resp.Status, string(body))
+	}
+
+	var key DeployKey
+	if err := json.NewDecoder(resp.Body).Decode(&key); err != nil {
+		return nil, fmt.Errorf("Failed to decode deploy key response: %w", err)
+	}
+	return &key, nil
+}
+
+// Remove a deploy key from a repository
+func (c *Client) DeleteDeployKey(ctx context.Context, owner,
+	repo string, keyID int64) error {
+	endpoint := fmt.Sprintf("/repos/%s/%s/keys/%d", owner, repo, keyID)
+
+	resp, err := c.doRequest(ctx, http.MethodDelete, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to delete deploy key: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 204 No Content = success, 404 Not Found = already deleted
+	if resp.StatusCode != http.StatusNoContent &&
+		resp.StatusCode != http.StatusNotFound {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("Failed to delete deploy key: %s - %s",
+			resp.Status, string(body))
+	}
+
+	return nil
+}
+
+// Exchange a GitHub App JWT for an installation access token
+// The JWT is signed (RS256) with the app's PEM-encoded RSA private key
+func (c *Client) GetInstallationToken(ctx context.Context, appID int64,
+	installationID int64, privateKey []byte) (string, error) {
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(privateKey)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse app private key: %w", err)
+	}
+
+	// GitHub allows at most 10 minutes; backdate iat for clock drift
+	now := time.Now()
+	appJWT, err := jwt.NewWithClaims(jwt.SigningMethodRS256,
+		jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now.Add(-60 * time.Second)),
+			ExpiresAt: jwt.NewNumericDate(now.Add(9 * time.Minute)),
+			Issuer:    strconv.FormatInt(appID, 10),
+		}).SignedString(key)
+	if err != nil {
+		return "", fmt.Errorf("Failed to sign app JWT: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens",
+		githubAPIBaseURL, installationID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+appJWT)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Failed to create installation token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("Failed to create installation token: %s - %s",
+			resp.Status, string(body))
+	}
+
+	var tokenResp struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", fmt.Errorf("Failed to decode installation token: %w", err)
+	}
+	return tokenResp.Token, nil
+}
+
 // Splits "owner/repo" into owner & repo
 func ParseRepoFullName(fullName string) (owner, repo string, err error) {
 	parts := strings.Split(fullName, "/")
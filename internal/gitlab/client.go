@@ -0,0 +1,81 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	gitlabAPIBaseURL = "https://gitlab.com/api/v4"
+	userAgent        = "RCNbuild-PaaS/1.0"
+)
+
+// Client wraps GitLab API calls with auth
+type Client struct {
+	accessToken string
+	httpClient  *http.Client
+}
+
+// Creates a GitLab API client with the provided access token
+func NewClient(accessToken string) *Client {
+	return &Client{
+		accessToken: accessToken,
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Represents the authenticated GitLab user
+type GitLabUser struct {
+	ID        int64  `json:"id"`
+	Username  string `json:"username"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+// Perform an authenticated request to the GitLab API
+func (c *Client) doRequest(ctx context.Context, method, endpoint string,
+	body io.Reader) (*http.Response, error) {
+	url := gitlabAPIBaseURL + endpoint
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return c.httpClient.Do(req)
+}
+
+// Fetch the user the access token belongs to
+func (c *Client) GetUser(ctx context.Context) (*GitLabUser, error) {
+	resp, err := c.doRequest(ctx, http.MethodGet, "/user", nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch user: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("GitLab API error: %s - %s",
+			resp.Status, string(body))
+	}
+
+	var user GitLabUser
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, fmt.Errorf("Failed to decode user response: %w", err)
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Levels that can be set at runtime
+var levels = map[string]zerolog.Level{
+	"debug": zerolog.DebugLevel,
+	"info":  zerolog.InfoLevel,
+	"warn":  zerolog.WarnLevel,
+	"error": zerolog.ErrorLevel,
+}
+
+// Body for changing the log level
+type SetLevelRequest struct {
+	Level string `json:"level" binding:"required"`
+}
+
+// Configure the global logger with pretty console output
+// Starting level comes from LOG_LEVEL (default info)
+func Setup() {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
+	setLevelFromEnv()
+}
+
+// Configure the global logger with one JSON object per line, for
+// processes whose output goes to a log aggregator (e.g. the worker)
+func SetupJSON() {
+	zerolog.TimeFieldFormat = time.RFC3339Nano
+	log.Logger = zerolog.New(os.Stderr).With().Timestamp().Logger()
+	setLevelFromEnv()
+}
+
+// Sets the global level from LOG_LEVEL (default info)
+func setLevelFromEnv() {
+	level, ok := levels[os.Getenv("LOG_LEVEL")]
+	if !ok {
+		level = zerolog.InfoLevel
+	}
+	zerolog.SetGlobalLevel(level)
+}
+
+// Returns the current global log level
+// zerolog stores it atomically, so it's safe to change while logging
+func Level() string {
+	return zerolog.GlobalLevel().String()
+}
+
+// Middleware that requires the shared X-Internal-Secret header
+// Rejects everything when INTERNAL_SECRET isn't configured
+func InternalSecretRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		secret := os.Getenv("INTERNAL_SECRET")
+		provided := c.GetHeader("X-Internal-Secret")
+		if secret == "" || subtle.ConstantTimeCompare([]byte(provided),
+			[]byte(secret)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// Change the global log level without a restart
+// PUT /internal/log-level
+func HandleSetLogLevel(c *gin.Context) {
+	var req SetLevelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	level, ok := levels[req.Level]
+	if !ok {
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "level must be debug, info, warn, or error"})
+		return
+	}
+
+	previous := Level()
+	zerolog.SetGlobalLevel(level)
+	log.WithLevel(zerolog.NoLevel).Str("from", previous).
+		Str("to", req.Level).Msg("Log level changed")
+
+	c.JSON(http.StatusOK, gin.H{"level": Level()})
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// Dev fallback when CORS_ALLOWED_ORIGINS isn't set
+const defaultCORSOrigin = "http://localhost:3000"
+
+// Middleware that lets the dashboard call the API from the browser
+// Production only allows DASHBOARD_URL; elsewhere CORS_ALLOWED_ORIGINS
+// (comma-separated) is used
+func CORS() gin.HandlerFunc {
+	return cors.New(cors.Config{
+		AllowOrigins: allowedOrigins(),
+		AllowMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE",
+			"OPTIONS"},
+		AllowHeaders: []string{"Content-Type", "Authorization",
+			RequestIDHeader},
+		ExposeHeaders:    []string{RequestIDHeader, "X-Token-Expiring"},
+		AllowCredentials: true, // Auth uses cookies
+		MaxAge:           24 * time.Hour,
+	})
+}
+
+// Origins allowed to make cross-site requests
+func allowedOrigins() []string {
+	dashboardURL := os.Getenv("DASHBOARD_URL")
+	if os.Getenv("ENVIRONMENT") == "production" && dashboardURL != "" {
+		return []string{dashboardURL}
+	}
+
+	var origins []string
+	for _, origin := range strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"),
+		",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		origins = []string{defaultCORSOrigin}
+	}
+	return origins
+}
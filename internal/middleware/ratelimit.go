@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/cache"
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware that allows each authenticated user limit requests per
+// window on the routes it guards, counted in Redis so every API instance
+// shares the budget. name keeps separate limits apart.
+// Must run after auth; fails closed if Redis is unavailable.
+func UserRateLimit(name string, limit int,
+	window time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString(UserIDKey)
+		if userID == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized,
+				gin.H{"error": "unauthorized"})
+			return
+		}
+
+		key := fmt.Sprintf("ratelimit:%s:%s", name, userID)
+		allowed, retryAfter, err := cache.Allow(c.Request.Context(), key,
+			limit, window)
+		if err != nil {
+			Logger(c).Error().Err(err).Str("limit", name).
+				Msg("Rate limiter unavailable")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable,
+				gin.H{"error": "try again later"})
+			return
+		}
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(
+				int(math.Ceil(retryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error": "rate limit exceeded",
+				"limit": limit,
+			})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Stack frames included in development error responses
+const recoveryStackFrames = 5
+
+// Middleware that turns a handler panic into a JSON 500 & logs the panic
+// value with its stack trace. Outside production the response also
+// carries the panic message & the top stack frames.
+func JSONRecovery() gin.HandlerFunc {
+	development := os.Getenv("ENVIRONMENT") != "production"
+
+	return func(c *gin.Context) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			// net/http's way of aborting a response; let it through
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			Logger(c).Error().
+				Str("panic", fmt.Sprint(rec)).
+				Str("stack", string(debug.Stack())).
+				Str("method", c.Request.Method).
+				Str("path", c.Request.URL.Path).
+				Msg("Recovered from panic")
+
+			body := gin.H{
+				"error":      "internal server error",
+				"request_id": GetRequestID(c),
+			}
+			if development {
+				body["panic"] = fmt.Sprint(rec)
+				body["stack"] = panicFrames(recoveryStackFrames)
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, body)
+		}()
+		c.Next()
+	}
+}
+
+// Returns up to n "function file:line" frames from where the panic was
+// raised, skipping the runtime's own panic machinery
+func panicFrames(n int) []string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+
+	stack := make([]string, 0, n)
+	for len(stack) < n {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "runtime.") {
+			stack = append(stack, fmt.Sprintf("%s %s:%d", frame.Function,
+				frame.File, frame.Line))
+		}
+		if !more {
+			break
+		}
+	}
+	return stack
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Header carrying the request ID in & out
+	RequestIDHeader = "X-Request-ID"
+	// RequestIDKey is the key used to store the request ID in gin context
+	RequestIDKey = "request_id"
+	// Key for the request-scoped logger in gin context
+	loggerKey = "logger"
+)
+
+// Middleware that tags every request with an ID for log correlation
+// Reuses an incoming X-Request-ID if it's a valid UUID
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if _, err := uuid.Parse(requestID); err != nil {
+			requestID = uuid.NewString()
+		}
+
+		logger := log.With().Str("request_id", requestID).Logger()
+
+		c.Set(RequestIDKey, requestID)
+		c.Set(loggerKey, &logger)
+		c.Request = c.Request.WithContext(
+			logger.WithContext(c.Request.Context()))
+		c.Header(RequestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+// Retrieve the request ID from context
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(RequestIDKey)
+}
+
+// Returns the request-scoped logger (global logger outside a request)
+func Logger(c *gin.Context) *zerolog.Logger {
+	if logger, ok := c.Get(loggerKey); ok {
+		return logger.(*zerolog.Logger)
+	}
+	return &log.Logger
+}
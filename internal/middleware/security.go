@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware that sets standard security headers on every response
+// HSTS is only sent in production so local HTTP setups aren't pinned
+func SecurityHeaders() gin.HandlerFunc {
+	hsts := os.Getenv("ENVIRONMENT") == "production"
+
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		// Legacy XSS auditor is itself exploitable; disable it explicitly
+		h.Set("X-XSS-Protection", "0")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		if hsts {
+			h.Set("Strict-Transport-Security",
+				"max-age=63072000; includeSubDomains")
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,101 @@
+package projects
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"time"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/cache"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// How long a rendered badge is cached (Redis & clients)
+const badgeTTL = 30 * time.Second
+
+// Right-hand badge colors by deployment status (shields.io palette)
+var badgeColors = map[database.DeploymentStatus]string{
+	database.DeploymentStatusLive:      "#4c1",
+	database.DeploymentStatusBuilding:  "#dfb317",
+	database.DeploymentStatusDeploying: "#dfb317",
+	database.DeploymentStatusFailed:    "#e05d44",
+	database.DeploymentStatusPending:   "#9f9f9f",
+}
+
+const badgeTemplate = `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="rcnbuild: %[4]s">
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[3]d" height="20" fill="%[5]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[6]d" y="14">rcnbuild</text><text x="%[7]d" y="14">%[4]s</text>
+</g>
+</svg>`
+
+// Returns an SVG badge with the project's latest deployment status
+// GET /api/projects/:id/badge (:id is the project slug; unauthenticated)
+func (h *Handlers) HandleGetBadge(c *gin.Context) {
+	logger := middleware.Logger(c)
+	slug := c.Param("id")
+	cacheKey := "badge:" + slug
+
+	svg, ok := cache.Get(c.Request.Context(), cacheKey)
+	if !ok {
+		project, err := database.GetProjectBySlug(c.Request.Context(), slug)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+			return
+		}
+
+		status, err := latestDeploymentStatus(c.Request.Context(), project.ID)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to get latest deployment")
+			c.JSON(http.StatusInternalServerError,
+				gin.H{"error": "Failed to render badge"})
+			return
+		}
+
+		svg = renderBadge(status)
+		cache.Set(c.Request.Context(), cacheKey, svg, badgeTTL)
+	}
+
+	c.Header("Cache-Control",
+		fmt.Sprintf("max-age=%d", int(badgeTTL.Seconds())))
+	c.Data(http.StatusOK, "image/svg+xml", svg)
+}
+
+// Status of the project's most recent deployment ("none" if never deployed)
+func latestDeploymentStatus(ctx context.Context,
+	projectID string) (string, error) {
+	deployments, err := database.GetDeploymentsByProjectID(ctx, projectID, 1)
+	if err != nil {
+		return "", err
+	}
+	if len(deployments) == 0 {
+		return "none", nil
+	}
+	return string(deployments[0].Status), nil
+}
+
+// Renders a shields.io-style "rcnbuild | status" badge
+func renderBadge(status string) []byte {
+	color, ok := badgeColors[database.DeploymentStatus(status)]
+	if !ok {
+		color = "#9f9f9f"
+	}
+
+	// Approximate Verdana 11px glyph width plus padding
+	labelWidth := textWidth("rcnbuild")
+	statusWidth := textWidth(status)
+	total := labelWidth + statusWidth
+
+	return []byte(fmt.Sprintf(badgeTemplate, total, labelWidth, statusWidth,
+		html.EscapeString(status), color, labelWidth/2,
+		labelWidth+statusWidth/2))
+}
+
+func textWidth(s string) int {
+	return len(s)*7 + 10
+}
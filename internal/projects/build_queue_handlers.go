@@ -0,0 +1,70 @@
+package projects
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/auth"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/queue"
+	"github.com/gin-gonic/gin"
+)
+
+// Assumed build time when a project has no successful builds yet
+const defaultBuildDuration = 2 * time.Minute
+
+// Where the project's next build sits in the build queue
+// GET /api/projects/:id/build-queue
+func (h *Handlers) HandleGetBuildQueue(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	projectID := c.Param("id")
+	project, err := database.GetProjectByID(c.Request.Context(), projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	// Nothing waiting to build
+	pending, err := database.GetOldestPendingDeployment(c.Request.Context(),
+		project.ID)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"position":               0,
+			"estimated_wait_seconds": 0,
+		})
+		return
+	}
+
+	position, err := queue.BuildQueuePosition(pending.ID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to inspect build queue")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to get build queue position"})
+		return
+	}
+
+	avg, err := database.AverageBuildDuration(c.Request.Context(), project.ID)
+	if err != nil || avg <= 0 {
+		avg = defaultBuildDuration
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deployment_id":          pending.ID,
+		"position":               position,
+		"estimated_wait_seconds": int(avg.Seconds()) * position,
+	})
+}
@@ -0,0 +1,187 @@
+package projects
+
+import (
+	"net/http"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/auth"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// Body for cloning a project onto another repository
+type CloneProjectRequest struct {
+	RepoFullName string `json:"repo_full_name" binding:"required"`
+	Name         string `json:"name"` // Defaults to the repo name
+}
+
+// Create a project with another project's settings & env vars
+// POST /api/projects/:id/clone
+// @Summary Clone a project onto another repository
+// @Description Copies runtime, build/start commands, port, branch, build
+// @Description settings & env vars into a new project with its own slug &
+// @Description webhook. Deployments & the deploy key are not copied.
+// @Tags projects
+// @Accept json
+// @Produce json
+// @Param id path string true "Project ID"
+// @Param body body CloneProjectRequest true "Target repo & name"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /projects/{id}/clone [post]
+func (h *Handlers) HandleCloneProject(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	projectID := c.Param("id")
+	source, err := database.GetProjectByID(c.Request.Context(), projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if source.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var req CloneProjectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.RespondBodyError(c, err)
+		return
+	}
+
+	if !withinProjectQuota(c, user) {
+		return
+	}
+
+	ghClient, owner, repoName, repo, ok := newProjectRepo(c, user,
+		req.RepoFullName)
+	if !ok {
+		return
+	}
+
+	// One project per repo & environment, as for new projects
+	existing, _ := database.GetProjectByRepoFullName(c.Request.Context(),
+		req.RepoFullName, source.Environment)
+	if existing != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "project for this repo & environment already exists",
+		})
+		return
+	}
+
+	projectName := req.Name
+	if projectName == "" {
+		projectName = repo.Name
+	}
+
+	// Never reuse the source's slug; it's bound to its subdomain
+	slug := generateSlug(projectName, repo.Name)
+	if isReservedSlug(slug) {
+		slug = slug + "-" + randomSuffix(4)
+	}
+	slug = uniqueSlug(c.Request.Context(), slug)
+
+	input := &database.CreateProjectInput{
+		UserId:            user.ID,
+		Name:              projectName,
+		Slug:              slug,
+		RepoFullName:      req.RepoFullName,
+		RepoURL:           repo.HTMLURL,
+		Branch:            source.Branch,
+		RootDirectory:     source.RootDirectory,
+		BuildContext:      source.BuildContext,
+		BuildCommand:      source.BuildCommand,
+		StartCommand:      source.StartCommand,
+		Runtime:           source.Runtime,
+		Port:              source.Port,
+		Environment:       source.Environment,
+		Tags:              source.Tags,
+		BuildEnvVars:      source.BuildEnvVars,
+		BuildSecrets:      source.BuildSecrets,
+		SubmodulesEnabled: source.SubmodulesEnabled,
+		WatchPullRequests: source.WatchPullRequests,
+		BaseDomain:        source.BaseDomain,
+		Description:       source.Description,
+		ReadinessProbeCmd: source.ReadinessProbeCmd,
+		// Always set on existing projects
+		ReadinessProbeTimeoutSeconds: source.ReadinessProbeTimeoutSeconds,
+	}
+
+	project, webhook, err := createProjectRecord(c.Request.Context(), logger,
+		ghClient, owner, repoName, input)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create cloned project")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "failed to clone project"})
+		return
+	}
+
+	// Settings that can't be given at creation; best effort, the clone
+	// itself is usable with the defaults
+	if source.DeployStrategy != database.DeployStrategyRecreate ||
+		source.ReloadSignal != nil {
+		updated, err := database.UpdateProject(c.Request.Context(),
+			project.ID, &database.UpdateProjectInput{
+				DeployStrategy: &source.DeployStrategy,
+				ReloadSignal:   source.ReloadSignal,
+			})
+		if err != nil {
+			logger.Warn().Err(err).Str("project_id", project.ID).
+				Msg("Failed to copy deploy settings to clone")
+		} else {
+			project = updated
+		}
+	}
+
+	// Ciphertexts are copied as-is; every project shares ENCRYPTION_KEY
+	envVars, err := database.GetEnvVarsByProjectID(c.Request.Context(),
+		source.ID)
+	if err == nil {
+		err = database.BulkUpsertEnvVars(c.Request.Context(), project.ID,
+			user.ID, envVars)
+	}
+	if err != nil {
+		logger.Error().Err(err).Str("project_id", project.ID).
+			Msg("Failed to copy env vars to clone")
+		// A clone without its env vars would deploy broken; undo it
+		deleteProjectWebhook(c.Request.Context(), logger, ghClient, owner,
+			repoName, webhook)
+		if err := database.DeleteProjectWithData(c.Request.Context(),
+			project.ID); err != nil {
+			logger.Error().Err(err).Str("project_id", project.ID).
+				Msg("Failed to remove partially cloned project")
+		}
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "failed to copy environment variables"})
+		return
+	}
+
+	// Add a read-only deploy key so the worker can clone over SSH
+	setupDeployKey(c.Request.Context(), ghClient, owner, repoName, project)
+
+	logger.Info().
+		Str("project_id", project.ID).
+		Str("source_project_id", source.ID).
+		Str("repo", req.RepoFullName).
+		Int("env_vars", len(envVars)).
+		Msg("Cloned project")
+
+	c.JSON(http.StatusCreated, withRateLimit(gin.H{
+		"project":        project,
+		"source_id":      source.ID,
+		"env_vars_count": len(envVars),
+	}, ghClient))
+}
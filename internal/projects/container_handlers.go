@@ -0,0 +1,171 @@
+package projects
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/auth"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/containers"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/queue"
+	"github.com/gin-gonic/gin"
+)
+
+// Stop the running container without deleting the project
+// POST /api/projects/:id/pause
+func (h *Handlers) HandlePauseProject(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	projectID := c.Param("id")
+	project, err := database.GetProjectByID(c.Request.Context(), projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	if project.PausedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Project is already paused"})
+		return
+	}
+
+	// Stop the live container if there is one
+	deployment, err := database.GetLiveDeployment(c.Request.Context(),
+		project.ID)
+	if err == nil && deployment.ContainerID != nil {
+		if err := containers.Stop(c.Request.Context(),
+			*deployment.ContainerID); err != nil {
+			logger.Error().Err(err).Str("project_id", project.ID).
+				Msg("Failed to stop container")
+			c.JSON(http.StatusInternalServerError,
+				gin.H{"error": "Failed to stop container"})
+			return
+		}
+	}
+
+	if err := database.SetProjectPaused(c.Request.Context(), project.ID,
+		true); err != nil {
+		logger.Error().Err(err).Msg("Failed to mark project paused")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to pause project"})
+		return
+	}
+
+	logger.Info().Str("project_id", project.ID).Msg("Project paused")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Project paused"})
+}
+
+// Queue a restart of the live deployment's image without a new build
+// The project stays paused until the worker has the container running
+// POST /api/projects/:id/resume
+func (h *Handlers) HandleResumeProject(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	projectID := c.Param("id")
+	project, err := database.GetProjectByID(c.Request.Context(), projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	if project.PausedAt == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Project is not paused"})
+		return
+	}
+
+	// Pulling & health-checking the image can outlast the request
+	taskID, err := queue.EnqueueResumeProject(c.Request.Context(),
+		&queue.ResumeProjectPayload{ProjectID: project.ID})
+	if err != nil {
+		logger.Error().Err(err).Str("project_id", project.ID).
+			Msg("Failed to enqueue resume")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to resume project"})
+		return
+	}
+
+	logger.Info().Str("project_id", project.ID).Msg("Project resume queued")
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Project resuming",
+		"task_id": taskID,
+	})
+}
+
+// Returns live CPU & memory usage of the project's container
+// GET /api/projects/:id/stats/container
+func (h *Handlers) HandleGetContainerStats(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	projectID := c.Param("id")
+	project, err := database.GetProjectByID(c.Request.Context(), projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	deployment, err := database.GetLiveDeployment(c.Request.Context(),
+		project.ID)
+	if err != nil || deployment.ContainerID == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No live deployment"})
+		return
+	}
+
+	stats, err := containers.GetContainerStats(c.Request.Context(),
+		*deployment.ContainerID)
+	if err != nil {
+		if errors.Is(err, containers.ErrDockerUnavailable) {
+			logger.Error().Err(err).Msg("Docker daemon unreachable")
+			c.JSON(http.StatusServiceUnavailable,
+				gin.H{"error": "Container runtime unavailable"})
+			return
+		}
+		logger.Error().Err(err).Str("project_id", project.ID).
+			Msg("Failed to get container stats")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to get container stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deployment_id": deployment.ID,
+		"stats":         stats,
+	})
+}
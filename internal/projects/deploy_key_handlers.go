@@ -0,0 +1,114 @@
+package projects
+
+import (
+	"net/http"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/auth"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/github"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
+	"github.com/Sys-Redux/rcnbuild-paas/pkg/crypto"
+	"github.com/gin-gonic/gin"
+)
+
+// Replace the project's deploy key with a freshly generated one
+// POST /api/projects/:id/rotate-deploy-key
+func (h *Handlers) HandleRotateDeployKey(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	projectID := c.Param("id")
+	project, err := database.GetProjectByID(c.Request.Context(), projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	owner, repoName, err := github.ParseRepoFullName(project.RepoFullName)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid repository"})
+		return
+	}
+
+	accessToken, err := database.GetUserAccessToken(c.Request.Context(),
+		user.ID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get user access token")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to get user access token"})
+		return
+	}
+	ghClient := github.NewClient(accessToken)
+
+	publicKey, privateKey, err := generateDeployKey()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to generate deploy key")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to generate deploy key"})
+		return
+	}
+
+	encryptedKey, err := crypto.Encrypt(privateKey)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to encrypt deploy key")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to encrypt deploy key"})
+		return
+	}
+
+	// The new key is added before the old one is removed, so builds keep
+	// a working key throughout & a failed rotation leaves the old one
+	deployKey, err := ghClient.CreateDeployKey(c.Request.Context(), owner,
+		repoName, "RCNbuild ("+project.Slug+")", publicKey, true)
+	if err != nil {
+		logger.Error().Err(err).Str("project_id", project.ID).
+			Msg("Failed to create GitHub deploy key")
+		c.JSON(http.StatusBadGateway,
+			gin.H{"error": "Failed to create deploy key on GitHub"})
+		return
+	}
+
+	if err := database.SetProjectDeployKey(c.Request.Context(), project.ID,
+		deployKey.ID, encryptedKey); err != nil {
+		logger.Error().Err(err).Msg("Failed to store deploy key")
+
+		// Roll back the GitHub side so no orphaned key is left behind
+		if err := ghClient.DeleteDeployKey(c.Request.Context(), owner,
+			repoName, deployKey.ID); err != nil {
+			logger.Error().Err(err).Int64("deploy_key_id", deployKey.ID).
+				Msg("Failed to remove new deploy key after rollback")
+		}
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to store deploy key"})
+		return
+	}
+
+	if project.DeployKeyID != nil {
+		if err := ghClient.DeleteDeployKey(c.Request.Context(), owner,
+			repoName, *project.DeployKeyID); err != nil {
+			logger.Warn().Err(err).Int64("deploy_key_id",
+				*project.DeployKeyID).Msg("Failed to delete old deploy key")
+		}
+	}
+
+	logger.Info().
+		Str("project_id", project.ID).
+		Int64("deploy_key_id", deployKey.ID).
+		Msg("Deploy key rotated")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Deploy key rotated",
+		"deploy_key_id": deployKey.ID,
+	})
+}
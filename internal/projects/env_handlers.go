@@ -1,13 +1,19 @@
 package projects
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"regexp"
+	"sync/atomic"
 
 	"github.com/Sys-Redux/rcnbuild-paas/internal/auth"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/containers"
 	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
 	"github.com/Sys-Redux/rcnbuild-paas/pkg/crypto"
 	"github.com/gin-gonic/gin"
-	"github.com/rs/zerolog/log"
+	"github.com/google/uuid"
 )
 
 // Body for creating/updating an environment variable
@@ -18,7 +24,19 @@ type CreateEnvVarRequest struct {
 
 // List env var for a project
 // GET /api/projects/:id/env
+// @Summary List a project's env vars (values masked)
+// @Tags env
+// @Produce json
+// @Param id path string true "Project ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /projects/{id}/env [get]
 func (h *Handlers) HandleListEnvVars(c *gin.Context) {
+	logger := middleware.Logger(c)
+
 	user := auth.GetCurrentUser(c)
 	if user == nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
@@ -41,7 +59,7 @@ func (h *Handlers) HandleListEnvVars(c *gin.Context) {
 	envVars, err := database.GetEnvVarsByProjectID(c.Request.Context(),
 		project.ID)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to get env vars")
+		logger.Error().Err(err).Msg("Failed to get env vars")
 		c.JSON(http.StatusInternalServerError,
 			gin.H{"error": "failed to get env vars"})
 		return
@@ -55,7 +73,22 @@ func (h *Handlers) HandleListEnvVars(c *gin.Context) {
 
 // Create or update an env var for a project
 // POST /api/projects/:id/env
+// @Summary Create or update an env var
+// @Tags env
+// @Accept json
+// @Produce json
+// @Param id path string true "Project ID"
+// @Param body body CreateEnvVarRequest true "Key & value"
+// @Success 201 {object} database.EnvVarDisplay
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /projects/{id}/env [post]
 func (h *Handlers) HandleCreateEnvVar(c *gin.Context) {
+	logger := middleware.Logger(c)
+
 	user := auth.GetCurrentUser(c)
 	if user == nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
@@ -77,11 +110,11 @@ func (h *Handlers) HandleCreateEnvVar(c *gin.Context) {
 
 	var req CreateEnvVarRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		middleware.RespondBodyError(c, err)
 		return
 	}
 
-	// Validate key format (only allow alphanumeric and underscores)
+	// Validate key format (ENV_KEY_PATTERN)
 	if !isValidEnvKey(req.Key) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key format"})
 		return
@@ -90,7 +123,7 @@ func (h *Handlers) HandleCreateEnvVar(c *gin.Context) {
 	// Encrypt the value before storing
 	encryptedValue, err := crypto.Encrypt(req.Value)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to encrypt env var value")
+		logger.Error().Err(err).Msg("Failed to encrypt env var value")
 		c.JSON(http.StatusInternalServerError,
 			gin.H{"error": "failed to encrypt env var value"})
 		return
@@ -98,9 +131,9 @@ func (h *Handlers) HandleCreateEnvVar(c *gin.Context) {
 
 	// Create or update the env var in the database
 	envVar, err := database.CreateOrUpdateEnvVar(c.Request.Context(),
-		project.ID, req.Key, encryptedValue)
+		project.ID, req.Key, encryptedValue, user.ID)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to create/update env var")
+		logger.Error().Err(err).Msg("Failed to create/update env var")
 		c.JSON(http.StatusInternalServerError,
 			gin.H{"error": "failed to create/update env var"})
 		return
@@ -112,7 +145,20 @@ func (h *Handlers) HandleCreateEnvVar(c *gin.Context) {
 
 // Delete an env var
 // DELETE /api/projects/:id/env/:key
+// @Summary Delete an env var
+// @Tags env
+// @Produce json
+// @Param id path string true "Project ID"
+// @Param key path string true "Env var key"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /projects/{id}/env/{key} [delete]
 func (h *Handlers) HandleDeleteEnvVar(c *gin.Context) {
+	logger := middleware.Logger(c)
+
 	user := auth.GetCurrentUser(c)
 	if user == nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
@@ -141,7 +187,7 @@ func (h *Handlers) HandleDeleteEnvVar(c *gin.Context) {
 			return
 		}
 
-		log.Error().Err(err).Msg("Failed to delete env var")
+		logger.Error().Err(err).Msg("Failed to delete env var")
 		c.JSON(http.StatusInternalServerError,
 			gin.H{"error": "failed to delete env var"})
 		return
@@ -150,24 +196,351 @@ func (h *Handlers) HandleDeleteEnvVar(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "env var deleted"})
 }
 
-// Validate env var key format (alphanumeric and underscores only)
-func isValidEnvKey(key string) bool {
-	if len(key) == 0 || len(key) > 255 {
-		return false
+// Decrypted value of a single env var
+type RevealEnvVarResponse struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Reveal the plaintext value of an env var
+// GET /api/projects/:id/env/:key/reveal
+// Rate limited per user; every reveal is written to the audit log
+// @Summary Reveal an env var's value
+// @Description Returns the decrypted value (10 per minute per user).
+// @Description Each call is recorded in the audit log with the caller's IP.
+// @Tags env
+// @Produce json
+// @Param id path string true "Project ID"
+// @Param key path string true "Env var key"
+// @Success 200 {object} RevealEnvVarResponse
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /projects/{id}/env/{key}/reveal [get]
+func (h *Handlers) HandleRevealEnvVar(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
 	}
 
-	// Must start w/ letter
-	first := key[0]
-	if !((first >= 'A' && first <= 'Z') || (first >= 'a' && first <= 'z')) {
-		return false
+	projectID := c.Param("id")
+	key := c.Param("key")
+
+	project, err := database.GetProjectByID(c.Request.Context(), projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+
+	// Check if user has access to the project
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access Denied"})
+		return
+	}
+
+	envVar, err := database.GetEnvVarByKey(c.Request.Context(), project.ID,
+		key)
+	if err != nil {
+		if err.Error() == "env var not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "env var not found"})
+			return
+		}
+
+		logger.Error().Err(err).Str("key", key).Msg("Failed to get env var")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "failed to get env var"})
+		return
+	}
+
+	// No audit entry, no value
+	if err := database.CreateAuditLogEntry(c.Request.Context(),
+		&database.CreateAuditLogInput{
+			UserID:    user.ID,
+			ProjectID: &project.ID,
+			Action:    database.AuditActionEnvVarRevealed,
+			Target:    &key,
+			IPAddress: c.ClientIP(),
+		}); err != nil {
+		logger.Error().Err(err).Str("key", key).
+			Msg("Failed to write audit log entry")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "failed to reveal env var"})
+		return
+	}
+
+	// The value itself must never reach the logs, only the key
+	value, err := crypto.Decrypt(envVar.ValueEncrypted)
+	if err != nil {
+		logger.Error().Err(err).Str("key", key).
+			Msg("Failed to decrypt env var")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "failed to reveal env var"})
+		return
+	}
+
+	logger.Info().Str("project_id", project.ID).Str("key", key).
+		Msg("Env var revealed")
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, RevealEnvVarResponse{Key: key, Value: value})
+}
+
+// Number of history entries returned per env var
+const envVarHistoryLimit = 20
+
+// List previous values of an env var (masked)
+// GET /api/projects/:id/env/:key/history
+// @Summary List an env var's previous values
+// @Tags env
+// @Produce json
+// @Param id path string true "Project ID"
+// @Param key path string true "Env var key"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /projects/{id}/env/{key}/history [get]
+func (h *Handlers) HandleGetEnvVarHistory(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	projectID := c.Param("id")
+	key := c.Param("key")
+
+	project, err := database.GetProjectByID(c.Request.Context(), projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+
+	// Check if user has access to the project
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access Denied"})
+		return
+	}
+
+	entries, err := database.GetEnvVarHistory(c.Request.Context(),
+		project.ID, key, envVarHistoryLimit)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get env var history")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "failed to get env var history"})
+		return
+	}
+
+	// Masked the same way as current values
+	history := make([]database.EnvVarHistoryDisplay, len(entries))
+	for i, e := range entries {
+		history[i] = e.ToDisplay()
+	}
+
+	c.JSON(http.StatusOK, gin.H{"key": key, "history": history})
+}
+
+// Restore an env var to a previous value
+// POST /api/projects/:id/env/:key/restore/:history_id
+// @Summary Restore an env var to a previous value
+// @Tags env
+// @Produce json
+// @Param id path string true "Project ID"
+// @Param key path string true "Env var key"
+// @Param history_id path string true "History entry ID"
+// @Success 200 {object} database.EnvVarDisplay
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /projects/{id}/env/{key}/restore/{history_id} [post]
+func (h *Handlers) HandleRestoreEnvVar(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	projectID := c.Param("id")
+	key := c.Param("key")
+	historyID := c.Param("history_id")
+	if _, err := uuid.Parse(historyID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid history id"})
+		return
+	}
+
+	project, err := database.GetProjectByID(c.Request.Context(), projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
 	}
 
-	// Rest can be letters, numbers, or underscores
-	for _, c := range key[1:] {
-		if !((c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') ||
-			(c >= '0' && c <= '9') || c == '_') {
-			return false
+	// Check if user has access to the project
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access Denied"})
+		return
+	}
+
+	envVar, err := database.RestoreEnvVarFromHistory(c.Request.Context(),
+		project.ID, key, historyID, user.ID)
+	if err != nil {
+		if err.Error() == "env var history entry not found" {
+			c.JSON(http.StatusNotFound,
+				gin.H{"error": "env var history entry not found"})
+			return
 		}
+
+		logger.Error().Err(err).Msg("Failed to restore env var")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "failed to restore env var"})
+		return
+	}
+
+	logger.Info().
+		Str("project_id", project.ID).
+		Str("key", key).
+		Str("history_id", historyID).
+		Msg("Env var restored from history")
+
+	c.JSON(http.StatusOK, envVar.ToDisplay())
+}
+
+// Push the current env vars into the live container without redeploying
+// POST /api/projects/:id/env/sync
+// @Summary Hot-reload env vars into the live container
+// @Description Writes the env vars to /.rcnbuild.env in the live container
+// @Description & sends its main process the project's reload signal
+// @Description (SIGHUP by default). The process environment can't be
+// @Description changed at runtime, so the app must re-read that file on the
+// @Description signal; apps that don't should redeploy instead.
+// @Tags env
+// @Produce json
+// @Param id path string true "Project ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /projects/{id}/env/sync [post]
+func (h *Handlers) HandleSyncEnvVars(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	projectID := c.Param("id")
+	project, err := database.GetProjectByID(c.Request.Context(), projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found"})
+		return
+	}
+
+	// Check if user has access to the project
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access Denied"})
+		return
+	}
+
+	deployment, err := database.GetLiveDeployment(c.Request.Context(),
+		project.ID)
+	if err != nil || deployment.ContainerID == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "no live deployment"})
+		return
+	}
+
+	envVars, err := database.GetEnvVarsAsMap(c.Request.Context(),
+		project.ID, crypto.Decrypt)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to fetch env vars")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "failed to fetch env vars"})
+		return
+	}
+	envVars["PORT"] = fmt.Sprintf("%d", project.Port)
+
+	signal := containers.DefaultReloadSignal
+	if project.ReloadSignal != nil {
+		signal = *project.ReloadSignal
+	}
+
+	changed, err := containers.ReloadEnv(c.Request.Context(),
+		*deployment.ContainerID, envVars, signal)
+	if err != nil {
+		if errors.Is(err, containers.ErrContainerNotRunning) {
+			c.JSON(http.StatusConflict,
+				gin.H{"error": "live container is not running"})
+			return
+		}
+
+		logger.Error().Err(err).Str("project_id", project.ID).
+			Msg("Failed to sync env vars")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "failed to sync env vars"})
+		return
+	}
+
+	logger.Info().
+		Str("project_id", project.ID).
+		Str("deployment_id", deployment.ID).
+		Str("signal", signal).
+		Strs("changed_keys", changed).
+		Msg("Env vars synced to live container")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "env vars synced",
+		"signal":       signal,
+		"env_file":     containers.ReloadEnvFile,
+		"changed_keys": changed,
+	})
+}
+
+// Default env var key format: a letter, then letters, digits or
+// underscores. Covers `__` nesting (APP__DB__HOST) & REACT_APP_* prefixes
+const defaultEnvKeyPattern = `[A-Za-z][A-Za-z0-9_]*`
+
+// Env var key format in use (ENV_KEY_PATTERN overrides the default)
+var envKeyPattern atomic.Pointer[regexp.Regexp]
+
+func init() {
+	envKeyPattern.Store(regexp.MustCompile(
+		anchorEnvKeyPattern(defaultEnvKeyPattern)))
+}
+
+// Replaces the env var key format; the whole key must match pattern
+// Returns the compile error (keeping the current format) if it's invalid
+func SetEnvKeyPattern(pattern string) error {
+	re, err := regexp.Compile(anchorEnvKeyPattern(pattern))
+	if err != nil {
+		return err
+	}
+	envKeyPattern.Store(re)
+	return nil
+}
+
+// Makes pattern match whole keys only
+func anchorEnvKeyPattern(pattern string) string {
+	return `^(?:` + pattern + `)$`
+}
+
+// Validate env var key format
+func isValidEnvKey(key string) bool {
+	if len(key) == 0 || len(key) > 255 {
+		return false
 	}
-	return true
+	return envKeyPattern.Load().MatchString(key)
 }
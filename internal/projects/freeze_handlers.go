@@ -0,0 +1,118 @@
+package projects
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/auth"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// Body for adding a deployment freeze window
+type CreateFreezeWindowRequest struct {
+	CronExpression  string `json:"cron_expression" binding:"required"`
+	DurationMinutes int    `json:"duration_minutes" binding:"required"`
+}
+
+// Add a freeze window to a project
+// POST /api/projects/:id/freeze-windows
+func (h *Handlers) HandleCreateFreezeWindow(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	projectID := c.Param("id")
+	project, err := database.GetProjectByID(c.Request.Context(), projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var req CreateFreezeWindowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.RespondBodyError(c, err)
+		return
+	}
+
+	window := &database.FreezeWindow{
+		CronExpression:  req.CronExpression,
+		DurationMinutes: req.DurationMinutes,
+	}
+	if err := window.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.AddProjectFreezeWindow(c.Request.Context(),
+		project.ID, window); err != nil {
+		logger.Error().Err(err).Msg("Failed to add freeze window")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to add freeze window"})
+		return
+	}
+
+	updated, err := database.GetProjectByID(c.Request.Context(), project.ID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to reload project")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to add freeze window"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"freeze_windows": updated.FreezeWindows,
+	})
+}
+
+// Remove a freeze window by its index
+// DELETE /api/projects/:id/freeze-windows/:index
+func (h *Handlers) HandleDeleteFreezeWindow(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	projectID := c.Param("id")
+	project, err := database.GetProjectByID(c.Request.Context(), projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil || index < 0 || index >= len(project.FreezeWindows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Freeze window not found"})
+		return
+	}
+
+	if err := database.RemoveProjectFreezeWindow(c.Request.Context(),
+		project.ID, index); err != nil {
+		logger.Error().Err(err).Msg("Failed to remove freeze window")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to remove freeze window"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Freeze window removed"})
+}
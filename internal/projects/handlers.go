@@ -1,18 +1,38 @@
 package projects
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"path"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/Sys-Redux/rcnbuild-paas/internal/auth"
 	"github.com/Sys-Redux/rcnbuild-paas/internal/builds"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/containers"
 	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
 	"github.com/Sys-Redux/rcnbuild-paas/internal/github"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
 	"github.com/Sys-Redux/rcnbuild-paas/pkg/crypto"
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
 )
 
 // Holds dependencies for project handlers
@@ -23,36 +43,171 @@ func NewHandlers() *Handlers {
 	return &Handlers{}
 }
 
+// Slugs that conflict with platform subdomains
+// Extended at runtime with RESERVED_SLUGS (comma-separated)
+var (
+	reservedSlugs     map[string]struct{}
+	reservedSlugsOnce sync.Once
+)
+
+var defaultReservedSlugs = []string{
+	"api", "www", "mail", "admin", "static", "health", "metrics",
+	"app", "dashboard", "docs", "status", "traefik", "registry",
+	"webhooks", "auth", "login", "smtp", "ftp", "cdn", "assets",
+}
+
+// Short-lived cache of runtime detection results
+// Saves GitHub Contents API calls while the create form is being edited
+const detectRuntimeTTL = 60 * time.Second
+
+type detectRuntimeEntry struct {
+	info      *builds.RuntimeInfo
+	expiresAt time.Time
+}
+
+var (
+	detectRuntimeCache   = make(map[string]detectRuntimeEntry)
+	detectRuntimeCacheMu sync.Mutex
+)
+
+// Matches DNS-label-safe slugs: lowercase alphanumerics & inner hyphens
+var slugPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
 // Query parms for listing repos
 type ListReposRequest struct {
-	Page     int `form:"page"`
-	PageSize int `form:"page_size"`
+	Org      string `form:"org"` // Blank lists the user's own repos
+	Query    string `form:"q"`
+	Page     int    `form:"page"`
+	PageSize int    `form:"page_size"`
+}
+
+// Query params for listing projects
+type ListProjectsRequest struct {
+	Tag    string `form:"tags"`
+	Limit  int    `form:"limit,default=20" binding:"min=1,max=100"`
+	Offset int    `form:"offset" binding:"min=0"`
+}
+
+// Query params for runtime detection
+type DetectRuntimeRequest struct {
+	Branch        string `form:"branch"`
+	RootDirectory string `form:"root_directory"`
+}
+
+// Query params for listing a repo's commits
+type ListCommitsRequest struct {
+	Branch string `form:"branch"`
+	Limit  int    `form:"limit,default=20" binding:"min=1,max=100"`
 }
 
 // Body for creating a new project
 type CreateProjectRequest struct {
-	RepoFullName  string  `json:"repo_full_name" binding:"required"`
-	Name          string  `json:"name"`
-	Slug          string  `json:"slug"`
-	Branch        string  `json:"branch"`
-	RootDirectory string  `json:"root_directory"`
-	BuildCommand  *string `json:"build_command"`
-	StartCommand  *string `json:"start_command"`
-	Port          int     `json:"port"`
+	RepoFullName  string                 `json:"repo_full_name" binding:"required"`
+	Name          string                 `json:"name"`
+	Slug          string                 `json:"slug"`
+	Branch        string                 `json:"branch"`
+	RootDirectory string                 `json:"root_directory"`
+	BuildContext  string                 `json:"build_context"` // Defaults to root_directory
+	BuildCommand  *string                `json:"build_command"`
+	StartCommand  *string                `json:"start_command"`
+	Port          int                    `json:"port"`
+	Environment   string                 `json:"environment"`
+	Tags          []string               `json:"tags"`
+	BuildEnvVars  map[string]string      `json:"build_env_vars"`
+	BuildSecrets  []database.BuildSecret `json:"build_secrets"`
+	// Initialize git submodules when cloning (off by default, slower)
+	SubmodulesEnabled bool `json:"submodules_enabled"`
+	// Deploy a preview for each PR against the project's branch
+	WatchPullRequests bool    `json:"watch_pull_requests"`
+	BaseDomain        *string `json:"base_domain"` // Defaults to BASE_DOMAIN
+	DisplayName       *string `json:"display_name"`
+	Description       *string `json:"description"`
+	// Run in each new container (sh -c); the deploy fails unless it exits
+	// 0 within the timeout (default 60s)
+	ReadinessProbeCmd            *string `json:"readiness_probe_cmd" binding:"omitempty,max=1000"`
+	ReadinessProbeTimeoutSeconds int     `json:"readiness_probe_timeout_seconds" binding:"omitempty,min=1,max=600"`
 }
 
 // Body for updating a project
 type UpdateProjectRequest struct {
-	Name          *string `json:"name"`
-	Branch        *string `json:"branch"`
-	RootDirectory *string `json:"root_directory"`
-	BuildCommand  *string `json:"build_command"`
-	StartCommand  *string `json:"start_command"`
-	Port          *int    `json:"port"`
+	Name              *string                `json:"name"`
+	Branch            *string                `json:"branch"`
+	RootDirectory     *string                `json:"root_directory"`
+	BuildContext      *string                `json:"build_context"` // "" resets
+	BuildCommand      *string                `json:"build_command"`
+	StartCommand      *string                `json:"start_command"`
+	Port              *int                   `json:"port"`
+	Tags              []string               `json:"tags"`
+	DeployStrategy    *string                `json:"deploy_strategy"`
+	BuildEnvVars      map[string]string      `json:"build_env_vars"`
+	BuildSecrets      []database.BuildSecret `json:"build_secrets"`
+	SubmodulesEnabled *bool                  `json:"submodules_enabled"`
+	WatchPullRequests *bool                  `json:"watch_pull_requests"`
+	BaseDomain        *string                `json:"base_domain"` // "" clears
+	Runtime           *string                `json:"runtime"`
+	ReloadSignal      *string                `json:"reload_signal"` // "" clears
+	// "" clears the probe
+	ReadinessProbeCmd            *string `json:"readiness_probe_cmd" binding:"omitempty,max=1000"`
+	ReadinessProbeTimeoutSeconds *int    `json:"readiness_probe_timeout_seconds" binding:"omitempty,min=1,max=600"`
+	// null or "" clears it; left out, it's unchanged
+	DisplayName ClearableString `json:"display_name" swaggertype:"string"`
+	// null or "" clears it; left out, it's unchanged
+	Description ClearableString `json:"description" swaggertype:"string"`
+	// Never changeable; rejected with 422 if it differs from the project
+	Slug *string `json:"slug"`
+	// Moves the project to another repo (webhook & deploy key included)
+	RepoFullName *string `json:"repo_full_name"`
+	// Confirms breaking changes to a project that is already live
+	Force bool `json:"force"`
+}
+
+// A string field of an update body that can be left out (unchanged), or
+// cleared with null as well as ""
+type ClearableString struct {
+	Set   bool
+	Value *string // nil when cleared with null
+}
+
+func (s *ClearableString) UnmarshalJSON(data []byte) error {
+	s.Set = true
+	if string(data) == "null" {
+		s.Value = nil
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	s.Value = &value
+	return nil
+}
+
+// The value for an update input: nil leaves the field alone & "" clears it
+func (s ClearableString) Update() *string {
+	if !s.Set {
+		return nil
+	}
+	if s.Value == nil {
+		empty := ""
+		return &empty
+	}
+	return s.Value
 }
 
 // Lists repos the user can deploy
 // GET /api/repos
+// @Summary List deployable repos
+// @Tags repos
+// @Produce json
+// @Param q query string false "Search by name"
+// @Param org query string false "Organization login (defaults to own repos)"
+// @Param page query int false "Page number"
+// @Param page_size query int false "Results per page"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /repos [get]
 func (h *Handlers) HandleListRepos(c *gin.Context) {
 	user := auth.GetCurrentUser(c)
 	if user == nil {
@@ -67,58 +222,354 @@ func (h *Handlers) HandleListRepos(c *gin.Context) {
 		return
 	}
 
+	listRepos(c, user, &req)
+}
+
+// Writes a page of repos (searched when req.Query is set) from the user's
+// own account or req.Org, in the same response shape for both
+// Projects deploy from GitHub, so GitLab-only users are asked to link it
+func listRepos(c *gin.Context, user *database.User, req *ListReposRequest) {
+	logger := middleware.Logger(c)
+
+	if user.GitHubUsername == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":    "link a GitHub account to list repositories",
+			"link_url": "/api/auth/github/link",
+		})
+		return
+	}
+
 	// Get user's access token
 	accessToken, err := database.GetUserAccessToken(c.Request.Context(),
 		user.ID)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to get user access token")
+		logger.Error().Err(err).Msg("Failed to get user access token")
 		c.JSON(http.StatusInternalServerError,
 			gin.H{"error": "failed to get user access token"})
 		return
 	}
-
-	// Create GitHub client & list repos
 	ghClient := github.NewClient(accessToken)
-	repos, err := ghClient.ListUserRepos(c.Request.Context(),
-		req.Page, req.PageSize)
+
+	owner := *user.GitHubUsername
+	if req.Org != "" {
+		owner = req.Org
+	}
+
+	// Search by name when a query is given
+	if req.Query != "" {
+		repos, total, err := ghClient.SearchUserRepos(c.Request.Context(),
+			owner, req.Query, req.Page, req.PageSize)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to search repos")
+			c.JSON(http.StatusInternalServerError,
+				gin.H{"error": "failed to search repos"})
+			return
+		}
+
+		c.JSON(http.StatusOK, withRateLimit(gin.H{
+			"repos":       repos,
+			"page":        req.Page,
+			"total_count": total,
+		}, ghClient))
+		return
+	}
+
+	// Otherwise list repos
+	var repos []*github.Repository
+	if req.Org != "" {
+		repos, err = ghClient.ListOrgRepos(c.Request.Context(), req.Org,
+			req.Page, req.PageSize)
+	} else {
+		repos, err = ghClient.ListUserRepos(c.Request.Context(),
+			req.Page, req.PageSize)
+	}
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to list user repos")
+		logger.Error().Err(err).Str("org", req.Org).
+			Msg("Failed to list repos")
 		c.JSON(http.StatusInternalServerError,
-			gin.H{"error": "failed to list user repos"})
+			gin.H{"error": "failed to list repos"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	c.JSON(http.StatusOK, withRateLimit(gin.H{
 		"repos": repos,
 		"page":  req.Page,
-	})
+	}, ghClient))
+}
+
+// GitHub calls left below which responses warn the frontend to throttle
+const lowRateLimitRemaining = 10
+
+// Adds rate_limit_remaining to a response when the user is close to
+// GitHub's rate limit
+func withRateLimit(resp gin.H, ghClient *github.Client) gin.H {
+	info := ghClient.RateLimitInfo()
+	if info.Limit > 0 && info.Remaining < lowRateLimitRemaining {
+		resp["rate_limit_remaining"] = info.Remaining
+	}
+	return resp
+}
+
+// Previews the detected runtime & commands for a repo
+// GET /api/repos/:owner/:repo/detect-runtime
+// @Summary Detect a repo's runtime
+// @Tags repos
+// @Produce json
+// @Param owner path string true "Repo owner"
+// @Param repo path string true "Repo name"
+// @Param branch query string false "Branch (defaults to the repo default)"
+// @Param root_directory query string false "Subdirectory to inspect"
+// @Success 200 {object} builds.RuntimeInfo
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /repos/{owner}/{repo}/detect-runtime [get]
+func (h *Handlers) HandleDetectRuntime(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req DetectRuntimeRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	owner := c.Param("owner")
+	repoName := c.Param("repo")
+
+	rootDir := req.RootDirectory
+	if rootDir == "" {
+		rootDir = "."
+	}
+
+	cacheKey := runtimeCacheKey(user.ID, owner, repoName, req.Branch, rootDir)
+	if info := getCachedRuntime(cacheKey); info != nil {
+		c.JSON(http.StatusOK, info)
+		return
+	}
+
+	accessToken, err := database.GetUserAccessToken(c.Request.Context(),
+		user.ID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get user access token")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "failed to get user access token"})
+		return
+	}
+
+	ghClient := github.NewClient(accessToken)
+
+	// Fall back to the repo's default branch
+	branch := req.Branch
+	if branch == "" {
+		repo, err := ghClient.GetRepo(c.Request.Context(), owner, repoName)
+		if err != nil {
+			logger.Error().Err(err).Str("repo", owner+"/"+repoName).Msg(
+				"Failed to get github repo")
+			c.JSON(http.StatusBadRequest,
+				gin.H{"error": "failed to access github repo"})
+			return
+		}
+		branch = repo.DefaultBranch
+	}
+
+	info, err := builds.DetectRuntime(c.Request.Context(), ghClient, owner,
+		repoName, branch, rootDir)
+	if err != nil {
+		logger.Error().Err(err).Str("repo", owner+"/"+repoName).Msg(
+			"Failed to detect runtime")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "failed to detect runtime"})
+		return
+	}
+
+	setCachedRuntime(cacheKey, info)
+	c.JSON(http.StatusOK, info)
+}
+
+// Longest commit message returned by HandleListCommits
+const maxCommitMessageLength = 72
+
+// Lists recent commits on a branch for the redeploy commit picker
+// GET /api/repos/:owner/:repo/commits
+// @Summary List recent commits on a branch
+// @Tags repos
+// @Produce json
+// @Param owner path string true "Repo owner"
+// @Param repo path string true "Repo name"
+// @Param branch query string false "Branch (defaults to the repo default)"
+// @Param limit query int false "Max commits (1-100)" default(20)
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /repos/{owner}/{repo}/commits [get]
+func (h *Handlers) HandleListCommits(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req ListCommitsRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	owner := c.Param("owner")
+	repoName := c.Param("repo")
+
+	accessToken, err := database.GetUserAccessToken(c.Request.Context(),
+		user.ID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get user access token")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "failed to get user access token"})
+		return
+	}
+
+	ghClient := github.NewClient(accessToken)
+
+	// Same access rule as the repo list: push or admin only
+	repo, err := ghClient.GetRepo(c.Request.Context(), owner, repoName)
+	if err != nil {
+		logger.Error().Err(err).Str("repo", owner+"/"+repoName).Msg(
+			"Failed to get github repo")
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "failed to access github repo"})
+		return
+	}
+	if !repo.Permissions.Push && !repo.Permissions.Admin {
+		c.JSON(http.StatusForbidden,
+			gin.H{"error": "push access to repository required"})
+		return
+	}
+
+	branch := req.Branch
+	if branch == "" {
+		branch = repo.DefaultBranch
+	}
+
+	commits, err := ghClient.ListCommits(c.Request.Context(), owner,
+		repoName, branch, req.Limit)
+	if err != nil {
+		logger.Error().Err(err).Str("repo", owner+"/"+repoName).Msg(
+			"Failed to list commits")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "failed to list commits"})
+		return
+	}
+
+	for _, commit := range commits {
+		commit.Message = truncateMessage(commit.Message,
+			maxCommitMessageLength)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"branch": branch, "commits": commits})
+}
+
+// Shortens s to at most n runes, marking the cut with an ellipsis
+func truncateMessage(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
 }
 
 // Lists user's projects
 // GET /api/projects
+// @Summary List the user's projects
+// @Tags projects
+// @Produce json
+// @Param tags query string false "Filter by tag"
+// @Param limit query int false "Page size (1-100)" default(20)
+// @Param offset query int false "Projects to skip" default(0)
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /projects [get]
 func (h *Handlers) HandleListProjects(c *gin.Context) {
+	logger := middleware.Logger(c)
+
 	user := auth.GetCurrentUser(c)
 	if user == nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
 	}
 
-	projects, err := database.GetProjectsByUserID(c.Request.Context(), user.ID)
+	var req ListProjectsRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Optional tag filter
+	var tag *string
+	if req.Tag != "" {
+		tag = &req.Tag
+	}
+
+	projects, err := database.GetProjectsWithStatusByUserID(
+		c.Request.Context(), user.ID, tag, req.Limit, req.Offset)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get user projects")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "failed to get user projects"})
+		return
+	}
+
+	total, err := database.CountProjectsByUserIDAndTag(c.Request.Context(),
+		user.ID, tag)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to get user projects")
+		logger.Error().Err(err).Msg("Failed to count user projects")
 		c.JSON(http.StatusInternalServerError,
 			gin.H{"error": "failed to get user projects"})
 		return
 	}
 
+	// Keep the JSON an array even past the last page
+	if projects == nil {
+		projects = []*database.ProjectWithStatus{}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"projects": projects,
+		"total":    total,
+		"limit":    req.Limit,
+		"offset":   req.Offset,
+		"has_more": req.Offset+len(projects) < total,
 	})
 }
 
 // Create a new project from a github repo
 // POST /api/projects
+// @Summary Create a project from a GitHub repo
+// @Tags projects
+// @Accept json
+// @Produce json
+// @Param body body CreateProjectRequest true "Project settings"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 422 {object} map[string]interface{}
+// @Failure 500 {object} map[string]string
+// @Router /projects [post]
 func (h *Handlers) HandleCreateProject(c *gin.Context) {
+	logger := middleware.Logger(c)
+
 	user := auth.GetCurrentUser(c)
 	if user == nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
@@ -127,48 +578,76 @@ func (h *Handlers) HandleCreateProject(c *gin.Context) {
 
 	var req CreateProjectRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest,
-			gin.H{"error": err.Error()})
+		middleware.RespondBodyError(c, err)
 		return
 	}
 
-	// Parse repo full name
-	owner, repoName, err := github.ParseRepoFullName(req.RepoFullName)
-	if err != nil {
-		c.JSON(http.StatusBadRequest,
-			gin.H{"error": "invalid repo full name"})
+	if !withinProjectQuota(c, user) {
 		return
 	}
 
-	// Get user's access token
-	accessToken, err := database.GetUserAccessToken(c.Request.Context(),
-		user.ID)
+	ghClient, owner, repoName, repo, ok := newProjectRepo(c, user,
+		req.RepoFullName)
+	if !ok {
+		return
+	}
+
+	// Default to production environment
+	environment := req.Environment
+	if environment == "" {
+		environment = database.EnvironmentProduction
+	}
+	if !database.IsValidEnvironment(environment) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "environment must be production, staging, or preview",
+		})
+		return
+	}
+
+	// Check if exists already (one project per repo & environment)
+	existing, _ := database.GetProjectByRepoFullName(c.Request.Context(),
+		req.RepoFullName, environment)
+	if existing != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "project for this repo & environment already exists",
+		})
+		return
+	}
+
+	tags, err := normalizeTags(req.Tags)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to get user access token")
-		c.JSON(http.StatusInternalServerError,
-			gin.H{"error": "failed to get user access token"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Create github client
-	ghClient := github.NewClient(accessToken)
+	if err := validateBuildEnvVars(req.BuildEnvVars); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	// Verify repo exists & user has permissions
-	repo, err := ghClient.GetRepo(c.Request.Context(), owner, repoName)
+	if err := validateBuildSecrets(req.BuildSecrets); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := validateDisplayFields(req.DisplayName,
+		req.Description); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	baseDomain, err := normalizeBaseDomain(req.BaseDomain)
 	if err != nil {
-		log.Error().Err(err).Str("repo", req.RepoFullName).Msg(
-			"Failed to get github repo")
-		c.JSON(http.StatusBadRequest,
-			gin.H{"error": "failed to access github repo"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if baseDomain != nil && *baseDomain == "" {
+		baseDomain = nil
+	}
 
-	// Check if exists already
-	existing, _ := database.GetProjectByRepoFullName(c.Request.Context(),
-		req.RepoFullName)
-	if existing != nil {
-		c.JSON(http.StatusBadRequest,
-			gin.H{"error": "project for this repo already exists"})
+	buildContext, err := normalizeBuildContext(req.BuildContext)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -188,32 +667,54 @@ func (h *Handlers) HandleCreateProject(c *gin.Context) {
 		rootDir = "."
 	}
 
-	// Generate slug
+	// Generate slug (user-provided slugs must already be valid)
 	slug := req.Slug
+	if slug != "" && !isValidSlug(slug) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "slug must be lowercase letters, numbers, and hyphens",
+		})
+		return
+	}
 	if slug == "" {
-		slug = generateSlug(projectName)
+		slug = generateSlug(projectName, repo.Name)
 	}
 
-	// Ensure slug is unique
-	for {
-		exists, _ := database.SlugExists(c.Request.Context(), slug)
-		if !exists {
-			break
-		}
-		slug = slug + "-" + randomSuffix()
+	// Reserved words would clash with platform subdomains
+	if isReservedSlug(slug) {
+		c.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": "slug '" + slug + "' is reserved"})
+		return
 	}
 
+	// Ensure slug is unique
+	slug = uniqueSlug(c.Request.Context(), slug)
+
 	// Detect runtime
 	runtimeInfo, err := builds.DetectRuntime(c.Request.Context(),
 		ghClient, owner, repoName, branch, rootDir)
 	if err != nil {
-		log.Warn().Err(err).Msg("Failed to detect runtime, using defaults")
+		logger.Warn().Err(err).Msg("Failed to detect runtime, using defaults")
 		runtimeInfo = &builds.RuntimeInfo{
 			Runtime: builds.RuntimeUnknown,
 			Port:    3000,
 		}
 	}
 
+	// A compose file means several services; refuse rather than deploy
+	// just one of them
+	if runtimeInfo.Runtime == builds.RuntimeDockerCompose {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":        runtimeInfo.WarningMessage,
+			"runtime_info": runtimeInfo,
+		})
+		return
+	}
+
+	// Monorepo: use the detected app directory if none was given
+	if req.RootDirectory == "" && runtimeInfo.DetectedRootDir != "" {
+		rootDir = runtimeInfo.DetectedRootDir
+	}
+
 	// Use detected values or user-provided overrides
 	buildCmd := req.BuildCommand
 	if buildCmd == nil && runtimeInfo.BuildCommand != "" {
@@ -231,76 +732,77 @@ func (h *Handlers) HandleCreateProject(c *gin.Context) {
 	}
 
 	runtime := string(runtimeInfo.Runtime)
-
-	// Generate webhook secret
-	webhookSecret, err := github.GenerateWebhookSecret()
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to generate webhook secret")
-		c.JSON(http.StatusInternalServerError,
-			gin.H{"error": "failed to generate webhook secret"})
+	if err := checkBuildContext(runtime, rootDir,
+		buildContext); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Create webhook on github
-	webhookURL := os.Getenv("API_URL") + "/api/webhooks/github"
-	webhook, err := ghClient.CreateWebhook(c.Request.Context(),
-		owner, repoName, webhookURL, webhookSecret)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to create github webhook")
-		// Continue anyway, webhook can be created later
-	}
-
 	// Create project in database
 	input := &database.CreateProjectInput{
-		UserId:        user.ID,
-		Name:          projectName,
-		Slug:          slug,
-		RepoFullName:  req.RepoFullName,
-		RepoURL:       repo.HTMLURL,
-		Branch:        branch,
-		RootDirectory: rootDir,
-		BuildCommand:  buildCmd,
-		StartCommand:  startCmd,
-		Runtime:       &runtime,
-		Port:          port,
-	}
-
-	project, err := database.CreateProject(c.Request.Context(), input)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to create project in database")
+		UserId:            user.ID,
+		Name:              projectName,
+		Slug:              slug,
+		RepoFullName:      req.RepoFullName,
+		RepoURL:           repo.HTMLURL,
+		Branch:            branch,
+		RootDirectory:     rootDir,
+		BuildContext:      buildContext,
+		BuildCommand:      buildCmd,
+		StartCommand:      startCmd,
+		Runtime:           &runtime,
+		Port:              port,
+		Environment:       environment,
+		Tags:              tags,
+		BuildEnvVars:      req.BuildEnvVars,
+		BuildSecrets:      req.BuildSecrets,
+		SubmodulesEnabled: req.SubmodulesEnabled,
+		WatchPullRequests: req.WatchPullRequests,
+		BaseDomain:        baseDomain,
+		DisplayName:       req.DisplayName,
+		Description:       req.Description,
+		ReadinessProbeCmd: req.ReadinessProbeCmd,
+		// 0 uses the default (60s)
+		ReadinessProbeTimeoutSeconds: req.ReadinessProbeTimeoutSeconds,
+	}
+
+	project, _, err := createProjectRecord(c.Request.Context(), logger,
+		ghClient, owner, repoName, input)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create project in database")
 		c.JSON(http.StatusInternalServerError,
 			gin.H{"error": "failed to create project"})
 		return
 	}
 
-	// Store webhook info
-	if webhook != nil {
-		// Encrypt webhook secret
-		encryptedSecret, err := crypto.Encrypt(webhookSecret)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to encrypt webhook secret")
-		} else {
-			if err := database.SetProjectWebhook(c.Request.Context(),
-				project.ID, webhook.ID, encryptedSecret); err != nil {
-				log.Error().Err(err).Msg("Failed to store webhook info")
-			}
-		}
-	}
+	// Add a read-only deploy key so the worker can clone over SSH
+	// (keeps builds working if the user's OAuth token is rotated)
+	setupDeployKey(c.Request.Context(), ghClient, owner, repoName, project)
 
-	log.Info().
+	logger.Info().
 		Str("project_id", project.ID).
 		Str("repo", req.RepoFullName).
 		Str("runtime", runtime).
+		Str("environment", environment).
 		Msg("Created new project")
 
-	c.JSON(http.StatusCreated, gin.H{
+	c.JSON(http.StatusCreated, withRateLimit(gin.H{
 		"project":      project,
 		"runtime_info": runtimeInfo,
-	})
+	}, ghClient))
 }
 
 // Returns a specific project
 // GET /api/projects/:id
+// @Summary Get a project
+// @Tags projects
+// @Produce json
+// @Param id path string true "Project ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /projects/{id} [get]
 func (h *Handlers) HandleGetProject(c *gin.Context) {
 	user := auth.GetCurrentUser(c)
 	if user == nil {
@@ -332,7 +834,24 @@ func (h *Handlers) HandleGetProject(c *gin.Context) {
 
 // Update project settings
 // PATCH /api/projects/:id
+// @Summary Update project settings
+// @Tags projects
+// @Accept json
+// @Produce json
+// @Param id path string true "Project ID"
+// @Param body body UpdateProjectRequest true "Fields to change"
+// @Success 200 {object} UpdateProjectResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]interface{}
+// @Failure 422 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /projects/{id} [patch]
 func (h *Handlers) HandleUpdateProject(c *gin.Context) {
+	logger := middleware.Logger(c)
+
 	user := auth.GetCurrentUser(c)
 	if user == nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
@@ -354,33 +873,432 @@ func (h *Handlers) HandleUpdateProject(c *gin.Context) {
 
 	var req UpdateProjectRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		middleware.RespondBodyError(c, err)
 		return
 	}
 
-	// Build update input
-	updateInput := &database.UpdateProjectInput{
-		Name:          req.Name,
-		Branch:        req.Branch,
-		RootDirectory: req.RootDirectory,
-		BuildCommand:  req.BuildCommand,
-		StartCommand:  req.StartCommand,
-		Port:          req.Port,
+	// Tags are replaced wholesale when provided
+	var tags []string
+	if req.Tags != nil {
+		tags, err = normalizeTags(req.Tags)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 	}
 
-	updatedProject, err := database.UpdateProject(c.Request.Context(), projectID, updateInput)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to update project")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update project"})
+	// Build env vars are replaced wholesale when provided
+	if err := validateBuildEnvVars(req.BuildEnvVars); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, updatedProject)
-}
+	if err := validateBuildSecrets(req.BuildSecrets); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	displayName, description := req.DisplayName.Update(),
+		req.Description.Update()
+	if err := validateDisplayFields(displayName,
+		description); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	baseDomain, err := normalizeBaseDomain(req.BaseDomain)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.BaseDomain = baseDomain
+
+	if req.BuildContext != nil {
+		buildContext, err := normalizeBuildContext(*req.BuildContext)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		req.BuildContext = &buildContext
+	}
+
+	if req.ReloadSignal != nil && *req.ReloadSignal != "" {
+		signal, err := containers.NormalizeReloadSignal(*req.ReloadSignal)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req.ReloadSignal = &signal
+	}
+
+	if req.DeployStrategy != nil &&
+		!database.IsValidDeployStrategy(*req.DeployStrategy) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "deploy_strategy must be recreate or blue_green",
+		})
+		return
+	}
+
+	if req.Runtime != nil {
+		switch builds.Runtime(*req.Runtime) {
+		case builds.RuntimeNodeJS, builds.RuntimePython, builds.RuntimeGo,
+			builds.RuntimeStatic, builds.RuntimeDocker, builds.RuntimeElixir:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid runtime"})
+			return
+		}
+	}
+
+	// Checked against the settings as they'll be after the update
+	runtime := stringOrEmpty(project.Runtime)
+	if req.Runtime != nil {
+		runtime = *req.Runtime
+	}
+	rootDir := project.RootDirectory
+	if req.RootDirectory != nil {
+		rootDir = *req.RootDirectory
+	}
+	buildContext := project.BuildContext
+	if req.BuildContext != nil {
+		buildContext = *req.BuildContext
+	}
+	if err := checkBuildContext(runtime, rootDir,
+		buildContext); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The slug is baked into the subdomain & container name
+	if req.Slug != nil && *req.Slug != project.Slug {
+		c.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": "slug cannot be changed"})
+		return
+	}
+
+	// Once live, switching repo, branch or runtime needs explicit
+	// confirmation
+	if changed := breakingChanges(project, &req); len(changed) > 0 &&
+		!req.Force {
+		if _, err := database.GetLiveDeployment(c.Request.Context(),
+			project.ID); err == nil {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":  "project is live, set force to confirm changes",
+				"fields": changed,
+			})
+			return
+		}
+	}
+
+	if req.RepoFullName != nil && !strings.EqualFold(*req.RepoFullName,
+		project.RepoFullName) {
+		if !moveProjectRepo(c, user, project, *req.RepoFullName) {
+			return
+		}
+	}
+
+	// Build update input
+	updateInput := &database.UpdateProjectInput{
+		Name:              req.Name,
+		Branch:            req.Branch,
+		RootDirectory:     req.RootDirectory,
+		BuildContext:      req.BuildContext,
+		BuildCommand:      req.BuildCommand,
+		StartCommand:      req.StartCommand,
+		Port:              req.Port,
+		Runtime:           req.Runtime,
+		Tags:              tags,
+		DeployStrategy:    req.DeployStrategy,
+		BuildEnvVars:      req.BuildEnvVars,
+		BuildSecrets:      req.BuildSecrets,
+		SubmodulesEnabled: req.SubmodulesEnabled,
+		WatchPullRequests: req.WatchPullRequests,
+		BaseDomain:        baseDomain,
+		DisplayName:       displayName,
+		Description:       description,
+		ReloadSignal:      req.ReloadSignal,
+		ReadinessProbeCmd: req.ReadinessProbeCmd,
+		// nil leaves the timeout unchanged
+		ReadinessProbeTimeoutSeconds: req.ReadinessProbeTimeoutSeconds,
+	}
+
+	updatedProject, err := database.UpdateProject(c.Request.Context(), projectID, updateInput)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to update project")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update project"})
+		return
+	}
+
+	resp := &UpdateProjectResponse{Project: updatedProject}
+
+	// The new branch may use another framework; best effort, the update
+	// itself has already succeeded
+	if updatedProject.Branch != project.Branch {
+		refreshed, info, err := redetectRuntime(c.Request.Context(), user,
+			project, updatedProject, &req)
+		if err != nil {
+			logger.Warn().Err(err).Str("project_id", projectID).
+				Msg("Failed to re-detect runtime after branch change")
+		} else if info != nil {
+			resp.Project = refreshed
+			resp.RuntimeUpdated = true
+			resp.RuntimeInfo = info
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// Project settings after an update
+// RuntimeUpdated is set when a branch change brought new detected commands
+type UpdateProjectResponse struct {
+	*database.Project
+	RuntimeUpdated bool                `json:"runtime_updated,omitempty"`
+	RuntimeInfo    *builds.RuntimeInfo `json:"runtime_info,omitempty"`
+}
+
+// Detects the runtime on after's branch and applies it to the runtime &
+// commands that still hold what detection found on before's branch (i.e.
+// weren't overridden) and weren't set in req. Returns a nil RuntimeInfo
+// when nothing needed changing
+func redetectRuntime(ctx context.Context, user *database.User, before,
+	after *database.Project, req *UpdateProjectRequest) (*database.Project,
+	*builds.RuntimeInfo, error) {
+	owner, repoName, err := github.ParseRepoFullName(after.RepoFullName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	accessToken, err := database.GetUserAccessToken(ctx, user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	ghClient := github.NewClient(accessToken)
+
+	oldInfo, err := detectRuntimeCached(ctx, ghClient, user.ID, owner,
+		repoName, before.Branch, before.RootDirectory)
+	if err != nil {
+		return nil, nil, err
+	}
+	newInfo, err := detectRuntimeCached(ctx, ghClient, user.ID, owner,
+		repoName, after.Branch, after.RootDirectory)
+	if err != nil {
+		return nil, nil, err
+	}
+	if newInfo.Runtime == builds.RuntimeUnknown ||
+		newInfo.Runtime == builds.RuntimeDockerCompose {
+		return nil, nil, nil
+	}
+
+	// Replace a field only if it still holds the old detected value
+	refresh := func(requested, current *string, detected,
+		next string) *string {
+		if requested != nil || next == detected {
+			return nil
+		}
+		if current != nil && *current != detected {
+			return nil
+		}
+		if current == nil && detected != "" {
+			return nil
+		}
+		return &next
+	}
+
+	input := &database.UpdateProjectInput{
+		Runtime: refresh(req.Runtime, before.Runtime,
+			string(oldInfo.Runtime), string(newInfo.Runtime)),
+		BuildCommand: refresh(req.BuildCommand, before.BuildCommand,
+			oldInfo.BuildCommand, newInfo.BuildCommand),
+		StartCommand: refresh(req.StartCommand, before.StartCommand,
+			oldInfo.StartCommand, newInfo.StartCommand),
+	}
+	if input.Runtime == nil && input.BuildCommand == nil &&
+		input.StartCommand == nil {
+		return nil, nil, nil
+	}
+
+	refreshed, err := database.UpdateProject(ctx, after.ID, input)
+	if err != nil {
+		return nil, nil, err
+	}
+	return refreshed, newInfo, nil
+}
+
+// Most build env vars a project can have
+const maxBuildEnvVars = 50
+
+// Checks build env var keys follow the runtime env var rules & values fit
+// on a single Dockerfile line
+func validateBuildEnvVars(vars map[string]string) error {
+	if len(vars) > maxBuildEnvVars {
+		return fmt.Errorf("at most %d build env vars allowed",
+			maxBuildEnvVars)
+	}
+	for key, value := range vars {
+		if !isValidEnvKey(key) {
+			return fmt.Errorf("invalid build env var key: %q", key)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("build env var %s must not contain newlines",
+				key)
+		}
+	}
+	return nil
+}
+
+// Most build secrets a project can have
+const maxBuildSecrets = 20
+
+// BuildKit secret IDs; also used as the /run/secrets/<id> file name
+var buildSecretKeyPattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+// Checks build secret ids are unique & usable and each names an env var
+// The env var itself is looked up at build time
+func validateBuildSecrets(secrets []database.BuildSecret) error {
+	if len(secrets) > maxBuildSecrets {
+		return fmt.Errorf("at most %d build secrets allowed",
+			maxBuildSecrets)
+	}
+	seen := make(map[string]bool, len(secrets))
+	for _, secret := range secrets {
+		if !buildSecretKeyPattern.MatchString(secret.Key) {
+			return fmt.Errorf("invalid build secret key: %q", secret.Key)
+		}
+		if seen[secret.Key] {
+			return fmt.Errorf("duplicate build secret key: %q", secret.Key)
+		}
+		seen[secret.Key] = true
+		if !isValidEnvKey(secret.EnvVarName) {
+			return fmt.Errorf("invalid env var name for build secret %s",
+				secret.Key)
+		}
+	}
+	return nil
+}
+
+// Length limits for the free-text project fields (in characters)
+const (
+	maxDisplayNameLength = 100
+	maxDescriptionLength = 500
+)
+
+// Checks display name & description fit their columns
+func validateDisplayFields(displayName, description *string) error {
+	if displayName != nil &&
+		utf8.RuneCountInString(*displayName) > maxDisplayNameLength {
+		return fmt.Errorf("display_name must be at most %d characters",
+			maxDisplayNameLength)
+	}
+	if description != nil &&
+		utf8.RuneCountInString(*description) > maxDescriptionLength {
+		return fmt.Errorf("description must be at most %d characters",
+			maxDescriptionLength)
+	}
+	return nil
+}
+
+// Returns the fields in req that would change how a live project builds
+func breakingChanges(project *database.Project,
+	req *UpdateProjectRequest) []string {
+	var changed []string
+	if req.RepoFullName != nil && !strings.EqualFold(*req.RepoFullName,
+		project.RepoFullName) {
+		changed = append(changed, "repo_full_name")
+	}
+	if req.Branch != nil && *req.Branch != project.Branch {
+		changed = append(changed, "branch")
+	}
+	if req.Runtime != nil &&
+		(project.Runtime == nil || *req.Runtime != *project.Runtime) {
+		changed = append(changed, "runtime")
+	}
+	if req.BaseDomain != nil &&
+		*req.BaseDomain != stringOrEmpty(project.BaseDomain) {
+		changed = append(changed, "base_domain")
+	}
+	return changed
+}
+
+// Custom base domains: dot-separated DNS labels ending in a TLD
+var baseDomainPattern = regexp.MustCompile(
+	`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// Lowercases & validates a requested base domain, which must resolve
+// Nil & "" (clears the domain on update) pass through unchanged
+func normalizeBaseDomain(domain *string) (*string, error) {
+	if domain == nil || *domain == "" {
+		return domain, nil
+	}
+
+	d := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(*domain)), ".")
+	if len(d) > 253 || !baseDomainPattern.MatchString(d) {
+		return nil, fmt.Errorf("invalid base domain: %q", *domain)
+	}
+	if _, err := net.LookupHost(d); err != nil {
+		return nil, fmt.Errorf("base domain %s does not resolve", d)
+	}
+	return &d, nil
+}
+
+// Cleans a build context directory, which must stay inside the repo
+// "" (use the root directory) passes through unchanged. Symlinks can only
+// be checked once the repo is cloned; the builder checks again then
+func normalizeBuildContext(dir string) (string, error) {
+	dir = strings.TrimSpace(dir)
+	if dir == "" {
+		return "", nil
+	}
+
+	cleaned := path.Clean(dir)
+	if path.IsAbs(cleaned) || cleaned == ".." ||
+		strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf(
+			"build_context must be a directory inside the repository: %q",
+			dir)
+	}
+	return cleaned, nil
+}
+
+// Rejects a build context other than the root directory unless the repo
+// has its own Dockerfile: generated ones COPY paths relative to the root
+// directory, so they'd build the wrong files
+func checkBuildContext(runtime, rootDir, buildContext string) error {
+	if buildContext == "" || runtime == string(builds.RuntimeDocker) {
+		return nil
+	}
+	if rootDir == "" {
+		rootDir = "."
+	}
+	if path.Clean(buildContext) == path.Clean(rootDir) {
+		return nil
+	}
+	return errors.New("build_context can only differ from root_directory " +
+		"when the repo has its own Dockerfile")
+}
+
+// Dereferences s, treating nil as ""
+func stringOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// Delete a project and its resources
+// DELETE /api/projects/:id
+// @Summary Delete a project
+// @Tags projects
+// @Produce json
+// @Param id path string true "Project ID"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /projects/{id} [delete]
+func (h *Handlers) HandleDeleteProject(c *gin.Context) {
+	logger := middleware.Logger(c)
 
-// Delete a project and its resources
-// DELETE /api/projects/:id
-func (h *Handlers) HandleDeleteProject(c *gin.Context) {
 	user := auth.GetCurrentUser(c)
 	if user == nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
@@ -408,30 +1326,34 @@ func (h *Handlers) HandleDeleteProject(c *gin.Context) {
 			if err == nil {
 				ghClient := github.NewClient(accessToken)
 				if err := ghClient.DeleteWebhook(c.Request.Context(), owner, repoName, *project.WebhookID); err != nil {
-					log.Warn().Err(err).Msg("Failed to delete GitHub webhook")
+					logger.Warn().Err(err).Msg("Failed to delete GitHub webhook")
 				}
 			}
 		}
 	}
 
-	// Delete all deployments
-	if err := database.DeleteDeploymentsByProjectID(c.Request.Context(), projectID); err != nil {
-		log.Error().Err(err).Msg("Failed to delete deployments")
-	}
-
-	// Delete all env vars
-	if err := database.DeleteAllEnvVar(c.Request.Context(), projectID); err != nil {
-		log.Error().Err(err).Msg("Failed to delete env vars")
+	// Delete deploy key from GitHub if it exists
+	if project.DeployKeyID != nil {
+		accessToken, err := database.GetUserAccessToken(c.Request.Context(), user.ID)
+		if err == nil {
+			owner, repoName, err := github.ParseRepoFullName(project.RepoFullName)
+			if err == nil {
+				ghClient := github.NewClient(accessToken)
+				if err := ghClient.DeleteDeployKey(c.Request.Context(), owner, repoName, *project.DeployKeyID); err != nil {
+					logger.Warn().Err(err).Msg("Failed to delete GitHub deploy key")
+				}
+			}
+		}
 	}
 
-	// Delete the project
-	if err := database.DeleteProject(c.Request.Context(), projectID); err != nil {
-		log.Error().Err(err).Msg("Failed to delete project")
+	// Delete the project, its deployments & env vars (single transaction)
+	if err := database.DeleteProjectWithData(c.Request.Context(), projectID); err != nil {
+		logger.Error().Err(err).Msg("Failed to delete project")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete project"})
 		return
 	}
 
-	log.Info().
+	logger.Info().
 		Str("project_id", projectID).
 		Str("repo", project.RepoFullName).
 		Msg("Project deleted successfully")
@@ -441,8 +1363,34 @@ func (h *Handlers) HandleDeleteProject(c *gin.Context) {
 	})
 }
 
+// Shortest generated slug used as-is; shorter ones get a random suffix
+const minSlugLength = 3
+
+// Strips accents so they survive slugging (é -> e, ü -> u)
+var stripAccents = transform.Chain(norm.NFD,
+	runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
 // Creates a URL-safe slug from a project name
-func generateSlug(name string) string {
+// Names with no Latin characters (e.g. Chinese, Arabic) fall back to the
+// repo name; slugs still too short get a random suffix
+func generateSlug(name, repoName string) string {
+	slug := slugify(name)
+	if slug == "" {
+		slug = slugify(repoName)
+	}
+	if len(slug) < minSlugLength {
+		slug = strings.Trim(slug+"-"+randomSuffix(6), "-")
+	}
+	return slug
+}
+
+// Lowercases, transliterates & strips a string down to [a-z0-9-]
+func slugify(name string) string {
+	// Transliterate accented Latin characters
+	if ascii, _, err := transform.String(stripAccents, name); err == nil {
+		name = ascii
+	}
+
 	// Convert to lowercase
 	slug := strings.ToLower(name)
 
@@ -463,18 +1411,364 @@ func generateSlug(name string) string {
 
 	// Limit length
 	if len(slug) > 50 {
-		slug = slug[:50]
+		slug = strings.TrimRight(slug[:50], "-")
 	}
 
 	return slug
 }
 
-// randomSuffix generates a short random suffix for slugs
-func randomSuffix() string {
+// Appends random suffixes to slug until no project uses it
+func uniqueSlug(ctx context.Context, slug string) string {
+	for {
+		exists, _ := database.SlugExists(ctx, slug)
+		if !exists {
+			return slug
+		}
+		slug = slug + "-" + randomSuffix(4)
+	}
+}
+
+// Generates an ed25519 SSH keypair for a deploy key
+// Returns the authorized_keys-format public key & PEM private key
+func generateDeployKey() (publicKey, privateKey string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return "", "", err
+	}
+
+	block, err := ssh.MarshalPrivateKey(priv, "rcnbuild deploy key")
+	if err != nil {
+		return "", "", err
+	}
+
+	publicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub)))
+	return publicKey, string(pem.EncodeToMemory(block)), nil
+}
+
+// Checks the user can create another project, responding if not
+func withinProjectQuota(c *gin.Context, user *database.User) bool {
+	projectCount, err := database.CountProjectsByUserID(c.Request.Context(),
+		user.ID)
+	if err != nil {
+		middleware.Logger(c).Error().Err(err).
+			Msg("Failed to count user projects")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "failed to create project"})
+		return false
+	}
+	if projectCount >= user.QuotaProjects {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": "project quota reached",
+			"limit": user.QuotaProjects,
+		})
+		return false
+	}
+	return true
+}
+
+// Looks up the repo a new project is for with the user's GitHub token,
+// which also checks they can access it. Responds & returns ok=false if not
+func newProjectRepo(c *gin.Context, user *database.User,
+	repoFullName string) (ghClient *github.Client, owner, repoName string,
+	repo *github.Repository, ok bool) {
+	logger := middleware.Logger(c)
+
+	owner, repoName, err := github.ParseRepoFullName(repoFullName)
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "invalid repo full name"})
+		return nil, "", "", nil, false
+	}
+
+	accessToken, err := database.GetUserAccessToken(c.Request.Context(),
+		user.ID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get user access token")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "failed to get user access token"})
+		return nil, "", "", nil, false
+	}
+	ghClient = github.NewClient(accessToken)
+
+	// Verify repo exists & user has permissions
+	repo, err = ghClient.GetRepo(c.Request.Context(), owner, repoName)
+	if err != nil {
+		logger.Error().Err(err).Str("repo", repoFullName).Msg(
+			"Failed to get github repo")
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "failed to access github repo"})
+		return nil, "", "", nil, false
+	}
+	return ghClient, owner, repoName, repo, true
+}
+
+// Creates the repo's GitHub webhook & then the project, storing the
+// webhook & its secret in the same transaction so the project is never
+// left without it. A webhook GitHub refuses is skipped (it can be added
+// later); if the secret can't be encrypted, nothing is created, and if
+// the project isn't created, the webhook is deleted again.
+// Returns the webhook (nil if none) so callers can undo it on rollback
+func createProjectRecord(ctx context.Context, logger *zerolog.Logger,
+	ghClient *github.Client, owner, repoName string,
+	input *database.CreateProjectInput) (*database.Project, *github.Webhook,
+	error) {
+	webhookSecret, err := github.GenerateWebhookSecret()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate webhook secret: %w",
+			err)
+	}
+
+	// Encrypt before creating the webhook: a project whose webhook secret
+	// can't be stored would get deliveries it can't verify
+	encryptedSecret, err := crypto.Encrypt(webhookSecret)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encrypt webhook secret: %w",
+			err)
+	}
+
+	webhook, err := ghClient.CreateWebhook(ctx, owner, repoName,
+		github.WebhookURL(), webhookSecret)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create github webhook")
+		// Continue anyway, webhook can be created later
+		webhook = nil
+	}
+
+	var project *database.Project
+	if webhook != nil {
+		project, err = database.CreateProjectWithWebhook(ctx, input,
+			webhook.ID, encryptedSecret)
+	} else {
+		project, err = database.CreateProject(ctx, input)
+	}
+	if err != nil {
+		// Don't leave an orphaned webhook on GitHub
+		deleteProjectWebhook(ctx, logger, ghClient, owner, repoName, webhook)
+		return nil, nil, err
+	}
+	return project, webhook, nil
+}
+
+// Moves a project to another repo the user can access: a webhook & deploy
+// key are created on the new repo & the old repo's are removed (best
+// effort). Responds & returns false if the project wasn't moved
+func moveProjectRepo(c *gin.Context, user *database.User,
+	project *database.Project, repoFullName string) bool {
+	logger := middleware.Logger(c)
+	ctx := c.Request.Context()
+
+	ghClient, owner, repoName, repo, ok := newProjectRepo(c, user,
+		repoFullName)
+	if !ok {
+		return false
+	}
+
+	webhookSecret, err := github.GenerateWebhookSecret()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to generate webhook secret")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to update project"})
+		return false
+	}
+	encryptedSecret, err := crypto.Encrypt(webhookSecret)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to encrypt webhook secret")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to update project"})
+		return false
+	}
+
+	// As on creation, a webhook GitHub refuses can be added later
+	var webhookID *int64
+	var storedSecret *string
+	webhook, err := ghClient.CreateWebhook(ctx, owner, repoName,
+		github.WebhookURL(), webhookSecret)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create github webhook")
+		webhook = nil
+	} else {
+		webhookID = &webhook.ID
+		storedSecret = &encryptedSecret
+	}
+
+	if err := database.SetProjectRepo(ctx, project.ID, repo.FullName,
+		repo.HTMLURL, webhookID, storedSecret); err != nil {
+		logger.Error().Err(err).Msg("Failed to update project repo")
+		deleteProjectWebhook(ctx, logger, ghClient, owner, repoName, webhook)
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to update project"})
+		return false
+	}
+
+	// The old repo would otherwise keep sending pushes & trusting the key
+	oldOwner, oldRepoName, err := github.ParseRepoFullName(
+		project.RepoFullName)
+	if err == nil {
+		if project.WebhookID != nil {
+			if err := ghClient.DeleteWebhook(ctx, oldOwner, oldRepoName,
+				*project.WebhookID); err != nil {
+				logger.Warn().Err(err).Str("repo", project.RepoFullName).
+					Msg("Failed to delete old GitHub webhook")
+			}
+		}
+		if project.DeployKeyID != nil {
+			if err := ghClient.DeleteDeployKey(ctx, oldOwner, oldRepoName,
+				*project.DeployKeyID); err != nil {
+				logger.Warn().Err(err).Str("repo", project.RepoFullName).
+					Msg("Failed to delete old GitHub deploy key")
+			}
+		}
+	}
+
+	logger.Info().
+		Str("project_id", project.ID).
+		Str("from", project.RepoFullName).
+		Str("to", repo.FullName).
+		Msg("Moved project to another repo")
+
+	project.RepoFullName = repo.FullName
+	project.DeployKeyID = nil
+	setupDeployKey(ctx, ghClient, owner, repoName, project)
+	return true
+}
+
+// Deletes a webhook created for a project that's being rolled back
+func deleteProjectWebhook(ctx context.Context, logger *zerolog.Logger,
+	ghClient *github.Client, owner, repoName string,
+	webhook *github.Webhook) {
+	if webhook == nil {
+		return
+	}
+	if err := ghClient.DeleteWebhook(ctx, owner, repoName,
+		webhook.ID); err != nil {
+		logger.Warn().Err(err).Msg("Failed to delete GitHub webhook")
+	}
+}
+
+// Creates a read-only deploy key on GitHub & stores the encrypted private
+// key. Failures are logged; the worker falls back to HTTPS cloning
+func setupDeployKey(ctx context.Context, ghClient *github.Client, owner,
+	repoName string, project *database.Project) {
+	publicKey, privateKey, err := generateDeployKey()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate deploy key")
+		return
+	}
+
+	encryptedKey, err := crypto.Encrypt(privateKey)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encrypt deploy key")
+		return
+	}
+
+	deployKey, err := ghClient.CreateDeployKey(ctx, owner, repoName,
+		"RCNbuild ("+project.Slug+")", publicKey, true)
+	if err != nil {
+		log.Warn().Err(err).Str("project_id", project.ID).
+			Msg("Failed to create GitHub deploy key")
+		return
+	}
+
+	if err := database.SetProjectDeployKey(ctx, project.ID, deployKey.ID,
+		encryptedKey); err != nil {
+		log.Error().Err(err).Msg("Failed to store deploy key")
+		return
+	}
+	project.DeployKeyID = &deployKey.ID
+}
+
+// Checks a slug is DNS-safe & not too long
+func isValidSlug(slug string) bool {
+	return len(slug) <= 50 && slugPattern.MatchString(slug)
+}
+
+// Checks the slug against the reserved list
+func isReservedSlug(slug string) bool {
+	reservedSlugsOnce.Do(func() {
+		reservedSlugs = make(map[string]struct{})
+		for _, s := range defaultReservedSlugs {
+			reservedSlugs[s] = struct{}{}
+		}
+		for _, s := range strings.Split(os.Getenv("RESERVED_SLUGS"), ",") {
+			if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+				reservedSlugs[s] = struct{}{}
+			}
+		}
+	})
+
+	_, reserved := reservedSlugs[slug]
+	return reserved
+}
+
+// randomSuffix generates a random [a-z0-9] suffix of n chars for slugs
+func randomSuffix(n int) string {
 	const chars = "abcdefghijklmnopqrstuvwxyz0123456789"
-	result := make([]byte, 4)
-	for i := range result {
-		result[i] = chars[i%len(chars)] // Simple, not crypto-random
+	result := make([]byte, n)
+	rand.Read(result) // Never fails as of Go 1.24
+	for i, b := range result {
+		result[i] = chars[int(b)%len(chars)]
 	}
 	return string(result)
 }
+
+// Keyed per user so private repo results are never shared
+func runtimeCacheKey(userID, owner, repo, branch, rootDir string) string {
+	return strings.Join([]string{userID, owner, repo, branch, rootDir},
+		"\x00")
+}
+
+// Runs DetectRuntime through the detection cache
+func detectRuntimeCached(ctx context.Context, ghClient *github.Client,
+	userID, owner, repo, branch, rootDir string) (*builds.RuntimeInfo,
+	error) {
+	key := runtimeCacheKey(userID, owner, repo, branch, rootDir)
+	if info := getCachedRuntime(key); info != nil {
+		return info, nil
+	}
+
+	info, err := builds.DetectRuntime(ctx, ghClient, owner, repo, branch,
+		rootDir)
+	if err != nil {
+		return nil, err
+	}
+	setCachedRuntime(key, info)
+	return info, nil
+}
+
+// Returns a cached detection result, or nil if missing/expired
+func getCachedRuntime(key string) *builds.RuntimeInfo {
+	detectRuntimeCacheMu.Lock()
+	defer detectRuntimeCacheMu.Unlock()
+
+	entry, ok := detectRuntimeCache[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(detectRuntimeCache, key)
+		return nil
+	}
+	return entry.info
+}
+
+// Stores a detection result & evicts any expired entries
+func setCachedRuntime(key string, info *builds.RuntimeInfo) {
+	detectRuntimeCacheMu.Lock()
+	defer detectRuntimeCacheMu.Unlock()
+
+	now := time.Now()
+	for k, entry := range detectRuntimeCache {
+		if now.After(entry.expiresAt) {
+			delete(detectRuntimeCache, k)
+		}
+	}
+	detectRuntimeCache[key] = detectRuntimeEntry{
+		info:      info,
+		expiresAt: now.Add(detectRuntimeTTL),
+	}
+}
@@ -0,0 +1,122 @@
+package projects
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/auth"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/containers"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Time allowed to send a single message to the client
+const logWriteTimeout = 10 * time.Second
+
+// Upgrades log streaming requests (dashboard origin only)
+var logUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || origin == os.Getenv("DASHBOARD_URL")
+	},
+}
+
+// Sends each write as a WebSocket text message
+// Shared by stdout & stderr, so writes are serialized
+type wsLogWriter struct {
+	conn *websocket.Conn
+	mu   sync.Mutex
+}
+
+func (w *wsLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.conn.SetWriteDeadline(time.Now().Add(logWriteTimeout))
+	if err := w.conn.WriteMessage(websocket.TextMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Streams live runtime logs from a deployment's container
+// GET /api/deployments/:id/logs/ws?token=...&since=...
+func (h *Handlers) HandleStreamDeploymentLogs(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	deployment, err := database.GetDeploymentByID(c.Request.Context(),
+		c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+
+	project, err := database.GetProjectByID(c.Request.Context(),
+		deployment.ProjectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	if deployment.ContainerID == nil {
+		c.JSON(http.StatusConflict,
+			gin.H{"error": "Deployment has no running container"})
+		return
+	}
+
+	conn, err := logUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrader has already written an error response
+		logger.Warn().Err(err).Msg("Failed to upgrade log stream")
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Stop streaming when the client disconnects
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	writer := &wsLogWriter{conn: conn}
+	err = containers.StreamContainerLogs(ctx, *deployment.ContainerID,
+		c.Query("since"), writer, writer)
+	if err != nil && ctx.Err() == nil {
+		logger.Warn().Err(err).Str("deployment_id", deployment.ID).
+			Msg("Log stream ended with error")
+	}
+
+	// Container stopped (or stream failed): close gracefully
+	writer.mu.Lock()
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure,
+			"log stream ended"),
+		time.Now().Add(time.Second))
+	writer.mu.Unlock()
+}
@@ -0,0 +1,119 @@
+package projects
+
+import (
+	"net/http"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/auth"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// Build duration percentiles & success rate over the last 30 days
+// Figures are refreshed hourly, so may lag recent deployments
+// GET /api/projects/:id/metrics
+// @Summary Get a project's deployment metrics
+// @Tags projects
+// @Produce json
+// @Param id path string true "Project ID"
+// @Success 200 {object} database.DeploymentMetrics
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /projects/{id}/metrics [get]
+func (h *Handlers) HandleGetProjectMetrics(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	projectID := c.Param("id")
+	project, err := database.GetProjectByID(c.Request.Context(), projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	metrics, err := database.GetDeploymentMetrics(c.Request.Context(),
+		project.ID)
+	if err != nil {
+		logger.Error().Err(err).Str("project_id", project.ID).
+			Msg("Failed to get deployment metrics")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to get deployment metrics"})
+		return
+	}
+
+	c.JSON(http.StatusOK, metrics)
+}
+
+// Deployments shown in a project's image size history
+const imageSizeHistoryLimit = 20
+
+// Image sizes of a project's recent deployments, oldest first
+type ImageSizesResponse struct {
+	ProjectID  string                     `json:"project_id"`
+	ImageSizes []*database.ImageSizePoint `json:"image_sizes"`
+}
+
+// Image sizes of the project's last 20 built deployments, to spot bloat
+// GET /api/projects/:id/stats/image-sizes
+// @Summary Get a project's image size history
+// @Description Oldest first. Multi-platform builds aren't sized, so are
+// @Description left out.
+// @Tags projects
+// @Produce json
+// @Param id path string true "Project ID"
+// @Success 200 {object} ImageSizesResponse
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /projects/{id}/stats/image-sizes [get]
+func (h *Handlers) HandleGetImageSizes(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	projectID := c.Param("id")
+	project, err := database.GetProjectByID(c.Request.Context(), projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	sizes, err := database.GetImageSizeHistory(c.Request.Context(),
+		project.ID, imageSizeHistoryLimit)
+	if err != nil {
+		logger.Error().Err(err).Str("project_id", project.ID).
+			Msg("Failed to get image size history")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to get image size history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, &ImageSizesResponse{
+		ProjectID:  project.ID,
+		ImageSizes: sizes,
+	})
+}
@@ -0,0 +1,160 @@
+package projects
+
+import (
+	"net/http"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/auth"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// Body for configuring a Traefik middleware
+type SetMiddlewareRequest struct {
+	Type string `json:"type" binding:"required"` // basic_auth or ip_allowlist
+	// basic_auth: htpasswd entries, e.g. from `htpasswd -nB user`
+	Users []string `json:"users"`
+	// ip_allowlist: IPs or CIDRs allowed to reach the app
+	SourceRange []string `json:"source_range"`
+}
+
+// Add or replace one of a project's Traefik middlewares
+// POST /api/projects/:id/middlewares
+// @Summary Configure a Traefik middleware
+// @Description Replaces any middleware of the same type. Takes effect on
+// @Description the next deployment (or when a paused project resumes).
+// @Tags projects
+// @Accept json
+// @Produce json
+// @Param id path string true "Project ID"
+// @Param body body SetMiddlewareRequest true "Middleware settings"
+// @Success 200 {object} map[string][]database.TraefikMiddleware
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /projects/{id}/middlewares [post]
+func (h *Handlers) HandleSetMiddleware(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	projectID := c.Param("id")
+	project, err := database.GetProjectByID(c.Request.Context(), projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var req SetMiddlewareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.RespondBodyError(c, err)
+		return
+	}
+
+	mw := &database.TraefikMiddleware{Type: req.Type}
+	switch req.Type {
+	case database.MiddlewareBasicAuth:
+		mw.Users = req.Users
+	case database.MiddlewareIPAllowlist:
+		mw.SourceRange = req.SourceRange
+	}
+	if err := mw.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.SetProjectMiddleware(c.Request.Context(),
+		project.ID, mw); err != nil {
+		logger.Error().Err(err).Msg("Failed to set middleware")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to set middleware"})
+		return
+	}
+
+	updated, err := database.GetProjectByID(c.Request.Context(), project.ID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to reload project")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to set middleware"})
+		return
+	}
+
+	logger.Info().
+		Str("project_id", project.ID).
+		Str("type", mw.Type).
+		Msg("Middleware configured")
+
+	c.JSON(http.StatusOK, gin.H{
+		"traefik_middlewares": updated.TraefikMiddlewares,
+	})
+}
+
+// Remove one of a project's Traefik middlewares
+// DELETE /api/projects/:id/middlewares/:type
+// @Summary Remove a Traefik middleware
+// @Description Takes effect on the next deployment
+// @Tags projects
+// @Produce json
+// @Param id path string true "Project ID"
+// @Param type path string true "basic_auth or ip_allowlist"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /projects/{id}/middlewares/{type} [delete]
+func (h *Handlers) HandleDeleteMiddleware(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	projectID := c.Param("id")
+	project, err := database.GetProjectByID(c.Request.Context(), projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	mwType := c.Param("type")
+	if project.Middleware(mwType) == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Middleware not found"})
+		return
+	}
+
+	if err := database.RemoveProjectMiddleware(c.Request.Context(),
+		project.ID, mwType); err != nil {
+		logger.Error().Err(err).Msg("Failed to remove middleware")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to remove middleware"})
+		return
+	}
+
+	logger.Info().
+		Str("project_id", project.ID).
+		Str("type", mwType).
+		Msg("Middleware removed")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Middleware removed"})
+}
@@ -0,0 +1,127 @@
+package projects
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/auth"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/github"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
+	"github.com/Sys-Redux/rcnbuild-paas/pkg/crypto"
+	"github.com/gin-gonic/gin"
+)
+
+// Body for configuring a project's deployment notification webhook
+type SetNotificationRequest struct {
+	URL    string `json:"url" binding:"required"`
+	Secret string `json:"secret"`
+}
+
+// Configure the outbound deployment notification webhook
+// POST /api/projects/:id/notification
+func (h *Handlers) HandleSetNotification(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	projectID := c.Param("id")
+	project, err := database.GetProjectByID(c.Request.Context(), projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var req SetNotificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.RespondBodyError(c, err)
+		return
+	}
+
+	parsed, err := url.Parse(req.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") ||
+		parsed.Host == "" {
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "url must be an absolute http(s) URL"})
+		return
+	}
+
+	// Generate a signing secret if the caller didn't supply one
+	secret := req.Secret
+	if secret == "" {
+		secret, err = github.GenerateWebhookSecret()
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to generate notification secret")
+			c.JSON(http.StatusInternalServerError,
+				gin.H{"error": "Failed to configure notification"})
+			return
+		}
+	}
+
+	encrypted, err := crypto.Encrypt(secret)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to encrypt notification secret")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to configure notification"})
+		return
+	}
+
+	if err := database.SetProjectNotification(c.Request.Context(),
+		project.ID, &req.URL, &encrypted); err != nil {
+		logger.Error().Err(err).Msg("Failed to save notification settings")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to configure notification"})
+		return
+	}
+
+	// The secret is only ever returned here
+	c.JSON(http.StatusOK, gin.H{
+		"url":    req.URL,
+		"secret": secret,
+	})
+}
+
+// Remove the deployment notification webhook
+// DELETE /api/projects/:id/notification
+func (h *Handlers) HandleDeleteNotification(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	projectID := c.Param("id")
+	project, err := database.GetProjectByID(c.Request.Context(), projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	if err := database.SetProjectNotification(c.Request.Context(),
+		project.ID, nil, nil); err != nil {
+		logger.Error().Err(err).Msg("Failed to clear notification settings")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to remove notification"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification removed"})
+}
@@ -0,0 +1,67 @@
+package projects
+
+import (
+	"net/http"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/auth"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/github"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// Lists the GitHub organizations the user belongs to
+// GET /api/orgs
+func (h *Handlers) HandleListOrgs(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	accessToken, err := database.GetUserAccessToken(c.Request.Context(),
+		user.ID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get user access token")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "failed to get user access token"})
+		return
+	}
+
+	orgs, err := github.NewClient(accessToken).ListUserOrgs(
+		c.Request.Context())
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list user orgs")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "failed to list orgs"})
+		return
+	}
+
+	logins := make([]string, len(orgs))
+	for i, org := range orgs {
+		logins[i] = org.Login
+	}
+
+	c.JSON(http.StatusOK, gin.H{"orgs": logins})
+}
+
+// Lists an organization's repos the user can deploy
+// GET /api/orgs/:org/repos
+func (h *Handlers) HandleListOrgRepos(c *gin.Context) {
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req ListReposRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Org = c.Param("org")
+
+	listRepos(c, user, &req)
+}
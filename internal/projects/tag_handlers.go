@@ -0,0 +1,68 @@
+package projects
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/auth"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// Limits on project tags
+const (
+	maxTagsPerProject = 10
+	maxTagLength      = 30
+)
+
+// Matches alphanumeric tags with hyphens
+var tagPattern = regexp.MustCompile(`^[a-zA-Z0-9-]+$`)
+
+// Lists distinct tags across the user's projects with project counts
+// GET /api/tags
+func (h *Handlers) HandleListTags(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	tags, err := database.GetUserTags(c.Request.Context(), user.ID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get user tags")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "failed to get tags"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tags": tags,
+	})
+}
+
+// Validates tags & drops duplicates (always returns a non-nil slice)
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]struct{}, len(tags))
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if len(tag) == 0 || len(tag) > maxTagLength ||
+			!tagPattern.MatchString(tag) {
+			return nil, errors.New(
+				"tags must be 1-30 letters, numbers, or hyphens")
+		}
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		result = append(result, tag)
+	}
+
+	if len(result) > maxTagsPerProject {
+		return nil, errors.New("a project can have at most 10 tags")
+	}
+	return result, nil
+}
@@ -0,0 +1,103 @@
+package projects
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/auth"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/containers"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+// Certificate lookups dial the app's domain, so results are cached briefly
+const (
+	certCacheSize = 500
+	certCacheTTL  = 5 * time.Minute
+)
+
+var certCache = expirable.NewLRU[string, *containers.CertificateInfo](
+	certCacheSize, nil, certCacheTTL)
+
+// A project's certificate status
+type TLSStatusResponse struct {
+	*containers.CertificateInfo
+	// Set when the certificate is invalid or expiry is near
+	Warning string `json:"warning,omitempty"`
+}
+
+// Inspect the TLS certificate served for the project's domain
+// GET /api/projects/:id/tls
+// @Summary Get a project's TLS certificate status
+// @Description Results are cached for 5 minutes. Expired or otherwise
+// @Description invalid certificates are reported with valid=false; a
+// @Description warning is included when the certificate is invalid or
+// @Description expires within 30 days. 502 means the domain couldn't be
+// @Description reached.
+// @Tags projects
+// @Produce json
+// @Param id path string true "Project ID"
+// @Success 200 {object} TLSStatusResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Router /projects/{id}/tls [get]
+func (h *Handlers) HandleGetTLSStatus(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	projectID := c.Param("id")
+	project, err := database.GetProjectByID(c.Request.Context(), projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	if !containers.TLSEnabled() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "TLS is not enabled"})
+		return
+	}
+
+	hostname := containers.ProjectHostname(project.Slug, project.Environment,
+		stringOrEmpty(project.BaseDomain))
+	cert, ok := certCache.Get(hostname)
+	if !ok {
+		cert, err = containers.InspectCertificate(c.Request.Context(),
+			hostname)
+		if err != nil {
+			logger.Warn().Err(err).Str("domain", hostname).
+				Msg("Failed to inspect TLS certificate")
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": "Failed to inspect certificate for " + hostname +
+					": " + err.Error(),
+			})
+			return
+		}
+		certCache.Add(hostname, cert)
+	}
+
+	resp := TLSStatusResponse{CertificateInfo: cert}
+	if !cert.Valid {
+		resp.Warning = "certificate is invalid: " + cert.VerifyError
+	} else if cert.DaysRemaining < containers.CertWarningDays {
+		resp.Warning = fmt.Sprintf("certificate expires in %d days",
+			cert.DaysRemaining)
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,135 @@
+package projects
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/auth"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/github"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
+	"github.com/Sys-Redux/rcnbuild-paas/pkg/crypto"
+	"github.com/gin-gonic/gin"
+)
+
+// Replace a (possibly compromised) webhook secret on GitHub & in the DB
+// POST /api/projects/:id/rotate-webhook-secret
+func (h *Handlers) HandleRotateWebhookSecret(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	projectID := c.Param("id")
+	project, err := database.GetProjectByID(c.Request.Context(), projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	if project.WebhookID == nil {
+		c.JSON(http.StatusConflict,
+			gin.H{"error": "Project has no GitHub webhook"})
+		return
+	}
+
+	owner, repoName, err := github.ParseRepoFullName(project.RepoFullName)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid repository"})
+		return
+	}
+
+	accessToken, err := database.GetUserAccessToken(c.Request.Context(),
+		user.ID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get user access token")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to get user access token"})
+		return
+	}
+	ghClient := github.NewClient(accessToken)
+
+	secret, err := github.GenerateWebhookSecret()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to generate webhook secret")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to generate webhook secret"})
+		return
+	}
+
+	// Encrypt up front so nothing can fail between the two updates
+	// except the DB write itself
+	encryptedSecret, err := crypto.Encrypt(secret)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to encrypt webhook secret")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to encrypt webhook secret"})
+		return
+	}
+
+	// Deliveries signed with the new secret fail verification until the
+	// DB update below lands; GitHub shows them as failed & they can be
+	// redelivered
+	if _, err := ghClient.UpdateWebhook(c.Request.Context(), owner,
+		repoName, *project.WebhookID, secret); err != nil {
+		logger.Error().Err(err).Str("project_id", project.ID).
+			Msg("Failed to update GitHub webhook secret")
+		c.JSON(http.StatusBadGateway,
+			gin.H{"error": "Failed to update webhook on GitHub"})
+		return
+	}
+
+	if err := database.SetProjectWebhook(c.Request.Context(), project.ID,
+		*project.WebhookID, encryptedSecret); err != nil {
+		logger.Error().Err(err).Msg("Failed to store webhook secret")
+
+		// Put the old secret back on GitHub so signatures still verify
+		oldSecret, err := database.GetProjectWebhookSecret(
+			c.Request.Context(), project.ID)
+		if err == nil {
+			_, err = ghClient.UpdateWebhook(c.Request.Context(), owner,
+				repoName, *project.WebhookID, oldSecret)
+		}
+		if err != nil {
+			logger.Error().Err(err).Str("project_id", project.ID).
+				Msg("Failed to restore old webhook secret on GitHub")
+		}
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to store webhook secret"})
+		return
+	}
+
+	// The new secret is already live, so a failed entry can't undo the
+	// rotation; it's logged below either way
+	webhookID := strconv.FormatInt(*project.WebhookID, 10)
+	if err := database.CreateAuditLogEntry(c.Request.Context(),
+		&database.CreateAuditLogInput{
+			UserID:    user.ID,
+			ProjectID: &project.ID,
+			Action:    database.AuditActionWebhookSecretRotated,
+			Target:    &webhookID,
+			IPAddress: c.ClientIP(),
+		}); err != nil {
+		logger.Error().Err(err).Str("project_id", project.ID).
+			Msg("Failed to write audit log entry")
+	}
+
+	logger.Info().
+		Str("event", "webhook_secret_rotated").
+		Str("project_id", project.ID).
+		Str("user_id", user.ID).
+		Int64("webhook_id", *project.WebhookID).
+		Str("client_ip", c.ClientIP()).
+		Msg("Webhook secret rotated")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook secret rotated"})
+}
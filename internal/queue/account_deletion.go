@@ -0,0 +1,140 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/containers"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/github"
+	"github.com/hibiken/asynq"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Removes each of the user's projects (GitHub hooks & keys, containers,
+// DB rows), then the user. Stops at the first project that can't be
+// deleted so the account isn't removed with resources still running.
+// Failures are recorded on the account_deletions row, not retried
+func HandleDeleteAccountTask(ctx context.Context, t *asynq.Task) error {
+	var payload DeleteAccountPayload
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal delete account payload: %w",
+			err)
+	}
+	deletionID, userID := payload.DeletionID, payload.UserID
+	logger := log.With().
+		Str("deletion_id", deletionID).
+		Str("user_id", userID).
+		Logger()
+
+	fail := func(message string, err error) {
+		errMsg := fmt.Sprintf("%s: %v", message, err)
+		logger.Error().Err(err).Msg(message)
+		if err := database.FinishAccountDeletion(ctx, deletionID,
+			&errMsg); err != nil {
+			logger.Error().Err(err).Msg("Failed to record deletion failure")
+		}
+	}
+
+	projects, err := database.GetProjectsByUserID(ctx, userID, nil, 0, 0)
+	if err != nil {
+		fail("failed to list projects", err)
+		return nil
+	}
+
+	if err := database.StartAccountDeletion(ctx, deletionID,
+		len(projects)); err != nil {
+		fail("failed to start account deletion", err)
+		return nil
+	}
+
+	// Without a token GitHub cleanup is skipped; hooks & keys are left for
+	// the user to remove
+	var ghClient *github.Client
+	if accessToken, err := database.GetUserAccessToken(ctx,
+		userID); err == nil {
+		ghClient = github.NewClient(accessToken).
+			SetMaxRateLimitWait(github.WorkerRateLimitWait)
+	} else {
+		logger.Warn().Err(err).Msg("No access token, skipping GitHub cleanup")
+	}
+
+	managed, err := containers.ListManagedContainers(ctx)
+	if err != nil {
+		fail("failed to list containers", err)
+		return nil
+	}
+
+	for _, project := range projects {
+		if ghClient != nil {
+			deleteProjectGitHubResources(ctx, ghClient, project, &logger)
+		}
+
+		for _, mc := range managed {
+			if mc.Slug != project.Slug {
+				continue
+			}
+			if err := containers.Stop(ctx, mc.ID); err != nil {
+				logger.Warn().Err(err).Str("container", mc.Name).
+					Msg("Failed to stop container")
+			}
+			if err := containers.Remove(ctx, mc.ID); err != nil {
+				fail("failed to remove container "+mc.Name, err)
+				return nil
+			}
+		}
+
+		if err := database.DeleteProjectWithData(ctx,
+			project.ID); err != nil {
+			fail("failed to delete project "+project.ID, err)
+			return nil
+		}
+
+		if err := database.IncrementAccountDeletionProgress(ctx,
+			deletionID); err != nil {
+			logger.Warn().Err(err).Msg("Failed to record deletion progress")
+		}
+	}
+
+	if err := database.DeleteUser(ctx, userID); err != nil {
+		fail("failed to delete user", err)
+		return nil
+	}
+
+	if err := database.FinishAccountDeletion(ctx, deletionID,
+		nil); err != nil {
+		logger.Error().Err(err).Msg("Failed to record deletion completion")
+	}
+
+	logger.Info().Int("projects", len(projects)).Msg("Account deleted")
+	return nil
+}
+
+// Removes a project's webhook & deploy key from its GitHub repo
+// Failures are logged only; the repo owner can remove them by hand
+func deleteProjectGitHubResources(ctx context.Context,
+	ghClient *github.Client, project *database.Project,
+	logger *zerolog.Logger) {
+	owner, repoName, err := github.ParseRepoFullName(project.RepoFullName)
+	if err != nil {
+		return
+	}
+
+	if project.WebhookID != nil {
+		if err := ghClient.DeleteWebhook(ctx, owner, repoName,
+			*project.WebhookID); err != nil {
+			logger.Warn().Err(err).Str("project_id", project.ID).
+				Msg("Failed to delete GitHub webhook")
+		}
+	}
+
+	if project.DeployKeyID != nil {
+		if err := ghClient.DeleteDeployKey(ctx, owner, repoName,
+			*project.DeployKeyID); err != nil {
+			logger.Warn().Err(err).Str("project_id", project.ID).
+				Msg("Failed to delete GitHub deploy key")
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/pkg/crypto"
+	"github.com/rs/zerolog/log"
+)
+
+// Writes each build secret's decrypted env var to its own temp file
+// Files live outside the build context so they can't be COPY'd into the
+// image. Returns secret id -> path; callers must removeBuildSecrets.
+func writeBuildSecrets(ctx context.Context, projectID string,
+	secrets []database.BuildSecret) (map[string]string, error) {
+	if len(secrets) == 0 {
+		return nil, nil
+	}
+
+	envVars, err := database.GetEnvVarsAsMap(ctx, projectID, crypto.Decrypt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load env vars: %w", err)
+	}
+
+	files := make(map[string]string, len(secrets))
+	for _, secret := range secrets {
+		value, ok := envVars[secret.EnvVarName]
+		if !ok {
+			removeBuildSecrets(files)
+			return nil, fmt.Errorf("env var %s for build secret %s is not set",
+				secret.EnvVarName, secret.Key)
+		}
+
+		path, err := writeSecretFile(value)
+		if err != nil {
+			removeBuildSecrets(files)
+			return nil, err
+		}
+		files[secret.Key] = path
+	}
+	return files, nil
+}
+
+// Writes value to a new owner-only temp file & returns its path
+func writeSecretFile(value string) (string, error) {
+	f, err := os.CreateTemp("", "rcnbuild-secret-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create secret file: %w", err)
+	}
+	if _, err := f.WriteString(value); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write secret file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write secret file: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// Deletes the temp files written by writeBuildSecrets
+func removeBuildSecrets(files map[string]string) {
+	for _, path := range files {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Warn().Err(err).Str("path", path).
+				Msg("Failed to remove build secret file")
+		}
+	}
+}
+
+// Secret ids in the order they were configured
+func buildSecretKeys(secrets []database.BuildSecret) []string {
+	keys := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		keys = append(keys, secret.Key)
+	}
+	return keys
+}
@@ -2,7 +2,9 @@ package queue
 
 import (
 	"context"
+	"errors"
 
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
 	"github.com/hibiken/asynq"
 	"github.com/rs/zerolog/log"
 )
@@ -10,17 +12,28 @@ import (
 // Asynq client for enqueueing jobs
 var client *asynq.Client
 
+// Asynq inspector for queue state & cancelling queued jobs
+var inspector *asynq.Inspector
+
+// Redis connection shared by the client, inspector & queue monitor
+var redisOpt asynq.RedisClientOpt
+
 // Initialize asynq client
 func Connect(redisAddr string) error {
-	client = asynq.NewClient(asynq.RedisClientOpt{
+	redisOpt = asynq.RedisClientOpt{
 		Addr: redisAddr,
-	})
+	}
+	client = asynq.NewClient(redisOpt)
+	inspector = asynq.NewInspector(redisOpt)
 	log.Info().Str("redis_addr", redisAddr).Msg("Connected to Asynq client")
 	return nil
 }
 
 // Close asynq client
 func Close() error {
+	if inspector != nil {
+		inspector.Close()
+	}
 	if client != nil {
 		return client.Close()
 	}
@@ -45,6 +58,13 @@ func EnqueueBuild(ctx context.Context, payload *BuildPayload) (string, error) {
 		Str("deployment_id", payload.DeploymentID).
 		Msg("Enqueued build job")
 
+	// Remembered so the build can be cancelled from the API
+	if err := database.SetDeploymentQueueTaskID(ctx, payload.DeploymentID,
+		info.ID); err != nil {
+		log.Warn().Err(err).Str("deployment_id", payload.DeploymentID).
+			Msg("Failed to store build task ID")
+	}
+
 	return info.ID, nil
 }
 
@@ -57,6 +77,10 @@ func EnqueueDeploy(ctx context.Context,
 	}
 
 	info, err := client.EnqueueContext(ctx, task)
+	if errors.Is(err, asynq.ErrTaskIDConflict) {
+		// A retried build already enqueued this deployment's deploy
+		return DeployTaskID(payload.DeploymentID), nil
+	}
 	if err != nil {
 		return "", err
 	}
@@ -69,3 +93,161 @@ func EnqueueDeploy(ctx context.Context,
 
 	return info.ID, nil
 }
+
+// Enqueue a job restarting a paused project's live deployment
+// A resume already waiting or running for the project is reused
+func EnqueueResumeProject(ctx context.Context,
+	payload *ResumeProjectPayload) (string, error) {
+	task, err := NewResumeProjectTask(payload)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := client.EnqueueContext(ctx, task)
+	if errors.Is(err, asynq.ErrTaskIDConflict) {
+		return ResumeTaskID(payload.ProjectID), nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	log.Info().
+		Str("task_id", info.ID).
+		Str("queue", info.Queue).
+		Str("project_id", payload.ProjectID).
+		Msg("Enqueued resume job")
+
+	return info.ID, nil
+}
+
+// Enqueue an image cleanup job
+func EnqueueCleanupImages(ctx context.Context,
+	payload *CleanupImagesPayload) (string, error) {
+	task, err := NewCleanupImagesTask(payload)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := client.EnqueueContext(ctx, task)
+	if err != nil {
+		return "", err
+	}
+
+	log.Info().
+		Str("task_id", info.ID).
+		Str("queue", info.Queue).
+		Str("project_id", payload.ProjectID).
+		Msg("Enqueued image cleanup job")
+
+	return info.ID, nil
+}
+
+// Enqueue the cleanup of a deleted account
+func EnqueueDeleteAccount(ctx context.Context,
+	payload *DeleteAccountPayload) (string, error) {
+	task, err := NewDeleteAccountTask(payload)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := client.EnqueueContext(ctx, task)
+	if err != nil {
+		return "", err
+	}
+
+	log.Info().
+		Str("task_id", info.ID).
+		Str("queue", info.Queue).
+		Str("deletion_id", payload.DeletionID).
+		Msg("Enqueued account deletion job")
+
+	return info.ID, nil
+}
+
+// Remove a deployment's build job from the queue if it hasn't started
+func CancelBuild(ctx context.Context, deploymentID string) error {
+	err := inspector.DeleteTask(BuildsQueue(), BuildTaskID(deploymentID))
+	if err != nil && !errors.Is(err, asynq.ErrTaskNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Stops a build job: deleted if still queued, cancelled if running
+func CancelBuildTask(taskID string) error {
+	return cancelTask(BuildsQueue(), taskID)
+}
+
+// Stops a deployment's deploy job: deleted if still queued, cancelled if
+// running
+func CancelDeployTask(deploymentID string) error {
+	return cancelTask(DeploymentsQueue(), DeployTaskID(deploymentID))
+}
+
+// Deletes a task still waiting on the queue, or cancels it if it's running
+func cancelTask(queueName, taskID string) error {
+	info, err := inspector.GetTaskInfo(queueName, taskID)
+	if err != nil {
+		if errors.Is(err, asynq.ErrTaskNotFound) ||
+			errors.Is(err, asynq.ErrQueueNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if info.State == asynq.TaskStateActive {
+		return inspector.CancelProcessing(taskID)
+	}
+
+	err = inspector.DeleteTask(queueName, taskID)
+	if err != nil && !errors.Is(err, asynq.ErrTaskNotFound) {
+		return err
+	}
+	return nil
+}
+
+// 1-based position of a deployment's build among waiting build jobs
+// Returns 0 if the build isn't waiting (already running or not queued).
+// Builds deferred by the worker's concurrency cap (or retrying after a
+// failure) wait in the retry set & rejoin the back of the queue when due,
+// so they're counted after every pending build, in the order they rejoin
+func BuildQueuePosition(deploymentID string) (int, error) {
+	taskID := BuildTaskID(deploymentID)
+
+	position, pending, err := taskPosition(inspector.ListPendingTasks,
+		taskID)
+	if err != nil || position > 0 {
+		return position, err
+	}
+
+	position, _, err = taskPosition(inspector.ListRetryTasks, taskID)
+	if err != nil || position == 0 {
+		return 0, err
+	}
+	return pending + position, nil
+}
+
+// 1-based position of a task in one of the builds queue's task lists, &
+// how many tasks the list holds (position is 0 if the task isn't in it)
+func taskPosition(list func(string, ...asynq.ListOption) ([]*asynq.TaskInfo,
+	error), taskID string) (position, total int, err error) {
+	for page := 1; ; page++ {
+		tasks, err := list(BuildsQueue(), asynq.PageSize(100),
+			asynq.Page(page))
+		if err != nil {
+			if errors.Is(err, asynq.ErrQueueNotFound) {
+				return 0, total, nil
+			}
+			return 0, 0, err
+		}
+		for _, t := range tasks {
+			total++
+			if t.ID == taskID {
+				return total, total, nil
+			}
+		}
+		if len(tasks) < 100 {
+			return 0, total, nil
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/containers"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/pkg/crypto"
+	"github.com/rs/zerolog/log"
+)
+
+// Container settings for running a built deployment: the project's env
+// vars (decrypted) plus PORT & the RCNBUILD_* platform vars, its route
+// (preview or not) & middlewares, and its readiness probe
+// Shared by deploy & resume jobs, so a restarted
+// container runs exactly like a deployed one. Project settings are read
+// as they are now, so changes apply to the next (re)start
+func DeployConfigFor(ctx context.Context, project *database.Project,
+	deployment *database.Deployment) (*containers.DeployConfig, error) {
+	if deployment.ImageTag == nil {
+		return nil, errors.New("deployment has no built image")
+	}
+
+	envVars, err := database.GetEnvVarsAsMap(ctx, project.ID,
+		crypto.Decrypt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch environment variables: %w",
+			err)
+	}
+	envVars["PORT"] = strconv.Itoa(project.Port)
+	// Platform metadata so apps can report their own version
+	setPlatformEnvVars(envVars, deployment.ID, map[string]string{
+		"RCNBUILD_DEPLOYMENT_ID": deployment.ID,
+		"RCNBUILD_COMMIT_SHA":    deployment.CommitSHA,
+		"RCNBUILD_PROJECT_ID":    project.ID,
+		"RCNBUILD_ENVIRONMENT":   deployment.Environment,
+	})
+
+	baseDomain := stringOrEmpty(project.BaseDomain)
+	if baseDomain == "" {
+		baseDomain = containers.BaseDomain()
+	}
+
+	// Previews run beside the project's own container, one per PR
+	prNumber := 0
+	containerName := containers.ContainerName(project.Slug)
+	if deployment.PRNumber != nil {
+		prNumber = *deployment.PRNumber
+		containerName = containers.ContainerName(
+			containers.PreviewSlug(project.Slug, prNumber))
+	}
+
+	basicAuthUsers, ipAllowlist := project.MiddlewareSettings()
+
+	return &containers.DeployConfig{
+		ContainerName:         containerName,
+		ImageTag:              *deployment.ImageTag,
+		Port:                  project.Port,
+		EnvVars:               envVars,
+		Slug:                  project.Slug,
+		Environment:           deployment.Environment,
+		BaseDomain:            baseDomain,
+		PRNumber:              prNumber,
+		BasicAuthUsers:        basicAuthUsers,
+		IPAllowlist:           ipAllowlist,
+		ReadinessProbeCmd:     stringOrEmpty(project.ReadinessProbeCmd),
+		ReadinessProbeTimeout: project.ReadinessProbeTimeout(),
+	}, nil
+}
+
+// Starts a deployment's container with the given deploy strategy
+// Blue-green keeps the old container serving until the new one is healthy;
+// anything else replaces it
+func StartDeploymentContainer(ctx context.Context, strategy string,
+	cfg *containers.DeployConfig) (string, error) {
+	if strategy != database.DeployStrategyBlueGreen {
+		return containers.Deploy(ctx, cfg)
+	}
+
+	containerID, oldContainerID, err := containers.BlueGreenDeploy(ctx, cfg)
+	if err == nil && oldContainerID != "" {
+		log.Info().
+			Str("container_id", containerID).
+			Str("old_container_id", oldContainerID).
+			Msg("Traffic cut over from old container")
+	}
+	return containerID, err
+}
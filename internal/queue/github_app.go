@@ -0,0 +1,112 @@
+package queue
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/github"
+	"github.com/rs/zerolog/log"
+)
+
+var ErrAppNotConfigured = errors.New("GitHub App not configured")
+
+// Installation tokens live 1 hour; refresh a bit early
+const installationTokenTTL = 50 * time.Minute
+
+// Returns the GitHub App's PEM private key
+// GITHUB_APP_PRIVATE_KEY (PEM contents) takes priority over
+// GITHUB_PRIVATE_KEY_PATH (path to a .pem file)
+func appPrivateKey() ([]byte, error) {
+	if key := os.Getenv("GITHUB_APP_PRIVATE_KEY"); key != "" {
+		return []byte(key), nil
+	}
+	if path := os.Getenv("GITHUB_PRIVATE_KEY_PATH"); path != "" {
+		return os.ReadFile(path)
+	}
+	return nil, ErrAppNotConfigured
+}
+
+// Returns a GitHub App installation token for the user, minting and
+// caching a new one when the cached token is missing or expired
+func GetInstallationAccessToken(ctx context.Context,
+	user *database.User) (string, error) {
+	if user.GitHubInstallationID == nil {
+		return "", errors.New("user has no GitHub App installation")
+	}
+
+	token, err := database.GetUserInstallationToken(ctx, user.ID)
+	if err != nil {
+		return "", err
+	}
+	if token != "" {
+		return token, nil
+	}
+
+	appID, err := strconv.ParseInt(os.Getenv("GITHUB_APP_ID"), 10, 64)
+	if err != nil {
+		return "", ErrAppNotConfigured
+	}
+	privateKey, err := appPrivateKey()
+	if err != nil {
+		return "", err
+	}
+
+	token, err = github.NewClient("").
+		SetMaxRateLimitWait(github.WorkerRateLimitWait).
+		GetInstallationToken(ctx, appID, *user.GitHubInstallationID,
+			privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	if err := database.SetUserInstallationToken(ctx, user.ID, token,
+		time.Now().Add(installationTokenTTL)); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Returns an installation token to clone a GitHub repo over HTTPS with,
+// or "" to clone anonymously: the repo isn't on GitHub, the project's
+// owner hasn't installed the app, or no token could be minted
+func cloneToken(ctx context.Context, projectID, cloneURL string) string {
+	if !strings.HasPrefix(cloneURL, "https://github.com/") {
+		return ""
+	}
+	project, err := database.GetProjectByID(ctx, projectID)
+	if err != nil {
+		return ""
+	}
+	user, err := database.GetUserByID(ctx, project.UserID)
+	if err != nil || user.GitHubInstallationID == nil {
+		return ""
+	}
+
+	token, err := GetInstallationAccessToken(ctx, user)
+	if err != nil {
+		log.Warn().Err(err).Str("project_id", projectID).
+			Msg("Failed to get installation token, cloning anonymously")
+		return ""
+	}
+	return token
+}
+
+// Environment passing an installation token to git as an auth header for
+// github.com only, so it's neither on the command line, written to the
+// checkout's config, nor sent to submodules hosted elsewhere
+func gitTokenEnv(token string) []string {
+	credentials := base64.StdEncoding.EncodeToString(
+		[]byte("x-access-token:" + token))
+	return append(os.Environ(),
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.https://github.com/.extraheader",
+		"GIT_CONFIG_VALUE_0=Authorization: Basic "+credentials,
+	)
+}
@@ -1,21 +1,37 @@
 package queue
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/Sys-Redux/rcnbuild-paas/internal/builds"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/cache"
 	"github.com/Sys-Redux/rcnbuild-paas/internal/containers"
 	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
-	"github.com/Sys-Redux/rcnbuild-paas/pkg/crypto"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"github.com/hibiken/asynq"
+	"github.com/jackc/pgx/v5"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ssh"
 )
 
+// Default cap on captured build output
+const defaultMaxBuildLogBytes = 10 << 20
+
 // Process build jobs
 func HandleBuildTask(ctx context.Context, t *asynq.Task) error {
 	var payload BuildPayload
@@ -29,11 +45,34 @@ func HandleBuildTask(ctx context.Context, t *asynq.Task) error {
 		Msg("Started processing build job")
 
 	// Update deployment status
-	if err := database.StartDeploymentBuild(ctx,
-		payload.DeploymentID); err != nil {
+	err := database.StartDeploymentBuild(ctx, payload.DeploymentID)
+	if errors.Is(err, database.ErrDeploymentCancelled) {
+		log.Info().Str("deployment_id", payload.DeploymentID).
+			Msg("Deployment cancelled before its build started")
+		return nil
+	}
+	if err != nil {
 		return fmt.Errorf("failed to start deployment build: %w", err)
 	}
 
+	// Kept for comparing deployments; not worth failing the build over
+	envKeys, err := database.GetEnvVarKeys(ctx, payload.ProjectID)
+	if err != nil {
+		log.Warn().Err(err).Str("deployment_id", payload.DeploymentID).
+			Msg("Failed to get env var keys for config snapshot")
+	}
+	if err := database.SetDeploymentBuildSnapshot(ctx, payload.DeploymentID,
+		&database.ConfigSnapshot{
+			BuildCommand: payload.BuildCommand,
+			StartCommand: payload.StartCommand,
+			Port:         payload.Port,
+			Runtime:      payload.Runtime,
+			EnvKeys:      envKeys,
+		}); err != nil {
+		log.Warn().Err(err).Str("deployment_id", payload.DeploymentID).
+			Msg("Failed to record config snapshot")
+	}
+
 	// Create build directory (temporary)
 	buildDir, err := os.MkdirTemp("", "recnbuild-*")
 	if err != nil {
@@ -42,10 +81,23 @@ func HandleBuildTask(ctx context.Context, t *asynq.Task) error {
 	}
 	defer os.RemoveAll(buildDir) // Cleanup after build
 
-	// Clone repo
+	// Clone repo (over SSH with the project's deploy key when available)
 	log.Info().Str("repo", payload.RepoFullName).Msg("Cloning repository")
-	if err := cloneRepo(ctx, payload.RepoCloneURL, payload.CommitSHA,
-		buildDir); err != nil {
+	deployKey, err := database.GetProjectDeployKey(ctx, payload.ProjectID)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to load deploy key, using HTTPS")
+		deployKey = ""
+	}
+	if deployKey != "" {
+		err = cloneRepoSSH(ctx, payload.RepoFullName, payload.CommitSHA,
+			buildDir, []byte(deployKey), payload.SubmodulesEnabled)
+	} else {
+		// Private repos need the GitHub App's installation token
+		token := cloneToken(ctx, payload.ProjectID, payload.RepoCloneURL)
+		err = cloneRepo(ctx, payload.RepoCloneURL, payload.CommitSHA,
+			buildDir, token, payload.SubmodulesEnabled)
+	}
+	if err != nil {
 		return failBuild(ctx, payload.DeploymentID,
 			"failed to clone repository", err)
 	}
@@ -58,7 +110,9 @@ func HandleBuildTask(ctx context.Context, t *asynq.Task) error {
 
 	// Make Dockerfile if it doesn't exist
 	dockerfilePath := filepath.Join(workDir, "Dockerfile")
+	generated := false
 	if _, err := os.Stat(dockerfilePath); os.IsNotExist(err) {
+		generated = true
 		log.Info().Str("runtime", payload.Runtime).Msg("Generating Dockerfile")
 		runtimeInfo := &builds.RuntimeInfo{
 			Runtime:      builds.Runtime(payload.Runtime),
@@ -66,8 +120,22 @@ func HandleBuildTask(ctx context.Context, t *asynq.Task) error {
 			StartCommand: payload.StartCommand,
 			Port:         payload.Port,
 		}
+		switch runtimeInfo.Runtime {
+		case builds.RuntimePython:
+			runtimeInfo.PackageManager =
+				builds.DetectPythonPackageManager(workDir)
+		case builds.RuntimeNodeJS:
+			builds.DetectNodePackage(workDir, runtimeInfo)
+		case builds.RuntimeElixir:
+			builds.DetectElixirProject(workDir, runtimeInfo)
+		case builds.RuntimeGo:
+			builds.DetectGoProject(workDir, runtimeInfo)
+		}
 		dockerfile := builds.GetDockerfileForRuntime(runtimeInfo,
 			payload.BuildCommand, payload.StartCommand)
+		dockerfile = builds.InjectBuildArgs(dockerfile, payload.BuildEnvVars)
+		dockerfile = builds.AddBuildSecretsHelp(dockerfile,
+			buildSecretKeys(payload.BuildSecrets))
 		if err := os.WriteFile(dockerfilePath, []byte(dockerfile),
 			0644); err != nil {
 			return failBuild(ctx, payload.DeploymentID,
@@ -75,6 +143,44 @@ func HandleBuildTask(ctx context.Context, t *asynq.Task) error {
 		}
 	}
 
+	// The build context defaults to the Dockerfile's directory
+	contextDir, err := filepath.EvalSymlinks(workDir)
+	if err != nil {
+		return failBuild(ctx, payload.DeploymentID,
+			"root directory not found", err)
+	}
+	if payload.BuildContext != "" {
+		rootContext := contextDir
+		contextDir, err = resolveBuildContext(buildDir, payload.BuildContext)
+		if err != nil {
+			return failBuild(ctx, payload.DeploymentID,
+				"invalid build context", err)
+		}
+		// Generated Dockerfiles COPY paths relative to the root directory
+		if generated && contextDir != rootContext {
+			return failBuild(ctx, payload.DeploymentID,
+				"invalid build context", errors.New("a generated "+
+					"Dockerfile builds from the root directory; add a "+
+					"Dockerfile or clear build_context"))
+		}
+	}
+	dockerfile, err := resolveDockerfile(buildDir, dockerfilePath)
+	if err != nil {
+		return failBuild(ctx, payload.DeploymentID,
+			"invalid Dockerfile", err)
+	}
+
+	// Make .dockerignore if it doesn't exist (keeps build context small)
+	dockerignorePath := filepath.Join(contextDir, ".dockerignore")
+	if _, err := os.Stat(dockerignorePath); os.IsNotExist(err) {
+		dockerignore := builds.GenerateDockerignore(
+			builds.Runtime(payload.Runtime))
+		if err := os.WriteFile(dockerignorePath, []byte(dockerignore),
+			0644); err != nil {
+			log.Warn().Err(err).Msg("Failed to write .dockerignore")
+		}
+	}
+
 	// Build container image
 	registryURL := os.Getenv("REGISTRY_URL")
 	if registryURL == "" {
@@ -82,29 +188,69 @@ func HandleBuildTask(ctx context.Context, t *asynq.Task) error {
 	}
 	imageTag := fmt.Sprintf("%s/%s:%s", registryURL,
 		payload.ProjectID, payload.CommitSHA[:8])
-	log.Info().Str("image", imageTag).Msg("Building container image")
-	if err := buildImage(ctx, workDir, imageTag); err != nil {
+	secretFiles, err := writeBuildSecrets(ctx, payload.ProjectID,
+		payload.BuildSecrets)
+	if err != nil {
+		return failBuild(ctx, payload.DeploymentID,
+			"failed to prepare build secrets", err)
+	}
+	// BUILD_PLATFORMS switches to a buildx build that pushes as it goes
+	platforms := containers.BuildPlatforms()
+	platform := containers.BuildPlatform()
+	if len(platforms) > 0 {
+		platform = strings.Join(platforms, ",")
+	}
+	log.Info().Str("image", imageTag).Str("platform", platform).
+		Msg("Building container image")
+	err = buildImage(ctx, payload.DeploymentID, contextDir, dockerfile,
+		imageTag, platforms, secretFiles)
+	// Secret values only live on disk for the duration of the build
+	removeBuildSecrets(secretFiles)
+	if err != nil {
 		return failBuild(ctx, payload.DeploymentID,
 			"failed to build container image", err)
 	}
 
-	// Push to docker registry
-	log.Info().Str("image", imageTag).Msg("Pushing to registry")
-	if err := pushImage(ctx, imageTag); err != nil {
-		return failBuild(ctx, payload.DeploymentID,
-			"failed to push container image", err)
+	// Push to docker registry (multi-platform builds already pushed)
+	if len(platforms) == 0 {
+		log.Info().Str("image", imageTag).Msg("Pushing to registry")
+		if err := pushImage(ctx, imageTag); err != nil {
+			return failBuild(ctx, payload.DeploymentID,
+				"failed to push container image", err)
+		}
 	}
 
-	// Update w/ image tag
-	if err := database.SetDeploymentBuilt(ctx, payload.DeploymentID,
-		imageTag); err != nil {
+	// Multi-platform images only exist in the registry, so aren't sized
+	var imageSize *int64
+	if len(platforms) == 0 {
+		if size, err := containers.ImageSize(ctx, imageTag); err != nil {
+			log.Warn().Err(err).Str("image", imageTag).
+				Msg("Failed to get image size")
+		} else {
+			imageSize = &size
+		}
+	}
+
+	// Update w/ image tag, platform & size
+	err = database.SetDeploymentBuilt(ctx, payload.DeploymentID,
+		imageTag, platform, imageSize)
+	if errors.Is(err, database.ErrDeploymentCancelled) {
+		// The image stays for the cleanup job; nothing is deployed
+		log.Info().Str("deployment_id", payload.DeploymentID).
+			Msg("Deployment cancelled during its build")
+		return nil
+	}
+	if err != nil {
 		return fmt.Errorf("failed to set deployment built: %w", err)
 	}
 
-	log.Info().
+	buildLog := log.Info().
 		Str("deployment_id", payload.DeploymentID).
-		Str("image", imageTag).
-		Msg("Build completed successfully")
+		Str("image", imageTag)
+	if imageSize != nil {
+		buildLog = buildLog.Int64("image_size_bytes", *imageSize)
+	}
+	buildLog.Msg("Build completed successfully")
 
 	// Get project for deploy info
 	project, err := database.GetProjectByID(ctx, payload.ProjectID)
@@ -114,16 +260,32 @@ func HandleBuildTask(ctx context.Context, t *asynq.Task) error {
 
 	// Enqueue deploy job
 	_, err = EnqueueDeploy(ctx, &DeployPayload{
-		DeploymentID: payload.DeploymentID,
-		ProjectID:    payload.ProjectID,
-		ProjectSlug:  project.Slug,
-		ImageTag:     imageTag,
-		Port:         payload.Port,
+		DeploymentID:   payload.DeploymentID,
+		ProjectID:      payload.ProjectID,
+		ProjectSlug:    project.Slug,
+		Environment:    project.Environment,
+		ImageTag:       imageTag,
+		Port:           payload.Port,
+		DeployStrategy: project.DeployStrategy,
+		PRNumber:       payload.PRNumber,
+		BaseDomain:     stringOrEmpty(project.BaseDomain),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to enqueue deploy job: %w", err)
 	}
 
+	// Enqueue image cleanup job (failure doesn't affect the deployment)
+	_, err = EnqueueCleanupImages(ctx, &CleanupImagesPayload{
+		ProjectID:   payload.ProjectID,
+		RegistryURL: registryURL,
+		KeepCount:   DefaultImageKeepCount,
+	})
+	if err != nil {
+		log.Warn().Err(err).
+			Str("project_id", payload.ProjectID).
+			Msg("Failed to enqueue image cleanup job")
+	}
+
 	return nil
 }
 
@@ -140,52 +302,80 @@ func HandleDeployTask(ctx context.Context, t *asynq.Task) error {
 		Msg("Starting deployment")
 
 	// Update deployment status
-	if err := database.UpdateDeploymentStatus(ctx, payload.DeploymentID,
-		database.DeploymentStatusDeploying, nil); err != nil {
+	err := database.StartDeploymentDeploy(ctx, payload.DeploymentID)
+	if errors.Is(err, database.ErrDeploymentCancelled) {
+		log.Info().Str("deployment_id", payload.DeploymentID).
+			Msg("Deployment cancelled before its deploy started")
+		return nil
+	}
+	if err != nil {
 		return fmt.Errorf("failed to update deployment status: %w", err)
 	}
 
-	// Fetch env vars for the project
-	envVars, err := database.GetEnvVarsAsMap(ctx, payload.ProjectID,
-		crypto.Decrypt)
+	// Last check before the running container is replaced
+	deployment, err := database.GetDeploymentByID(ctx, payload.DeploymentID)
+	if err != nil {
+		return failDeploy(ctx, payload.DeploymentID,
+			"failed to fetch deployment", err)
+	}
+	if deployment.Status == database.DeploymentStatusCancelled {
+		log.Info().Str("deployment_id", payload.DeploymentID).
+			Msg("Deployment cancelled before its container started")
+		return nil
+	}
+
+	project, err := database.GetProjectByID(ctx, payload.ProjectID)
 	if err != nil {
 		return failDeploy(ctx, payload.DeploymentID,
-			"failed to fetch environment variables", err)
+			"failed to fetch project", err)
 	}
 
-	// add PORT to env
-	envVars["PORT"] = fmt.Sprintf("%d", payload.Port)
+	deployCfg, err := DeployConfigFor(ctx, project, deployment)
+	if err != nil {
+		return failDeploy(ctx, payload.DeploymentID,
+			"failed to prepare container config", err)
+	}
 
-	// Deploy container
-	baseDomain := os.Getenv("BASE_DOMAIN")
-	if baseDomain == "" {
-		baseDomain = "rcnbuild.dev"
+	// Only the user's own keys, not PORT & platform vars
+	envKeys, err := database.GetEnvVarKeys(ctx, payload.ProjectID)
+	if err == nil {
+		err = database.SetDeploymentEnvKeys(ctx, payload.DeploymentID,
+			envKeys)
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("deployment_id", payload.DeploymentID).
+			Msg("Failed to record env var keys")
 	}
 
-	containerID, err := containers.Deploy(ctx, &containers.DeployConfig{
-		ContainerName: fmt.Sprintf("rcn-%s", payload.ProjectSlug),
-		ImageTag:      payload.ImageTag,
-		Port:          payload.Port,
-		EnvVars:       envVars,
-		Slug:          payload.ProjectSlug,
-		BaseDomain:    baseDomain,
-	})
+	containerID, err := StartDeploymentContainer(ctx,
+		payload.DeployStrategy, deployCfg)
 	if err != nil {
 		return failDeploy(ctx, payload.DeploymentID,
 			"failed to deploy container", err)
 	}
 
-	// Mark old deployments superseded
-	if err := database.SupersededOldDeployments(ctx, payload.ProjectID,
-		payload.DeploymentID); err != nil {
-		return fmt.Errorf("failed to supersede old deployments: %w", err)
+	// Supersede the old live deployment & mark this one live
+	deployURL := "https://" + containers.RouteHostname(deployCfg)
+	if payload.PRNumber > 0 {
+		err = database.PromotePreviewDeploymentToLive(ctx, payload.ProjectID,
+			payload.DeploymentID, payload.PRNumber, containerID, deployURL)
+	} else {
+		err = database.PromoteDeploymentToLive(ctx, payload.ProjectID,
+			payload.DeploymentID, containerID, deployURL)
 	}
-
-	// Update deployment as live
-	deployURL := fmt.Sprintf("https://%s.%s", payload.ProjectSlug, baseDomain)
-	if err := database.SetDeploymentLive(ctx, payload.DeploymentID,
-		containerID, deployURL); err != nil {
-		return fmt.Errorf("failed to set deployment deployed: %w", err)
+	if errors.Is(err, database.ErrDeploymentCancelled) {
+		// Cancelled while the container started; don't leave it serving
+		log.Info().Str("deployment_id", payload.DeploymentID).
+			Str("container_id", containerID).
+			Msg("Deployment cancelled during deploy, removing container")
+		if err := containers.Remove(ctx, containerID); err != nil {
+			log.Warn().Err(err).Str("container_id", containerID).
+				Msg("Failed to remove cancelled deployment's container")
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to promote deployment to live: %w", err)
 	}
 
 	log.Info().
@@ -194,46 +384,569 @@ func HandleDeployTask(ctx context.Context, t *asynq.Task) error {
 		Str("url", deployURL).
 		Msg("Deployment completed successfully")
 
+	notifyDeployment(ctx, payload.DeploymentID, EventDeploymentLive)
+
+	// Keep the container's output after it's replaced or removed
+	go persistRuntimeLogs(payload.DeploymentID, containerID, "")
+
+	return nil
+}
+
+// Process project resume jobs
+// Restarts the live deployment's image (if any) without a new build, then
+// clears paused_at. The project stays paused until the container is up,
+// so a failed resume can simply be retried
+func HandleResumeProjectTask(ctx context.Context, t *asynq.Task) error {
+	var payload ResumeProjectPayload
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal resume payload: %w", err)
+	}
+
+	project, err := database.GetProjectByID(ctx, payload.ProjectID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch project: %w", err)
+	}
+	if project.PausedAt == nil {
+		log.Info().Str("project_id", project.ID).
+			Msg("Project no longer paused, nothing to resume")
+		return nil
+	}
+
+	deployment, err := database.GetLiveDeployment(ctx, project.ID)
+	if err == nil && deployment.ImageTag != nil {
+		deployCfg, err := DeployConfigFor(ctx, project, deployment)
+		if err != nil {
+			return fmt.Errorf("failed to prepare container config: %w", err)
+		}
+
+		containerID, err := StartDeploymentContainer(ctx,
+			project.DeployStrategy, deployCfg)
+		if err != nil {
+			return fmt.Errorf("failed to restart container: %w", err)
+		}
+
+		if err := database.SetDeploymentContainerID(ctx, deployment.ID,
+			containerID); err != nil {
+			log.Error().Err(err).Str("deployment_id", deployment.ID).
+				Msg("Failed to update deployment container")
+		}
+
+		// Keep the container's output after it's replaced or removed
+		go persistRuntimeLogs(deployment.ID, containerID, "")
+	}
+
+	if err := database.SetProjectPaused(ctx, project.ID, false); err != nil {
+		return fmt.Errorf("failed to mark project resumed: %w", err)
+	}
+
+	log.Info().Str("project_id", project.ID).Msg("Project resumed")
+	return nil
+}
+
+// Prefix reserved for platform-provided env vars
+const platformEnvPrefix = "RCNBUILD_"
+
+// Adds platform env vars, overriding any user-defined RCNBUILD_* keys
+// User keys with the prefix are kept unless they clash, but warned about
+func setPlatformEnvVars(envVars map[string]string, deploymentID string,
+	platform map[string]string) {
+	for key := range envVars {
+		if strings.HasPrefix(key, platformEnvPrefix) {
+			log.Warn().
+				Str("deployment_id", deploymentID).
+				Str("key", key).
+				Msg("User env var uses the reserved RCNBUILD_ prefix")
+		}
+	}
+	for key, value := range platform {
+		envVars[key] = value
+	}
+}
+
+// Process image cleanup jobs
+// Keeps the most recent KeepCount images for a project & removes the rest
+func HandleCleanupImagesTask(ctx context.Context, t *asynq.Task) error {
+	var payload CleanupImagesPayload
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal cleanup payload: %w", err)
+	}
+
+	keepCount := payload.KeepCount
+	if keepCount <= 0 {
+		keepCount = DefaultImageKeepCount
+	}
+
+	deployments, err := database.GetDeploymentsByProjectID(ctx,
+		payload.ProjectID, 100)
+	if err != nil {
+		log.Warn().Err(err).Str("project_id", payload.ProjectID).
+			Msg("Failed to get deployments for image cleanup")
+		return nil
+	}
+
+	// Collect unique image tags, newest first
+	seen := make(map[string]bool)
+	var imageTags []string
+	liveTags := make(map[string]bool)
+	for _, d := range deployments {
+		if d.ImageTag == nil || *d.ImageTag == "" {
+			continue
+		}
+		if d.Status == database.DeploymentStatusLive {
+			liveTags[*d.ImageTag] = true
+		}
+		if seen[*d.ImageTag] {
+			continue
+		}
+		seen[*d.ImageTag] = true
+		imageTags = append(imageTags, *d.ImageTag)
+	}
+
+	if len(imageTags) <= keepCount {
+		return nil
+	}
+
+	removed := 0
+	for _, tag := range imageTags[keepCount:] {
+		// Never remove the image backing the live container
+		if liveTags[tag] {
+			continue
+		}
+		if payload.RegistryURL != "" &&
+			!strings.HasPrefix(tag, payload.RegistryURL+"/") {
+			continue
+		}
+		if err := containers.RemoveImage(ctx, tag); err != nil {
+			log.Warn().Err(err).Str("image", tag).
+				Msg("Failed to remove old image")
+			continue
+		}
+		removed++
+	}
+
+	log.Info().
+		Str("project_id", payload.ProjectID).
+		Int("removed", removed).
+		Int("kept", keepCount).
+		Msg("Image cleanup completed")
+
+	return nil
+}
+
+// Process container cleanup jobs
+// Removes managed containers whose project was deleted or whose
+// deployment was superseded/cancelled
+func HandleCleanupContainersTask(ctx context.Context, t *asynq.Task) error {
+	managed, err := containers.ListManagedContainers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list managed containers: %w", err)
+	}
+
+	removed := 0
+	for _, c := range managed {
+		reason := ""
+
+		_, err := database.GetProjectBySlug(ctx, c.Slug)
+		if errors.Is(err, pgx.ErrNoRows) {
+			reason = "project deleted"
+		} else if err != nil {
+			log.Warn().Err(err).Str("slug", c.Slug).
+				Msg("Failed to look up project for container")
+			continue
+		} else {
+			deployment, err := database.GetDeploymentByContainerID(ctx, c.ID)
+			if err == nil && (deployment.Status ==
+				database.DeploymentStatusSuperseded ||
+				deployment.Status == database.DeploymentStatusCancelled) {
+				reason = "deployment " + string(deployment.Status)
+			}
+		}
+
+		if reason == "" {
+			continue
+		}
+
+		if err := containers.Stop(ctx, c.ID); err != nil {
+			log.Warn().Err(err).Str("container_id", c.ID[:12]).
+				Msg("Failed to stop container")
+		}
+		if err := containers.Remove(ctx, c.ID); err != nil {
+			log.Warn().Err(err).Str("container_id", c.ID[:12]).
+				Msg("Failed to remove container")
+			continue
+		}
+
+		log.Info().
+			Str("container_id", c.ID[:12]).
+			Str("slug", c.Slug).
+			Str("reason", reason).
+			Msg("Removed orphaned container")
+		removed++
+	}
+
+	log.Info().
+		Int("checked", len(managed)).
+		Int("removed", removed).
+		Msg("Container cleanup completed")
+
+	return nil
+}
+
+// Days webhook deliveries are kept unless WEBHOOK_DELIVERY_RETENTION_DAYS
+// says otherwise. GitHub only redelivers within a few days, so older
+// copies are only useful for replay
+const defaultWebhookDeliveryRetentionDays = 30
+
+// How long webhook deliveries are kept (WEBHOOK_DELIVERY_RETENTION_DAYS)
+func webhookDeliveryRetention() time.Duration {
+	days := defaultWebhookDeliveryRetentionDays
+	v, err := strconv.Atoi(os.Getenv("WEBHOOK_DELIVERY_RETENTION_DAYS"))
+	if err == nil && v > 0 {
+		days = v
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Process webhook delivery retention jobs: deletes deliveries older than
+// the retention period
+func HandlePruneWebhookDeliveriesTask(ctx context.Context,
+	t *asynq.Task) error {
+	deleted, err := database.DeleteWebhookDeliveriesBefore(ctx,
+		time.Now().Add(-webhookDeliveryRetention()))
+	if err != nil {
+		return fmt.Errorf("failed to prune webhook deliveries: %w", err)
+	}
+
+	log.Info().Int64("deleted", deleted).Msg("Pruned webhook deliveries")
+	return nil
+}
+
+// Process frozen deployment release jobs
+// Builds the newest frozen deployment of each project whose freeze window
+// has ended & cancels older ones (they'd be superseded immediately anyway)
+func HandleReleaseFrozenTask(ctx context.Context, t *asynq.Task) error {
+	frozen, err := database.GetFrozenDeployments(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get frozen deployments: %w", err)
+	}
+
+	now := time.Now()
+	handled := make(map[string]bool)
+	for _, d := range frozen {
+		if handled[d.ProjectID] {
+			if err := database.CancelDeployment(ctx, d.ID); err != nil {
+				log.Warn().Err(err).Str("deployment_id", d.ID).
+					Msg("Failed to cancel stale frozen deployment")
+			}
+			continue
+		}
+
+		project, err := database.GetProjectByID(ctx, d.ProjectID)
+		if err != nil {
+			log.Warn().Err(err).Str("project_id", d.ProjectID).
+				Msg("Failed to get project for frozen deployment")
+			continue
+		}
+		if project.IsFrozen(now) {
+			continue
+		}
+		handled[d.ProjectID] = true
+
+		if err := database.ReleaseFrozenDeployment(ctx, d.ID); err != nil {
+			log.Warn().Err(err).Str("deployment_id", d.ID).
+				Msg("Failed to release frozen deployment")
+			continue
+		}
+
+		if _, err := EnqueueBuild(ctx, NewBuildPayload(project, d)); err != nil {
+			log.Error().Err(err).Str("deployment_id", d.ID).
+				Msg("Failed to enqueue released deployment")
+			continue
+		}
+
+		log.Info().
+			Str("deployment_id", d.ID).
+			Str("project_id", d.ProjectID).
+			Msg("Released frozen deployment")
+	}
+
+	return nil
+}
+
+// Process deployment metrics refresh jobs
+func HandleRefreshMetricsTask(ctx context.Context, t *asynq.Task) error {
+	start := time.Now()
+	if err := database.RefreshDeploymentMetrics(ctx); err != nil {
+		return fmt.Errorf("failed to refresh deployment metrics: %w", err)
+	}
+
+	log.Debug().Dur("took", time.Since(start)).
+		Msg("Refreshed deployment metrics")
+	return nil
+}
+
+// Process the nightly certificate check: warn projects whose certificate
+// expires within CertCriticalDays via their notification URL
+func HandleCheckCertificatesTask(ctx context.Context, t *asynq.Task) error {
+	if !containers.TLSEnabled() {
+		return nil
+	}
+
+	projects, err := database.GetLiveProjects(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get live projects: %w", err)
+	}
+
+	expiring := 0
+	for _, project := range projects {
+		hostname := containers.ProjectHostname(project.Slug,
+			project.Environment, stringOrEmpty(project.BaseDomain))
+		cert, err := containers.InspectCertificate(ctx, hostname)
+		if err != nil {
+			log.Warn().Err(err).Str("project_id", project.ID).
+				Str("domain", hostname).
+				Msg("Failed to inspect TLS certificate")
+			continue
+		}
+		if !cert.Valid {
+			log.Warn().Str("project_id", project.ID).Str("domain", hostname).
+				Str("reason", cert.VerifyError).
+				Msg("TLS certificate doesn't verify")
+		}
+		if cert.DaysRemaining >= containers.CertCriticalDays {
+			continue
+		}
+
+		expiring++
+		log.Warn().Str("project_id", project.ID).Str("domain", hostname).
+			Int("days_remaining", cert.DaysRemaining).
+			Msg("TLS certificate expiring soon")
+		notifyCertificateExpiring(ctx, project, cert)
+	}
+
+	log.Info().Int("checked", len(projects)).Int("expiring", expiring).
+		Msg("Checked TLS certificates")
 	return nil
 }
 
 // Helper functions
 // Clone repo
-func cloneRepo(ctx context.Context, cloneURL, commitSHA,
-	destDir string) error {
-	cmd := exec.CommandContext(ctx, "git", "clone", "--depth", "1",
-		cloneURL, destDir)
+// Submodules are only initialized when opted in (they slow clones down)
+// A non-empty token authenticates requests to github.com
+func cloneRepo(ctx context.Context, cloneURL, commitSHA, destDir,
+	token string, submodules bool) error {
+	git := func(args ...string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		if token != "" {
+			cmd.Env = gitTokenEnv(token)
+		}
+		return cmd
+	}
+
+	cmd := git("clone", "--depth", "1", cloneURL, destDir)
 	if output, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("git clone failed: %s, %w", string(output), err)
 	}
 
 	// Fetch specific commit if not HEAD
-	fetchCmd := exec.CommandContext(ctx, "git", "-C", destDir,
-		"fetch", "origin", commitSHA)
+	fetchCmd := git("-C", destDir, "fetch", "origin", commitSHA)
 	// Ignore error if commit is HEAD
 	fetchCmd.CombinedOutput()
 
 	// Checkout specific commit
-	checkoutCmd := exec.CommandContext(ctx, "git", "-C", destDir,
-		"checkout", commitSHA)
+	checkoutCmd := git("-C", destDir, "checkout", commitSHA)
 	if output, err := checkoutCmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("git checkout failed: %s, %w", string(output), err)
 	}
 
+	if submodules && hasSubmodules(destDir) {
+		submoduleCmd := git("-C", destDir, "submodule", "update",
+			"--init", "--recursive", "--depth", "1")
+		if output, err := submoduleCmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git submodule update failed: %s, %w",
+				string(output), err)
+		}
+	}
+
 	return nil
 }
 
-// Build container image using Docker CLI
-func buildImage(ctx context.Context, workDir, imageTag string) error {
-	cmd := exec.CommandContext(ctx, "docker", "build", "-t", imageTag, ".")
-	cmd.Dir = workDir
-	output, err := cmd.CombinedOutput()
+// Reports whether a checked-out repo declares any submodules
+func hasSubmodules(repoDir string) bool {
+	_, err := os.Stat(filepath.Join(repoDir, ".gitmodules"))
+	return err == nil
+}
+
+// GitHub's published ed25519 SSH host key
+const githubHostKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl"
+
+// Clone repo over SSH using a deploy key & checkout the commit
+// Submodules are fetched with the same key, so they must be reachable by it
+func cloneRepoSSH(ctx context.Context, repoFullName, commitSHA,
+	destDir string, privateKey []byte, submodules bool) error {
+	auth, err := gitssh.NewPublicKeys("git", privateKey, "")
+	if err != nil {
+		return fmt.Errorf("invalid deploy key: %w", err)
+	}
+
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(githubHostKey))
 	if err != nil {
-		return fmt.Errorf("docker build failed: %s, %w", string(output), err)
+		return fmt.Errorf("invalid GitHub host key: %w", err)
+	}
+	auth.HostKeyCallback = ssh.FixedHostKey(hostKey)
+	auth.HostKeyAlgorithms = []string{ssh.KeyAlgoED25519}
+
+	repo, err := git.PlainCloneContext(ctx, destDir, false, &git.CloneOptions{
+		URL:  fmt.Sprintf("git@github.com:%s.git", repoFullName),
+		Auth: auth,
+	})
+	if err != nil {
+		return fmt.Errorf("git clone failed: %w", err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("git worktree failed: %w", err)
+	}
+
+	if err := worktree.Checkout(&git.CheckoutOptions{
+		Hash: plumbing.NewHash(commitSHA),
+	}); err != nil {
+		return fmt.Errorf("git checkout failed: %w", err)
+	}
+
+	if !submodules || !hasSubmodules(destDir) {
+		return nil
+	}
+
+	return updateSubmodules(ctx, worktree, auth,
+		git.DefaultSubmoduleRecursionDepth)
+}
+
+// Initializes & shallow-fetches a worktree's submodules, recursing up to
+// depth levels. The deploy key only authenticates SSH remotes (including
+// relative URLs, which resolve against the SSH origin); http(s) submodules
+// are fetched anonymously, as the key means nothing to them
+func updateSubmodules(ctx context.Context, worktree *git.Worktree,
+	sshAuth transport.AuthMethod, depth git.SubmoduleRescursivity) error {
+	subs, err := worktree.Submodules()
+	if err != nil {
+		return fmt.Errorf("git submodule list failed: %w", err)
+	}
+	for _, sm := range subs {
+		var auth transport.AuthMethod
+		if !isHTTPRemote(sm.Config().URL) {
+			auth = sshAuth
+		}
+		if err := sm.UpdateContext(ctx, &git.SubmoduleUpdateOptions{
+			Init:  true,
+			Depth: 1,
+			Auth:  auth,
+		}); err != nil {
+			return fmt.Errorf("git submodule update failed for %s: %w",
+				sm.Config().Name, err)
+		}
+
+		if depth <= 1 {
+			continue
+		}
+		subRepo, err := sm.Repository()
+		if err != nil {
+			return fmt.Errorf("git submodule open failed for %s: %w",
+				sm.Config().Name, err)
+		}
+		subWorktree, err := subRepo.Worktree()
+		if err != nil {
+			return fmt.Errorf("git worktree failed for %s: %w",
+				sm.Config().Name, err)
+		}
+		if err := updateSubmodules(ctx, subWorktree, sshAuth,
+			depth-1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reports whether a remote URL is fetched over http(s)
+func isHTTPRemote(url string) bool {
+	return strings.HasPrefix(url, "https://") ||
+		strings.HasPrefix(url, "http://")
+}
+
+// Build container image using the Docker SDK (or the CLI for secrets &
+// multi-platform builds, which buildx pushes straight to the registry)
+// Output is published live to Redis as it arrives, capped at
+// MAX_BUILD_LOG_BYTES & saved to deployment_logs once the build ends
+func buildImage(ctx context.Context, deploymentID, contextDir, dockerfile,
+	imageTag string, platforms []string,
+	secretFiles map[string]string) error {
+	var logReader io.ReadCloser
+	var err error
+	if len(platforms) > 0 {
+		logReader, err = containers.BuildMultiPlatformImage(ctx, contextDir,
+			dockerfile, imageTag, platforms, secretFiles)
+	} else if len(secretFiles) > 0 {
+		logReader, err = containers.BuildImageWithSecrets(ctx, contextDir,
+			dockerfile, imageTag, secretFiles)
+	} else {
+		logReader, err = containers.BuildImage(ctx, contextDir, dockerfile,
+			imageTag)
+	}
+	if err != nil {
+		return fmt.Errorf("docker build failed: %w", err)
+	}
+	defer logReader.Close()
+
+	// Lines go out live as they're read; the buffer is the saved snapshot
+	live := builds.NewLogWriter(ctx, cache.Client(), deploymentID)
+	var buf bytes.Buffer
+
+	// Read one byte past the limit to detect runaway output
+	maxLogBytes := maxBuildLogBytes()
+	_, buildErr := io.Copy(io.MultiWriter(&buf, live),
+		io.LimitReader(logReader, maxLogBytes+1))
+	output := buf.Bytes()
+	truncated := int64(len(output)) > maxLogBytes
+	if truncated {
+		// Back off to a rune boundary so the saved log stays valid UTF-8
+		cut := maxLogBytes
+		for cut > 0 && cut > maxLogBytes-utf8.UTFMax &&
+			!utf8.RuneStart(output[cut]) {
+			cut--
+		}
+		output = append(output[:cut], "\n[log truncated]\n"...)
+		live.Write([]byte("\n[log truncated]\n"))
+	}
+
+	// Saved before the stream ends, so late subscribers find the snapshot
+	if err := database.SaveDeploymentLog(context.WithoutCancel(ctx),
+		deploymentID, string(output), truncated); err != nil {
+		log.Warn().Err(err).Str("deployment_id", deploymentID).
+			Msg("Failed to save build log")
+	}
+	live.Close()
+
+	if truncated {
+		return fmt.Errorf("build output exceeded %d bytes", maxLogBytes)
+	}
+	if buildErr != nil {
+		return fmt.Errorf("docker build failed: %w", buildErr)
 	}
 	return nil
 }
 
+// Max build output kept per deployment (MAX_BUILD_LOG_BYTES, default 10 MB)
+func maxBuildLogBytes() int64 {
+	if v := os.Getenv("MAX_BUILD_LOG_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultMaxBuildLogBytes
+}
+
 // Push docker image
 func pushImage(ctx context.Context, imageTag string) error {
 	cmd := exec.CommandContext(ctx, "docker", "push", imageTag)
@@ -244,13 +957,76 @@ func pushImage(ctx context.Context, imageTag string) error {
 	return nil
 }
 
+// Returns the absolute build context for a repo-relative directory
+// Symlinks are resolved first so the context can't escape the checkout
+func resolveBuildContext(buildDir, dir string) (string, error) {
+	root, err := filepath.EvalSymlinks(buildDir)
+	if err != nil {
+		return "", err
+	}
+	contextDir, err := filepath.EvalSymlinks(filepath.Join(root, dir))
+	if err != nil {
+		return "", fmt.Errorf("build context %q not found", dir)
+	}
+	if !isWithinDir(root, contextDir) {
+		return "", fmt.Errorf("build context %q is outside the repository",
+			dir)
+	}
+	if info, err := os.Stat(contextDir); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("build context %q is not a directory", dir)
+	}
+	return contextDir, nil
+}
+
+// Returns the Dockerfile's resolved path. It may sit outside contextDir
+// (e.g. at the repo root with a subdirectory as context) - the build
+// sends it alongside the context, as `docker build -f` does
+func resolveDockerfile(buildDir, dockerfilePath string) (string, error) {
+	root, err := filepath.EvalSymlinks(buildDir)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(dockerfilePath)
+	if err != nil {
+		return "", err
+	}
+	// A symlinked Dockerfile must not pull in files from the worker
+	if !isWithinDir(root, resolved) {
+		return "", errors.New("Dockerfile is outside the repository")
+	}
+	return resolved, nil
+}
+
+// Reports whether path is dir or inside it (both already resolved)
+func isWithinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." &&
+		!strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 // Fail build helper
+// Transient failures are left to asynq's retries with the deployment still
+// building; it's only marked failed on a terminal error or the last attempt
 func failBuild(ctx context.Context, deploymentID,
 	message string, err error) error {
 	fullMessage := fmt.Sprintf("%s: %v", message, err)
+	retriable := isRetriableBuildError(err)
+	if retriable && !retriesExhausted(ctx) {
+		log.Warn().Err(err).Str("deployment_id", deploymentID).
+			Msg(message + ", will retry")
+		return errors.New(fullMessage)
+	}
+
 	log.Error().Err(err).Str("deployment_id", deploymentID).Msg(message)
-	database.SetDeploymentFailed(ctx, deploymentID, fullMessage)
-	return fmt.Errorf(fullMessage)
+	// Not updated if the deployment was cancelled - nothing to notify
+	if database.SetDeploymentFailed(ctx, deploymentID, fullMessage) == nil {
+		notifyDeployment(ctx, deploymentID, EventDeploymentFailed)
+	}
+	if !retriable {
+		// Rebuilding the same commit would fail the same way
+		return fmt.Errorf("%s: %w", fullMessage, asynq.SkipRetry)
+	}
+	return errors.New(fullMessage)
 }
 
 // Fail deploy helper
@@ -258,6 +1034,9 @@ func failDeploy(ctx context.Context, deploymentID,
 	message string, err error) error {
 	fullMessage := fmt.Sprintf("%s: %v", message, err)
 	log.Error().Err(err).Str("deployment_id", deploymentID).Msg(message)
-	database.SetDeploymentFailed(ctx, deploymentID, fullMessage)
-	return fmt.Errorf(fullMessage)
+	// Not updated if the deployment was cancelled - nothing to notify
+	if database.SetDeploymentFailed(ctx, deploymentID, fullMessage) == nil {
+		notifyDeployment(ctx, deploymentID, EventDeploymentFailed)
+	}
+	return errors.New(fullMessage)
 }
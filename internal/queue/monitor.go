@@ -0,0 +1,37 @@
+package queue
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+
+	"github.com/hibiken/asynqmon"
+)
+
+// Create the Asynq web UI (queue depths, failed tasks, retries) rooted at
+// rootPath, using the Redis connection from Connect
+// Caller must Close the handler on shutdown
+func NewMonitor(rootPath string) *asynqmon.HTTPHandler {
+	return asynqmon.New(asynqmon.Options{
+		RootPath:     rootPath,
+		RedisConnOpt: redisOpt,
+	})
+}
+
+// Wraps next in HTTP Basic Auth against ADMIN_USERNAME & ADMIN_PASSWORD
+// Every request is rejected while either is unset
+func MonitorAuthRequired(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username := os.Getenv("ADMIN_USERNAME")
+		password := os.Getenv("ADMIN_PASSWORD")
+		user, pass, ok := r.BasicAuth()
+		if !ok || username == "" || password == "" ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="rcnbuild-queues"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,213 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/containers"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/pkg/crypto"
+	"github.com/hibiken/asynq"
+	"github.com/rs/zerolog/log"
+)
+
+// Outbound notification events
+const (
+	EventDeploymentLive      = "deployment.live"
+	EventDeploymentFailed    = "deployment.failed"
+	EventCertificateExpiring = "certificate.expiring"
+)
+
+// Delay before the single retry of a failed notification
+const notificationRetryDelay = 30 * time.Second
+
+var ErrPrivateAddress = errors.New(
+	"notification URL resolves to a non-public address")
+
+// Notification URLs are user-supplied, so the client only connects to
+// public addresses. The check runs on the resolved IP at connect time, so
+// DNS rebinding & redirects can't reach internal services either. No
+// proxy: the check would only ever see the proxy's address.
+var notificationClient = func() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: rejectNonPublic,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Transport: transport, Timeout: 10 * time.Second}
+}()
+
+// net.Dialer.Control refusing loopback, private, link-local, multicast &
+// unspecified addresses
+func rejectNonPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, ip)
+	}
+	return nil
+}
+
+// Body POSTed to a project's notification URL
+type DeploymentNotification struct {
+	Event        string  `json:"event"`
+	DeploymentID string  `json:"deployment_id"`
+	ProjectID    string  `json:"project_id"`
+	URL          *string `json:"url"`
+	CommitSHA    string  `json:"commit_sha"`
+	ErrorMessage *string `json:"error_message"`
+}
+
+// Body POSTed when a project's TLS certificate is close to expiry
+type CertificateNotification struct {
+	Event         string    `json:"event"`
+	ProjectID     string    `json:"project_id"`
+	Domain        string    `json:"domain"`
+	ValidUntil    time.Time `json:"valid_until"`
+	DaysRemaining int       `json:"days_remaining"`
+}
+
+// Sends a deployment event to the project's notification URL (if set)
+// A failed attempt is retried once after 30 seconds (see deliverNotification)
+func notifyDeployment(ctx context.Context, deploymentID, event string) {
+	deployment, err := database.GetDeploymentByID(ctx, deploymentID)
+	if err != nil {
+		log.Warn().Err(err).Str("deployment_id", deploymentID).
+			Msg("Failed to load deployment for notification")
+		return
+	}
+
+	project, err := database.GetProjectByID(ctx, deployment.ProjectID)
+	if err != nil || project.NotificationURL == nil {
+		return
+	}
+
+	body, err := json.Marshal(&DeploymentNotification{
+		Event:        event,
+		DeploymentID: deployment.ID,
+		ProjectID:    project.ID,
+		URL:          deployment.URL,
+		CommitSHA:    deployment.CommitSHA,
+		ErrorMessage: deployment.ErrorMessage,
+	})
+	if err != nil {
+		return
+	}
+	deliverNotification(ctx, project, body)
+}
+
+// Warns the project's notification URL (if set) of an expiring certificate
+func notifyCertificateExpiring(ctx context.Context, project *database.Project,
+	cert *containers.CertificateInfo) {
+	if project.NotificationURL == nil {
+		return
+	}
+
+	body, err := json.Marshal(&CertificateNotification{
+		Event:         EventCertificateExpiring,
+		ProjectID:     project.ID,
+		Domain:        cert.Domain,
+		ValidUntil:    cert.ValidUntil,
+		DaysRemaining: cert.DaysRemaining,
+	})
+	if err != nil {
+		return
+	}
+	deliverNotification(ctx, project, body)
+}
+
+// Sends a notification body, queueing a single retry if the attempt fails
+func deliverNotification(ctx context.Context, project *database.Project,
+	body []byte) {
+	err := sendNotification(ctx, project, body)
+	if err == nil {
+		return
+	}
+	log.Warn().Err(err).Str("project_id", project.ID).
+		Msg("Failed to send notification, retrying in 30s")
+
+	task, err := NewNotifyTask(&NotifyPayload{
+		ProjectID: project.ID,
+		Body:      body,
+	})
+	if err != nil {
+		return
+	}
+	if _, err := client.EnqueueContext(ctx, task,
+		asynq.ProcessIn(notificationRetryDelay)); err != nil {
+		log.Warn().Err(err).Msg("Failed to enqueue notification retry")
+	}
+}
+
+// POST the body to the notification URL, signed with the project's secret
+func sendNotification(ctx context.Context, project *database.Project,
+	body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		*project.NotificationURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "RCNbuild-Notifications/1.0")
+
+	if project.NotificationSecret != nil {
+		secret, err := crypto.Decrypt(*project.NotificationSecret)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt notification secret: %w", err)
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-RCNbuild-Signature",
+			"sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := notificationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("notification endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Process notification retry jobs (single attempt, no further retries)
+func HandleNotifyTask(ctx context.Context, t *asynq.Task) error {
+	var payload NotifyPayload
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal notify payload: %w", err)
+	}
+
+	project, err := database.GetProjectByID(ctx, payload.ProjectID)
+	if err != nil || project.NotificationURL == nil {
+		return nil
+	}
+
+	if err := sendNotification(ctx, project, payload.Body); err != nil {
+		log.Warn().Err(err).Str("project_id", project.ID).
+			Msg("Notification retry failed, giving up")
+	}
+	return nil
+}
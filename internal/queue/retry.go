@@ -0,0 +1,66 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	dockerclient "github.com/docker/docker/client"
+	"github.com/hibiken/asynq"
+)
+
+// Error text from the git & docker CLIs that points to a transient fault
+// (their errors arrive as plain strings, not typed network errors)
+var transientErrorMarkers = []string{
+	"connection refused",
+	"connection reset",
+	"i/o timeout",
+	"tls handshake timeout",
+	"no such host",
+	"temporary failure in name resolution",
+	"unexpected eof",
+	"503 service unavailable",
+	"502 bad gateway",
+	"cannot connect to the docker daemon",
+}
+
+// Reports whether a build step failed for reasons unrelated to the code
+// (network failures, Docker daemon timeouts), so retrying may succeed
+// Cancellation & anything from the build itself count as terminal
+func isRetriableBuildError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) ||
+		dockerclient.IsErrConnectionFailed(err) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, marker := range transientErrorMarkers {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether this is the task's last attempt
+// Outside a task (no retry info) every attempt is the last
+func retriesExhausted(ctx context.Context) bool {
+	retried, ok := asynq.GetRetryCount(ctx)
+	if !ok {
+		return true
+	}
+	maxRetry, ok := asynq.GetMaxRetry(ctx)
+	if !ok {
+		return true
+	}
+	return retried >= maxRetry
+}
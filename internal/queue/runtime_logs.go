@@ -0,0 +1,263 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/cache"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/containers"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/hibiken/asynq"
+	"github.com/rs/zerolog/log"
+)
+
+// How often buffered container output is written to runtime_logs
+const runtimeLogFlushInterval = 2 * time.Second
+
+// Buffered output above this size is flushed without waiting
+const runtimeLogMaxChunk = 64 * 1024
+
+// Days runtime logs are kept unless RUNTIME_LOG_RETENTION_DAYS says
+// otherwise
+const defaultRuntimeLogRetentionDays = 14
+
+// How long a tailer's claim on a container outlives its last renewal
+const runtimeLogClaimTTL = 3 * runtimeLogFlushInterval
+
+// Tails a live deployment's container into runtime_logs until the
+// container stops or is removed (e.g. by the next deploy), or the
+// deployment stops being live. Runs in its own goroutine. since ("" for
+// the container's whole output) is passed to Docker's logs API.
+// A container is only tailed by one worker at a time
+func persistRuntimeLogs(deploymentID, containerID, since string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := log.With().
+		Str("deployment_id", deploymentID).
+		Str("container_id", containerID).
+		Logger()
+
+	claimKey := "runtime_logs:tailer:" + containerID
+	if !claimRuntimeLogs(ctx, claimKey) {
+		logger.Debug().Msg("Runtime logs already persisted by another worker")
+		return
+	}
+	defer releaseRuntimeLogs(claimKey)
+
+	w := &runtimeLogWriter{deploymentID: deploymentID}
+	stdout := &runtimeLogStream{w: w, stream: "stdout"}
+	stderr := &runtimeLogStream{w: w, stream: "stderr"}
+
+	// Periodic flush, which also notices when the deployment is superseded
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(runtimeLogFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				w.flush(false)
+				renewRuntimeLogs(ctx, claimKey)
+				deployment, err := database.GetDeploymentByID(ctx,
+					deploymentID)
+				if err == nil &&
+					deployment.Status != database.DeploymentStatusLive {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	err := containers.StreamContainerLogs(ctx, containerID, "", stdout,
+		stderr)
+	close(done)
+	w.flush(true)
+
+	if err != nil && ctx.Err() == nil {
+		logger.Warn().Err(err).Msg("Runtime log stream ended with error")
+		return
+	}
+	logger.Debug().Msg("Stopped persisting runtime logs")
+}
+
+// Buffers container output per stream between flushes
+type runtimeLogWriter struct {
+	deploymentID string
+
+	mu      sync.Mutex
+	buffers map[string][]byte
+
+	// Serializes flushes so chunks are stored in order
+	flushMu sync.Mutex
+}
+
+func (w *runtimeLogWriter) write(stream string, p []byte) {
+	w.mu.Lock()
+	if w.buffers == nil {
+		w.buffers = make(map[string][]byte)
+	}
+	w.buffers[stream] = append(w.buffers[stream], p...)
+	full := len(w.buffers[stream]) >= runtimeLogMaxChunk
+	w.mu.Unlock()
+
+	if full {
+		w.flush(false)
+	}
+}
+
+// Saves & clears every non-empty buffer
+// Unless final, a UTF-8 sequence cut off at the end of a buffer is kept
+// for the next flush rather than saved as a broken character. Uses a
+// fresh context so the final flush still runs after cancellation
+func (w *runtimeLogWriter) flush(final bool) {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	buffers := w.buffers
+	w.buffers = nil
+	if !final {
+		for stream, content := range buffers {
+			n := completeRunesLen(content)
+			if n == len(content) {
+				continue
+			}
+			if w.buffers == nil {
+				w.buffers = make(map[string][]byte)
+			}
+			w.buffers[stream] = append([]byte(nil), content[n:]...)
+			buffers[stream] = content[:n]
+		}
+	}
+	w.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for stream, content := range buffers {
+		if len(content) == 0 {
+			continue
+		}
+		if err := database.SaveRuntimeLog(ctx, w.deploymentID, stream,
+			string(content)); err != nil {
+			log.Warn().Err(err).Str("deployment_id", w.deploymentID).
+				Msg("Failed to save runtime log")
+		}
+	}
+}
+
+// io.Writer for one of a container's output streams
+type runtimeLogStream struct {
+	w      *runtimeLogWriter
+	stream string
+}
+
+func (s *runtimeLogStream) Write(p []byte) (int, error) {
+	s.w.write(s.stream, p)
+	return len(p), nil
+}
+
+// Length of b without a UTF-8 sequence cut off at its end
+func completeRunesLen(b []byte) int {
+	for i := 1; i <= utf8.UTFMax && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if utf8.FullRune(b[len(b)-i:]) {
+				return len(b)
+			}
+			return len(b) - i
+		}
+	}
+	return len(b)
+}
+
+// Claims a container for this worker's tailer; true unless another worker
+// holds the claim. Without Redis every tailer proceeds
+func claimRuntimeLogs(ctx context.Context, key string) bool {
+	rdb := cache.Client()
+	if rdb == nil {
+		return true
+	}
+	claimed, err := rdb.SetNX(ctx, key, 1, runtimeLogClaimTTL).Result()
+	if err != nil {
+		log.Warn().Err(err).Str("key", key).
+			Msg("Failed to claim runtime log tailer")
+		return true
+	}
+	return claimed
+}
+
+// Extends a tailer's claim on its container
+func renewRuntimeLogs(ctx context.Context, key string) {
+	if rdb := cache.Client(); rdb != nil {
+		rdb.Expire(ctx, key, runtimeLogClaimTTL)
+	}
+}
+
+// Drops a tailer's claim so the container can be tailed again right away
+func releaseRuntimeLogs(key string) {
+	if rdb := cache.Client(); rdb != nil {
+		ctx, cancel := context.WithTimeout(context.Background(),
+			5*time.Second)
+		defer cancel()
+		rdb.Del(ctx, key)
+	}
+}
+
+// Restarts runtime log tailers for live deployments when a worker starts
+// Each picks up from its last saved chunk, so output from while no worker
+// was tailing is still captured
+func ResumeRuntimeLogs(ctx context.Context) error {
+	deployments, err := database.GetLiveDeploymentsWithContainers(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range deployments {
+		since := ""
+		latest, err := database.GetLatestRuntimeLogTime(ctx, d.ID)
+		if err != nil {
+			log.Warn().Err(err).Str("deployment_id", d.ID).
+				Msg("Failed to get latest runtime log, skipping")
+			continue
+		}
+		if latest != nil {
+			since = latest.Format(time.RFC3339Nano)
+		}
+		go persistRuntimeLogs(d.ID, *d.ContainerID, since)
+	}
+
+	log.Info().Int("deployments", len(deployments)).
+		Msg("Resumed runtime log tailing")
+	return nil
+}
+
+// How long runtime logs are kept (RUNTIME_LOG_RETENTION_DAYS)
+func runtimeLogRetention() time.Duration {
+	days := defaultRuntimeLogRetentionDays
+	v, err := strconv.Atoi(os.Getenv("RUNTIME_LOG_RETENTION_DAYS"))
+	if err == nil && v > 0 {
+		days = v
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Process runtime log retention jobs: deletes chunks older than the
+// retention period
+func HandlePruneRuntimeLogsTask(ctx context.Context, t *asynq.Task) error {
+	deleted, err := database.DeleteRuntimeLogsBefore(ctx,
+		time.Now().Add(-runtimeLogRetention()))
+	if err != nil {
+		return fmt.Errorf("failed to prune runtime logs: %w", err)
+	}
+
+	log.Info().Int64("deleted", deleted).Msg("Pruned runtime logs")
+	return nil
+}
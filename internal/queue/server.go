@@ -0,0 +1,206 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/rs/zerolog/log"
+)
+
+// Default queue weights (higher = processed more often); deploys go first
+// so finished builds go live promptly
+const (
+	DefaultBuildsPriority      = 3
+	DefaultDeploymentsPriority = 6
+)
+
+// Default per-queue caps on tasks running at once on one worker
+const (
+	DefaultBuildsConcurrency      = 3
+	DefaultDeploymentsConcurrency = 2
+)
+
+// How long a task turned away by its queue's concurrency cap waits before
+// it's picked up again
+const concurrencyRetryDelay = 5 * time.Second
+
+// Returned by handlers whose queue is at its concurrency cap; the task is
+// put back without counting as a failed attempt
+var errAtConcurrencyLimit = errors.New("queue concurrency limit reached")
+
+// Every queue tasks are enqueued on, weighted by priority
+func QueuePriorities(buildsPriority,
+	deploymentsPriority int) map[string]int {
+	return map[string]int{
+		DeploymentsQueue(): deploymentsPriority,
+		BuildsQueue():      buildsPriority,
+		MaintenanceQueue:   1,
+	}
+}
+
+// Parses weighted queues written as name:weight pairs, comma-separated
+// e.g. "builds:3,deployments:1"; a name without a weight gets 1
+func ParseQueues(spec string) (map[string]int, error) {
+	queues := map[string]int{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, weightStr, hasWeight := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, fmt.Errorf("queue %q has no name", entry)
+		}
+		weight := 1
+		if hasWeight {
+			w, err := strconv.Atoi(strings.TrimSpace(weightStr))
+			if err != nil || w < 1 {
+				return nil, fmt.Errorf("queue %q needs a positive weight",
+					name)
+			}
+			weight = w
+		}
+		queues[name] = weight
+	}
+	if len(queues) == 0 {
+		return nil, fmt.Errorf("no queues in %q", spec)
+	}
+	return queues, nil
+}
+
+// Create a worker server processing queues (name -> priority weight)
+// Must be called after Connect, whose Redis it uses
+func NewServer(concurrency int, queues map[string]int) *asynq.Server {
+	return asynq.NewServer(redisOpt, asynq.Config{
+		Concurrency: concurrency,
+		Queues:      queues,
+		// Tasks turned away at a concurrency cap don't use up retries
+		IsFailure: func(err error) bool {
+			return !errors.Is(err, errAtConcurrencyLimit)
+		},
+		RetryDelayFunc: func(n int, err error, task *asynq.Task) time.Duration {
+			if errors.Is(err, errAtConcurrencyLimit) {
+				return concurrencyRetryDelay
+			}
+			return asynq.DefaultRetryDelayFunc(n, err, task)
+		},
+		// Retries are logged by the handlers; this reports the error that
+		// ended each attempt
+		ErrorHandler: asynq.ErrorHandlerFunc(func(ctx context.Context,
+			task *asynq.Task, err error) {
+			taskID, _ := asynq.GetTaskID(ctx)
+			if errors.Is(err, errAtConcurrencyLimit) {
+				log.Debug().Str("task_type", task.Type()).
+					Str("task_id", taskID).
+					Msg("Task deferred by queue concurrency limit")
+				return
+			}
+			log.Error().Err(err).
+				Str("task_type", task.Type()).
+				Str("task_id", taskID).
+				Msg("Task failed")
+		}),
+		ShutdownTimeout: 30 * time.Second,
+		Logger:          asynqLogger{},
+	})
+}
+
+// Routes asynq's own log output through zerolog
+type asynqLogger struct{}
+
+func (asynqLogger) Debug(args ...any) { log.Debug().Msg(fmt.Sprint(args...)) }
+func (asynqLogger) Info(args ...any)  { log.Info().Msg(fmt.Sprint(args...)) }
+func (asynqLogger) Warn(args ...any)  { log.Warn().Msg(fmt.Sprint(args...)) }
+func (asynqLogger) Error(args ...any) { log.Error().Msg(fmt.Sprint(args...)) }
+func (asynqLogger) Fatal(args ...any) { log.Fatal().Msg(fmt.Sprint(args...)) }
+
+// Caps how many of a handler's tasks run at once on this worker
+// Over the cap, tasks are deferred: they wait concurrencyRetryDelay in the
+// retry set, then rejoin the back of their queue, so a deferred task can
+// run after tasks enqueued behind it (BuildQueuePosition counts it that
+// way). On a task's last attempt, where asynq would archive a deferred
+// task, it holds its server goroutine until a slot frees instead
+func limitConcurrency(limit int, handler asynq.HandlerFunc) asynq.HandlerFunc {
+	slots := make(chan struct{}, limit)
+	return func(ctx context.Context, t *asynq.Task) error {
+		select {
+		case slots <- struct{}{}:
+		default:
+			retried, _ := asynq.GetRetryCount(ctx)
+			maxRetry, _ := asynq.GetMaxRetry(ctx)
+			if retried < maxRetry {
+				return errAtConcurrencyLimit
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		defer func() { <-slots }()
+		return handler(ctx, t)
+	}
+}
+
+// Returns a ServeMux with all task handlers registered
+// Builds & deploys are capped at buildsConcurrency & deploysConcurrency
+// tasks at once
+func NewServeMux(buildsConcurrency, deploysConcurrency int) *asynq.ServeMux {
+	mux := asynq.NewServeMux()
+	mux.HandleFunc(TypeBuildProject,
+		limitConcurrency(buildsConcurrency, HandleBuildTask))
+	mux.HandleFunc(TypeDeployProject,
+		limitConcurrency(deploysConcurrency, HandleDeployTask))
+	mux.HandleFunc(TypeResumeProject,
+		limitConcurrency(deploysConcurrency, HandleResumeProjectTask))
+	mux.HandleFunc(TypeCleanupImages, HandleCleanupImagesTask)
+	mux.HandleFunc(TypeCleanupContainers, HandleCleanupContainersTask)
+	mux.HandleFunc(TypeReleaseFrozen, HandleReleaseFrozenTask)
+	mux.HandleFunc(TypeNotifyDeployment, HandleNotifyTask)
+	mux.HandleFunc(TypeRefreshMetrics, HandleRefreshMetricsTask)
+	mux.HandleFunc(TypeCheckCertificates, HandleCheckCertificatesTask)
+	mux.HandleFunc(TypePruneRuntimeLogs, HandlePruneRuntimeLogsTask)
+	mux.HandleFunc(TypePruneWebhookDeliveries,
+		HandlePruneWebhookDeliveriesTask)
+	mux.HandleFunc(TypeDeleteAccount, HandleDeleteAccountTask)
+	return mux
+}
+
+// Provides the periodic task configs for the PeriodicTaskManager
+type periodicTaskProvider struct{}
+
+func (p *periodicTaskProvider) GetConfigs() ([]*asynq.PeriodicTaskConfig,
+	error) {
+	return []*asynq.PeriodicTaskConfig{
+		// Daily at 3 AM (UTC)
+		{Cronspec: "0 3 * * *", Task: NewCleanupContainersTask()},
+		// Every 5 minutes
+		{Cronspec: "*/5 * * * *", Task: NewReleaseFrozenTask()},
+		// Hourly
+		{Cronspec: "0 * * * *", Task: NewRefreshMetricsTask()},
+		// Nightly at 2 AM (UTC)
+		{Cronspec: "0 2 * * *", Task: NewCheckCertificatesTask()},
+		// Daily at 4 AM (UTC)
+		{Cronspec: "0 4 * * *", Task: NewPruneRuntimeLogsTask()},
+		// Daily at 4:30 AM (UTC)
+		{Cronspec: "30 4 * * *", Task: NewPruneWebhookDeliveriesTask()},
+	}, nil
+}
+
+// Create a manager that enqueues periodic maintenance tasks
+// Each running manager enqueues every task, so only one worker starts it
+func NewPeriodicTaskManager(redisAddr string) (*asynq.PeriodicTaskManager,
+	error) {
+	return asynq.NewPeriodicTaskManager(asynq.PeriodicTaskManagerOpts{
+		RedisConnOpt:               asynq.RedisClientOpt{Addr: redisAddr},
+		PeriodicTaskConfigProvider: &periodicTaskProvider{},
+		SyncInterval:               10 * time.Minute,
+		SchedulerOpts:              &asynq.SchedulerOpts{Logger: asynqLogger{}},
+	})
+}
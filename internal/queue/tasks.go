@@ -2,16 +2,61 @@ package queue
 
 import (
 	"encoding/json"
+	"os"
 	"time"
 
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
 	"github.com/hibiken/asynq"
 )
 
 const (
 	TypeBuildProject  = "build:project"
 	TypeDeployProject = "deploy:project"
+	TypeCleanupImages = "cleanup:images"
+
+	TypeCleanupContainers = "cleanup:containers"
+
+	TypeReleaseFrozen = "deploy:release-frozen"
+
+	TypeResumeProject = "deploy:resume-project"
+
+	TypeNotifyDeployment = "notify:deployment"
+
+	TypeRefreshMetrics = "metrics:refresh"
+
+	TypeCheckCertificates = "tls:check-certificates"
+
+	TypePruneRuntimeLogs = "cleanup:runtime-logs"
+
+	TypePruneWebhookDeliveries = "cleanup:webhook-deliveries"
+
+	TypeDeleteAccount = "account:delete"
 )
 
+// Default number of images to keep per project
+const DefaultImageKeepCount = 5
+
+// Queue for periodic & best-effort tasks
+const MaintenanceQueue = "maintenance"
+
+// Queue build tasks go on (QUEUE_BUILDS_NAME, default "builds")
+// The API & workers must agree on it
+func BuildsQueue() string {
+	if name := os.Getenv("QUEUE_BUILDS_NAME"); name != "" {
+		return name
+	}
+	return "builds"
+}
+
+// Queue deploy tasks go on (QUEUE_DEPLOYMENTS_NAME, default "deployments")
+// The API & workers must agree on it
+func DeploymentsQueue() string {
+	if name := os.Getenv("QUEUE_DEPLOYMENTS_NAME"); name != "" {
+		return name
+	}
+	return "deployments"
+}
+
 // Data for build job
 type BuildPayload struct {
 	DeploymentID string `json:"deployment_id"`
@@ -21,10 +66,63 @@ type BuildPayload struct {
 	RepoFullName string `json:"repo_full_name"`
 	RepoCloneURL string `json:"repo_clone_url"`
 	RootDir      string `json:"root_dir"`
+	// Docker build context relative to the repo ("" = RootDir)
+	BuildContext string `json:"build_context,omitempty"`
 	BuildCommand string `json:"build_command"`
 	StartCommand string `json:"start_command"`
 	Runtime      string `json:"runtime"`
 	Port         int    `json:"port"`
+	// Injected into generated Dockerfiles only
+	BuildEnvVars map[string]string `json:"build_env_vars,omitempty"`
+	// Secret id -> env var name; values are decrypted by the worker
+	BuildSecrets []database.BuildSecret `json:"build_secrets,omitempty"`
+	// Initialize git submodules after checkout
+	SubmodulesEnabled bool `json:"submodules_enabled,omitempty"`
+	// Set for pull request previews
+	PRNumber int `json:"pr_number,omitempty"`
+}
+
+// Data for a deployment notification retry
+type NotifyPayload struct {
+	ProjectID string `json:"project_id"`
+	Body      []byte `json:"body"`
+}
+
+// Data for an account deletion job
+type DeleteAccountPayload struct {
+	DeletionID string `json:"deletion_id"`
+	UserID     string `json:"user_id"`
+}
+
+// Builds the build job payload for a project's deployment
+func NewBuildPayload(project *database.Project,
+	deployment *database.Deployment) *BuildPayload {
+	branch := project.Branch
+	if deployment.Branch != nil {
+		branch = *deployment.Branch
+	}
+	prNumber := 0
+	if deployment.PRNumber != nil {
+		prNumber = *deployment.PRNumber
+	}
+	return &BuildPayload{
+		DeploymentID:      deployment.ID,
+		ProjectID:         project.ID,
+		CommitSHA:         deployment.CommitSHA,
+		Branch:            branch,
+		RepoFullName:      project.RepoFullName,
+		RepoCloneURL:      project.RepoURL,
+		RootDir:           project.RootDirectory,
+		BuildContext:      project.BuildContext,
+		BuildCommand:      stringOrEmpty(project.BuildCommand),
+		StartCommand:      stringOrEmpty(project.StartCommand),
+		Runtime:           stringOrEmpty(project.Runtime),
+		Port:              project.Port,
+		BuildEnvVars:      project.BuildEnvVars,
+		BuildSecrets:      project.BuildSecrets,
+		SubmodulesEnabled: project.SubmodulesEnabled,
+		PRNumber:          prNumber,
+	}
 }
 
 // Data for deploy job
@@ -32,8 +130,27 @@ type DeployPayload struct {
 	DeploymentID string `json:"deployment_id"`
 	ProjectID    string `json:"project_id"`
 	ProjectSlug  string `json:"project_slug"`
+	Environment  string `json:"environment"`
 	ImageTag     string `json:"image_tag"`
 	Port         int    `json:"port"`
+	// Empty means recreate (payloads enqueued before strategies existed)
+	DeployStrategy string `json:"deploy_strategy,omitempty"`
+	// Set for pull request previews
+	PRNumber int `json:"pr_number,omitempty"`
+	// Project's own base domain; empty uses BASE_DOMAIN
+	BaseDomain string `json:"base_domain,omitempty"`
+}
+
+// Data for resuming a paused project
+type ResumeProjectPayload struct {
+	ProjectID string `json:"project_id"`
+}
+
+// Data for image cleanup job
+type CleanupImagesPayload struct {
+	ProjectID   string `json:"project_id"`
+	RegistryURL string `json:"registry_url"`
+	KeepCount   int    `json:"keep_count"`
 }
 
 // Create new build task
@@ -43,12 +160,23 @@ func NewBuildTask(payload *BuildPayload) (*asynq.Task, error) {
 		return nil, err
 	}
 	return asynq.NewTask(TypeBuildProject, data,
+		asynq.TaskID(BuildTaskID(payload.DeploymentID)),
 		asynq.MaxRetry(3),
 		asynq.Timeout(30*time.Minute),
-		asynq.Queue("builds"),
+		asynq.Queue(BuildsQueue()),
 	), nil
 }
 
+// Asynq task ID for a deployment's build (lets it be cancelled later)
+func BuildTaskID(deploymentID string) string {
+	return "build:" + deploymentID
+}
+
+// Asynq task ID for a deployment's deploy (lets it be cancelled later)
+func DeployTaskID(deploymentID string) string {
+	return "deploy:" + deploymentID
+}
+
 // Create new deploy task
 func NewDeployTask(payload *DeployPayload) (*asynq.Task, error) {
 	data, err := json.Marshal(payload)
@@ -56,8 +184,132 @@ func NewDeployTask(payload *DeployPayload) (*asynq.Task, error) {
 		return nil, err
 	}
 	return asynq.NewTask(TypeDeployProject, data,
+		asynq.TaskID(DeployTaskID(payload.DeploymentID)),
 		asynq.MaxRetry(3),
 		asynq.Timeout(5*time.Minute),
-		asynq.Queue("deployments"),
+		asynq.Queue(DeploymentsQueue()),
 	), nil
 }
+
+// Asynq task ID for resuming a project (one resume at a time)
+func ResumeTaskID(projectID string) string {
+	return "resume:" + projectID
+}
+
+// Create new project resume task
+func NewResumeProjectTask(payload *ResumeProjectPayload) (*asynq.Task,
+	error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(TypeResumeProject, data,
+		asynq.TaskID(ResumeTaskID(payload.ProjectID)),
+		asynq.MaxRetry(3),
+		asynq.Timeout(5*time.Minute),
+		asynq.Queue(DeploymentsQueue()),
+	), nil
+}
+
+// Create new image cleanup task
+func NewCleanupImagesTask(payload *CleanupImagesPayload) (*asynq.Task, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(TypeCleanupImages, data,
+		asynq.MaxRetry(1),
+		asynq.Timeout(10*time.Minute),
+		asynq.Queue(MaintenanceQueue),
+	), nil
+}
+
+// Create new container cleanup task (no payload, runs periodically)
+func NewCleanupContainersTask() *asynq.Task {
+	return asynq.NewTask(TypeCleanupContainers, nil,
+		asynq.MaxRetry(1),
+		asynq.Timeout(15*time.Minute),
+		asynq.Queue(MaintenanceQueue),
+	)
+}
+
+// Create new frozen deployment release task (no payload, runs periodically)
+func NewReleaseFrozenTask() *asynq.Task {
+	return asynq.NewTask(TypeReleaseFrozen, nil,
+		asynq.MaxRetry(0),
+		asynq.Timeout(2*time.Minute),
+		asynq.Queue(MaintenanceQueue),
+	)
+}
+
+// Create new deployment metrics refresh task (no payload, runs periodically)
+func NewRefreshMetricsTask() *asynq.Task {
+	return asynq.NewTask(TypeRefreshMetrics, nil,
+		asynq.MaxRetry(1),
+		asynq.Timeout(5*time.Minute),
+		asynq.Queue(MaintenanceQueue),
+	)
+}
+
+// Create new certificate expiry check task (no payload, runs periodically)
+func NewCheckCertificatesTask() *asynq.Task {
+	return asynq.NewTask(TypeCheckCertificates, nil,
+		asynq.MaxRetry(0),
+		asynq.Timeout(30*time.Minute),
+		asynq.Queue(MaintenanceQueue),
+	)
+}
+
+// Create new runtime log retention task (no payload, runs periodically)
+func NewPruneRuntimeLogsTask() *asynq.Task {
+	return asynq.NewTask(TypePruneRuntimeLogs, nil,
+		asynq.MaxRetry(1),
+		asynq.Timeout(10*time.Minute),
+		asynq.Queue(MaintenanceQueue),
+	)
+}
+
+// Create new webhook delivery retention task (no payload, runs
+// periodically)
+func NewPruneWebhookDeliveriesTask() *asynq.Task {
+	return asynq.NewTask(TypePruneWebhookDeliveries, nil,
+		asynq.MaxRetry(1),
+		asynq.Timeout(10*time.Minute),
+		asynq.Queue(MaintenanceQueue),
+	)
+}
+
+// Create new account deletion task (one per account_deletions row)
+func NewDeleteAccountTask(payload *DeleteAccountPayload) (*asynq.Task,
+	error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(TypeDeleteAccount, data,
+		asynq.TaskID("account-delete:"+payload.DeletionID),
+		asynq.MaxRetry(0),
+		asynq.Timeout(15*time.Minute),
+		asynq.Queue(MaintenanceQueue),
+	), nil
+}
+
+// Create new notification retry task
+func NewNotifyTask(payload *NotifyPayload) (*asynq.Task, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(TypeNotifyDeployment, data,
+		asynq.MaxRetry(0),
+		asynq.Timeout(30*time.Second),
+		asynq.Queue(MaintenanceQueue),
+	), nil
+}
+
+func stringOrEmpty(s *string) string {
+	if s != nil {
+		return *s
+	}
+	return ""
+}
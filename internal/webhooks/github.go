@@ -7,6 +7,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"io"
 	"strings"
 )
 
@@ -30,6 +31,60 @@ type PushEvent struct {
 	Sender     Sender     `json:"sender"`
 }
 
+// Represents a GitHub App installation webhook payload
+type InstallationEvent struct {
+	Action       string       `json:"action"` // created, deleted, suspend, ...
+	Installation Installation `json:"installation"`
+	Sender       Sender       `json:"sender"`
+}
+
+// Represents a GitHub App installation_repositories webhook payload,
+// sent when repos are added to or removed from an installation
+type InstallationRepositoriesEvent struct {
+	Action              string                   `json:"action"` // added, removed
+	Installation        Installation             `json:"installation"`
+	RepositoriesAdded   []InstallationRepository `json:"repositories_added"`
+	RepositoriesRemoved []InstallationRepository `json:"repositories_removed"`
+	Sender              Sender                   `json:"sender"`
+}
+
+type InstallationRepository struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	Private  bool   `json:"private"`
+}
+
+// Represents a GitHub pull_request webhook payload
+type PullRequestEvent struct {
+	Action      string      `json:"action"` // opened, synchronize, closed, ...
+	Number      int         `json:"number"`
+	PullRequest PullRequest `json:"pull_request"`
+	Repository  Repository  `json:"repository"`
+	Sender      Sender      `json:"sender"`
+}
+
+type PullRequest struct {
+	Number int            `json:"number"`
+	Title  string         `json:"title"`
+	State  string         `json:"state"`
+	Merged bool           `json:"merged"`
+	Head   PullRequestRef `json:"head"`
+	Base   PullRequestRef `json:"base"`
+}
+
+type PullRequestRef struct {
+	Ref  string      `json:"ref"` // Branch name
+	SHA  string      `json:"sha"`
+	Repo *Repository `json:"repo"` // Nil if a fork was deleted
+}
+
+type Installation struct {
+	ID      int64  `json:"id"`
+	AppID   int64  `json:"app_id"`
+	Account Sender `json:"account"`
+}
+
 type Repository struct {
 	ID       int64  `json:"id"`
 	Name     string `json:"name"`
@@ -66,7 +121,10 @@ type Sender struct {
 
 // Verify webhook payload came from GitHub using HMAC SHA256
 // Signature header is in format: sha256=<hex>
-func ValidateSignature(payload []byte, signatureHeader,
+// The HMAC is computed as the payload is read, so a reader teed into a
+// buffer validates & captures a body in one pass. Errors reading the
+// payload are returned as-is.
+func ValidateSignature(payload io.Reader, signatureHeader,
 	secret string) error {
 	if signatureHeader == "" {
 		return ErrMissingSignature
@@ -84,7 +142,9 @@ func ValidateSignature(payload []byte, signatureHeader,
 
 	// Compute HMAC SHA256
 	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write(payload)
+	if _, err := io.Copy(mac, payload); err != nil {
+		return err
+	}
 	expectedSignature := mac.Sum(nil)
 
 	// Compare signatures (contant-time to avoid timing attacks)
@@ -105,6 +165,37 @@ func ParsePushEvent(payload []byte) (*PushEvent, error) {
 	return &event, nil
 }
 
+// Parse a GitHub App installation webhook payload
+func ParseInstallationEvent(payload []byte) (*InstallationEvent, error) {
+	var event InstallationEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, ErrInvalidPayload
+	}
+
+	return &event, nil
+}
+
+// Parse a GitHub App installation_repositories webhook payload
+func ParseInstallationRepositoriesEvent(
+	payload []byte) (*InstallationRepositoriesEvent, error) {
+	var event InstallationRepositoriesEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, ErrInvalidPayload
+	}
+
+	return &event, nil
+}
+
+// Parse a GitHub pull_request webhook payload
+func ParsePullRequestEvent(payload []byte) (*PullRequestEvent, error) {
+	var event PullRequestEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, ErrInvalidPayload
+	}
+
+	return &event, nil
+}
+
 // Extract branch from the ref ("refs/heads/main" -> "main")
 func (e *PushEvent) GetBranch() string {
 	return strings.TrimPrefix(e.Ref, "refs/heads/")
@@ -143,3 +234,26 @@ func (e *PushEvent) GetCommitInfo() (sha, message, author string) {
 	}
 	return
 }
+
+// Commit message markers that stop a push from deploying
+var skipDirectives = []string{
+	"[skip ci]", "[ci skip]", "[skip deploy]", "[rcnbuild skip]",
+}
+
+// Deployment controls embedded in a commit message
+type DeployDirectives struct {
+	Skip bool
+	// The marker that set Skip, as written in skipDirectives
+	SkipDirective string
+}
+
+// Extracts deployment directives from a commit message (case-insensitive)
+func ParseDeployDirectives(message string) DeployDirectives {
+	lower := strings.ToLower(message)
+	for _, directive := range skipDirectives {
+		if strings.Contains(lower, directive) {
+			return DeployDirectives{Skip: true, SkipDirective: directive}
+		}
+	}
+	return DeployDirectives{}
+}
@@ -1,15 +1,29 @@
 package webhooks
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"io"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
+	"github.com/Sys-Redux/rcnbuild-paas/internal/containers"
 	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/github"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
 	"github.com/Sys-Redux/rcnbuild-paas/internal/queue"
+	"github.com/Sys-Redux/rcnbuild-paas/pkg/crypto"
 	"github.com/gin-gonic/gin"
-	"github.com/rs/zerolog/log"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog"
 )
 
+// Cap on webhook bodies; GitHub push payloads for large diffs can be big
+const MaxBodyBytes = 10 << 20 // 10 MB
+
 // Provide HTTP handlers for webhooks
 type Handlers struct{}
 
@@ -19,27 +33,63 @@ func NewHandlers() *Handlers {
 }
 
 // Handle incoming GitHub webhook
+// @Summary Receive a GitHub webhook
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param X-GitHub-Event header string true "Event type"
+// @Param X-Hub-Signature-256 header string true "HMAC signature"
+// @Param X-GitHub-Delivery header string false "Delivery ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /webhooks/github [post]
 func (h *Handlers) HandleGitHubWebhook(c *gin.Context) {
-	// Read the request body for signature verification
-	body, err := io.ReadAll(c.Request.Body)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to read webhook body")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
-		return
-	}
+	logger := middleware.Logger(c)
 
 	eventType := c.GetHeader("X-GitHub-Event")
 	deliveryID := c.GetHeader("X-GitHub-Delivery")
 	signature := c.GetHeader("X-Hub-Signature-256")
 
-	log.Info().
+	logger.Info().
 		Str("event", eventType).
 		Str("delivery_id", deliveryID).
 		Msg("Received GitHub webhook")
 
+	// One byte past the cap, so oversized bodies are caught, not truncated
+	bodyReader := io.LimitReader(c.Request.Body, MaxBodyBytes+1)
+
+	// GitHub App installation events are signed with the app's secret
+	if eventType == "installation" {
+		h.handleInstallationEvent(c, bodyReader, signature)
+		return
+	}
+	if eventType == "installation_repositories" {
+		h.handleInstallationRepositoriesEvent(c, bodyReader, signature)
+		return
+	}
+
+	// Other events are signed with a project's secret, which is found from
+	// the payload itself, so the body is read before validation
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(bodyReader); err != nil {
+		respondBodyReadError(c, err)
+		return
+	}
+	if buf.Len() > MaxBodyBytes {
+		respondBodyReadError(c, &http.MaxBytesError{Limit: MaxBodyBytes})
+		return
+	}
+	body := buf.Bytes()
+
+	if eventType == "pull_request" {
+		h.handlePullRequestEvent(c, body, signature)
+		return
+	}
+
 	// Only handle push events for now
 	if eventType != "push" {
-		log.Debug().Str("event", eventType).Msg("Ignoring non-push event")
+		logger.Debug().Str("event", eventType).Msg("Ignoring non-push event")
 		c.JSON(http.StatusOK, gin.H{"message": "Event ignored"})
 		return
 	}
@@ -47,16 +97,16 @@ func (h *Handlers) HandleGitHubWebhook(c *gin.Context) {
 	// Parse push event payload
 	pushEvent, err := ParsePushEvent(body)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to parse push event")
+		logger.Error().Err(err).Msg("Failed to parse push event")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid push event"})
 		return
 	}
 
-	// Find project by repo full name
-	project, err := database.GetProjectByRepoFullName(c.Request.Context(),
+	// Find projects (one per environment) for the repo
+	projects, err := database.GetProjectsByRepoFullName(c.Request.Context(),
 		pushEvent.Repository.FullName)
-	if err != nil {
-		log.Warn().
+	if err != nil || len(projects) == 0 {
+		logger.Warn().
 			Str("repo", pushEvent.Repository.FullName).
 			Msg("No project found for repository")
 		c.JSON(http.StatusOK, gin.H{
@@ -65,108 +115,777 @@ func (h *Handlers) HandleGitHubWebhook(c *gin.Context) {
 		return
 	}
 
-	// Validate webhook signature using project's webhook secret
-	if project.WebhookSecret == nil || *project.WebhookSecret == "" {
-		log.Error().Str("project_id", project.ID).
-			Msg("Project has no webhook secret configured")
+	// Each project has its own webhook & secret; find the one that sent
+	// this delivery and validate its signature
+	project, err := findWebhookProject(c, projects,
+		c.GetHeader("X-GitHub-Hook-ID"), body, signature)
+	if err != nil {
+		logger.Warn().
+			Err(err).
+			Str("repo", pushEvent.Repository.FullName).
+			Msg("Invalid webhook signature")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
-	if err := ValidateSignature(body, signature,
-		*project.WebhookSecret); err != nil {
-		log.Warn().
-			Err(err).
-			Str("project_id", project.ID).
-			Msg("Invalid webhook signature")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	// Keep the raw delivery so it can be replayed later; GitHub redelivers
+	// with the same ID when an attempt times out, so it also dedupes
+	delivery, duplicate := recordDelivery(c, project, eventType, body,
+		signature)
+	if duplicate {
+		c.JSON(http.StatusOK, gin.H{"message": "already processed"})
+		return
+	}
+
+	status, response, deployment := processPushEvent(c.Request.Context(),
+		logger, project, pushEvent)
+	if delivery != nil && deployment != nil {
+		if err := database.SetWebhookDeliveryDeployment(c.Request.Context(),
+			delivery.ID, deployment.ID); err != nil {
+			logger.Warn().Err(err).Str("delivery_id", deliveryID).
+				Msg("Failed to link webhook delivery to deployment")
+		}
+	}
+	finishDelivery(c, delivery, status)
+
+	c.JSON(status, response)
+}
+
+// Records a verified delivery as pending before it's processed
+// duplicate is true if GitHub redelivered one that already succeeded;
+// failed or unfinished ones are processed again. A nil delivery (no
+// delivery ID, or a DB error) is processed without being recorded
+func recordDelivery(c *gin.Context, project *database.Project,
+	eventType string, body []byte, signature string) (*database.WebhookDelivery, bool) {
+	logger := middleware.Logger(c)
+
+	deliveryID := c.GetHeader("X-GitHub-Delivery")
+	if deliveryID == "" {
+		return nil, false
+	}
+
+	delivery, created, err := database.CreateWebhookDelivery(
+		c.Request.Context(), &database.CreateWebhookDeliveryInput{
+			DeliveryID: deliveryID,
+			ProjectID:  project.ID,
+			Event:      eventType,
+			Payload:    body,
+			Signature:  signature,
+		})
+	if err != nil {
+		logger.Warn().Err(err).Str("delivery_id", deliveryID).
+			Msg("Failed to record webhook delivery")
+		return nil, false
+	}
+	if !created {
+		logger.Info().Str("delivery_id", deliveryID).
+			Msg("Webhook delivery already processed, ignoring")
+		return nil, true
+	}
+	return delivery, false
+}
+
+// Stores the outcome of a recorded delivery from its response status:
+// server errors are failures, so a redelivery gets another attempt
+func finishDelivery(c *gin.Context, delivery *database.WebhookDelivery,
+	status int) {
+	if delivery == nil {
 		return
 	}
 
+	outcome := database.WebhookDeliveryStatusSuccess
+	if status >= http.StatusInternalServerError {
+		outcome = database.WebhookDeliveryStatusFailed
+	}
+	if err := database.SetWebhookDeliveryStatus(c.Request.Context(),
+		delivery.ID, outcome); err != nil {
+		middleware.Logger(c).Warn().Err(err).
+			Str("delivery_id", delivery.DeliveryID).
+			Msg("Failed to record webhook delivery status")
+	}
+}
+
+// Creates (& enqueues) a deployment for a verified push event
+// Shared by live webhooks & delivery replays; returns the HTTP response
+// and the deployment, if one was created
+func processPushEvent(ctx context.Context, logger *zerolog.Logger,
+	project *database.Project,
+	pushEvent *PushEvent) (int, gin.H, *database.Deployment) {
+	// Paused projects don't deploy until resumed
+	if project.PausedAt != nil {
+		logger.Debug().Str("project_id", project.ID).
+			Msg("Project is paused, skipping deployment")
+		return http.StatusOK, gin.H{
+			"message": "Project is paused, deployment skipped",
+		}, nil
+	}
+
 	// Check if this push should deploy
 	if !pushEvent.ShouldDeploy() {
-		log.Debug().Msg("Push event does not meet deployment criteria")
-		c.JSON(http.StatusOK, gin.H{
+		logger.Debug().Msg("Push event does not meet deployment criteria")
+		return http.StatusOK, gin.H{
 			"message": "Push event does not trigger deployment",
-		})
-		return
+		}, nil
 	}
 
 	// Check if push is to the configured branch
 	pushBranch := pushEvent.GetBranch()
 	if pushBranch != project.Branch {
-		log.Debug().
+		logger.Debug().
 			Str("push_branch", pushBranch).
 			Str("configured_branch", project.Branch).
 			Msg("Push to non-configured branch, skipping deployment")
-		c.JSON(http.StatusOK, gin.H{
+		return http.StatusOK, gin.H{
 			"message": "Push to non-configured branch, deployment skipped",
 			"branch":  pushBranch,
-		})
-		return
+		}, nil
 	}
 
 	// Get commit info
 	commitSHA, commitMessage, commitAuthor := pushEvent.GetCommitInfo()
 
+	// Commit messages can opt out, e.g. "[skip ci]"
+	if directives := ParseDeployDirectives(commitMessage); directives.Skip {
+		logger.Info().
+			Str("project_id", project.ID).
+			Str("commit", commitSHA).
+			Str("directive", directives.SkipDirective).
+			Msg("Commit directive, skipping deployment")
+		return http.StatusOK, gin.H{
+			"message": "deployment skipped via commit directive",
+		}, nil
+	}
+
+	// Enforce the owner's monthly deployment quota
+	exceeded, err := deploymentQuotaExceeded(ctx, project.UserID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to check deployment quota")
+		return http.StatusInternalServerError,
+			gin.H{"error": "Failed to create deployment"}, nil
+	}
+	if exceeded {
+		logger.Info().Str("project_id", project.ID).
+			Msg("Deployment quota exceeded, skipping deployment")
+		return http.StatusOK, gin.H{
+			"message": "deployment quota exceeded",
+		}, nil
+	}
+
+	// During a freeze window, record the deployment but don't build it
+	status := database.DeploymentStatusPending
+	if project.IsFrozen(time.Now()) {
+		status = database.DeploymentStatusFrozen
+	}
+
+	// At the project's build limit, the oldest queued build gives way
+	if status == database.DeploymentStatusPending {
+		makeRoomForBuild(ctx, logger, project)
+	}
+
 	// Create deployment record
-	deployment, err := database.CreateDeployment(c.Request.Context(),
+	triggeredBy := senderUserID(ctx, logger, pushEvent.Sender)
+	deployment, err := database.CreateDeployment(ctx,
 		&database.CreateDeploymentInput{
-			ProjectID:     project.ID,
-			CommitSHA:     commitSHA,
-			CommitMessage: &commitMessage,
-			CommitAuthor:  &commitAuthor,
-			Branch:        &pushBranch,
+			ProjectID:         project.ID,
+			CommitSHA:         commitSHA,
+			CommitMessage:     &commitMessage,
+			CommitAuthor:      &commitAuthor,
+			Branch:            &pushBranch,
+			Environment:       project.Environment,
+			Status:            status,
+			TriggeredByUserID: triggeredBy,
 		})
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to create deployment record")
-		c.JSON(http.StatusInternalServerError, gin.H{
+		logger.Error().Err(err).Msg("Failed to create deployment record")
+		return http.StatusInternalServerError, gin.H{
 			"error": "Failed to create deployment",
-		})
-		return
+		}, nil
 	}
 
-	log.Info().
+	logger.Info().
 		Str("deployment_id", deployment.ID).
 		Str("project_id", project.ID).
 		Str("commit", commitSHA[:8]).
 		Str("branch", pushBranch).
 		Msg("Created deployment record from push event")
 
+	if status == database.DeploymentStatusFrozen {
+		logger.Info().
+			Str("deployment_id", deployment.ID).
+			Str("project_id", project.ID).
+			Msg("Project is in a freeze window, deployment frozen")
+		return http.StatusAccepted, gin.H{
+			"message":       "Deployment frozen until freeze window ends",
+			"deployment_id": deployment.ID,
+			"commit":        commitSHA,
+			"branch":        pushBranch,
+		}, deployment
+	}
+
 	// Enqueue build job w/ Asynq
-	_, err = queue.EnqueueBuild(c.Request.Context(), &queue.BuildPayload{
-		DeploymentID: deployment.ID,
-		ProjectID:    project.ID,
-		CommitSHA:    commitSHA,
-		Branch:       pushBranch,
-		RepoFullName: project.RepoFullName,
-		RepoCloneURL: project.RepoURL,
-		RootDir:      project.RootDirectory,
-		BuildCommand: stringOrDefault(project.BuildCommand, ""),
-		StartCommand: stringOrDefault(project.StartCommand, ""),
-		Runtime:      stringOrDefault(project.Runtime, ""),
-		Port:         project.Port,
-	})
+	_, err = queue.EnqueueBuild(ctx, queue.NewBuildPayload(project, deployment))
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to enqueue build job")
-		c.JSON(http.StatusInternalServerError, gin.H{
+		logger.Error().Err(err).Msg("Failed to enqueue build job")
+		return http.StatusInternalServerError, gin.H{
 			"error": "Failed to enqueue build job",
-		})
-		return
+		}, deployment
 	}
 
-	c.JSON(http.StatusAccepted, gin.H{
+	return http.StatusAccepted, gin.H{
 		"message":       "Deployment created",
 		"deployment_id": deployment.ID,
 		"commit":        commitSHA,
 		"branch":        pushBranch,
+	}, deployment
+}
+
+// Responds to a webhook body that couldn't be read in full
+func respondBodyReadError(c *gin.Context, err error) {
+	middleware.Logger(c).Error().Err(err).Msg("Failed to read webhook body")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		middleware.RespondBodyError(c, err)
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+}
+
+// Reads a body signed with the GitHub App's secret, validating it as it's
+// read. Responds & returns ok=false if the signature or body is bad.
+func readAppSignedBody(c *gin.Context, r io.Reader,
+	signature string) (body []byte, ok bool) {
+	logger := middleware.Logger(c)
+
+	appSecret := os.Getenv("GITHUB_WEBHOOK_SECRET")
+	if appSecret == "" {
+		logger.Error().Msg("GITHUB_WEBHOOK_SECRET not set for installation events")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, false
+	}
+
+	var buf bytes.Buffer
+	err := ValidateSignature(io.TeeReader(r, &buf), signature, appSecret)
+	if buf.Len() > MaxBodyBytes {
+		err = &http.MaxBytesError{Limit: MaxBodyBytes}
+	}
+	if errors.Is(err, ErrInvalidSignature) ||
+		errors.Is(err, ErrMissingSignature) {
+		logger.Warn().Err(err).Msg("Invalid installation webhook signature")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, false
+	}
+	if err != nil {
+		respondBodyReadError(c, err)
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// Record or clear the user's GitHub App installation
+// The app's secret is known up front, so the body is validated as it's read
+func (h *Handlers) handleInstallationEvent(c *gin.Context, r io.Reader,
+	signature string) {
+	logger := middleware.Logger(c)
+
+	body, ok := readAppSignedBody(c, r, signature)
+	if !ok {
+		return
+	}
+
+	event, err := ParseInstallationEvent(body)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to parse installation event")
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "Invalid installation event"})
+		return
+	}
+
+	var installationID *int64
+	switch event.Action {
+	case "created", "unsuspend":
+		installationID = &event.Installation.ID
+	case "deleted", "suspend":
+		installationID = nil
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "Event ignored"})
+		return
+	}
+
+	if err := database.SetUserInstallationID(c.Request.Context(),
+		event.Sender.ID, installationID); err != nil {
+		logger.Warn().Err(err).
+			Str("sender", event.Sender.Login).
+			Msg("No user found for installation sender")
+		c.JSON(http.StatusOK, gin.H{"message": "No associated user found"})
+		return
+	}
+
+	logger.Info().
+		Str("action", event.Action).
+		Int64("installation_id", event.Installation.ID).
+		Str("sender", event.Sender.Login).
+		Msg("Processed GitHub App installation event")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Installation " + event.Action})
+}
+
+// Create webhooks for the sender's projects on repos added to the GitHub
+// App installation, and delete them for repos removed from it
+func (h *Handlers) handleInstallationRepositoriesEvent(c *gin.Context,
+	r io.Reader, signature string) {
+	logger := middleware.Logger(c)
+
+	body, ok := readAppSignedBody(c, r, signature)
+	if !ok {
+		return
+	}
+
+	event, err := ParseInstallationRepositoriesEvent(body)
+	if err != nil {
+		logger.Error().Err(err).
+			Msg("Failed to parse installation_repositories event")
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "Invalid installation_repositories event"})
+		return
+	}
+	if event.Action != "added" && event.Action != "removed" {
+		c.JSON(http.StatusOK, gin.H{"message": "Event ignored"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := database.GetUserByGitHubID(ctx, event.Sender.ID)
+	if err != nil {
+		logger.Warn().Err(err).
+			Str("sender", event.Sender.Login).
+			Msg("No user found for installation sender")
+		c.JSON(http.StatusOK, gin.H{"message": "No associated user found"})
+		return
+	}
+
+	accessToken, err := database.GetUserAccessToken(ctx, user.ID)
+	if err != nil {
+		logger.Error().Err(err).Str("user_id", user.ID).
+			Msg("Failed to get user access token")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to get user access token"})
+		return
+	}
+	ghClient := github.NewClient(accessToken)
+
+	updated := 0
+	for _, repo := range event.RepositoriesAdded {
+		for _, project := range userRepoProjects(ctx, user.ID, repo.FullName) {
+			// Projects created before the repo was added may still
+			// have their webhook; don't add a second one
+			if project.WebhookID != nil {
+				continue
+			}
+			if err := createProjectWebhook(ctx, logger, ghClient,
+				project); err != nil {
+				logger.Error().Err(err).Str("project_id", project.ID).
+					Str("repo", repo.FullName).
+					Msg("Failed to create webhook for added repository")
+				continue
+			}
+			updated++
+		}
+	}
+	for _, repo := range event.RepositoriesRemoved {
+		for _, project := range userRepoProjects(ctx, user.ID, repo.FullName) {
+			if project.WebhookID == nil {
+				continue
+			}
+			owner, repoName, err := github.ParseRepoFullName(
+				project.RepoFullName)
+			if err != nil {
+				continue
+			}
+			// The app no longer has access, but the user's own token
+			// may; either way the project stops listening for it
+			if err := ghClient.DeleteWebhook(ctx, owner, repoName,
+				*project.WebhookID); err != nil {
+				logger.Warn().Err(err).Str("project_id", project.ID).
+					Msg("Failed to delete GitHub webhook")
+			}
+			if err := database.ClearProjectWebhook(ctx,
+				project.ID); err != nil {
+				logger.Error().Err(err).Str("project_id", project.ID).
+					Msg("Failed to clear project webhook")
+				continue
+			}
+			updated++
+		}
+	}
+
+	logger.Info().
+		Str("action", event.Action).
+		Int64("installation_id", event.Installation.ID).
+		Str("sender", event.Sender.Login).
+		Int("repos_added", len(event.RepositoriesAdded)).
+		Int("repos_removed", len(event.RepositoriesRemoved)).
+		Int("projects_updated", updated).
+		Msg("Processed GitHub App installation_repositories event")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Repositories " + event.Action,
+		"projects_updated": updated,
 	})
 }
 
-// Helper functions
-func stringOrDefault(s *string, def string) string {
-	if s != nil {
-		return *s
+// Returns the user's projects (one per environment) for a repo
+func userRepoProjects(ctx context.Context, userID,
+	repoFullName string) []*database.Project {
+	projects, err := database.GetProjectsByRepoFullName(ctx, repoFullName)
+	if err != nil {
+		return nil
+	}
+
+	var owned []*database.Project
+	for _, project := range projects {
+		if project.UserID == userID {
+			owned = append(owned, project)
+		}
+	}
+	return owned
+}
+
+// Creates a GitHub webhook for the project & stores its secret
+// The webhook is deleted again if it can't be stored
+func createProjectWebhook(ctx context.Context, logger *zerolog.Logger,
+	ghClient *github.Client, project *database.Project) error {
+	owner, repoName, err := github.ParseRepoFullName(project.RepoFullName)
+	if err != nil {
+		return err
+	}
+
+	secret, err := github.GenerateWebhookSecret()
+	if err != nil {
+		return err
+	}
+	encryptedSecret, err := crypto.Encrypt(secret)
+	if err != nil {
+		return err
+	}
+
+	webhook, err := ghClient.CreateWebhook(ctx, owner, repoName,
+		github.WebhookURL(), secret)
+	if err != nil {
+		return err
+	}
+
+	if err := database.SetProjectWebhook(ctx, project.ID, webhook.ID,
+		encryptedSecret); err != nil {
+		if delErr := ghClient.DeleteWebhook(ctx, owner, repoName,
+			webhook.ID); delErr != nil {
+			logger.Warn().Err(delErr).Int64("webhook_id", webhook.ID).
+				Msg("Failed to delete unstored GitHub webhook")
+		}
+		return err
+	}
+	return nil
+}
+
+// Tear down preview deployments when their pull request closes
+func (h *Handlers) handlePullRequestEvent(c *gin.Context, body []byte,
+	signature string) {
+	logger := middleware.Logger(c)
+
+	event, err := ParsePullRequestEvent(body)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to parse pull_request event")
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "Invalid pull_request event"})
+		return
+	}
+
+	projects, err := database.GetProjectsByRepoFullName(c.Request.Context(),
+		event.Repository.FullName)
+	if err != nil || len(projects) == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "No project configured for this repository",
+		})
+		return
+	}
+
+	project, err := findWebhookProject(c, projects,
+		c.GetHeader("X-GitHub-Hook-ID"), body, signature)
+	if err != nil {
+		logger.Warn().Err(err).
+			Str("repo", event.Repository.FullName).
+			Msg("Invalid webhook signature")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	delivery, duplicate := recordDelivery(c, project, "pull_request", body,
+		signature)
+	if duplicate {
+		c.JSON(http.StatusOK, gin.H{"message": "already processed"})
+		return
+	}
+
+	var status int
+	var response gin.H
+	switch event.Action {
+	case "opened", "synchronize", "reopened":
+		status, response = deployPullRequestPreview(c.Request.Context(),
+			logger, project, event)
+	case "closed":
+		status, response = cleanUpPullRequestPreviews(c.Request.Context(),
+			logger, project, event)
+	default:
+		status, response = http.StatusOK, gin.H{"message": "Event ignored"}
+	}
+	finishDelivery(c, delivery, status)
+
+	c.JSON(status, response)
+}
+
+// Cancels a closed pull request's preview deployments & removes their
+// containers
+func cleanUpPullRequestPreviews(ctx context.Context, logger *zerolog.Logger,
+	project *database.Project, event *PullRequestEvent) (int, gin.H) {
+	// Matched by PR number: branch names repeat across forks & over time
+	deployments, err := database.CancelPreviewDeployments(ctx, project.ID,
+		event.Number)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to cancel preview deployments")
+		return http.StatusInternalServerError,
+			gin.H{"error": "Failed to clean up preview deployments"}
+	}
+
+	for _, d := range deployments {
+		// Builds & deploys still queued or running
+		buildTaskID := queue.BuildTaskID(d.ID)
+		if d.QueueTaskID != nil {
+			buildTaskID = *d.QueueTaskID
+		}
+		if err := queue.CancelBuildTask(buildTaskID); err != nil {
+			logger.Warn().Err(err).Str("deployment_id", d.ID).
+				Msg("Failed to cancel preview build task")
+		}
+		if err := queue.CancelDeployTask(d.ID); err != nil {
+			logger.Warn().Err(err).Str("deployment_id", d.ID).
+				Msg("Failed to cancel preview deploy task")
+		}
+
+		if d.ContainerID != nil {
+			if err := containers.Stop(ctx, *d.ContainerID); err != nil {
+				logger.Warn().Err(err).Str("deployment_id", d.ID).
+					Msg("Failed to stop preview container")
+			}
+			if err := containers.Remove(ctx, *d.ContainerID); err != nil {
+				logger.Warn().Err(err).Str("deployment_id", d.ID).
+					Msg("Failed to remove preview container")
+			}
+		}
+	}
+
+	logger.Info().
+		Str("project_id", project.ID).
+		Int("pr", event.Number).
+		Str("branch", event.PullRequest.Head.Ref).
+		Int("deployments", len(deployments)).
+		Msg("Cleaned up preview deployments for closed pull request")
+
+	return http.StatusOK, gin.H{
+		"message":     "Preview deployments cleaned up",
+		"deployments": len(deployments),
+	}
+}
+
+// Creates (& enqueues) a preview deployment for an open pull request
+// Only PRs from the repo itself into the project's branch are deployed:
+// fork PRs would otherwise run untrusted code with the project's secrets
+func deployPullRequestPreview(ctx context.Context, logger *zerolog.Logger,
+	project *database.Project, event *PullRequestEvent) (int, gin.H) {
+	pr := event.PullRequest
+
+	if !project.WatchPullRequests {
+		return http.StatusOK, gin.H{
+			"message": "Pull request previews are disabled",
+		}
+	}
+	if project.PausedAt != nil {
+		return http.StatusOK, gin.H{
+			"message": "Project is paused, deployment skipped",
+		}
+	}
+	if pr.Base.Ref != project.Branch {
+		return http.StatusOK, gin.H{
+			"message": "Pull request targets a non-configured branch",
+			"branch":  pr.Base.Ref,
+		}
+	}
+	if pr.Head.Repo == nil ||
+		pr.Head.Repo.FullName != event.Repository.FullName {
+		logger.Info().Str("project_id", project.ID).Int("pr", event.Number).
+			Msg("Skipping preview for pull request from a fork")
+		return http.StatusOK, gin.H{
+			"message": "Pull requests from forks are not deployed",
+		}
+	}
+
+	exceeded, err := deploymentQuotaExceeded(ctx, project.UserID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to check deployment quota")
+		return http.StatusInternalServerError,
+			gin.H{"error": "Failed to create deployment"}
+	}
+	if exceeded {
+		logger.Info().Str("project_id", project.ID).
+			Msg("Deployment quota exceeded, skipping preview")
+		return http.StatusOK, gin.H{
+			"message": "deployment quota exceeded",
+		}
+	}
+
+	makeRoomForBuild(ctx, logger, project)
+
+	prNumber := event.Number
+	headRef := pr.Head.Ref
+	triggeredBy := senderUserID(ctx, logger, event.Sender)
+	deployment, err := database.CreateDeployment(ctx,
+		&database.CreateDeploymentInput{
+			ProjectID:         project.ID,
+			CommitSHA:         pr.Head.SHA,
+			CommitMessage:     &pr.Title,
+			Branch:            &headRef,
+			Environment:       project.Environment,
+			DeploymentType:    database.DeploymentTypePreview,
+			PRNumber:          &prNumber,
+			TriggeredByUserID: triggeredBy,
+		})
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create preview deployment")
+		return http.StatusInternalServerError,
+			gin.H{"error": "Failed to create deployment"}
+	}
+
+	logger.Info().
+		Str("deployment_id", deployment.ID).
+		Str("project_id", project.ID).
+		Int("pr", prNumber).
+		Str("commit", pr.Head.SHA).
+		Msg("Created preview deployment from pull request")
+
+	_, err = queue.EnqueueBuild(ctx, queue.NewBuildPayload(project, deployment))
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to enqueue build job")
+		return http.StatusInternalServerError, gin.H{
+			"error": "Failed to enqueue build job",
+		}
+	}
+
+	return http.StatusAccepted, gin.H{
+		"message":       "Preview deployment created",
+		"deployment_id": deployment.ID,
+		"pr_number":     prNumber,
+		"commit":        pr.Head.SHA,
+	}
+}
+
+// Returns the project whose webhook sent the delivery, verifying the
+// signature with that project's (decrypted) webhook secret. Prefers the
+// X-GitHub-Hook-ID match, falling back to trying each project's secret
+func findWebhookProject(c *gin.Context, projects []*database.Project,
+	hookIDHeader string, body []byte,
+	signature string) (*database.Project, error) {
+	logger := middleware.Logger(c)
+
+	candidates := projects
+	if hookID, err := strconv.ParseInt(hookIDHeader, 10, 64); err == nil {
+		for _, p := range projects {
+			if p.WebhookID != nil && *p.WebhookID == hookID {
+				candidates = []*database.Project{p}
+				break
+			}
+		}
+	}
+
+	for _, p := range candidates {
+		secret, err := database.GetProjectWebhookSecret(c.Request.Context(),
+			p.ID)
+		if err != nil {
+			logger.Error().Err(err).Str("project_id", p.ID).
+				Msg("Project has no usable webhook secret configured")
+			continue
+		}
+		if err := ValidateSignature(bytes.NewReader(body), signature,
+			secret); err == nil {
+			return p, nil
+		}
+	}
+
+	return nil, ErrInvalidSignature
+}
+
+// Reports whether a user has used up this month's deployments
+func deploymentQuotaExceeded(ctx context.Context,
+	userID string) (bool, error) {
+	user, err := database.GetUserByID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := database.CountDeploymentsThisMonth(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return count >= user.QuotaDeploymentsPerMonth, nil
+}
+
+// Returns the ID of the user behind a webhook's sender, or nil if they
+// have no account here (e.g. a collaborator of the project owner)
+// Matched by GitHub ID, since logins can be renamed & reused
+func senderUserID(ctx context.Context, logger *zerolog.Logger,
+	sender Sender) *string {
+	if sender.ID == 0 {
+		return nil
 	}
-	return def
+	user, err := database.GetUserByGitHubID(ctx, sender.ID)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			logger.Warn().Err(err).Str("sender", sender.Login).
+				Msg("Failed to look up webhook sender")
+		}
+		return nil
+	}
+	return &user.ID
+}
+
+// Cancels the project's oldest pending build if it's at its build limit
+func makeRoomForBuild(ctx context.Context, logger *zerolog.Logger,
+	project *database.Project) {
+	active, err := database.CountActiveBuilds(ctx, project.ID)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Failed to count active builds")
+		return
+	}
+	if active < project.MaxConcurrentBuilds {
+		return
+	}
+
+	oldest, err := database.GetOldestPendingDeployment(ctx, project.ID)
+	if err != nil {
+		// Everything in flight is already building
+		return
+	}
+
+	if err := queue.CancelBuild(ctx, oldest.ID); err != nil {
+		logger.Warn().Err(err).Str("deployment_id", oldest.ID).
+			Msg("Failed to remove queued build")
+	}
+	if err := database.CancelDeployment(ctx, oldest.ID); err != nil {
+		logger.Warn().Err(err).Str("deployment_id", oldest.ID).
+			Msg("Failed to cancel pending deployment")
+		return
+	}
+
+	logger.Info().
+		Str("project_id", project.ID).
+		Str("deployment_id", oldest.ID).
+		Msg("Build limit reached, cancelled oldest pending deployment")
 }
@@ -0,0 +1,127 @@
+package webhooks
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/Sys-Redux/rcnbuild-paas/internal/auth"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/database"
+	"github.com/Sys-Redux/rcnbuild-paas/internal/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// Deployments a replay must not duplicate (succeeded or still running)
+var replayBlockingStatuses = map[database.DeploymentStatus]bool{
+	database.DeploymentStatusPending:    true,
+	database.DeploymentStatusBuilding:   true,
+	database.DeploymentStatusDeploying:  true,
+	database.DeploymentStatusLive:       true,
+	database.DeploymentStatusSuperseded: true,
+}
+
+// Re-run a recorded push delivery through the deployment logic
+// POST /api/projects/:id/webhook-deliveries/:delivery_id/redeliver
+func (h *Handlers) HandleRedeliver(c *gin.Context) {
+	logger := middleware.Logger(c)
+
+	user := auth.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	projectID := c.Param("id")
+	project, err := database.GetProjectByID(c.Request.Context(), projectID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+
+	// Verify ownership
+	if project.UserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	deliveryID := c.Param("delivery_id")
+	delivery, err := database.GetWebhookDelivery(c.Request.Context(),
+		project.ID, deliveryID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+		return
+	}
+
+	if delivery.Event != "push" {
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "Only push deliveries can be replayed"})
+		return
+	}
+
+	// Don't duplicate a deployment that succeeded (or is still running)
+	if delivery.DeploymentID != nil {
+		existing, err := database.GetDeploymentByID(c.Request.Context(),
+			*delivery.DeploymentID)
+		if err == nil && replayBlockingStatuses[existing.Status] {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":         "Delivery already triggered a deployment",
+				"deployment_id": existing.ID,
+			})
+			return
+		}
+	}
+
+	// The stored payload must still verify against the project's secret
+	secret, err := database.GetProjectWebhookSecret(c.Request.Context(),
+		project.ID)
+	if err != nil {
+		logger.Error().Err(err).Str("project_id", project.ID).
+			Msg("Project has no usable webhook secret configured")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "Failed to replay delivery"})
+		return
+	}
+	if err := ValidateSignature(bytes.NewReader(delivery.Payload),
+		delivery.Signature,
+		secret); err != nil {
+		logger.Warn().Err(err).Str("delivery_id", deliveryID).
+			Msg("Stored delivery no longer verifies")
+		c.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": "Delivery signature no longer valid"})
+		return
+	}
+
+	pushEvent, err := ParsePushEvent(delivery.Payload)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid push event"})
+		return
+	}
+
+	// Commit already live: nothing to redeploy
+	commitSHA, _, _ := pushEvent.GetCommitInfo()
+	live, err := database.GetLiveDeployment(c.Request.Context(), project.ID)
+	if err == nil && live.CommitSHA == commitSHA {
+		c.JSON(http.StatusOK, gin.H{
+			"message":    "Commit is already deployed",
+			"deployment": live,
+		})
+		return
+	}
+
+	replayLogger := logger.With().
+		Str("replayed_from_delivery_id", deliveryID).Logger()
+	replayLogger.Info().Str("project_id", project.ID).
+		Msg("Replaying webhook delivery")
+
+	status, response, deployment := processPushEvent(c.Request.Context(),
+		&replayLogger, project, pushEvent)
+	if deployment != nil {
+		if err := database.SetWebhookDeliveryDeployment(c.Request.Context(),
+			delivery.ID, deployment.ID); err != nil {
+			replayLogger.Warn().Err(err).
+				Msg("Failed to link webhook delivery to deployment")
+		}
+	}
+
+	response["replayed_from_delivery_id"] = deliveryID
+	c.JSON(status, response)
+}
@@ -0,0 +1,2 @@
+-- Rollback: Drop paused_at column
+ALTER TABLE projects DROP COLUMN IF EXISTS paused_at;
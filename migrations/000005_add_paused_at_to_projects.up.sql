@@ -0,0 +1,2 @@
+-- Paused projects have their container stopped and skip webhook deployments
+ALTER TABLE projects ADD COLUMN paused_at TIMESTAMPTZ;
@@ -0,0 +1,2 @@
+-- Rollback: Drop freeze_windows column
+ALTER TABLE projects DROP COLUMN IF EXISTS freeze_windows;
@@ -0,0 +1,3 @@
+-- Freeze windows: array of {cron_expression, duration_minutes} during which
+-- pushes are recorded as 'frozen' deployments instead of being built
+ALTER TABLE projects ADD COLUMN freeze_windows JSONB NOT NULL DEFAULT '[]';
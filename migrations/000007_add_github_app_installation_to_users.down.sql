@@ -0,0 +1,5 @@
+-- Rollback: Drop GitHub App installation columns
+DROP INDEX IF EXISTS idx_users_github_installation_id;
+ALTER TABLE users DROP COLUMN IF EXISTS installation_token_expires_at;
+ALTER TABLE users DROP COLUMN IF EXISTS installation_token_encrypted;
+ALTER TABLE users DROP COLUMN IF EXISTS github_installation_id;
@@ -0,0 +1,6 @@
+-- GitHub App installation (alternative to OAuth App tokens)
+ALTER TABLE users ADD COLUMN github_installation_id BIGINT;
+ALTER TABLE users ADD COLUMN installation_token_encrypted TEXT; -- Encrypted at rest
+ALTER TABLE users ADD COLUMN installation_token_expires_at TIMESTAMPTZ;
+
+CREATE INDEX idx_users_github_installation_id ON users(github_installation_id);
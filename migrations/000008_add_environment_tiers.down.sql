@@ -0,0 +1,4 @@
+-- Rollback: Drop environment columns and index
+DROP INDEX IF EXISTS idx_projects_repo_environment;
+ALTER TABLE deployments DROP COLUMN IF EXISTS environment;
+ALTER TABLE projects DROP COLUMN IF EXISTS environment;
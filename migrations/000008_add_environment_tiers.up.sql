@@ -0,0 +1,8 @@
+-- Environment tiers: one repository can back several projects
+-- (production, staging, preview), each with its own deployments
+ALTER TABLE projects ADD COLUMN environment VARCHAR(20) NOT NULL DEFAULT 'production';
+ALTER TABLE deployments ADD COLUMN environment VARCHAR(20) NOT NULL DEFAULT 'production';
+
+-- At most one project per repository & environment
+CREATE UNIQUE INDEX idx_projects_repo_environment
+    ON projects(repo_full_name, environment);
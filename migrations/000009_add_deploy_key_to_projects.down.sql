@@ -0,0 +1,3 @@
+-- Rollback: Drop deploy key columns
+ALTER TABLE projects DROP COLUMN IF EXISTS deploy_key_encrypted;
+ALTER TABLE projects DROP COLUMN IF EXISTS deploy_key_id;
@@ -0,0 +1,3 @@
+-- Read-only SSH deploy key used by the worker to clone the repository
+ALTER TABLE projects ADD COLUMN deploy_key_id BIGINT; -- GitHub deploy key ID
+ALTER TABLE projects ADD COLUMN deploy_key_encrypted TEXT; -- Private key (encrypted at rest)
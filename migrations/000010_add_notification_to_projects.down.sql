@@ -0,0 +1,3 @@
+-- Rollback: Drop notification columns
+ALTER TABLE projects DROP COLUMN IF EXISTS notification_secret;
+ALTER TABLE projects DROP COLUMN IF EXISTS notification_url;
@@ -0,0 +1,3 @@
+-- Outbound deployment notifications (POSTed to the user's own service)
+ALTER TABLE projects ADD COLUMN notification_url TEXT;
+ALTER TABLE projects ADD COLUMN notification_secret TEXT; -- HMAC secret (encrypted at rest)
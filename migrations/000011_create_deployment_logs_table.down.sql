@@ -0,0 +1,2 @@
+-- Rollback: Drop deployment_logs table
+DROP TABLE IF EXISTS deployment_logs;
@@ -0,0 +1,10 @@
+-- Deployment logs table: build output captured by the worker
+CREATE TABLE deployment_logs (
+    id BIGSERIAL PRIMARY KEY,
+    deployment_id UUID NOT NULL REFERENCES deployments(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    truncated BOOLEAN NOT NULL DEFAULT FALSE, -- hit MAX_BUILD_LOG_BYTES
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX idx_deployment_logs_deployment_id ON deployment_logs(deployment_id);
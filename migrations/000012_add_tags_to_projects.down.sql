@@ -0,0 +1,3 @@
+-- Rollback: Drop project tags
+DROP INDEX IF EXISTS idx_projects_tags;
+ALTER TABLE projects DROP COLUMN IF EXISTS tags;
@@ -0,0 +1,4 @@
+-- Project tags: free-form labels for organising projects in the dashboard
+ALTER TABLE projects ADD COLUMN tags TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX idx_projects_tags ON projects USING GIN (tags);
@@ -0,0 +1,4 @@
+-- Rollback: Drop quota & admin columns
+ALTER TABLE users DROP COLUMN IF EXISTS is_admin;
+ALTER TABLE users DROP COLUMN IF EXISTS quota_deployments_per_month;
+ALTER TABLE users DROP COLUMN IF EXISTS quota_projects;
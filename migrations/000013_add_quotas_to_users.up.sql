@@ -0,0 +1,4 @@
+-- Per-user limits (free tier defaults) & platform admin flag
+ALTER TABLE users ADD COLUMN quota_projects INT NOT NULL DEFAULT 3;
+ALTER TABLE users ADD COLUMN quota_deployments_per_month INT NOT NULL DEFAULT 100;
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;
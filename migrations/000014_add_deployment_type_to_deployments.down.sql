@@ -0,0 +1,3 @@
+-- Rollback: Drop deployment_type column
+DROP INDEX IF EXISTS idx_deployments_preview_branch;
+ALTER TABLE deployments DROP COLUMN IF EXISTS deployment_type;
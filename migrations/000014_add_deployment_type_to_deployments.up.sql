@@ -0,0 +1,5 @@
+-- Deployment type: 'push' (branch push) or 'preview' (pull request)
+ALTER TABLE deployments ADD COLUMN deployment_type VARCHAR(20) NOT NULL DEFAULT 'push';
+
+CREATE INDEX idx_deployments_preview_branch
+    ON deployments(project_id, branch) WHERE deployment_type = 'preview';
@@ -0,0 +1,2 @@
+-- Rollback: Drop webhook_deliveries table
+DROP TABLE IF EXISTS webhook_deliveries;
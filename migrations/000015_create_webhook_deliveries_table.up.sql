@@ -0,0 +1,14 @@
+-- Webhook deliveries table: raw GitHub deliveries kept for replay
+CREATE TABLE webhook_deliveries (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    delivery_id VARCHAR(64) NOT NULL, -- X-GitHub-Delivery
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    event VARCHAR(50) NOT NULL,
+    payload BYTEA NOT NULL, -- exact body, so the signature still verifies
+    signature VARCHAR(100) NOT NULL,
+    deployment_id UUID REFERENCES deployments(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX idx_webhook_deliveries_project_delivery
+    ON webhook_deliveries(project_id, delivery_id);
@@ -0,0 +1,2 @@
+-- Rollback: Drop max_concurrent_builds column
+ALTER TABLE projects DROP COLUMN IF EXISTS max_concurrent_builds;
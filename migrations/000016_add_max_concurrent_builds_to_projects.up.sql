@@ -0,0 +1,2 @@
+-- Cap on a project's pending + running builds
+ALTER TABLE projects ADD COLUMN max_concurrent_builds INT NOT NULL DEFAULT 1;
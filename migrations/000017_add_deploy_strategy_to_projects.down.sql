@@ -0,0 +1,2 @@
+-- Rollback: Drop deploy_strategy column
+ALTER TABLE projects DROP COLUMN IF EXISTS deploy_strategy;
@@ -0,0 +1,4 @@
+-- How deploys replace the running container: recreate or blue_green
+ALTER TABLE projects ADD COLUMN deploy_strategy VARCHAR(20) NOT NULL
+    DEFAULT 'recreate'
+    CHECK (deploy_strategy IN ('recreate', 'blue_green'));
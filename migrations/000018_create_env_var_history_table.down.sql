@@ -0,0 +1,4 @@
+-- Rollback: Drop env var history trigger and table
+DROP TRIGGER IF EXISTS env_vars_history ON env_vars;
+DROP FUNCTION IF EXISTS record_env_var_history();
+DROP TABLE IF EXISTS env_var_history;
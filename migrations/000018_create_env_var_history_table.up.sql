@@ -0,0 +1,35 @@
+-- Every value an env var has held, written by trigger on env_vars
+CREATE TABLE env_var_history (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    env_var_id UUID REFERENCES env_vars(id) ON DELETE SET NULL,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    key VARCHAR(255) NOT NULL,
+    value_encrypted TEXT NOT NULL,
+    changed_by_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_env_var_history_project_key
+    ON env_var_history(project_id, key, changed_at DESC);
+
+-- The acting user is passed in via the rcnbuild.user_id setting
+CREATE FUNCTION record_env_var_history() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND OLD.value_encrypted = NEW.value_encrypted THEN
+        RETURN NEW;
+    END IF;
+
+    INSERT INTO env_var_history (
+        env_var_id, project_id, key, value_encrypted, changed_by_user_id
+    ) VALUES (
+        NEW.id, NEW.project_id, NEW.key, NEW.value_encrypted,
+        NULLIF(current_setting('rcnbuild.user_id', true), '')::uuid
+    );
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER env_vars_history
+    AFTER INSERT OR UPDATE ON env_vars
+    FOR EACH ROW EXECUTE FUNCTION record_env_var_history();
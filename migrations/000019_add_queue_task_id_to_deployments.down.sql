@@ -0,0 +1,2 @@
+-- Rollback: Drop queue_task_id column
+ALTER TABLE deployments DROP COLUMN IF EXISTS queue_task_id;
@@ -0,0 +1,2 @@
+-- Asynq task ID of the deployment's build job (for cancellation)
+ALTER TABLE deployments ADD COLUMN queue_task_id VARCHAR(255);
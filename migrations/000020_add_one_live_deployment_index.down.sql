@@ -0,0 +1,2 @@
+-- Rollback: Drop one-live-deployment index
+DROP INDEX IF EXISTS idx_one_live_per_project;
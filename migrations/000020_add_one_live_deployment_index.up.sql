@@ -0,0 +1,14 @@
+-- At most one live deployment per project
+-- Supersede all but the newest live deployment so the index can be built
+UPDATE deployments d
+SET status = 'superseded', completed_at = COALESCE(d.completed_at, NOW())
+WHERE d.status = 'live'
+    AND EXISTS (
+        SELECT 1 FROM deployments newer
+        WHERE newer.project_id = d.project_id
+            AND newer.status = 'live'
+            AND (newer.created_at, newer.id) > (d.created_at, d.id)
+    );
+
+CREATE UNIQUE INDEX idx_one_live_per_project
+    ON deployments(project_id) WHERE status = 'live';
@@ -0,0 +1,2 @@
+-- Rollback: Drop build_env_vars column
+ALTER TABLE projects DROP COLUMN IF EXISTS build_env_vars;
@@ -0,0 +1,2 @@
+-- Build-time only variables (e.g. NODE_ENV), not secrets so stored as-is
+ALTER TABLE projects ADD COLUMN build_env_vars JSONB NOT NULL DEFAULT '{}';
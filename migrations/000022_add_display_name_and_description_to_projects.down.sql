@@ -0,0 +1,3 @@
+-- Rollback: Drop display_name and description columns
+ALTER TABLE projects DROP COLUMN IF EXISTS description;
+ALTER TABLE projects DROP COLUMN IF EXISTS display_name;
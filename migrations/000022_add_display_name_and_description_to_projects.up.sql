@@ -0,0 +1,3 @@
+-- Human-readable name & description; name stays the slug base
+ALTER TABLE projects ADD COLUMN display_name VARCHAR(100);
+ALTER TABLE projects ADD COLUMN description VARCHAR(500);
@@ -0,0 +1,2 @@
+-- Rollback: Drop account_deletions table
+DROP TABLE IF EXISTS account_deletions;
@@ -0,0 +1,17 @@
+-- Account deletions: progress of background account cleanup
+-- No FK to users since the user row is deleted as the last step
+CREATE TABLE account_deletions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL,
+    github_username VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, running, completed, failed
+    projects_total INT NOT NULL DEFAULT 0,
+    projects_deleted INT NOT NULL DEFAULT 0,
+    error_message TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    completed_at TIMESTAMPTZ
+);
+
+-- One cleanup in flight per user
+CREATE UNIQUE INDEX idx_account_deletions_active_user
+    ON account_deletions(user_id) WHERE status IN ('pending', 'running');
@@ -0,0 +1,2 @@
+-- Rollback: Drop build_secrets column
+ALTER TABLE projects DROP COLUMN IF EXISTS build_secrets;
@@ -0,0 +1,3 @@
+-- Env vars mounted as BuildKit secrets during builds: [{key, env_var_name}]
+-- Only the mapping is stored here; values stay encrypted in env_vars
+ALTER TABLE projects ADD COLUMN build_secrets JSONB NOT NULL DEFAULT '[]';
@@ -0,0 +1,2 @@
+-- Rollback: Drop submodules_enabled column
+ALTER TABLE projects DROP COLUMN IF EXISTS submodules_enabled;
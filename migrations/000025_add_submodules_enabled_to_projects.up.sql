@@ -0,0 +1,2 @@
+-- Opt-in git submodule initialization during builds (slows clones down)
+ALTER TABLE projects ADD COLUMN submodules_enabled BOOLEAN NOT NULL DEFAULT false;
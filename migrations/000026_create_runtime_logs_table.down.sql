@@ -0,0 +1,2 @@
+-- Rollback: Drop runtime_logs table
+DROP TABLE IF EXISTS runtime_logs;
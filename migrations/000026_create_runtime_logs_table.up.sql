@@ -0,0 +1,11 @@
+-- Runtime logs table: stdout/stderr of live containers, kept after removal
+CREATE TABLE runtime_logs (
+    id BIGSERIAL PRIMARY KEY,
+    deployment_id UUID NOT NULL REFERENCES deployments(id) ON DELETE CASCADE,
+    stream VARCHAR(6) NOT NULL, -- stdout or stderr
+    content TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_runtime_logs_deployment_created
+    ON runtime_logs(deployment_id, created_at);
@@ -0,0 +1,13 @@
+-- Rollback: Drop pull request preview columns & restore the live index
+DROP INDEX IF EXISTS idx_one_live_per_pull_request;
+DROP INDEX IF EXISTS idx_one_live_per_project;
+
+UPDATE deployments
+SET status = 'superseded', completed_at = COALESCE(completed_at, NOW())
+WHERE deployment_type = 'preview' AND status = 'live';
+
+CREATE UNIQUE INDEX idx_one_live_per_project
+    ON deployments(project_id) WHERE status = 'live';
+
+ALTER TABLE deployments DROP COLUMN IF EXISTS pr_number;
+ALTER TABLE projects DROP COLUMN IF EXISTS watch_pull_requests;
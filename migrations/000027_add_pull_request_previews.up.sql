@@ -0,0 +1,12 @@
+-- Pull request preview deployments (opt-in per project)
+ALTER TABLE projects ADD COLUMN watch_pull_requests BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE deployments ADD COLUMN pr_number INTEGER;
+
+-- Previews are live alongside the project's own deployment, one per PR
+DROP INDEX IF EXISTS idx_one_live_per_project;
+CREATE UNIQUE INDEX idx_one_live_per_project
+    ON deployments(project_id)
+    WHERE status = 'live' AND deployment_type <> 'preview';
+CREATE UNIQUE INDEX idx_one_live_per_pull_request
+    ON deployments(project_id, pr_number)
+    WHERE status = 'live' AND deployment_type = 'preview';
@@ -0,0 +1,10 @@
+-- Rollback: Drop GitLab columns (GitLab-only users can't be kept)
+DELETE FROM users WHERE github_id IS NULL;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_has_provider;
+ALTER TABLE users DROP COLUMN IF EXISTS gitlab_access_token_encrypted;
+ALTER TABLE users DROP COLUMN IF EXISTS gitlab_username;
+ALTER TABLE users DROP COLUMN IF EXISTS gitlab_id;
+
+ALTER TABLE users ALTER COLUMN github_username SET NOT NULL;
+ALTER TABLE users ALTER COLUMN github_id SET NOT NULL;
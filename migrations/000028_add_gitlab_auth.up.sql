@@ -0,0 +1,10 @@
+-- GitLab as a second login provider; a user may link GitHub, GitLab or both
+ALTER TABLE users ALTER COLUMN github_id DROP NOT NULL;
+ALTER TABLE users ALTER COLUMN github_username DROP NOT NULL;
+
+ALTER TABLE users ADD COLUMN gitlab_id BIGINT UNIQUE;
+ALTER TABLE users ADD COLUMN gitlab_username VARCHAR(255);
+ALTER TABLE users ADD COLUMN gitlab_access_token_encrypted TEXT;
+
+ALTER TABLE users ADD CONSTRAINT users_has_provider
+    CHECK (github_id IS NOT NULL OR gitlab_id IS NOT NULL);
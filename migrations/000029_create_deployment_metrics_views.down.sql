@@ -0,0 +1,3 @@
+-- Rollback: Drop deployment metrics views
+DROP MATERIALIZED VIEW IF EXISTS platform_deployment_metrics;
+DROP MATERIALIZED VIEW IF EXISTS deployment_metrics;
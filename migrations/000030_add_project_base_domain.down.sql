@@ -0,0 +1,2 @@
+-- Rollback: Drop base_domain column
+ALTER TABLE projects DROP COLUMN IF EXISTS base_domain;
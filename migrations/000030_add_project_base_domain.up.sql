@@ -0,0 +1,2 @@
+-- Per-project base domain (NULL uses the platform's BASE_DOMAIN)
+ALTER TABLE projects ADD COLUMN base_domain VARCHAR(253);
@@ -0,0 +1,2 @@
+-- Rollback: Drop unique delivery_id index
+DROP INDEX IF EXISTS idx_webhook_deliveries_delivery_id;
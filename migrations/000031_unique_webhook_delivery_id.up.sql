@@ -0,0 +1,9 @@
+-- One row per GitHub delivery, so redeliveries can be detected & ignored
+-- Keeps the latest copy of any delivery recorded more than once
+DELETE FROM webhook_deliveries a
+USING webhook_deliveries b
+WHERE a.delivery_id = b.delivery_id
+    AND (a.created_at, a.id) < (b.created_at, b.id);
+
+CREATE UNIQUE INDEX idx_webhook_deliveries_delivery_id
+    ON webhook_deliveries(delivery_id);
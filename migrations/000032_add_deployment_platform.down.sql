@@ -0,0 +1,2 @@
+-- Rollback: Drop platform column
+ALTER TABLE deployments DROP COLUMN IF EXISTS platform;
@@ -0,0 +1,3 @@
+-- Platform(s) a deployment's image was built for, e.g. linux/amd64 or
+-- linux/amd64,linux/arm64 (empty for deployments built before this)
+ALTER TABLE deployments ADD COLUMN platform VARCHAR(255) NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- Rollback: Drop token_expires_at column
+ALTER TABLE users DROP COLUMN IF EXISTS token_expires_at;
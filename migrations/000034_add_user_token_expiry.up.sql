@@ -0,0 +1,2 @@
+-- When the user's GitHub token expires (NULL = never, e.g. OAuth app tokens)
+ALTER TABLE users ADD COLUMN token_expires_at TIMESTAMPTZ;
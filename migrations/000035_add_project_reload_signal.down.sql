@@ -0,0 +1,2 @@
+-- Rollback: Drop reload_signal column
+ALTER TABLE projects DROP COLUMN IF EXISTS reload_signal;
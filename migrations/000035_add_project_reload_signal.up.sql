@@ -0,0 +1,2 @@
+-- Signal sent to the live container by env sync (NULL = SIGHUP)
+ALTER TABLE projects ADD COLUMN reload_signal VARCHAR(16);
@@ -0,0 +1,2 @@
+-- Rollback: Drop traefik_middlewares column
+ALTER TABLE projects DROP COLUMN IF EXISTS traefik_middlewares;
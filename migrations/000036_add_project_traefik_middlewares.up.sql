@@ -0,0 +1,3 @@
+-- Traefik middlewares: array of {type, users | source_range}, at most one
+-- per type, applied to the project's routers on each deployment
+ALTER TABLE projects ADD COLUMN traefik_middlewares JSONB NOT NULL DEFAULT '[]';
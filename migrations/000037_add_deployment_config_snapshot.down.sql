@@ -0,0 +1,2 @@
+-- Rollback: Drop config_snapshot column
+ALTER TABLE deployments DROP COLUMN IF EXISTS config_snapshot;
@@ -0,0 +1,4 @@
+-- Settings a deployment was built & run with, for comparing deployments:
+-- {build_command, start_command, port, runtime, env_keys} (keys only)
+-- NULL for deployments made before snapshots were recorded
+ALTER TABLE deployments ADD COLUMN config_snapshot JSONB;
@@ -0,0 +1,2 @@
+-- Rollback: Drop build_context column
+ALTER TABLE projects DROP COLUMN IF EXISTS build_context;
@@ -0,0 +1,4 @@
+-- Docker build context, relative to the repo root, for projects whose
+-- Dockerfile sits outside the directory they build from
+-- Empty means the root directory (the Dockerfile's directory)
+ALTER TABLE projects ADD COLUMN build_context TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- Rollback: Drop triggered_by_user_id column
+ALTER TABLE deployments DROP COLUMN IF EXISTS triggered_by_user_id;
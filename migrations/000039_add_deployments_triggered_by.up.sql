@@ -0,0 +1,4 @@
+-- User whose push or pull request triggered the deployment
+-- NULL when the sender has no account here, or for older deployments
+ALTER TABLE deployments ADD COLUMN triggered_by_user_id UUID
+    REFERENCES users(id) ON DELETE SET NULL;
@@ -0,0 +1,3 @@
+-- Rollback: Drop readiness probe columns
+ALTER TABLE projects DROP COLUMN IF EXISTS readiness_probe_timeout_seconds;
+ALTER TABLE projects DROP COLUMN IF EXISTS readiness_probe_cmd;
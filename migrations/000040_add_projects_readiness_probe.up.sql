@@ -0,0 +1,5 @@
+-- Command run inside a new container after it starts; the deploy fails
+-- unless it exits 0 within the timeout (NULL = no probe)
+ALTER TABLE projects ADD COLUMN readiness_probe_cmd TEXT;
+ALTER TABLE projects ADD COLUMN readiness_probe_timeout_seconds INTEGER
+    NOT NULL DEFAULT 60;
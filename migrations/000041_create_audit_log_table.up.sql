@@ -0,0 +1,14 @@
+-- Security-relevant actions (e.g. revealing a secret), append-only
+CREATE TABLE audit_log (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    project_id UUID REFERENCES projects(id) ON DELETE CASCADE,
+    action VARCHAR(64) NOT NULL, -- e.g. env_var.revealed
+    target VARCHAR(255), -- What was acted on, e.g. the env var key
+    ip_address VARCHAR(45), -- Caller's IP (v4 or v6)
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_audit_log_project_id
+    ON audit_log(project_id, created_at DESC);
+CREATE INDEX idx_audit_log_user_id ON audit_log(user_id, created_at DESC);
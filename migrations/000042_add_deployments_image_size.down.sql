@@ -0,0 +1,2 @@
+-- Rollback: Remove image_size_bytes from deployments
+ALTER TABLE deployments DROP COLUMN IF EXISTS image_size_bytes;
@@ -0,0 +1,3 @@
+-- Built image's size in bytes (NULL for multi-platform builds, which are
+-- pushed without a local image, and deployments before this migration)
+ALTER TABLE deployments ADD COLUMN image_size_bytes BIGINT;
@@ -0,0 +1,71 @@
+-- Rollback: Restore completed_at-based metrics views & drop built_at
+DROP MATERIALIZED VIEW IF EXISTS platform_deployment_metrics;
+DROP MATERIALIZED VIEW IF EXISTS deployment_metrics;
+
+CREATE MATERIALIZED VIEW deployment_metrics AS
+SELECT
+    project_id,
+    COUNT(*) AS deployments_total,
+    COUNT(*) FILTER (WHERE status IN ('live', 'superseded'))
+        AS deployments_succeeded,
+    AVG(EXTRACT(EPOCH FROM completed_at - started_at))
+        FILTER (WHERE status IN ('live', 'superseded'))
+        AS avg_build_duration,
+    PERCENTILE_CONT(0.5) WITHIN GROUP (
+        ORDER BY EXTRACT(EPOCH FROM completed_at - started_at))
+        FILTER (WHERE status IN ('live', 'superseded'))
+        AS p50_build_duration,
+    PERCENTILE_CONT(0.95) WITHIN GROUP (
+        ORDER BY EXTRACT(EPOCH FROM completed_at - started_at))
+        FILTER (WHERE status IN ('live', 'superseded'))
+        AS p95_build_duration,
+    PERCENTILE_CONT(0.99) WITHIN GROUP (
+        ORDER BY EXTRACT(EPOCH FROM completed_at - started_at))
+        FILTER (WHERE status IN ('live', 'superseded'))
+        AS p99_build_duration,
+    COUNT(*) FILTER (WHERE status IN ('live', 'superseded'))::float8
+        / COUNT(*) AS success_rate,
+    NOW() AS refreshed_at
+FROM deployments
+WHERE created_at >= NOW() - INTERVAL '30 days'
+    AND status IN ('live', 'superseded', 'failed')
+    AND started_at IS NOT NULL
+    AND completed_at IS NOT NULL
+GROUP BY project_id;
+
+-- Required for REFRESH ... CONCURRENTLY
+CREATE UNIQUE INDEX idx_deployment_metrics_project_id
+    ON deployment_metrics(project_id);
+
+-- Same figures across every project (percentiles can't be combined from
+-- the per-project rows)
+CREATE MATERIALIZED VIEW platform_deployment_metrics AS
+SELECT
+    COUNT(*) AS deployments_total,
+    COUNT(*) FILTER (WHERE status IN ('live', 'superseded'))
+        AS deployments_succeeded,
+    AVG(EXTRACT(EPOCH FROM completed_at - started_at))
+        FILTER (WHERE status IN ('live', 'superseded'))
+        AS avg_build_duration,
+    PERCENTILE_CONT(0.5) WITHIN GROUP (
+        ORDER BY EXTRACT(EPOCH FROM completed_at - started_at))
+        FILTER (WHERE status IN ('live', 'superseded'))
+        AS p50_build_duration,
+    PERCENTILE_CONT(0.95) WITHIN GROUP (
+        ORDER BY EXTRACT(EPOCH FROM completed_at - started_at))
+        FILTER (WHERE status IN ('live', 'superseded'))
+        AS p95_build_duration,
+    PERCENTILE_CONT(0.99) WITHIN GROUP (
+        ORDER BY EXTRACT(EPOCH FROM completed_at - started_at))
+        FILTER (WHERE status IN ('live', 'superseded'))
+        AS p99_build_duration,
+    COUNT(*) FILTER (WHERE status IN ('live', 'superseded'))::float8
+        / NULLIF(COUNT(*), 0) AS success_rate,
+    NOW() AS refreshed_at
+FROM deployments
+WHERE created_at >= NOW() - INTERVAL '30 days'
+    AND status IN ('live', 'superseded', 'failed')
+    AND started_at IS NOT NULL
+    AND completed_at IS NOT NULL;
+
+ALTER TABLE deployments DROP COLUMN IF EXISTS built_at;
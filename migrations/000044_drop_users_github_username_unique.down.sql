@@ -0,0 +1,2 @@
+-- Rollback: Nothing to restore; logins can't safely be made unique again
+SELECT 1;
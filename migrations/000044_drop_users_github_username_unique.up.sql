@@ -0,0 +1,4 @@
+-- GitHub logins can be renamed & reused, so they can't be unique here;
+-- webhook senders are matched by GitHub ID instead. Earlier releases of
+-- migration 39 created this index.
+DROP INDEX IF EXISTS idx_users_github_username;
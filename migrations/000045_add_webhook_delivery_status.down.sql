@@ -0,0 +1,2 @@
+-- Rollback: Drop status from webhook_deliveries
+ALTER TABLE webhook_deliveries DROP COLUMN IF EXISTS status;
@@ -0,0 +1,7 @@
+-- Outcome of processing a delivery: only successful ones are skipped when
+-- GitHub redelivers, so failed (or interrupted) ones get another attempt
+ALTER TABLE webhook_deliveries
+    ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'pending';
+
+-- Deliveries from before this column were deduplicated unconditionally
+UPDATE webhook_deliveries SET status = 'success';
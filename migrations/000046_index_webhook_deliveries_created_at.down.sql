@@ -0,0 +1,2 @@
+-- Rollback: Drop webhook_deliveries created_at index
+DROP INDEX IF EXISTS idx_webhook_deliveries_created_at;
@@ -0,0 +1,3 @@
+-- Deliveries past WEBHOOK_DELIVERY_RETENTION_DAYS are pruned nightly
+CREATE INDEX idx_webhook_deliveries_created_at
+    ON webhook_deliveries(created_at);
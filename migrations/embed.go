@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL migrations so the API binary can apply
+// them itself (see database.RunMigrations)
+package migrations
+
+import "embed"
+
+// Numbered golang-migrate files: NNNNNN_name.up.sql & .down.sql
+//
+//go:embed *.sql
+var FS embed.FS
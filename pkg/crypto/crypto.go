@@ -4,6 +4,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"io"
@@ -18,71 +19,152 @@ var (
 	ErrDecryptionFail = errors.New("decryption failed")
 )
 
+// Ciphers built from the keys in the environment, rebuilt whenever the
+// keys change (e.g. tests that set ENCRYPTION_KEY after a first call)
 var (
-	gcm     cipher.AEAD
-	gcmOnce sync.Once
-	gcmErr  error
+	gcmMu sync.Mutex
+	// SHA-256 of the keys the cached ciphers & error were built from
+	gcmKeyHash [sha256.Size]byte
+	gcmCached  bool
+	gcm        cipher.AEAD
+	gcmErr     error
+	// From ENCRYPTION_KEY_PREVIOUS during a key rotation (nil otherwise)
+	previousGCM cipher.AEAD
 )
 
-// initGCM initializes the AES-GCM cipher once
-func initGCM() {
-	gcmOnce.Do(func() {
-		key := os.Getenv("ENCRYPTION_KEY")
-		if key == "" {
-			// Fallback to JWT_SECRET if ENCRYPTION_KEY not set
-			key = os.Getenv("JWT_SECRET")
-		}
-		if key == "" {
-			gcmErr = ErrKeyNotSet
-			return
-		}
+// Returns the ciphers for the current keys, reading the environment on
+// every call but only rebuilding them when a key has changed
+func ciphers() (primary, previous cipher.AEAD, err error) {
+	key := os.Getenv("ENCRYPTION_KEY")
+	if key == "" {
+		// Fallback to JWT_SECRET if ENCRYPTION_KEY not set
+		key = os.Getenv("JWT_SECRET")
+	}
+	previousKey := os.Getenv("ENCRYPTION_KEY_PREVIOUS")
+	hash := sha256.Sum256([]byte(key + "\x00" + previousKey))
 
-		// Ensure key is exactly 32 bytes for AES-256
-		keyBytes := []byte(key)
-		if len(keyBytes) < 32 {
-			gcmErr = ErrKeyTooShort
-			return
-		}
-		keyBytes = keyBytes[:32] // Use first 32 bytes
+	gcmMu.Lock()
+	defer gcmMu.Unlock()
+	if gcmCached && hash == gcmKeyHash {
+		return gcm, previousGCM, gcmErr
+	}
+
+	gcm, previousGCM, gcmErr = buildCiphers(key, previousKey)
+	gcmKeyHash, gcmCached = hash, true
+	return gcm, previousGCM, gcmErr
+}
+
+// Builds the primary cipher & the previous one (nil if previousKey is "")
+func buildCiphers(key, previousKey string) (primary,
+	previous cipher.AEAD, err error) {
+	if key == "" {
+		return nil, nil, ErrKeyNotSet
+	}
+	if primary, err = newGCM(key); err != nil {
+		return nil, nil, err
+	}
 
-		block, err := aes.NewCipher(keyBytes)
-		if err != nil {
-			gcmErr = err
-			return
+	// Values still under the old key stay readable until RotateKey
+	// has re-encrypted them
+	if previousKey != "" {
+		if previous, err = newGCM(previousKey); err != nil {
+			return nil, nil, err
 		}
+	}
+	return primary, previous, nil
+}
 
-		gcm, gcmErr = cipher.NewGCM(block)
-	})
+// Clears the cached ciphers so the next call rebuilds them (for tests)
+func Reset() {
+	gcmMu.Lock()
+	defer gcmMu.Unlock()
+	gcm, previousGCM, gcmErr = nil, nil, nil
+	gcmKeyHash, gcmCached = [sha256.Size]byte{}, false
+}
+
+// Reports whether the encryption keys in the environment are usable
+// Call at startup to fail fast instead of on the first encryption
+func IsConfigured() bool {
+	_, _, err := ciphers()
+	return err == nil
+}
+
+// Builds an AES-256-GCM cipher from the first 32 bytes of key
+func newGCM(key string) (cipher.AEAD, error) {
+	// Ensure key is exactly 32 bytes for AES-256
+	keyBytes := []byte(key)
+	if len(keyBytes) < 32 {
+		return nil, ErrKeyTooShort
+	}
+	keyBytes = keyBytes[:32] // Use first 32 bytes
+
+	block, err := aes.NewCipher(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
 }
 
 // Encrypt encrypts plaintext using AES-256-GCM and returns base64-encoded ciphertext
 // The nonce is prepended to the ciphertext before encoding
 func Encrypt(plaintext string) (string, error) {
-	initGCM()
-	if gcmErr != nil {
-		return "", gcmErr
+	primary, _, err := ciphers()
+	if err != nil {
+		return "", err
 	}
+	return seal(primary, plaintext)
+}
+
+// Decrypt decrypts base64-encoded ciphertext that was encrypted with Encrypt()
+// Falls back to ENCRYPTION_KEY_PREVIOUS when it's set
+func Decrypt(ciphertext string) (string, error) {
+	primary, previous, err := ciphers()
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(primary, ciphertext)
+	if errors.Is(err, ErrDecryptionFail) && previous != nil {
+		return open(previous, ciphertext)
+	}
+	return plaintext, err
+}
 
+// Like Encrypt, but with an explicit key instead of ENCRYPTION_KEY
+// Only meant for key rotation (cmd/rekey)
+func EncryptWithKey(plaintext, key string) (string, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	return seal(aead, plaintext)
+}
+
+// Like Decrypt, but with an explicit key instead of ENCRYPTION_KEY
+// Only meant for key rotation (cmd/rekey)
+func DecryptWithKey(ciphertext, key string) (string, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	return open(aead, ciphertext)
+}
+
+func seal(aead cipher.AEAD, plaintext string) (string, error) {
 	// Create a unique nonce for this encryption
-	nonce := make([]byte, gcm.NonceSize())
+	nonce := make([]byte, aead.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return "", err
 	}
 
 	// Encrypt and append nonce + ciphertext
-	ciphertext := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	ciphertext := aead.Seal(nonce, nonce, []byte(plaintext), nil)
 
 	// Encode as base64 for safe database storage
 	return base64.StdEncoding.EncodeToString(ciphertext), nil
 }
 
-// Decrypt decrypts base64-encoded ciphertext that was encrypted with Encrypt()
-func Decrypt(ciphertext string) (string, error) {
-	initGCM()
-	if gcmErr != nil {
-		return "", gcmErr
-	}
-
+func open(aead cipher.AEAD, ciphertext string) (string, error) {
 	// Decode from base64
 	data, err := base64.StdEncoding.DecodeString(ciphertext)
 	if err != nil {
@@ -90,7 +172,7 @@ func Decrypt(ciphertext string) (string, error) {
 	}
 
 	// Extract nonce from the beginning
-	nonceSize := gcm.NonceSize()
+	nonceSize := aead.NonceSize()
 	if len(data) < nonceSize {
 		return "", ErrInvalidData
 	}
@@ -98,7 +180,7 @@ func Decrypt(ciphertext string) (string, error) {
 	nonce, encryptedData := data[:nonceSize], data[nonceSize:]
 
 	// Decrypt
-	plaintext, err := gcm.Open(nil, nonce, encryptedData, nil)
+	plaintext, err := aead.Open(nil, nonce, encryptedData, nil)
 	if err != nil {
 		return "", ErrDecryptionFail
 	}
@@ -0,0 +1,134 @@
+package crypto_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Sys-Redux/rcnbuild-paas/pkg/crypto"
+)
+
+const (
+	testKey  = "0123456789abcdef0123456789abcdef"
+	otherKey = "fedcba9876543210fedcba9876543210"
+)
+
+// Sets the encryption key & clears cached ciphers from earlier tests
+func setKey(tb testing.TB, key string) {
+	tb.Setenv("ENCRYPTION_KEY", key)
+	tb.Setenv("ENCRYPTION_KEY_PREVIOUS", "")
+	crypto.Reset()
+	tb.Cleanup(crypto.Reset)
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		length int
+	}{
+		{"empty", 0},
+		{"one byte", 1},
+		{"100 bytes", 100},
+		{"10000 bytes", 10000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setKey(t, testKey)
+			plaintext := strings.Repeat("x", tt.length)
+
+			ciphertext, err := crypto.Encrypt(plaintext)
+			if err != nil {
+				t.Fatalf("Encrypt: %v", err)
+			}
+			got, err := crypto.Decrypt(ciphertext)
+			if err != nil {
+				t.Fatalf("Decrypt: %v", err)
+			}
+			if got != plaintext {
+				t.Errorf("round trip returned %d bytes, want %d",
+					len(got), len(plaintext))
+			}
+		})
+	}
+}
+
+func TestEncryptUsesUniqueNonces(t *testing.T) {
+	setKey(t, testKey)
+
+	first, err := crypto.Encrypt("same input")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	second, err := crypto.Encrypt("same input")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if first == second {
+		t.Error("encrypting the same input twice gave identical ciphertexts")
+	}
+}
+
+func TestDecryptTruncatedCiphertext(t *testing.T) {
+	setKey(t, testKey)
+
+	ciphertext, err := crypto.Encrypt("secret")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		t.Fatalf("ciphertext isn't base64: %v", err)
+	}
+
+	// Shorter than the 12-byte GCM nonce
+	truncated := base64.StdEncoding.EncodeToString(data[:8])
+	if _, err := crypto.Decrypt(truncated); !errors.Is(err,
+		crypto.ErrInvalidData) {
+		t.Errorf("Decrypt(truncated) error = %v, want %v", err,
+			crypto.ErrInvalidData)
+	}
+}
+
+func TestDecryptWithWrongKey(t *testing.T) {
+	setKey(t, testKey)
+	ciphertext, err := crypto.Encrypt("secret")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	setKey(t, otherKey)
+	if _, err := crypto.Decrypt(ciphertext); !errors.Is(err,
+		crypto.ErrDecryptionFail) {
+		t.Errorf("Decrypt with wrong key error = %v, want %v", err,
+			crypto.ErrDecryptionFail)
+	}
+}
+
+func BenchmarkEncrypt(b *testing.B) {
+	setKey(b, testKey)
+	plaintext := strings.Repeat("x", 100)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := crypto.Encrypt(plaintext); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecrypt(b *testing.B) {
+	setKey(b, testKey)
+	ciphertext, err := crypto.Encrypt(strings.Repeat("x", 100))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := crypto.Decrypt(ciphertext); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
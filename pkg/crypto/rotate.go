@@ -0,0 +1,180 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrPreviousKeyNotSet = errors.New(
+	"ENCRYPTION_KEY_PREVIOUS environment variable not set")
+
+// A column holding values encrypted with ENCRYPTION_KEY
+// Every listed table has a UUID `id` primary key
+type EncryptedColumn struct {
+	Table  string
+	Column string
+	// Trigger to suspend while re-keying, so the rewrite isn't recorded
+	// as a change (e.g. env var history)
+	Trigger string
+}
+
+// Every encrypted column, in the order RekeyAll processes them
+var EncryptedColumns = []EncryptedColumn{
+	{Table: "env_vars", Column: "value_encrypted",
+		Trigger: "env_vars_history"},
+	{Table: "env_var_history", Column: "value_encrypted"},
+	{Table: "users", Column: "access_token_encrypted"},
+	{Table: "users", Column: "installation_token_encrypted"},
+	{Table: "users", Column: "gitlab_access_token_encrypted"},
+	{Table: "projects", Column: "webhook_secret"},
+	{Table: "projects", Column: "deploy_key_encrypted"},
+	{Table: "projects", Column: "notification_secret"},
+}
+
+// Rows re-keyed per transaction
+const rekeyBatchSize = 100
+
+// Rewrites every non-null value in col through rekey, batchSize rows per
+// transaction. rekey returns the value to store & whether it changed, so
+// already-converted rows from an interrupted run can be skipped.
+// Returns the number of rows rewritten.
+func rekeyColumn(ctx context.Context, db *pgxpool.Pool, col EncryptedColumn,
+	rekey func(string) (string, bool, error)) (int, error) {
+	// Identifiers come from EncryptedColumns, never from user input
+	table := pgx.Identifier{col.Table}.Sanitize()
+	column := pgx.Identifier{col.Column}.Sanitize()
+
+	selectQuery := fmt.Sprintf(`
+		SELECT id, %s
+		FROM %s
+		WHERE %s IS NOT NULL AND id > $1
+		ORDER BY id
+		LIMIT $2
+		FOR UPDATE
+	`, column, table, column)
+	updateQuery := fmt.Sprintf(`UPDATE %s SET %s = $2 WHERE id = $1`,
+		table, column)
+
+	total := 0
+	lastID := "00000000-0000-0000-0000-000000000000"
+	for {
+		batchLen := 0
+		err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+			if col.Trigger != "" {
+				// Transactional DDL: re-enabled on rollback too
+				if _, err := tx.Exec(ctx, fmt.Sprintf(
+					`ALTER TABLE %s DISABLE TRIGGER %s`, table,
+					pgx.Identifier{col.Trigger}.Sanitize())); err != nil {
+					return err
+				}
+			}
+
+			rows, err := tx.Query(ctx, selectQuery, lastID, rekeyBatchSize)
+			if err != nil {
+				return err
+			}
+			type row struct{ id, value string }
+			var batch []row
+			for rows.Next() {
+				var r row
+				if err := rows.Scan(&r.id, &r.value); err != nil {
+					rows.Close()
+					return err
+				}
+				batch = append(batch, r)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			for _, r := range batch {
+				newValue, changed, err := rekey(r.value)
+				if err != nil {
+					return fmt.Errorf("row %s: %w", r.id, err)
+				}
+				if changed {
+					if _, err := tx.Exec(ctx, updateQuery, r.id,
+						newValue); err != nil {
+						return err
+					}
+					total++
+				}
+			}
+
+			if col.Trigger != "" {
+				if _, err := tx.Exec(ctx, fmt.Sprintf(
+					`ALTER TABLE %s ENABLE TRIGGER %s`, table,
+					pgx.Identifier{col.Trigger}.Sanitize())); err != nil {
+					return err
+				}
+			}
+
+			batchLen = len(batch)
+			if batchLen > 0 {
+				lastID = batch[batchLen-1].id
+			}
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+		if batchLen < rekeyBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// Rewrites every column in EncryptedColumns through rekey (see
+// rekeyColumn). Batches already committed stay converted, so a failed run
+// can be re-run. Returns the number of rows rewritten.
+func RekeyAll(ctx context.Context, db *pgxpool.Pool,
+	rekey func(string) (string, bool, error)) (rekeyed int, err error) {
+	// Keep going past a failed column so one bad value doesn't strand
+	// the remaining columns on the old key
+	var errs []error
+	for _, col := range EncryptedColumns {
+		count, err := rekeyColumn(ctx, db, col, rekey)
+		rekeyed += count
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.%s: %w", col.Table,
+				col.Column, err))
+		}
+	}
+	return rekeyed, errors.Join(errs...)
+}
+
+// Re-encrypts every value that only decrypts with ENCRYPTION_KEY_PREVIOUS
+// under ENCRYPTION_KEY, in place. Values already under ENCRYPTION_KEY are
+// left alone, so it's safe to re-run after a failure. Once it succeeds,
+// ENCRYPTION_KEY_PREVIOUS can be removed.
+// Returns the number of rows re-encrypted.
+func RotateKey(ctx context.Context, db *pgxpool.Pool) (rotated int,
+	err error) {
+	primary, previous, err := ciphers()
+	if err != nil {
+		return 0, err
+	}
+	if previous == nil {
+		return 0, ErrPreviousKeyNotSet
+	}
+
+	rekey := func(ciphertext string) (string, bool, error) {
+		_, err := open(primary, ciphertext)
+		if err == nil {
+			return ciphertext, false, nil
+		}
+		plaintext, prevErr := open(previous, ciphertext)
+		if prevErr != nil {
+			return "", false, err
+		}
+		newValue, err := seal(primary, plaintext)
+		return newValue, true, err
+	}
+
+	return RekeyAll(ctx, db, rekey)
+}